@@ -28,5 +28,7 @@ func newMux() *http.ServeMux {
 	r.HandleFunc("/ping", pingHandler)
 	r.HandleFunc("/logs/", api.LogHandler)
 	r.HandleFunc("/listlogs/", api.ListLogsHandler)
+	r.HandleFunc("/api/v1/listlogs/", api.ListLogsJSONHandler)
+	r.HandleFunc("/api/v1/logmetadata/", api.LogMetadataHandler)
 	return r
 }
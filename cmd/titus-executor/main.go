@@ -58,7 +58,7 @@ func main() {
 	// avoid os.Exit as much as possible to let deferred functions run
 	defer time.Sleep(1 * time.Second)
 
-	app.Flags = append(flags, docker.Flags...)
+	app.Flags = append(append(flags, docker.Flags...), docker.RuncFlags...)
 
 	cfg, cfgFlags := config.NewConfig()
 	app.Flags = append(app.Flags, cfgFlags...)
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	"github.com/Netflix/titus-executor/imagecache"
+	"github.com/Netflix/titus-executor/imagecache/server"
+	"github.com/Netflix/titus-executor/logsutil"
+	docker "github.com/docker/docker/client"
+	"github.com/docker/go-units"
+	log "github.com/sirupsen/logrus"
+)
+
+var dockerHost string
+var listenAddress string
+var diskBudget string
+var debug bool
+
+func init() {
+	flag.StringVar(&dockerHost, "docker-host", "unix:///var/run/docker.sock", "Docker Daemon URI")
+	flag.StringVar(&listenAddress, "listen", "localhost:8007", "Address to serve the image cache API on")
+	flag.StringVar(&diskBudget, "disk-budget", "", "Disk space that images can take up before the least recently used ones which aren't in use are evicted, i.e. 100GB. Images are never evicted if it isn't set")
+	flag.BoolVar(&debug, "debug", false, "Turn on debug logging")
+	flag.Parse()
+}
+
+func main() {
+	ctx := context.Background()
+	logsutil.MaybeSetupLoggerIfUnderSystemd()
+	if debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	var budget int64
+	if diskBudget != "" {
+		var err error
+		if budget, err = units.FromHumanSize(diskBudget); err != nil {
+			log.Fatal("Invalid disk budget: ", err)
+		}
+	}
+
+	client, err := docker.NewClient(dockerHost, "1.26", nil, map[string]string{})
+	if err != nil {
+		log.Fatal("Unable to create Docker client: ", err)
+	}
+
+	m := metrics.New(ctx, log.StandardLogger(), nil)
+	cache := imagecache.New(client, m, budget)
+	go cache.Run(ctx)
+	if err := http.ListenAndServe(listenAddress, server.NewImageCacheServer(cache)); err != nil {
+		log.Error("Error: HTTP ListenAndServe: ", err)
+	}
+}
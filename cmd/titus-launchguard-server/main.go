@@ -11,10 +11,14 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-var debug bool
+var (
+	debug       bool
+	journalPath string
+)
 
 func init() {
 	flag.BoolVar(&debug, "debug", false, "Turn on debug logging")
+	flag.StringVar(&journalPath, "journal", "/run/titus-launchguard/journal.json", "Where to journal cleanup events, so they survive restarts. Empty to keep them in memory only.")
 	flag.Parse()
 }
 
@@ -26,7 +30,16 @@ func main() {
 	}
 
 	m := metrics.New(ctx, log.StandardLogger(), nil)
-	if err := http.ListenAndServe(":8006", server.NewLaunchGuardServer(m)); err != nil {
+	var lgs *server.LaunchGuardServer
+	if journalPath == "" {
+		lgs = server.NewLaunchGuardServer(m)
+	} else {
+		var err error
+		if lgs, err = server.NewPersistentLaunchGuardServer(m, journalPath); err != nil {
+			log.Fatal("Unable to load journal: ", err)
+		}
+	}
+	if err := http.ListenAndServe(":8006", lgs); err != nil {
 		log.Error("Error: HTTP ListenAndServe: ", err)
 	}
 }
@@ -12,10 +12,23 @@ import (
 
 var dockerHost string
 var debug bool
+var dryRun bool
+var paths = reaper.DefaultHostPaths
 
 func init() {
 	flag.StringVar(&dockerHost, "docker-host", "unix:///var/run/docker.sock", "Docker Daemon URI")
 	flag.BoolVar(&debug, "debug", false, "Turn on debug logging")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only report the containers that would be terminated, and the leaked resources that would be removed")
+	// These have to match the executor's, and the VPC tool's configuration
+	flag.StringVar(&paths.TitusInits, "titus-inits", paths.TitusInits, "Where the executor bind mounts the containers' init processes")
+	flag.StringVar(&paths.TitusEnvironments, "titus-environments", paths.TitusEnvironments, "Where the executor writes the containers' environments")
+	flag.StringVar(&paths.Passports, "passports", paths.Passports, "Where the executor writes the tasks' Metatron passports")
+	flag.StringVar(&paths.RuncBundles, "runc-bundle-root", paths.RuncBundles, "The executor's titus.executor.runc.bundleRoot")
+	flag.StringVar(&paths.TaskLocks, "task-locks", paths.TaskLocks, "The lockDir under the executor's task-lock-dir")
+	flag.StringVar(&paths.CgroupMarkers, "cgroup-markers", paths.CgroupMarkers, "The executor's titus.executor.cgroupMarkerDir")
+	flag.StringVar(&paths.TiniSockets, "tini-sockets", paths.TiniSockets, "Glob of the executors' tini socket directories")
+	flag.StringVar(&paths.IPLocks, "ip-locks", paths.IPLocks, "Glob of the VPC tool's IP address locks")
+	flag.StringVar(&paths.GPULocks, "gpu-locks", paths.GPULocks, "Glob of the executor's GPU device locks")
 	flag.Parse()
 }
 
@@ -28,5 +41,5 @@ func main() {
 	if err := os.Setenv("PATH", fmt.Sprintf("%s%s", path, ":/usr/sbin:/sbin:/usr/local/sbin")); err != nil {
 		log.Fatal("Unable to set path: ", err)
 	}
-	reaper.RunReaper(dockerHost)
+	reaper.RunReaper(dockerHost, dryRun, paths)
 }
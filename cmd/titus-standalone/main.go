@@ -75,7 +75,7 @@ func main() {
 			Destination: &options.logLevel,
 		},
 	}
-	app.Flags = append(app.Flags, docker.Flags...)
+	app.Flags = append(append(app.Flags, docker.Flags...), docker.RuncFlags...)
 	app.Flags = append(app.Flags, cfgFlags...)
 
 	if err := app.Run(os.Args); err != nil {
@@ -138,7 +138,7 @@ func mainWithError(c *cli.Context, cfg *config.Config, options *cliOptions) erro
 		runner.Kill()
 	}()
 	log.Info("Starting task")
-	err = runner.StartTask(options.taskID, &containerInfo, options.mem, options.cpu, options.disk)
+	err = runner.StartTask(options.taskID, &containerInfo, options.mem, options.cpu, options.disk, nil)
 	if err != nil {
 		return err
 	}
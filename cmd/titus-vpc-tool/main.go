@@ -4,12 +4,15 @@ import (
 	"os"
 	"time"
 
+	"github.com/Netflix/titus-executor/vpc"
 	"github.com/Netflix/titus-executor/vpc/allocate"
 	"github.com/Netflix/titus-executor/vpc/context"
 	"github.com/Netflix/titus-executor/vpc/gc"
 	"github.com/Netflix/titus-executor/vpc/genconf"
 	"github.com/Netflix/titus-executor/vpc/globalgc"
+	"github.com/Netflix/titus-executor/vpc/limits"
 	"github.com/Netflix/titus-executor/vpc/setup"
+	"github.com/Netflix/titus-executor/vpc/stats"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -22,7 +25,7 @@ func main() {
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:   context.StateDir,
-			Value:  "/run/titus-vpc-tool",
+			Value:  vpc.DefaultStateDir,
 			Usage:  "Where to store the state, and locker state -- creates directory",
 			EnvVar: "VPC_STATE_DIR",
 		},
@@ -34,6 +37,19 @@ func main() {
 			Name:  "journald",
 			Usage: "Allows disabling the journald logging hook -- is enabled by default",
 		},
+		cli.StringFlag{
+			Name:   "limits-file",
+			Usage:  "JSON file with instance type limits, which override, or add to the built-in ones",
+			EnvVar: "VPC_LIMITS_FILE",
+		},
+	}
+	app.Before = func(cliCtx *cli.Context) error {
+		if limitsFile := cliCtx.GlobalString("limits-file"); limitsFile != "" {
+			if err := vpc.LoadLimitsOverrides(limitsFile); err != nil {
+				return cli.NewMultiError(cli.NewExitError("Unable to load limits file", 1), err)
+			}
+		}
+		return nil
 	}
 	app.Commands = []cli.Command{
 		setup.Setup,
@@ -42,6 +58,8 @@ func main() {
 		allocate.SetupContainer,
 		globalgc.GlobalGC,
 		genconf.GenConf,
+		limits.Limits,
+		stats.Stats,
 	}
 
 	// This is here because logs are buffered, and it's a way to try to guarantee that logs
@@ -8,15 +8,30 @@ import (
 	"unicode"
 
 	"github.com/Netflix/titus-executor/api/netflix/titus"
+	"github.com/Netflix/titus-executor/vpc"
 	"gopkg.in/urfave/cli.v1"
 )
 
 const (
 	defaultStatusCheckFrequency   = 5 * time.Second
+	defaultHealthCheckFrequency   = 30 * time.Second
 	defaultLogUploadThreshold     = 6 * time.Hour
 	defaultLogUploadCheckInterval = 15 * time.Minute
 	defaultStdioLogCheckInterval  = 1 * time.Minute
+	defaultStdioRotateSize        = 256000000
+	defaultMaxStdioRotateSize     = 1000000000
+	defaultMaxLogUploadThreshold  = 24 * time.Hour
 	defaultLogsTmpDir             = "/var/lib/titus-container-logs"
+	defaultContainerRuntime       = DockerContainerRuntime
+	defaultTaskLockDir            = "/run/titus-executor/tasks"
+)
+
+// Container runtimes that the executor can use to run tasks
+const (
+	// DockerContainerRuntime runs tasks through dockerd
+	DockerContainerRuntime = "docker"
+	// RuncContainerRuntime runs tasks by calling runc directly, without dockerd
+	RuncContainerRuntime = "runc"
 )
 
 // Config contains the executor configuration
@@ -35,9 +50,21 @@ type Config struct { // nolint: maligned
 	//LogUpload logUpload
 	// StatusCheckFrequency returns duration between the periods the executor will poll Dockerd
 	StatusCheckFrequency time.Duration
+	// HealthCheckFrequency returns duration between the periods the executor will run the task's health check command
+	HealthCheckFrequency time.Duration
 	LogsTmpDir           string
 	// Stack returns the stack configuration variable
 	Stack string
+	// ContainerRuntime returns which container runtime tasks are run with
+	ContainerRuntime string
+	// TaskLockDir is where the executor holds a lock on its task for as long as it runs, so the reaper can tell the
+	// task is live before it has a container
+	TaskLockDir string
+	// VPCStateDir is where the VPC driver keeps its locks, and caches. It's shared with titus-vpc-tool, so the GC, and
+	// the CLI see the executor's allocations.
+	VPCStateDir string
+	// VPCLimitsFile overrides, or adds to the built-in instance type limits of the VPC driver
+	VPCLimitsFile string
 	// Docker returns the Docker-specific configuration settings
 	DockerHost     string
 	DockerRegistry string
@@ -46,6 +73,14 @@ type Config struct { // nolint: maligned
 	LogUploadThresholdTime   time.Duration
 	LogUploadCheckInterval   time.Duration
 	StdioLogCheckInterval    time.Duration
+	// StdioRotateSize and LogMaxLocalBytes are the default log policy of tasks, which they can override through their env
+	StdioRotateSize  int64
+	LogMaxLocalBytes int64
+	LogCompression   string
+	// MaxStdioRotateSize and MaxLogUploadThresholdTime bound the overrides of tasks. LogMaxLocalBytes bounds them too,
+	// unless it's unlimited.
+	MaxStdioRotateSize        int64
+	MaxLogUploadThresholdTime time.Duration
 
 	// CopiedFromHost indicates which environment variables to lift from the current config
 	copiedFromHostEnv cli.StringSlice
@@ -54,6 +89,8 @@ type Config struct { // nolint: maligned
 	CopyUploaders cli.StringSlice
 	S3Uploaders   cli.StringSlice
 	NoopUploaders cli.StringSlice
+	// S3LogLocationsOnly makes tasks which specify their own S3 log locations upload only to them, and not to the global uploaders
+	S3LogLocationsOnly bool
 }
 
 // NewConfig generates a configuration and a set of flags to passed to urfave/cli
@@ -106,6 +143,11 @@ func NewConfig() (*Config, []cli.Flag) {
 			Destination: &cfg.StatusCheckFrequency,
 			Value:       defaultStatusCheckFrequency,
 		},
+		cli.DurationFlag{
+			Name:        "health-check-frequency",
+			Destination: &cfg.HealthCheckFrequency,
+			Value:       defaultHealthCheckFrequency,
+		},
 		cli.StringFlag{
 			Name:        "logs-tmp-dir",
 			Value:       defaultLogsTmpDir,
@@ -118,6 +160,28 @@ func NewConfig() (*Config, []cli.Flag) {
 			EnvVar:      "STACK,NETFLIX_STACK",
 			Destination: &cfg.Stack,
 		},
+		cli.StringFlag{
+			Name:        "container-runtime",
+			Value:       defaultContainerRuntime,
+			EnvVar:      "CONTAINER_RUNTIME",
+			Destination: &cfg.ContainerRuntime,
+		},
+		cli.StringFlag{
+			Name:        "task-lock-dir",
+			Value:       defaultTaskLockDir,
+			Destination: &cfg.TaskLockDir,
+		},
+		cli.StringFlag{
+			Name:        "vpc-state-dir",
+			Value:       vpc.DefaultStateDir,
+			EnvVar:      "VPC_STATE_DIR",
+			Destination: &cfg.VPCStateDir,
+		},
+		cli.StringFlag{
+			Name:        "vpc-limits-file",
+			EnvVar:      "VPC_LIMITS_FILE",
+			Destination: &cfg.VPCLimitsFile,
+		},
 		cli.StringFlag{
 			Name: "docker-host",
 			// In prod this is tcp://127.0.0.1:4243
@@ -150,6 +214,34 @@ func NewConfig() (*Config, []cli.Flag) {
 			Value:       defaultStdioLogCheckInterval,
 			Destination: &cfg.StdioLogCheckInterval,
 		},
+		cli.Int64Flag{
+			Name:        "stdio-rotate-size",
+			Value:       defaultStdioRotateSize,
+			Destination: &cfg.StdioRotateSize,
+		},
+		cli.Int64Flag{
+			Name:        "log-max-local-bytes",
+			Usage:       "How many bytes of rotated stdio are kept on the host before they're uploaded early, 0 for unlimited",
+			Destination: &cfg.LogMaxLocalBytes,
+		},
+		cli.Int64Flag{
+			Name:        "max-stdio-rotate-size",
+			Value:       defaultMaxStdioRotateSize,
+			Usage:       "The largest stdio rotate size that tasks can ask for",
+			Destination: &cfg.MaxStdioRotateSize,
+		},
+		cli.DurationFlag{
+			Name:        "max-log-upload-threshold-time",
+			Value:       defaultMaxLogUploadThreshold,
+			Usage:       "The longest log upload threshold, and upload check interval that tasks can ask for",
+			Destination: &cfg.MaxLogUploadThresholdTime,
+		},
+		cli.StringFlag{
+			Name:        "log-compression",
+			Value:       "none",
+			Usage:       "How logs are compressed when they're uploaded, none, gzip, or zstd",
+			Destination: &cfg.LogCompression,
+		},
 		cli.StringSliceFlag{
 			Name:  "copied-from-host-env",
 			Value: &cfg.copiedFromHostEnv,
@@ -171,6 +263,10 @@ func NewConfig() (*Config, []cli.Flag) {
 			Name:  "noop-uploaders",
 			Value: &cfg.NoopUploaders,
 		},
+		cli.BoolFlag{
+			Name:        "s3-log-locations-only",
+			Destination: &cfg.S3LogLocationsOnly,
+		},
 	}
 
 	return cfg, flags
@@ -242,6 +338,11 @@ func addElementFromHost(addTo map[string]string, hostEnvVarName string, containe
 	}
 }
 
+// GetUserProvidedEnvForTask returns the task's user and Titus provided env, without any of the env the executor adds
+func (c *Config) GetUserProvidedEnvForTask(taskInfo *titus.ContainerInfo) map[string]string {
+	return c.getUserProvided(taskInfo)
+}
+
 // Merge user and titus provided ENV vars
 func (c *Config) getUserProvided(taskInfo *titus.ContainerInfo) map[string]string {
 	var (
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	titusproto "github.com/Netflix/titus-executor/api/netflix/titus"
+	"github.com/Netflix/titus-executor/vpc"
 	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/assert"
 )
@@ -27,6 +28,11 @@ func TestDefaultDurations(t *testing.T) {
 	assert.Equal(t, cfg.Stack, "mainvpc")
 	assert.Equal(t, cfg.LogUploadThresholdTime, defaultLogUploadThreshold)
 	assert.Equal(t, cfg.LogUploadCheckInterval, defaultLogUploadCheckInterval)
+	assert.Equal(t, cfg.HealthCheckFrequency, defaultHealthCheckFrequency)
+	assert.Equal(t, cfg.ContainerRuntime, DockerContainerRuntime)
+	assert.Equal(t, cfg.TaskLockDir, defaultTaskLockDir)
+	assert.Equal(t, cfg.VPCStateDir, vpc.DefaultStateDir)
+	assert.Equal(t, cfg.MaxLogUploadThresholdTime, defaultMaxLogUploadThreshold)
 
 }
 
@@ -0,0 +1,349 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Netflix/titus-executor/filesystems"
+	"github.com/Netflix/titus-executor/filesystems/xattr"
+	"github.com/Netflix/titus-executor/uploader"
+	log "github.com/sirupsen/logrus"
+)
+
+const tailChunkSize = 64 * 1024
+
+var (
+	// followPollInterval is how often a followed file is checked for new data.
+	followPollInterval = time.Second
+)
+
+// logSection is the part of a file which makes up a log file. Stdio files are made up of virtual files, and the
+// active part of the file, which all live in the same physical file, one after the other.
+type logSection struct {
+	file  *os.File
+	start int64
+	// length is -1 if the section goes until the end of the file
+	length int64
+	stdio  bool
+}
+
+// serveLogSection serves the log, honouring Range requests, or the tail, and follow parameters. Range requests are
+// ignored when tailing or following, since the end of the log isn't known.
+func serveLogSection(w http.ResponseWriter, r *http.Request, section *logSection) error {
+	query := r.URL.Query()
+	tail, ok := tailParameter(w, r)
+	if !ok {
+		return nil
+	}
+	follow := false
+	if followStr := query.Get("follow"); followStr != "" {
+		var err error
+		if follow, err = strconv.ParseBool(followStr); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid follow parameter: %q", followStr), http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	fi, err := section.file.Stat()
+	if err != nil {
+		log.Errorf("Could not stat %s because: %v", section.file.Name(), err)
+		return err
+	}
+	end := fi.Size()
+	if section.length >= 0 && section.start+section.length < end {
+		end = section.start + section.length
+	}
+	if section.start > end {
+		end = section.start
+	}
+
+	if tail < 0 && !follow {
+		http.ServeContent(w, r, "", fi.ModTime(), io.NewSectionReader(section.file, section.start, end-section.start))
+		return nil
+	}
+
+	pos := section.start
+	if tail >= 0 {
+		if pos, err = tailOffset(section.file, section.start, end, tail); err != nil {
+			log.Errorf("Could not find the last %d lines of %s because: %v", tail, section.file.Name(), err)
+			return err
+		}
+	}
+
+	if !follow {
+		if _, err = io.Copy(w, io.NewSectionReader(section.file, pos, end-pos)); err != nil {
+			log.Errorf("Error writing %s because: %v", section.file.Name(), err)
+		}
+		return nil
+	}
+
+	var out io.Writer = w
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		w.Header().Set("Content-Type", "text/event-stream")
+		out = &sseWriter{w: w}
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err = followLog(r.Context(), out, w, section, pos); err != nil {
+		log.Errorf("Error following %s because: %v", section.file.Name(), err)
+	}
+	return nil
+}
+
+// tailParameter returns the number of lines the client wants from the end of the log, or -1 if it wants all of it. If
+// the parameter's invalid, the client is told so, and false is returned.
+func tailParameter(w http.ResponseWriter, r *http.Request) (int, bool) {
+	tailStr := r.URL.Query().Get("tail")
+	if tailStr == "" {
+		return -1, true
+	}
+	tail, err := strconv.Atoi(tailStr)
+	if err != nil || tail < 0 {
+		http.Error(w, fmt.Sprintf("Invalid tail parameter: %q", tailStr), http.StatusBadRequest)
+		return 0, false
+	}
+	return tail, true
+}
+
+// serveCompressed serves a log file which the watcher compressed on the host after uploading it. Clients which accept
+// its encoding get it as it is, and everyone else gets it decompressed. Range requests aren't supported, since offsets
+// into the decompressed log aren't known without decompressing it, and neither is following, since it isn't written to
+// anymore.
+func serveCompressed(w http.ResponseWriter, r *http.Request, file *os.File, contentEncoding string) error {
+	tail, ok := tailParameter(w, r)
+	if !ok {
+		return nil
+	}
+
+	contentType := xattr.GetMimeType(file.Name())
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	if tail < 0 && acceptsEncoding(r.Header.Get("Accept-Encoding"), contentEncoding) {
+		w.Header().Set("Content-Encoding", contentEncoding)
+		if _, err := io.Copy(w, file); err != nil {
+			log.Errorf("Error writing %s because: %v", file.Name(), err)
+		}
+		return nil
+	}
+
+	decompressed, err := uploader.NewDecompressReader(file, contentEncoding)
+	if err != nil {
+		log.Errorf("Could not decompress %s because: %v", file.Name(), err)
+		return err
+	}
+	defer shouldCloseReader(file.Name(), decompressed)
+
+	if tail < 0 {
+		_, err = io.Copy(w, decompressed)
+	} else {
+		var lines [][]byte
+		if lines, err = lastLines(decompressed, tail); err == nil {
+			_, err = w.Write(bytes.Join(lines, nil))
+		}
+	}
+	if err != nil {
+		log.Errorf("Error writing %s because: %v", file.Name(), err)
+	}
+	return nil
+}
+
+// acceptsEncoding checks whether an Accept-Encoding header allows encoding. An entry naming the encoding takes precedence
+// over a "*" one, and entries with a quality value of 0 refuse it.
+func acceptsEncoding(header, encoding string) bool {
+	accepted, wildcard := false, false
+	for _, entry := range strings.Split(header, ",") {
+		params := strings.Split(entry, ";")
+		coding := strings.TrimSpace(params[0])
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) < 2 || !strings.EqualFold(param[:2], "q=") {
+				continue
+			}
+			var err error
+			if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+				q = 0
+			}
+		}
+		switch {
+		case strings.EqualFold(coding, encoding):
+			return q > 0
+		case coding == "*":
+			accepted, wildcard = q > 0, true
+		}
+	}
+	return wildcard && accepted
+}
+
+// lastLines returns the last n lines read from r, without holding more than n lines in memory
+func lastLines(r io.Reader, n int) ([][]byte, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	lines := make([][]byte, 0, n)
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if len(lines) == n {
+				lines = append(lines[:0], lines[1:]...)
+			}
+			lines = append(lines, line)
+		}
+		if err == io.EOF {
+			return lines, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func shouldCloseReader(name string, r io.Closer) {
+	if err := r.Close(); err != nil {
+		log.Errorf("Could not close reader of %s because %v", name, err)
+	}
+}
+
+// tailOffset returns where the last n lines between start, and end begin
+func tailOffset(r io.ReaderAt, start, end int64, n int) (int64, error) {
+	if n == 0 {
+		return end, nil
+	}
+
+	buf := make([]byte, tailChunkSize)
+	lines := 0
+	pos := end
+	for pos > start {
+		readSize := int64(tailChunkSize)
+		if pos-start < readSize {
+			readSize = pos - start
+		}
+		pos -= readSize
+		if _, err := r.ReadAt(buf[:readSize], pos); err != nil && err != io.EOF {
+			return 0, err
+		}
+		chunk := buf[:readSize]
+		for idx := bytes.LastIndexByte(chunk, '\n'); idx != -1; idx = bytes.LastIndexByte(chunk, '\n') {
+			chunk = chunk[:idx]
+			// A trailing newline ends the last line, rather than starting a new one
+			if pos+int64(idx) == end-1 {
+				continue
+			}
+			lines++
+			if lines == n {
+				return pos + int64(idx) + 1, nil
+			}
+		}
+	}
+
+	return start, nil
+}
+
+// followLog copies the file from pos onwards, as it's written to, until the client goes away, or the file is removed.
+// Stdio files are followed by their physical offset, which carries on across rotations, and virtual file boundaries.
+func followLog(ctx context.Context, w io.Writer, rw http.ResponseWriter, section *logSection, pos int64) error {
+	flusher, _ := rw.(http.Flusher)
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+
+	for {
+		fi, err := section.file.Stat()
+		if err != nil {
+			return err
+		}
+		size := fi.Size()
+		if section.stdio {
+			// The watcher reclaims uploaded virtual files by punching holes in them. Rather than reading those back as
+			// zeroes, skip ahead to the oldest data that's still around.
+			if earliest, ok := earliestStdioOffset(section.file); ok && pos < earliest {
+				pos = earliest
+			}
+		} else if size < pos {
+			// The file was truncated
+			pos = 0
+		}
+
+		if size > pos {
+			n, err := io.Copy(w, io.NewSectionReader(section.file, pos, size-pos))
+			pos += n
+			if err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if !stillExists(section.file, fi) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// earliestStdioOffset returns where the oldest data in a stdio file which hasn't been reclaimed starts
+func earliestStdioOffset(file *os.File) (int64, bool) {
+	earliest, err := filesystems.GetCurrentOffset(file)
+	if err != nil {
+		return 0, false
+	}
+	xattrList, err := xattr.FListXattrs(file)
+	if err != nil {
+		return 0, false
+	}
+	for xattrKey := range xattrList {
+		if !strings.HasPrefix(xattrKey, filesystems.VirtualFilePrefixWithSeparator) {
+			continue
+		}
+		if start, _, err := filesystems.FetchStartAndLen(xattrKey, file); err == nil && start < earliest {
+			earliest = start
+		}
+	}
+	return earliest, true
+}
+
+// stillExists checks if the file is still at the path it was opened from. Files that aren't are rotated, or belong to
+// a container which was cleaned up, and won't be written to anymore.
+func stillExists(file *os.File, fi os.FileInfo) bool {
+	pathFi, err := os.Stat(file.Name())
+	return err == nil && os.SameFile(fi, pathFi)
+}
+
+// sseWriter frames the log as server-sent events, with one event per line. Partial lines are held back until they're
+// finished.
+type sseWriter struct {
+	w       io.Writer
+	partial []byte
+}
+
+func (s *sseWriter) Write(p []byte) (int, error) {
+	s.partial = append(s.partial, p...)
+	for {
+		idx := bytes.IndexByte(s.partial, '\n')
+		if idx == -1 {
+			return len(p), nil
+		}
+		if _, err := fmt.Fprintf(s.w, "data: %s\n\n", bytes.TrimSuffix(s.partial[:idx], []byte{'\r'})); err != nil {
+			return 0, err
+		}
+		s.partial = s.partial[idx+1:]
+	}
+}
@@ -0,0 +1,329 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix/titus-executor/darion/conf"
+	"github.com/Netflix/titus-executor/filesystems/xattr"
+	"github.com/Netflix/titus-executor/uploader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailOffset(t *testing.T) {
+	data := strings.NewReader("a\nb\nc\n")
+	testCases := []struct {
+		n        int
+		expected int64
+	}{
+		{0, 6},
+		{1, 4},
+		{2, 2},
+		{3, 0},
+		{10, 0},
+	}
+	for _, tc := range testCases {
+		offset, err := tailOffset(data, 0, 6, tc.n)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, offset, "tail=%d", tc.n)
+	}
+
+	// Without a trailing newline, the last line is the partial one
+	offset, err := tailOffset(strings.NewReader("a\nb\nc"), 0, 5, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), offset)
+
+	// The offset can't be outside of the section
+	offset, err = tailOffset(strings.NewReader("a\nb\nc\n"), 2, 6, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), offset)
+}
+
+func TestReadLogsRange(t *testing.T) {
+	verifyFunc := func(resp *http.Response, t *testing.T) {
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "bytes 5-9/20", resp.Header.Get("Content-Range"))
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "is\na\n", string(data))
+	}
+	testReadLogsWithHeaders(verifyFunc, "/logs/Titus-fake-container?f=stdout", map[string]string{"Range": "bytes=5-9"}, t)
+}
+
+func TestReadLogsTail(t *testing.T) {
+	verifyFunc := func(resp *http.Response, t *testing.T) {
+		assert.Equal(t, "test\nfile\n", verifyHelper(resp, t))
+	}
+	testReadLogs(verifyFunc, "/logs/Titus-fake-container?f=stdout&tail=2", t)
+}
+
+func TestReadLogsInvalidTail(t *testing.T) {
+	verifyFunc := func(resp *http.Response, t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	testReadLogs(verifyFunc, "/logs/Titus-fake-container?f=stdout&tail=-1", t)
+}
+
+func TestReadVirtualFileLogsTail(t *testing.T) {
+	verifyFunc := func(resp *http.Response, t *testing.T) {
+		dataStr := verifyHelper(resp, t)
+		// The tail is of the virtual file, and not of the whole stdio file it's in
+		assert.Equal(t, 637, strings.Count(dataStr, "a"))
+		assert.Equal(t, 0, strings.Count(dataStr, "z"))
+	}
+	testReadLogs(verifyFunc, "/logs/Titus-fake-container?f=subdir/otherlogfile.testsuffix&tail=1", t)
+}
+
+func TestEarliestStdioOffset(t *testing.T) {
+	fout, err := os.Open("testdata/Titus-fake-container/logs/subdir/otherlogfile")
+	require.NoError(t, err)
+	defer shouldClose(fout)
+
+	earliest, ok := earliestStdioOffset(fout)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), earliest)
+}
+
+func TestFollowLogs(t *testing.T) {
+	oldFollowPollInterval := followPollInterval
+	followPollInterval = 10 * time.Millisecond
+	defer func() {
+		followPollInterval = oldFollowPollInterval
+	}()
+
+	dir, err := ioutil.TempDir("", "darion-follow")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "Titus-follow", "logs", "app.log")
+	require.NoError(t, os.MkdirAll(filepath.Dir(logFile), 0755))
+	require.NoError(t, ioutil.WriteFile(logFile, []byte("first\n"), 0644))
+
+	conf.ContainersHome = dir
+	defer func() {
+		conf.ContainersHome = "testdata"
+	}()
+	r := http.NewServeMux()
+	r.HandleFunc("/logs/", LogHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"/logs/Titus-follow?f=app.log&follow=true", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer mustClose(resp.Body)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		blank, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "\n", blank)
+		return line
+	}
+	assert.Equal(t, "data: first\n", readEvent())
+
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("second\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, "data: second\n", readEvent())
+
+	// Once the file is gone, the stream ends
+	require.NoError(t, os.Remove(logFile))
+	rest, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
+
+func TestSSEWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &sseWriter{w: &buf}
+	_, err := w.Write([]byte("one\ntw"))
+	require.NoError(t, err)
+	assert.Equal(t, "data: one\n\n", buf.String())
+	_, err = w.Write([]byte("o\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "data: one\n\ndata: two\n\n", buf.String())
+}
+
+func testReadLogsWithHeaders(verifyFunc func(resp *http.Response, t *testing.T), path string, headers map[string]string, t *testing.T) {
+	conf.ContainersHome = "testdata"
+	r := http.NewServeMux()
+	r.HandleFunc("/logs/", LogHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+path, nil)
+	require.NoError(t, err)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer mustClose(resp.Body)
+
+	verifyFunc(resp, t)
+}
+
+func TestLastLines(t *testing.T) {
+	lines, err := lastLines(strings.NewReader("a\nb\nc\n"), 2)
+	require.NoError(t, err)
+	assert.Equal(t, "b\nc\n", string(bytes.Join(lines, nil)))
+
+	lines, err = lastLines(strings.NewReader("a\nb\nc"), 5)
+	require.NoError(t, err)
+	assert.Equal(t, "a\nb\nc", string(bytes.Join(lines, nil)))
+
+	lines, err = lastLines(strings.NewReader("a\nb\nc\n"), 0)
+	require.NoError(t, err)
+	assert.Empty(t, lines)
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for header, accepted := range map[string]bool{
+		"":                      false,
+		"gzip":                  true,
+		"deflate, GZIP":         true,
+		"gzip;q=0.5":            true,
+		"gzip; q=0":             false,
+		"gzip;q=0.0, deflate":   false,
+		"x-gzip":                false,
+		"*":                     true,
+		"*;q=0":                 false,
+		"gzip;q=0, *":           false,
+		"*;q=0, gzip;q=1":       true,
+		"deflate, identity;q=1": false,
+	} {
+		assert.Equal(t, accepted, acceptsEncoding(header, "gzip"), header)
+	}
+}
+
+func TestReadCompressedLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "darion-compressed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "Titus-compressed", "logs", "app.log.gz")
+	require.NoError(t, os.MkdirAll(filepath.Dir(logFile), 0755))
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	_, err = gz.Write([]byte("first\nsecond\nthird\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, ioutil.WriteFile(logFile, buf.Bytes(), 0644))
+	require.NoError(t, xattr.SetXattr(logFile, xattr.ContentEncodingAttr, []byte(uploader.ContentEncodingGzip)))
+
+	conf.ContainersHome = dir
+	defer func() {
+		conf.ContainersHome = "testdata"
+	}()
+	r := http.NewServeMux()
+	r.HandleFunc("/logs/", LogHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	get := func(path string, client *http.Client) (*http.Response, string) {
+		resp, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		defer mustClose(resp.Body)
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(data)
+	}
+	noGzipClient := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	// The file can be fetched by the name it was written under, or the one it was compressed to
+	for _, path := range []string{"/logs/Titus-compressed?f=app.log", "/logs/Titus-compressed?f=app.log.gz"} {
+		resp, data := get(path, noGzipClient)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "first\nsecond\nthird\n", data)
+	}
+
+	// Clients which accept gzip get it as it is
+	resp, data := get("/logs/Titus-compressed?f=app.log", http.DefaultClient)
+	assert.True(t, resp.Uncompressed)
+	assert.Equal(t, "first\nsecond\nthird\n", data)
+
+	// Clients which refuse gzip get it decompressed
+	req, err := http.NewRequest("GET", server.URL+"/logs/Titus-compressed?f=app.log", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip;q=0, identity")
+	resp, err = noGzipClient.Do(req)
+	require.NoError(t, err)
+	defer mustClose(resp.Body)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\nthird\n", string(body))
+
+	_, data = get("/logs/Titus-compressed?f=app.log&tail=2", http.DefaultClient)
+	assert.Equal(t, "second\nthird\n", data)
+}
+
+func TestReadZstdCompressedLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "darion-compressed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "Titus-compressed", "logs", "app.log.zst")
+	require.NoError(t, os.MkdirAll(filepath.Dir(logFile), 0755))
+	buf := &bytes.Buffer{}
+	zw, err := uploader.NewCompressWriter(buf, uploader.ContentEncodingZstd)
+	require.NoError(t, err)
+	_, err = zw.Write([]byte("first\nsecond\nthird\n"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, ioutil.WriteFile(logFile, buf.Bytes(), 0644))
+	require.NoError(t, xattr.SetXattr(logFile, xattr.ContentEncodingAttr, []byte(uploader.ContentEncodingZstd)))
+
+	conf.ContainersHome = dir
+	defer func() {
+		conf.ContainersHome = "testdata"
+	}()
+	r := http.NewServeMux()
+	r.HandleFunc("/logs/", LogHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	get := func(path, acceptEncoding string) (*http.Response, []byte) {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := (&http.Client{Transport: &http.Transport{DisableCompression: true}}).Do(req)
+		require.NoError(t, err)
+		defer mustClose(resp.Body)
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, data
+	}
+
+	// Clients which don't accept zstd get it decompressed, by either name
+	for _, path := range []string{"/logs/Titus-compressed?f=app.log", "/logs/Titus-compressed?f=app.log.zst"} {
+		resp, data := get(path, "gzip")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "first\nsecond\nthird\n", string(data))
+	}
+
+	// Clients which accept zstd get it as it is
+	resp, data := get("/logs/Titus-compressed?f=app.log", "gzip, zstd")
+	assert.Equal(t, uploader.ContentEncodingZstd, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, buf.Bytes(), data)
+
+	_, data = get("/logs/Titus-compressed?f=app.log&tail=2", "zstd")
+	assert.Equal(t, "second\nthird\n", string(data))
+}
@@ -1,10 +1,12 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"html/template"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -14,11 +16,14 @@ import (
 	"github.com/Netflix/titus-executor/darion/conf"
 	"github.com/Netflix/titus-executor/filesystems"
 	"github.com/Netflix/titus-executor/filesystems/xattr"
+	"github.com/Netflix/titus-executor/uploader"
 	log "github.com/sirupsen/logrus"
 )
 
 var logsExp = regexp.MustCompile(`/logs/(.*)`)
 var listLogsExp = regexp.MustCompile(`/listlogs/(.*)`)
+var apiListLogsExp = regexp.MustCompile(`/api/v1/listlogs/(.*)`)
+var apiLogMetadataExp = regexp.MustCompile(`/api/v1/logmetadata/(.*)`)
 
 // LogHandler is an HTTP handler that handles the /logs/:containerid/... endpoint, and fetches a file on the client's behalf
 func LogHandler(w http.ResponseWriter, r *http.Request) {
@@ -41,6 +46,20 @@ func logHandler(w http.ResponseWriter, r *http.Request, containerID, fileName st
 	filePath := filepath.Join(conf.ContainersHome, containerID, "logs", fileName)
 	fout, err := os.Open(filePath)
 	if os.IsNotExist(err) {
+		// Log files which the watcher compressed on the host are still served by the name they were written under
+		for _, contentEncoding := range uploader.ContentEncodings {
+			compressed, compressedErr := os.Open(filePath + uploader.Extension(contentEncoding))
+			if compressedErr != nil {
+				continue
+			}
+			defer shouldClose(compressed)
+			if xattr.GetContentEncoding(compressed.Name()) == contentEncoding {
+				if err = serveCompressed(w, r, compressed, contentEncoding); err != nil {
+					http.Error(w, fmt.Sprint("Error reading file: ", err), 404)
+				}
+				return
+			}
+		}
 		err = maybeVirtualFileStdioLogHandler(w, r, containerID, fileName)
 		if err != nil {
 			http.Error(w, fmt.Sprint("Error reading file: ", err), 404)
@@ -90,7 +109,7 @@ func buildVirtualFileMapping(containerID, uriFileName string) map[string]virtual
 	return virtualFilemapping
 }
 
-func maybeVirtualFileStdioLogHandler(w io.Writer, r *http.Request, containerID, uriFileName string) error {
+func maybeVirtualFileStdioLogHandler(w http.ResponseWriter, r *http.Request, containerID, uriFileName string) error {
 	virtualFilemapping := buildVirtualFileMapping(containerID, uriFileName)
 
 	mapping, ok := virtualFilemapping[path.Base(uriFileName)]
@@ -112,156 +131,114 @@ func maybeVirtualFileStdioLogHandler(w io.Writer, r *http.Request, containerID,
 		return err
 	}
 
-	_, err = fout.Seek(offset, io.SeekStart)
-	if err != nil {
-		log.Errorf("Error seeking in %s because: %v", fout.Name(), err)
-		return err
-	}
-
-	_, err = io.CopyN(w, fout, length)
-	if err != nil {
-		log.Errorf("Error writing %s because: %v", fout.Name(), err)
-		return err
-	}
-
-	return nil
+	return serveLogSection(w, r, &logSection{file: fout, start: offset, length: length, stdio: true})
 }
 
-func logHandlerWithFile(w io.Writer, r *http.Request, fout *os.File) error {
-
+func logHandlerWithFile(w http.ResponseWriter, r *http.Request, fout *os.File) error {
 	if filesystems.CheckFDForStdio(fout) {
 		return stdioLogHandlerWithFile(w, r, fout)
 	}
-	_, err := io.Copy(w, fout)
-	if err != nil {
-		log.Errorf("Error writing %s because: %v", fout.Name(), err)
+	if contentEncoding := xattr.GetContentEncoding(fout.Name()); uploader.Extension(contentEncoding) != "" {
+		return serveCompressed(w, r, fout, contentEncoding)
 	}
 
-	return nil
+	return serveLogSection(w, r, &logSection{file: fout, length: -1})
 }
 
-func stdioLogHandlerWithFile(w io.Writer, r *http.Request, fout *os.File) error {
+func stdioLogHandlerWithFile(w http.ResponseWriter, r *http.Request, fout *os.File) error {
 	// Do stdio handler path
 	currentOffset, err := filesystems.GetCurrentOffset(fout)
 	if err != nil {
 		log.Errorf("Error getting current offest for %s because %v", fout.Name(), err)
 		return err
 	}
-	_, err = fout.Seek(currentOffset, io.SeekStart)
-	if err != nil {
-		log.Errorf("Error seeking in %s because: %v", fout.Name(), err)
-		return err
-	}
-	_, err = io.Copy(w, fout)
-	if err != nil {
-		log.Errorf("Error writing %s because: %v", fout.Name(), err)
-	}
-	return nil
+
+	return serveLogSection(w, r, &logSection{file: fout, start: currentOffset, length: -1, stdio: true})
 }
 
 // ListLogsHandler handles the /listlogs/:containerid/... endpoint and enumerates the files, and subdirectories of /logs for a given container
 func ListLogsHandler(w http.ResponseWriter, r *http.Request) {
-	matchResult := listLogsExp.FindStringSubmatch(r.URL.Path)
-
-	if len(matchResult) < 2 || matchResult[1] == "" {
-		http.Error(w, "Invalid URI", 404)
+	listing, ok := logListingForRequest(w, r, listLogsExp)
+	if !ok {
 		return
 	}
 
-	containerID := matchResult[1]
-	dirName := buildLogLocationBase(containerID)
-
-	fileList := []string{}
-	err := filepath.Walk(dirName, func(path string, f os.FileInfo, err error) error {
-		if f != nil && !f.IsDir() {
-			fileList = append(fileList, path)
-		}
-		return nil
-	})
+	w.Header().Set("Content-Type", "text/html")
+	if err := listLogsTemplate.Execute(w, listing); err != nil {
+		log.Error("Unable to list logs, error while writing response: ", err)
+	}
+}
 
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "No Log files are present", 404)
+// ListLogsJSONHandler handles the /api/v1/listlogs/:containerid endpoint, and describes the log files of a container
+func ListLogsJSONHandler(w http.ResponseWriter, r *http.Request) {
+	listing, ok := logListingForRequest(w, r, apiListLogsExp)
+	if !ok {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html")
-
-	err = writeResponse(w, containerID, fileList)
-	if err != nil {
-		log.Error("Unable to list logs, error while writing response: ", err)
-	}
+	writeJSON(w, listing)
 }
 
-func writeResponse(w io.Writer, containerID string, fileList []string) error {
-	if _, err := fmt.Fprintf(w, "<html>"); err != nil {
-		return err
+// LogMetadataHandler handles the /api/v1/logmetadata/:containerid?f=... endpoint, and describes a single log file, or virtual file
+func LogMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	listing, ok := logListingForRequest(w, r, apiLogMetadataExp)
+	if !ok {
+		return
 	}
-	if _, err := fmt.Fprintf(w, "<h4>Log files for %v</h4>", containerID); err != nil {
-		return err
+
+	fileName := r.URL.Query().Get("f")
+	if fileName == "" {
+		fileName = "stdout"
 	}
-	if _, err := fmt.Fprintf(w, "<ul>"); err != nil {
-		return err
+	logFile := listing.find(path.Clean(fileName))
+	if logFile == nil {
+		http.Error(w, "Log file not found", 404)
+		return
 	}
 
-	for _, fileName := range fileList {
-		if filesystems.CheckFileForStdio(fileName) {
-			if err := writeStdioVirtualLinks(containerID, fileName, w); err != nil {
-				return err
-			}
-		} else {
-			if _, err := fmt.Fprintf(w, buildLink(containerID, fileName)); err != nil {
-				return err
-			}
-		}
-	}
+	writeJSON(w, logFile)
+}
 
-	_, err := fmt.Fprintf(w, "</ul>")
+func logListingForRequest(w http.ResponseWriter, r *http.Request, exp *regexp.Regexp) (*LogListing, bool) {
+	matchResult := exp.FindStringSubmatch(r.URL.Path)
+	if len(matchResult) < 2 || matchResult[1] == "" {
+		http.Error(w, "Invalid URI", 404)
+		return nil, false
+	}
 
-	return err
+	listing, err := buildLogListing(matchResult[1])
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "No Log files are present", 404)
+		return nil, false
+	}
+	return listing, true
 }
 
-func buildLogLocationBase(containerID string) string {
-	return conf.ContainersHome + "/" + containerID + "/logs/"
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Unable to write JSON response: ", err)
+	}
 }
 
-func buildLink(containerID, fileName string) string {
-	base := buildLogLocationBase(containerID)
-	relFilePath, err := filepath.Rel(base, fileName)
-	if err == nil {
-		return fmt.Sprintf("<li><a href=\"/logs/%s?f=%s\">%s</a></li>\n", containerID, relFilePath, relFilePath)
-	}
-	return ""
+var listLogsTemplate = template.Must(template.New("listlogs").Funcs(template.FuncMap{"logURL": logURL}).Parse(`<html><h4>Log files for {{.ContainerID}}</h4><ul>
+{{- $containerID := .ContainerID}}
+{{- range .Files}}
+<li><a href="{{logURL $containerID .Name}}">{{.Name}}</a></li>
+{{- range .Segments}}
+<li><a href="{{logURL $containerID .Name}}">{{.Name}}</a></li>
+{{- end}}
+{{- end}}
+</ul>`))
+
+// logURL escapes the container ID, and the file name, which can have characters like & and # in it
+func logURL(containerID, fileName string) string {
+	return "/logs/" + url.PathEscape(containerID) + "?" + url.Values{"f": []string{fileName}}.Encode()
 }
 
-func writeStdioVirtualLinks(containerID, fileName string, w io.Writer) error {
-	// First build the "normal" file
-	base := buildLogLocationBase(containerID)
-	relFilePath, err := filepath.Rel(base, fileName)
-	if err == nil {
-		_, err = fmt.Fprintf(w, "<li><a href=\"/logs/%s?f=%s\">%s</a></li>\n", containerID, relFilePath, relFilePath)
-		if err != nil {
-			return err
-		}
-	}
-	// Then build the virtual files
-	xattrList, err := xattr.ListXattrs(fileName)
-	if err != nil {
-		log.Warning("Could not fetch xattr list for %s, because %v, not listing virtual files", fileName, err)
-		return nil
-	}
-	for xattrKey := range xattrList {
-		if !strings.HasPrefix(xattrKey, filesystems.VirtualFilePrefixWithSeparator) {
-			continue
-		}
-		virtualFileSuffix := strings.TrimPrefix(xattrKey, filesystems.VirtualFilePrefixWithSeparator)
-		virtualFileName := strings.Join([]string{path.Base(fileName), virtualFileSuffix}, ".")
-		_, err = fmt.Fprintf(w, `<li><a href="/logs/%s?f=%s">%s</a></li>`, containerID, virtualFileName, virtualFileName)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+func buildLogLocationBase(containerID string) string {
+	return conf.ContainersHome + "/" + containerID + "/logs/"
 }
 
 func shouldClose(file *os.File) {
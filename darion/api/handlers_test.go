@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -12,6 +13,8 @@ import (
 	"github.com/Netflix/titus-executor/filesystems"
 	"github.com/Netflix/titus-executor/filesystems/xattr"
 	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func init() {
@@ -44,10 +47,11 @@ func TestListLogs(t *testing.T) {
 		if !strings.Contains(dataStr, "stdout") {
 			t.Fatal("stdout not found")
 		}
-		if !strings.Contains(dataStr, `<a href="/logs/Titus-fake-container?f=subdir/otherlogfile">subdir/otherlogfile</a>`) {
+		if !strings.Contains(dataStr, `<a href="/logs/Titus-fake-container?f=subdir%2Fotherlogfile">subdir/otherlogfile</a>`) {
 			t.Fatal("base virtual file not found")
 		}
-		if !strings.Contains(dataStr, `<a href="/logs/Titus-fake-container?f=otherlogfile.testsuffix">otherlogfile.testsuffix</a>`) {
+		// Virtual files are listed next to their stdio file, so the link has to include its directory
+		if !strings.Contains(dataStr, `<a href="/logs/Titus-fake-container?f=subdir%2Fotherlogfile.testsuffix">subdir/otherlogfile.testsuffix</a>`) {
 			t.Fatal("extended virtual file found")
 		}
 	}
@@ -65,10 +69,64 @@ func TestListLogsBadURI(t *testing.T) {
 	testListLogs(verifyFun, "/listlogs", t)
 }
 
+func TestListLogsJSON(t *testing.T) {
+	verifyFun := func(resp *http.Response, t *testing.T) {
+		require.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+		var listing LogListing
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&listing))
+		assert.Equal(t, "Titus-fake-container", listing.ContainerID)
+
+		files := map[string]LogFile{}
+		for _, file := range listing.Files {
+			files[file.Name] = file
+		}
+		_, ok := files["stdout"]
+		require.True(t, ok)
+		assert.False(t, files["stdout"].Stdio)
+		assert.NotEqual(t, int64(0), files["stdout"].Size)
+
+		otherLogFile, ok := files["subdir/otherlogfile"]
+		require.True(t, ok)
+		assert.True(t, otherLogFile.Stdio)
+		assert.Equal(t, int64(638), otherLogFile.Offset)
+		assert.Equal(t, []LogFile{{
+			Name:   "subdir/otherlogfile.testsuffix",
+			Size:   638,
+			Parent: "subdir/otherlogfile",
+		}}, otherLogFile.Segments)
+	}
+	testListLogsHandler(verifyFun, "/api/v1/listlogs/", ListLogsJSONHandler, "/api/v1/listlogs/Titus-fake-container", t)
+}
+
+func TestLogMetadata(t *testing.T) {
+	verifyFun := func(resp *http.Response, t *testing.T) {
+		require.Equal(t, 200, resp.StatusCode)
+		var logFile LogFile
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&logFile))
+		assert.Equal(t, "subdir/otherlogfile.testsuffix", logFile.Name)
+		assert.Equal(t, "subdir/otherlogfile", logFile.Parent)
+		assert.False(t, logFile.Uploaded)
+	}
+	testListLogsHandler(verifyFun, "/api/v1/logmetadata/", LogMetadataHandler, "/api/v1/logmetadata/Titus-fake-container?f=subdir/otherlogfile.testsuffix", t)
+}
+
+func TestLogMetadataMissingFile(t *testing.T) {
+	verifyFun := func(resp *http.Response, t *testing.T) {
+		assert.Equal(t, 404, resp.StatusCode)
+	}
+	testListLogsHandler(verifyFun, "/api/v1/logmetadata/", LogMetadataHandler, "/api/v1/logmetadata/Titus-fake-container?f=missing", t)
+}
+
 func testListLogs(verifyFunc func(resp *http.Response, t *testing.T), path string, t *testing.T) {
+	testListLogsHandler(verifyFunc, "/listlogs/", ListLogsHandler, path, t)
+}
+
+func testListLogsHandler(verifyFunc func(resp *http.Response, t *testing.T), pattern string, handler http.HandlerFunc, path string, t *testing.T) {
 	conf.ContainersHome = "testdata"
 	r := http.NewServeMux()
-	r.HandleFunc("/listlogs/", ListLogsHandler)
+	r.HandleFunc(pattern, handler)
 	server := httptest.NewServer(r)
 	defer server.Close()
 
@@ -205,3 +263,9 @@ func mustClose(f io.Closer) {
 		panic(err)
 	}
 }
+
+func TestLogURL(t *testing.T) {
+	assert.Equal(t, "/logs/Titus-fake-container?f=subdir%2Fapp.log", logURL("Titus-fake-container", "subdir/app.log"))
+	assert.Equal(t, "/logs/Titus-fake-container?f=a%26tail%3D1+%231.log", logURL("Titus-fake-container", "a&tail=1 #1.log"))
+	assert.Equal(t, "/logs/Titus%2Ffake?f=stdout", logURL("Titus/fake", "stdout"))
+}
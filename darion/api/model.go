@@ -0,0 +1,147 @@
+package api
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Netflix/titus-executor/filesystems"
+	"github.com/Netflix/titus-executor/filesystems/xattr"
+	log "github.com/sirupsen/logrus"
+)
+
+// LogListing is the set of log files of a container
+type LogListing struct {
+	ContainerID string    `json:"containerId"`
+	Files       []LogFile `json:"files"`
+}
+
+// LogFile describes a log file, or a virtual file which was rotated out of a stdio file. Name is relative to the
+// container's log directory, and is what the /logs/ endpoint takes in its f parameter.
+type LogFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Stdio   bool      `json:"stdio,omitempty"`
+	// Offset is where the active part of a stdio file starts, or where a virtual file starts in its stdio file
+	Offset int64 `json:"offset,omitempty"`
+	// Parent is the stdio file that a virtual file is a part of
+	Parent string `json:"parent,omitempty"`
+	// Segments are the virtual files of a stdio file
+	Segments   []LogFile  `json:"segments,omitempty"`
+	Uploaded   bool       `json:"uploaded"`
+	UploadedAt *time.Time `json:"uploadedAt,omitempty"`
+	// ContentEncoding is set if the file was compressed on the host after it was uploaded. It's decompressed when it's
+	// served.
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+}
+
+// find returns the file, or virtual file with the given name
+func (l *LogListing) find(name string) *LogFile {
+	for idx := range l.Files {
+		file := &l.Files[idx]
+		if file.Name == name {
+			return file
+		}
+		for segmentIdx := range file.Segments {
+			if file.Segments[segmentIdx].Name == name {
+				return &file.Segments[segmentIdx]
+			}
+		}
+	}
+	return nil
+}
+
+func buildLogListing(containerID string) (*LogListing, error) {
+	base := buildLogLocationBase(containerID)
+	listing := &LogListing{ContainerID: containerID, Files: []LogFile{}}
+
+	err := filepath.Walk(base, func(fileName string, fi os.FileInfo, err error) error {
+		if fi == nil || fi.IsDir() {
+			return nil
+		}
+		relFilePath, err := filepath.Rel(base, fileName)
+		if err != nil {
+			return nil
+		}
+		logFile := LogFile{
+			Name:    relFilePath,
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		}
+		if filesystems.CheckFileForStdio(fileName) {
+			addStdioMetadata(fileName, &logFile)
+		} else {
+			logFile.UploadedAt = uploadedAt(fileName, filesystems.UploadedAttr)
+			logFile.ContentEncoding = xattr.GetContentEncoding(fileName)
+		}
+		logFile.Uploaded = logFile.UploadedAt != nil
+		listing.Files = append(listing.Files, logFile)
+		return nil
+	})
+
+	return listing, err
+}
+
+func addStdioMetadata(fileName string, logFile *LogFile) {
+	logFile.Stdio = true
+	logFile.UploadedAt = uploadedAt(fileName, filesystems.UploadedAttr)
+
+	fout, err := os.Open(fileName)
+	if err != nil {
+		log.Warningf("Could not open %s because %v, not listing virtual files", fileName, err)
+		return
+	}
+	defer shouldClose(fout)
+
+	if logFile.Offset, err = filesystems.GetCurrentOffset(fout); err != nil {
+		log.Warningf("Could not get current offset of %s because %v", fileName, err)
+	}
+
+	xattrList, err := xattr.FListXattrs(fout)
+	if err != nil {
+		log.Warningf("Could not fetch xattr list for %s, because %v, not listing virtual files", fileName, err)
+		return
+	}
+	for xattrKey := range xattrList {
+		if !strings.HasPrefix(xattrKey, filesystems.VirtualFilePrefixWithSeparator) {
+			continue
+		}
+		virtualFileSuffix := strings.TrimPrefix(xattrKey, filesystems.VirtualFilePrefixWithSeparator)
+		start, length, err := filesystems.FetchStartAndLen(xattrKey, fout)
+		if err != nil {
+			continue
+		}
+		segment := LogFile{
+			Name:       path.Join(path.Dir(logFile.Name), strings.Join([]string{path.Base(fileName), virtualFileSuffix}, ".")),
+			Size:       length,
+			Offset:     start,
+			Parent:     logFile.Name,
+			UploadedAt: uploadedAt(fileName, filesystems.UploadedAttrPrefixWithSeparator+virtualFileSuffix),
+		}
+		segment.Uploaded = segment.UploadedAt != nil
+		if creationTime, err := filesystems.VirtualFileCreationTime(virtualFileSuffix); err == nil {
+			segment.ModTime = creationTime
+		}
+		logFile.Segments = append(logFile.Segments, segment)
+	}
+	sort.Slice(logFile.Segments, func(i, j int) bool {
+		return logFile.Segments[i].Name < logFile.Segments[j].Name
+	})
+}
+
+func uploadedAt(fileName, xattrKey string) *time.Time {
+	value, err := xattr.GetXattr(fileName, xattrKey)
+	if err != nil {
+		return nil
+	}
+	t, err := time.Parse(filesystems.UploadedTimeFormat, string(value))
+	if err != nil {
+		log.Warningf("Could not parse upload time %q of %s", string(value), fileName)
+		return nil
+	}
+	return &t
+}
@@ -1,6 +1,8 @@
 package titusmesosdriver
 
 import (
+	"fmt"
+	"math"
 	"net"
 	"os"
 	"strconv"
@@ -167,6 +169,8 @@ func (driver *TitusMesosDriver) LaunchTask(exec mesosExecutor.ExecutorDriver, ta
 	// TODO(Andrew L): We should move this info to the protobuf
 	var mem, cpu int64
 	var disk uint64
+	var hostPorts []uint16
+	var err error
 	for _, r := range taskInfo.GetResources() {
 		if r.GetName() == "mem" {
 			mem = int64(r.GetScalar().GetValue())
@@ -174,14 +178,34 @@ func (driver *TitusMesosDriver) LaunchTask(exec mesosExecutor.ExecutorDriver, ta
 			cpu = int64(r.GetScalar().GetValue())
 		} else if r.GetName() == "disk" {
 			disk = uint64(r.GetScalar().GetValue())
+		} else if r.GetName() == "ports" {
+			if hostPorts, err = appendHostPorts(hostPorts, r.GetRanges().GetRange()); err != nil {
+				log.Printf("Invalid ports for task %s: %s", taskID, err)
+				driver.metrics.Counter("titus.executor.launchTaskFailed", 1, nil)
+				driver.ReportTitusTaskStatus(taskID, err.Error(), titusdriver.Lost, nil)
+				return
+			}
 		}
 	}
 
-	if err := driver.runner.StartTask(taskID, titusInfo, mem, cpu, disk); err != nil {
+	if err := driver.runner.StartTask(taskID, titusInfo, mem, cpu, disk, hostPorts); err != nil {
 		log.Printf("Failed to start task %s: %s", taskID, err)
 	}
 }
 
+// appendHostPorts appends the ports in the ranges, which must be within 1-65535
+func appendHostPorts(hostPorts []uint16, portRanges []*mesosproto.Value_Range) ([]uint16, error) {
+	for _, portRange := range portRanges {
+		if portRange.GetBegin() < 1 || portRange.GetEnd() > math.MaxUint16 || portRange.GetBegin() > portRange.GetEnd() {
+			return nil, fmt.Errorf("port range %d-%d is not within 1-%d", portRange.GetBegin(), portRange.GetEnd(), math.MaxUint16)
+		}
+		for port := portRange.GetBegin(); port <= portRange.GetEnd(); port++ {
+			hostPorts = append(hostPorts, uint16(port))
+		}
+	}
+	return hostPorts, nil
+}
+
 // KillTask kills a running task
 func (driver *TitusMesosDriver) KillTask(exec mesosExecutor.ExecutorDriver, taskID *mesosproto.TaskID) {
 	time.AfterFunc(10*time.Minute, func() {
@@ -237,6 +261,7 @@ func (driver *TitusMesosDriver) handleUpdate(update runner.Update) {
 		Message: protobuf.String(update.Mesg),
 		State:   titusToMesosTaskState(update.State).Enum().Enum(),
 		Data:    dataBytes,
+		Healthy: update.Healthy,
 	}
 
 	if _, err := driver.mesosDriver.SendStatusUpdate(mesosStatus); err != nil {
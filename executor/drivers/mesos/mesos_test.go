@@ -0,0 +1,24 @@
+package titusmesosdriver
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/mesos/mesos-go/mesosproto"
+	"github.com/stretchr/testify/assert"
+)
+
+func portRange(begin, end uint64) *mesosproto.Value_Range {
+	return &mesosproto.Value_Range{Begin: proto.Uint64(begin), End: proto.Uint64(end)}
+}
+
+func TestAppendHostPorts(t *testing.T) {
+	hostPorts, err := appendHostPorts(nil, []*mesosproto.Value_Range{portRange(31000, 31001), portRange(65535, 65535)})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{31000, 31001, 65535}, hostPorts)
+
+	for _, invalid := range []*mesosproto.Value_Range{portRange(0, 1), portRange(65535, 65536), portRange(31001, 31000)} {
+		_, err = appendHostPorts(nil, []*mesosproto.Value_Range{invalid})
+		assert.Error(t, err, invalid.String())
+	}
+}
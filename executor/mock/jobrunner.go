@@ -206,7 +206,7 @@ func (jobRunner *JobRunner) StartJob(jobInput *JobInput) *JobRunResponse {
 	// Get a reference to the executor and somewhere to stash results
 
 	// Start the task and wait for it to complete
-	err := jobRunner.runner.StartTask(taskID, ci, memMiB, cpu, diskMiB)
+	err := jobRunner.runner.StartTask(taskID, ci, memMiB, cpu, diskMiB, nil)
 	if err != nil {
 		log.Printf("Failed to start task %s: %s", taskID, err)
 	}
@@ -15,6 +15,7 @@ import (
 	"io/ioutil"
 
 	"github.com/Netflix/titus-executor/api/netflix/titus"
+	"github.com/Netflix/titus-executor/config"
 	"github.com/Netflix/titus-executor/executor/mock"
 	"github.com/Netflix/titus-executor/executor/runtime/docker"
 	"github.com/mesos/mesos-go/mesosproto"
@@ -23,7 +24,11 @@ import (
 	"gopkg.in/urfave/cli.v1"
 )
 
-var standalone bool
+var (
+	standalone bool
+	// containerRuntime is what the executor under test runs tasks with, which is picked in the same way
+	containerRuntime = os.Getenv("CONTAINER_RUNTIME")
+)
 
 func init() {
 	if debug, err := strconv.ParseBool(os.Getenv("DEBUG")); err == nil && debug {
@@ -32,7 +37,8 @@ func init() {
 	flag.BoolVar(&standalone, "standalone", false, "Enable standalone tests")
 	flag.Parse()
 	app := cli.NewApp()
-	app.Flags = docker.Flags
+	// The container runtime is picked with CONTAINER_RUNTIME, so both runtimes' flags need their defaults
+	app.Flags = append(append([]cli.Flag{}, docker.Flags...), docker.RuncFlags...)
 	app.Writer = ioutil.Discard
 	_ = app.Run(os.Args)
 }
@@ -75,6 +81,10 @@ func TestStandalone(t *testing.T) {
 	if !standalone {
 		t.Skipf("Standalone tests are not enabled! Activate with the -standalone cmdline flag.")
 	}
+	if containerRuntime == "" {
+		containerRuntime = config.DockerContainerRuntime
+	}
+	log.Infof("Running standalone tests against the %s container runtime", containerRuntime)
 	testFunctions := []func(*testing.T){
 		testSimpleJob,
 		testNoCapPtraceByDefault,
@@ -112,6 +122,13 @@ func makeTestParallel(f func(*testing.T)) func(*testing.T) {
 	}
 }
 
+// skipWithRunc skips tests of features the runc container runtime doesn't support
+func skipWithRunc(t *testing.T, reason string) {
+	if containerRuntime == config.RuncContainerRuntime {
+		t.Skip("Not supported by the runc container runtime: ", reason)
+	}
+}
+
 func testSimpleJob(t *testing.T) {
 	ji := &mock.JobInput{
 		ImageName:  alpine.name,
@@ -401,6 +418,7 @@ func testShutdown(t *testing.T) {
 }
 
 func testMetadataProxyInjection(t *testing.T) {
+	skipWithRunc(t, "without the VPC driver, containers share the host's network namespace, so there's nowhere to inject the proxy")
 	ji := &mock.JobInput{
 		ImageName:  ubuntu.name,
 		Version:    ubuntu.tag,
@@ -412,6 +430,7 @@ func testMetadataProxyInjection(t *testing.T) {
 }
 
 func testMetdataProxyDefaultRoute(t *testing.T) {
+	skipWithRunc(t, "without the VPC driver, containers share the host's network namespace, so there's nowhere to inject the proxy")
 	ji := &mock.JobInput{
 		ImageName:  ubuntu.name,
 		Version:    ubuntu.tag,
@@ -0,0 +1,149 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	"github.com/Netflix/titus-executor/models"
+	"github.com/gorilla/mux"
+)
+
+// maxStatusHistory is how many of the task's status updates are kept around for the API
+const maxStatusHistory = 100
+
+// startAPIServer binds an ephemeral listener on localhost for the task introspection API, and serves it until the
+// context is done. It returns the address of the listener.
+func (r *Runner) startAPIServer(ctx context.Context) (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+
+	srv := &http.Server{Handler: r.apiHandler()}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			r.logger.Warning("Introspection API server stopped: ", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		if err := srv.Close(); err != nil {
+			r.logger.Warning("Unable to shutdown introspection API server: ", err)
+		}
+	}()
+
+	return l.Addr().String(), nil
+}
+
+func (r *Runner) apiHandler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/get-current-state", r.getCurrentState).Methods("GET")
+	router.HandleFunc("/tasks/{taskID}", r.getTaskState).Methods("GET")
+	return router
+}
+
+func (r *Runner) getCurrentState(resp http.ResponseWriter, req *http.Request) {
+	r.RLock()
+	defer r.RUnlock()
+
+	state := models.CurrentState{Tasks: make(map[string]string)}
+	if r.taskState.TaskID != "" {
+		state.Tasks[r.taskState.TaskID] = r.taskState.State
+	}
+	writeJSON(resp, state)
+}
+
+func (r *Runner) getTaskState(resp http.ResponseWriter, req *http.Request) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.taskState.TaskID == "" || r.taskState.TaskID != mux.Vars(req)["taskID"] {
+		http.NotFound(resp, req)
+		return
+	}
+	writeJSON(resp, r.taskState)
+}
+
+func writeJSON(resp http.ResponseWriter, v interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(v); err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// recordTaskState updates the copy of the task's state that the API serves. The container itself isn't safe to
+// read concurrently, so the runner has to copy whatever changed into the task state as it goes.
+func (r *Runner) recordTaskState(f func(*models.TaskState)) {
+	r.Lock()
+	defer r.Unlock()
+	f(&r.taskState)
+}
+
+func (r *Runner) recordContainer(c *runtimeTypes.Container) {
+	r.recordTaskState(func(ts *models.TaskState) {
+		ts.TaskID = c.TaskID
+		ts.ContainerID = c.ID
+		ts.Resources = &models.TaskResources{
+			Mem:       c.Resources.Mem,
+			CPU:       c.Resources.CPU,
+			Disk:      c.Resources.Disk,
+			HostPorts: c.Resources.HostPorts,
+		}
+		ts.Allocation = models.TaskAllocation{
+			IPV4Address:    c.Allocation.IPV4Address,
+			IPV6Address:    c.Allocation.IPV6Address,
+			DeviceIndex:    c.Allocation.DeviceIndex,
+			ENI:            c.Allocation.ENI,
+			SecurityGroups: c.Allocation.SecurityGroups,
+		}
+		if c.GPUInfo != nil {
+			ts.GPUDevices = c.GPUInfo.Devices()
+		}
+	})
+}
+
+func (r *Runner) recordUpdate(update Update) {
+	r.recordTaskState(func(ts *models.TaskState) {
+		ts.State = update.State.String()
+		if update.Details != nil {
+			ts.Details = taskDetails(update.Details)
+		}
+		ts.Updates = append(ts.Updates, models.TaskStatus{
+			State:     update.State.String(),
+			Message:   update.Mesg,
+			Healthy:   update.Healthy,
+			Timestamp: time.Now(),
+		})
+		if len(ts.Updates) > maxStatusHistory {
+			ts.Updates = ts.Updates[len(ts.Updates)-maxStatusHistory:]
+		}
+	})
+}
+
+// taskDetails copies the runtime's details into the API's model of them
+func taskDetails(details *runtimeTypes.Details) *models.TaskDetails {
+	ret := &models.TaskDetails{IPAddresses: details.IPAddresses}
+	if nc := details.NetworkConfiguration; nc != nil {
+		ret.NetworkConfiguration = &models.TaskNetworkConfiguration{
+			IsRoutableIP:   nc.IsRoutableIP,
+			IPAddress:      nc.IPAddress,
+			EniIPAddress:   nc.EniIPAddress,
+			EniIPv6Address: nc.EniIPv6Address,
+			EniID:          nc.EniID,
+			ResourceID:     nc.ResourceID,
+			SecurityGroups: nc.SecurityGroups,
+		}
+	}
+	for _, portMapping := range details.PortMappings {
+		ret.PortMappings = append(ret.PortMappings, models.TaskPortMapping{
+			HostPort:      portMapping.HostPort,
+			ContainerPort: portMapping.ContainerPort,
+			Protocol:      portMapping.Protocol,
+		})
+	}
+	return ret
+}
@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Netflix/titus-executor/executor/drivers"
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	"github.com/Netflix/titus-executor/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospectionAPI(t *testing.T) {
+	r := &Runner{logger: logrus.NewEntry(logrus.StandardLogger())}
+	r.recordContainer(&runtimeTypes.Container{
+		ID:        "container-id",
+		TaskID:    "Titus-123",
+		Resources: &runtimeTypes.Resources{Mem: 1024, CPU: 2},
+	})
+	details := &runtimeTypes.Details{
+		IPAddresses:          map[string]string{"nfvpc": "1.2.3.4"},
+		NetworkConfiguration: &runtimeTypes.NetworkConfigurationDetails{IsRoutableIP: true, EniID: "eni-1", ResourceID: "resource-eni-0", SecurityGroups: []string{"sg-1"}},
+		PortMappings:         []runtimeTypes.PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: runtimeTypes.ProtocolUDP}},
+	}
+	r.recordUpdate(Update{TaskID: "Titus-123", State: titusdriver.Starting, Mesg: "starting"})
+	r.recordUpdate(Update{TaskID: "Titus-123", State: titusdriver.Running, Mesg: "running", Details: details})
+
+	handler := r.apiHandler()
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/get-current-state", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	var currentState models.CurrentState
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&currentState))
+	assert.Equal(t, map[string]string{"Titus-123": "TASK_RUNNING"}, currentState.Tasks)
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/tasks/Titus-123", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	var taskState models.TaskState
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&taskState))
+	assert.Equal(t, "container-id", taskState.ContainerID)
+	assert.Equal(t, "TASK_RUNNING", taskState.State)
+	assert.Equal(t, int64(1024), taskState.Resources.Mem)
+	assert.Equal(t, details.IPAddresses, taskState.Details.IPAddresses)
+	require.NotNil(t, taskState.Details.NetworkConfiguration)
+	assert.Equal(t, "eni-1", taskState.Details.NetworkConfiguration.EniID)
+	assert.Equal(t, []string{"sg-1"}, taskState.Details.NetworkConfiguration.SecurityGroups)
+	assert.Equal(t, []models.TaskPortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "udp"}}, taskState.Details.PortMappings)
+	require.Len(t, taskState.Updates, 2)
+	assert.Equal(t, "starting", taskState.Updates[0].Message)
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/tasks/Titus-456", nil))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Netflix/titus-executor/executor/drivers"
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+)
+
+const (
+	// HealthyMessage is the status message we send to the master when a task's health check recovers
+	HealthyMessage = "healthy"
+	// UnhealthyMessage is the prefix of the status message we send to the master when a task's health check fails
+	UnhealthyMessage = "unhealthy"
+)
+
+// healthState tracks the consecutive health check failures of a task. A maxFailures of 0 means
+// that failures are only reported, and never fail the task.
+type healthState struct {
+	consecutiveFailures uint32
+	maxFailures         uint32
+}
+
+func (r *Runner) shouldHealthCheck() bool {
+	return len(r.container.TitusInfo.GetHealthCheckCmd()) > 0 && r.config.HealthCheckFrequency > 0
+}
+
+// healthCheckLoop runs the health check on every tick, and sends the result on the results channel until the context is done
+func (r *Runner) healthCheckLoop(ctx context.Context, results chan<- error) {
+	ticks := time.NewTicker(r.config.HealthCheckFrequency)
+	defer ticks.Stop()
+
+	for {
+		select {
+		case <-ticks.C:
+			healthCheckStartTime := time.Now()
+			err := r.runtime.HealthCheck(ctx, r.container)
+			r.metrics.Timer("titus.executor.healthCheckTime", time.Since(healthCheckStartTime), r.container.ImageTagForMetrics())
+			select {
+			case results <- err:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleHealthCheckResult reports changes in the task's health, and returns true if the task should be failed
+func (r *Runner) handleHealthCheckResult(ctx context.Context, hs *healthState, err error, details *runtimeTypes.Details) bool {
+	if err == nil {
+		if hs.consecutiveFailures > 0 {
+			r.logger.WithField("consecutiveFailures", hs.consecutiveFailures).Info("Health check recovered")
+			hs.consecutiveFailures = 0
+			r.updateHealth(ctx, true, HealthyMessage, details)
+		}
+		return false
+	}
+
+	hs.consecutiveFailures++
+	r.metrics.Counter("titus.executor.healthCheckFailed", 1, r.container.ImageTagForMetrics())
+	r.logger.WithField("consecutiveFailures", hs.consecutiveFailures).Warning("Health check failed: ", err)
+
+	if hs.maxFailures > 0 && hs.consecutiveFailures > hs.maxFailures {
+		r.metrics.Counter("titus.executor.healthCheckTaskFailed", 1, r.container.ImageTagForMetrics())
+		msg := fmt.Sprintf("Health check failed %d consecutive times, exceeding the maximum of %d: %s", hs.consecutiveFailures, hs.maxFailures, err)
+		r.updateStatus(ctx, titusdriver.Failed, msg)
+		return true
+	}
+
+	// Only report the transition from healthy to unhealthy, so we don't flood the master with updates
+	if hs.consecutiveFailures == 1 {
+		r.updateHealth(ctx, false, fmt.Sprintf("%s: %s", UnhealthyMessage, err), details)
+	}
+	return false
+}
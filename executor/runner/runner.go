@@ -18,20 +18,34 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/Netflix/titus-executor/api/netflix/titus"
 	"github.com/Netflix/titus-executor/config"
 	"github.com/Netflix/titus-executor/executor/drivers"
 	"github.com/Netflix/titus-executor/executor/metatron"
 	"github.com/Netflix/titus-executor/filesystems"
+	"github.com/Netflix/titus-executor/fslocker"
 	"github.com/Netflix/titus-executor/models"
+	"github.com/Netflix/titus-executor/procinfo"
 	"github.com/sirupsen/logrus"
 )
 
 // WaitingOnLaunchguardMessage is the status message we send to the master while we wait for launchguard
 const WaitingOnLaunchguardMessage = "waiting_on_launchguard"
+
+// LaunchguardResultMessagePrefix starts the status message we send to the master with why launchguard let us launch,
+// if we had to wait on it, or it didn't let us launch cleanly
+const LaunchguardResultMessagePrefix = "launchguard: "
+
+// PullingImageMessagePrefix starts the status messages we send to the master with the progress of the image pull
+const PullingImageMessagePrefix = "pulling_image: "
 const waitForTaskTimeout = 5 * time.Minute
 
+// newS3Uploader is a var so tests can avoid talking to AWS
+var newS3Uploader = uploader.NewS3UploaderWithPrefix
+
 var (
 	errorRunnerAlreadyStarted = errors.New("Runner already started task or not available")
 	errorTaskWaitTimeout      = errors.New("Runner timed out waiting for task")
@@ -45,6 +59,7 @@ type task struct {
 	mem       int64
 	cpu       int64
 	disk      uint64
+	hostPorts []uint16
 }
 
 // Runner maintains in memory state for the task runner
@@ -58,6 +73,7 @@ type Runner struct { // nolint: maligned
 
 	container *runtimeTypes.Container
 	watcher   *filesystems.Watcher
+	logPolicy filesystems.LogPolicy
 
 	// TODO: Remove
 	logUploaders *uploader.Uploaders
@@ -71,17 +87,29 @@ type Runner struct { // nolint: maligned
 	StoppedChan chan struct{}
 	UpdatesChan chan Update
 	lastStatus  titusdriver.TitusTaskState
+	// taskState is what the introspection API serves, and it's protected by the RWMutex
+	taskState models.TaskState
 }
 
 // RuntimeProvider is a factory function for runtime implementations. It is called only once by WithRuntime
 type RuntimeProvider func(context.Context, config.Config) (runtimeTypes.Runtime, error)
 
-// New constructs a new Executor object with the default (docker) runtime
+// New constructs a new Executor object with the runtime selected by the config
 func New(ctx context.Context, m metrics.Reporter, logUploaders *uploader.Uploaders, cfg config.Config) (*Runner, error) {
-	dockerRuntime := func(ctx context.Context, cfg config.Config) (runtimeTypes.Runtime, error) {
-		return docker.NewDockerRuntime(ctx, m, cfg)
+	var rp RuntimeProvider
+	switch cfg.ContainerRuntime {
+	case config.DockerContainerRuntime:
+		rp = func(ctx context.Context, cfg config.Config) (runtimeTypes.Runtime, error) {
+			return docker.NewDockerRuntime(ctx, m, cfg)
+		}
+	case config.RuncContainerRuntime:
+		rp = func(ctx context.Context, cfg config.Config) (runtimeTypes.Runtime, error) {
+			return docker.NewRuncRuntime(ctx, m, cfg)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown container runtime: %s", cfg.ContainerRuntime)
 	}
-	return WithRuntime(ctx, m, dockerRuntime, logUploaders, cfg)
+	return WithRuntime(ctx, m, rp, logUploaders, cfg)
 }
 
 // WithRuntime builds an Executor using the provided Runtime factory func
@@ -118,7 +146,7 @@ func WithRuntime(ctx context.Context, m metrics.Reporter, rp RuntimeProvider, lo
 }
 
 // StartTask can be called once to start a task, by a given Runner
-func (r *Runner) StartTask(taskID string, titusInfo *titus.ContainerInfo, mem int64, cpu int64, disk uint64) error {
+func (r *Runner) StartTask(taskID string, titusInfo *titus.ContainerInfo, mem int64, cpu int64, disk uint64, hostPorts []uint16) error {
 	// This can only be called once!
 	t := task{
 		taskID:    taskID,
@@ -126,6 +154,7 @@ func (r *Runner) StartTask(taskID string, titusInfo *titus.ContainerInfo, mem in
 		mem:       mem,
 		cpu:       cpu,
 		disk:      disk,
+		hostPorts: hostPorts,
 	}
 	select {
 	case r.taskChan <- t:
@@ -140,6 +169,13 @@ func (r *Runner) StartTask(taskID string, titusInfo *titus.ContainerInfo, mem in
 	}
 }
 
+// setErr records why the runner stopped, for StartTask to return
+func (r *Runner) setErr(err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.err = err
+}
+
 // Kill is idempotent, and will either kill a task, or prevent a new one from being spawned
 func (r *Runner) Kill() {
 	r.killOnce.Do(func() {
@@ -165,9 +201,7 @@ func (r *Runner) startRunner(parentCtx context.Context, setupCh chan error, rp R
 
 	r.logger = r.logger.WithField("taskID", taskConfig.taskID)
 	if err != nil {
-		r.Lock()
-		defer r.Unlock()
-		r.err = err
+		r.setErr(err)
 		return
 	}
 
@@ -176,6 +210,18 @@ func (r *Runner) startRunner(parentCtx context.Context, setupCh chan error, rp R
 		models.ExecutorPidLabel: fmt.Sprintf("%d", os.Getpid()),
 		models.TaskIDLabel:      taskConfig.taskID,
 	}
+	if startTime, startTimeErr := procinfo.StartTime(os.Getpid()); startTimeErr != nil {
+		r.logger.Warning("Unable to determine executor start time: ", startTimeErr)
+	} else {
+		labels[models.ExecutorPidStartTimeLabel] = strconv.FormatUint(startTime, 10)
+	}
+	labels[models.ExecutorProcessNameLabel] = filepath.Base(os.Args[0])
+	if apiAddress, apiErr := r.startAPIServer(ctx); apiErr != nil {
+		r.logger.Warning("Unable to start introspection API: ", apiErr)
+	} else {
+		r.logger.WithField("address", apiAddress).Info("Introspection API started")
+		labels[models.ExecutorHTTPListenerAddressLabel] = apiAddress
+	}
 
 	// Should we remove this?
 	if len(taskConfig.titusInfo.GetIamProfile()) > 0 {
@@ -183,11 +229,34 @@ func (r *Runner) startRunner(parentCtx context.Context, setupCh chan error, rp R
 	}
 
 	resources := &runtimeTypes.Resources{
-		Mem:  taskConfig.mem,
-		CPU:  taskConfig.cpu,
-		Disk: taskConfig.disk,
+		Mem:       taskConfig.mem,
+		CPU:       taskConfig.cpu,
+		Disk:      taskConfig.disk,
+		HostPorts: taskConfig.hostPorts,
 	}
 	r.container = runtime.NewContainer(taskConfig.taskID, taskConfig.titusInfo, resources, labels, r.config)
+	r.recordContainer(r.container)
+
+	if r.config.TaskLockDir != "" {
+		unlockTask, lockErr := r.lockTask(taskConfig.taskID)
+		if lockErr != nil {
+			r.logger.Error(lockErr)
+			r.setErr(lockErr)
+			r.updateStatus(ctx, titusdriver.Lost, lockErr.Error())
+			return
+		}
+		defer unlockTask()
+	}
+
+	// Check the task's log policy before launching it, so a bad one fails the task, rather than leaving it without logs
+	r.logPolicy, err = filesystems.LogPolicyForTask(r.config, r.config.GetUserProvidedEnvForTask(taskConfig.titusInfo))
+	if err != nil {
+		r.logger.Error("Invalid log policy: ", err)
+		r.setErr(err)
+		r.updateStatus(ctx, titusdriver.Failed, err.Error())
+		return
+	}
+	r.logger.WithField("logPolicy", r.logPolicy).Info("Log policy")
 
 	// TODO: Wire up cleanup callback
 	var le launchguardCore.LaunchEvent = &launchguardCore.NoopLaunchEvent{}
@@ -198,7 +267,7 @@ func (r *Runner) startRunner(parentCtx context.Context, setupCh chan error, rp R
 		// Wait until the launchGuard is released.
 		// TODO(Andrew L): We only block concurrent launches to avoid a race condition introduced
 		// by the Titus master releasing resources prior to the agent releasing them.
-		le = r.launchGuard.NewLaunchEvent(ctx, r.container.TitusInfo.GetNetworkConfigInfo().GetEniLabel())
+		le = r.launchGuard.NewLaunchEvent(ctx, r.launchGuardKey())
 	}
 	if r.config.MetatronEnabled {
 		r.updateStatus(ctx, titusdriver.Starting, "creating_metatron")
@@ -217,7 +286,7 @@ func (r *Runner) startRunner(parentCtx context.Context, setupCh chan error, rp R
 			// We are expecting executor container cleanup to remove
 			// any files created during the process
 			r.logger.Errorf("Failed to acquire Metatron certificates: %s", err)
-			r.err = err
+			r.setErr(err)
 			r.updateStatus(ctx, titusdriver.Lost, err.Error())
 			return
 		}
@@ -225,31 +294,10 @@ func (r *Runner) startRunner(parentCtx context.Context, setupCh chan error, rp R
 
 	// At this point we've begun starting, and we need to explicitly inform the master when the task finishes
 	defer r.handleShutdown(ctx)
-	select {
-	case <-le.Launch():
-		r.logger.Info("Launch not blocked on on launchGuard")
-		goto no_launchguard
-	default:
-		r.logger.Info("Launch waiting on launchGuard")
-		r.updateStatus(ctx, titusdriver.Starting, WaitingOnLaunchguardMessage)
-
-	}
-	select {
-	case <-le.Launch():
-		r.logger.Info("No longer waiting on launchGuard")
-	case <-r.killChan:
-		r.logger.Warning("Killed while waiting on launchguard")
-		return
-	case <-ctx.Done():
-		r.logger.Warning("local context done while waiting on launchguard")
-		return
-	case <-parentCtx.Done():
-		r.logger.Warning("Parent context done while waiting on launchguard")
+	if !r.waitForLaunchGuard(parentCtx, ctx, le) {
 		return
 	}
 
-no_launchguard:
-
 	select {
 	case <-r.killChan:
 		r.logger.Error("Task was killed before task was created")
@@ -260,6 +308,9 @@ no_launchguard:
 	default:
 	}
 	r.updateStatus(ctx, titusdriver.Starting, "creating")
+	r.container.OnPullProgress = func(progress runtimeTypes.PullProgress) {
+		r.updateStatus(ctx, titusdriver.Starting, PullingImageMessagePrefix+progress.String())
+	}
 
 	// When Create() returns the host may have been modified to create storage and pull the image.
 	// These steps may or may not have completed depending on if/where a failure occurred.
@@ -269,16 +320,25 @@ no_launchguard:
 		r.metrics.Counter("titus.executor.launchTaskFailed", 1, nil)
 		r.logger.Error("task failed to create container: ", err)
 		// Treat registry pull errors as LOST and non-existent images as FAILED.
-		switch err.(type) {
-		case *runtimeTypes.RegistryImageNotFoundError, *runtimeTypes.InvalidSecurityGroupError, *runtimeTypes.BadEntryPointError:
+		if prepareFailedPermanently(err) {
 			r.logger.Error("Returning TASK_FAILED for task: ", err)
 			r.updateStatus(ctx, titusdriver.Failed, err.Error())
-		default:
+		} else {
 			r.logger.Error("Returning TASK_LOST for task: ", err)
 			r.updateStatus(ctx, titusdriver.Lost, err.Error())
 		}
 		return
 	}
+	r.recordContainer(r.container)
+
+	// Cleanup events are queued under the ENI the container was allocated, so if it isn't the one the container asked
+	// for, the launch has to wait for the cleanups on that one too
+	if key := r.launchGuardKey(); !r.container.TitusInfo.GetIgnoreLaunchGuard() && key != r.container.TitusInfo.GetNetworkConfigInfo().GetEniLabel() {
+		r.logger.WithField("launchGuardKey", key).Info("Allocated another ENI than the one asked for, waiting on its launchGuard")
+		if !r.waitForLaunchGuard(parentCtx, ctx, r.launchGuard.NewLaunchEvent(ctx, key)) {
+			return
+		}
+	}
 
 	r.updateStatus(ctx, titusdriver.Starting, "starting")
 	logDir, err := r.runtime.Start(ctx, r.container)
@@ -327,8 +387,22 @@ no_launchguard:
 	ticks := time.NewTicker(r.config.StatusCheckFrequency)
 	defer ticks.Stop()
 
+	// A nil channel blocks forever, so if there is no health check, we never select on it
+	var healthCheckResults chan error
+	hs := &healthState{maxFailures: r.container.TitusInfo.GetMaxHealthFailures()}
+	if r.shouldHealthCheck() {
+		healthCheckCtx, healthCheckCancel := context.WithCancel(ctx)
+		defer healthCheckCancel()
+		healthCheckResults = make(chan error)
+		go r.healthCheckLoop(healthCheckCtx, healthCheckResults)
+	}
+
 	for {
 		select {
+		case healthCheckErr := <-healthCheckResults:
+			if r.handleHealthCheckResult(ctx, hs, healthCheckErr, details) {
+				return
+			}
 		case <-ticks.C:
 			status, err := r.runtime.Status(r.container)
 			if err != nil {
@@ -359,7 +433,7 @@ func (r *Runner) handleShutdown(ctx context.Context) { // nolint: gocyclo
 
 	if r.wasKilled() {
 		r.logger.Info("Setting launchGuard while stopping task")
-		ce = r.launchGuard.NewRealCleanUpEvent(launchGuardCtx, r.container.TitusInfo.GetNetworkConfigInfo().GetEniLabel())
+		ce = r.launchGuard.NewRealCleanUpEvent(launchGuardCtx, r.launchGuardKey())
 	}
 
 	killStartTime := time.Now()
@@ -388,6 +462,8 @@ func (r *Runner) handleShutdown(ctx context.Context) { // nolint: gocyclo
 		defer ce.Done()
 	}
 
+	r.maybeSnapshot()
+
 	if r.watcher != nil {
 		if err := r.watcher.Stop(); err != nil {
 			r.logger.Error("Error while shutting down watcher for: ", err)
@@ -421,6 +497,44 @@ func (r *Runner) handleShutdown(ctx context.Context) { // nolint: gocyclo
 	r.metrics.Timer("titus.executor.containerCleanupTime", time.Since(killStartTime), r.container.ImageTagForMetrics())
 }
 
+// waitForLaunchGuard waits until le gives clearance to launch, and returns false if the task was killed, or its context
+// was cancelled first
+func (r *Runner) waitForLaunchGuard(parentCtx, ctx context.Context, le launchguardCore.LaunchEvent) bool {
+	launchGuardWaitStart := time.Now()
+	select {
+	case <-le.Launch():
+		r.logger.Info("Launch not blocked on on launchGuard")
+		r.recordLaunchResult(ctx, le.Result(), false, time.Since(launchGuardWaitStart))
+		return true
+	default:
+		r.logger.Info("Launch waiting on launchGuard")
+		r.updateStatus(ctx, titusdriver.Starting, WaitingOnLaunchguardMessage)
+
+	}
+	select {
+	case <-le.Launch():
+		r.logger.Info("No longer waiting on launchGuard")
+		r.recordLaunchResult(ctx, le.Result(), true, time.Since(launchGuardWaitStart))
+		return true
+	case <-r.killChan:
+		r.logger.Warning("Killed while waiting on launchguard")
+	case <-ctx.Done():
+		r.logger.Warning("local context done while waiting on launchguard")
+	case <-parentCtx.Done():
+		r.logger.Warning("Parent context done while waiting on launchguard")
+	}
+	return false
+}
+
+// launchGuardKey is the ENI label that launch guard events are queued under. Once the container's been allocated an ENI,
+// which may not be the one it asked for, it's that ENI's label.
+func (r *Runner) launchGuardKey() string {
+	if r.container.Allocation.DeviceIndex > 0 {
+		return strconv.Itoa(r.container.Allocation.DeviceIndex - 1)
+	}
+	return r.container.TitusInfo.GetNetworkConfigInfo().GetEniLabel()
+}
+
 func (r *Runner) wasKilled() bool {
 	select {
 	case <-r.killChan:
@@ -430,6 +544,18 @@ func (r *Runner) wasKilled() bool {
 	}
 }
 
+// prepareFailedPermanently returns true if the task should be failed, rather than lost, because running it again won't
+// help
+func prepareFailedPermanently(err error) bool {
+	switch e := err.(type) {
+	case *runtimeTypes.RegistryImageNotFoundError, *runtimeTypes.InvalidSecurityGroupError, *runtimeTypes.BadEntryPointError, *runtimeTypes.InvalidPortMappingError, *runtimeTypes.ImagePolicyViolationError:
+		return true
+	case *runtimeTypes.PullError:
+		return e.Permanent()
+	}
+	return false
+}
+
 func parseStatus(status runtimeTypes.Status, err error) (bool, titusdriver.TitusTaskState, string) {
 
 	switch status {
@@ -450,7 +576,7 @@ func (r *Runner) maybeSetupExternalLogger(ctx context.Context, logDir string) er
 
 	uploadDir := r.container.UploadDir("logs")
 	uploadRegex := r.container.TitusInfo.GetLogUploadRegexp()
-	r.watcher, err = filesystems.NewWatcher(r.metrics, logDir, uploadDir, uploadRegex, r.logUploaders, r.config)
+	r.watcher, err = filesystems.NewWatcher(r.metrics, logDir, uploadDir, uploadRegex, r.taskLogUploaders(), r.config, r.logPolicy)
 	if err != nil {
 		return err
 	}
@@ -458,6 +584,49 @@ func (r *Runner) maybeSetupExternalLogger(ctx context.Context, logDir string) er
 	return r.watcher.Watch(ctx)
 }
 
+// taskLogUploaders returns the uploaders for the task's logs, which include the task's own S3 log locations
+func (r *Runner) taskLogUploaders() *uploader.Uploaders {
+	taskUploaders := []uploader.Uploader{}
+	for _, location := range r.container.TitusInfo.GetS3LogLocations() {
+		l := r.logger.WithField("bucket", location.GetBucket()).WithField("prefix", location.GetPrefix())
+		u, err := newS3Uploader(l, location.GetBucket(), location.GetPrefix())
+		if err != nil {
+			r.metrics.Counter("titus.executor.s3LogLocationError", 1, nil)
+			l.Error("Unable to setup uploader for S3 log location: ", err)
+			continue
+		}
+		taskUploaders = append(taskUploaders, u)
+	}
+
+	if len(taskUploaders) > 0 && r.config.S3LogLocationsOnly {
+		return uploader.NewUploadersFromUploaderArray(taskUploaders)
+	}
+	return uploader.NewUploadersWith(r.logUploaders, taskUploaders...)
+}
+
+// lockTask holds a lock on the task for as long as the executor runs it. The reaper treats tasks whose lock is held as
+// live, so it doesn't collect their resources before they have a container.
+func (r *Runner) lockTask(taskID string) (func(), error) {
+	if err := os.MkdirAll(r.config.TaskLockDir, 0700); err != nil {
+		return nil, err
+	}
+	locker, err := fslocker.NewFSLocker(r.config.TaskLockDir)
+	if err != nil {
+		return nil, err
+	}
+	noWait := time.Duration(0)
+	lock, err := locker.ExclusiveLock(taskID, &noWait)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to lock task %s: %v", taskID, err)
+	}
+	return func() {
+		lock.Unlock()
+		if err := locker.RemovePath(taskID); err != nil {
+			r.logger.Warning("Unable to remove task lock: ", err)
+		}
+	}, nil
+}
+
 // setupMetatron returns a Docker formatted string bind mount for a container for a directory that will contain
 // TODO(fabio): create a type for Binds
 func (r *Runner) setupMetatron() (*metatron.CredentialsConfig, error) {
@@ -533,23 +702,55 @@ func (r *Runner) setupRunner(ctx context.Context, rp RuntimeProvider) error {
 	return err
 }
 
+// recordLaunchResult reports why launchguard let us launch. The master only hears about it if we had to wait, or the
+// launch wasn't cleared, so that uneventful launches don't get an extra status update.
+func (r *Runner) recordLaunchResult(ctx context.Context, result launchguardCore.LaunchResult, waited bool, waitTime time.Duration) {
+	r.logger.WithField("launchGuardResult", result.String()).WithField("waitTime", waitTime).Info("Launchguard gave clearance")
+	r.metrics.Counter("titus.executor.launchGuard.result", 1, map[string]string{"reason": string(result.Reason)})
+	if waited {
+		r.metrics.Timer("titus.executor.launchGuard.waitTime", waitTime, map[string]string{"reason": string(result.Reason)})
+	}
+	if waited || result.Reason != launchguardCore.LaunchCleared {
+		r.updateStatus(ctx, titusdriver.Starting, LaunchguardResultMessagePrefix+result.String())
+	}
+}
+
 func (r *Runner) updateStatus(ctx context.Context, status titusdriver.TitusTaskState, msg string) {
 	r.updateStatusWithDetails(ctx, status, msg, nil)
 }
 
 func (r *Runner) updateStatusWithDetails(ctx context.Context, status titusdriver.TitusTaskState, msg string, details *runtimeTypes.Details) {
-	r.lastStatus = status
-	l := r.logger.WithField("msg", msg).WithField("taskStatus", status)
-	if details != nil {
-		l = l.WithField("details", details)
-	}
-	select {
-	case r.UpdatesChan <- Update{
+	r.sendUpdate(ctx, Update{
 		TaskID:  r.container.TaskID,
 		State:   status,
 		Mesg:    msg,
 		Details: details,
-	}:
+	})
+}
+
+// updateHealth sends a running update which carries the result of the task's health check
+func (r *Runner) updateHealth(ctx context.Context, healthy bool, msg string, details *runtimeTypes.Details) {
+	r.sendUpdate(ctx, Update{
+		TaskID:  r.container.TaskID,
+		State:   titusdriver.Running,
+		Mesg:    msg,
+		Details: details,
+		Healthy: &healthy,
+	})
+}
+
+func (r *Runner) sendUpdate(ctx context.Context, update Update) {
+	r.lastStatus = update.State
+	r.recordUpdate(update)
+	l := r.logger.WithField("msg", update.Mesg).WithField("taskStatus", update.State)
+	if update.Details != nil {
+		l = l.WithField("details", update.Details)
+	}
+	if update.Healthy != nil {
+		l = l.WithField("healthy", *update.Healthy)
+	}
+	select {
+	case r.UpdatesChan <- update:
 		l.Info("Updating task status")
 	case <-ctx.Done():
 		l.Info("Not sending update")
@@ -562,4 +763,6 @@ type Update struct {
 	State   titusdriver.TitusTaskState
 	Mesg    string
 	Details *runtimeTypes.Details
+	// Healthy is only set on updates which carry the result of the task's health check
+	Healthy *bool
 }
@@ -3,6 +3,10 @@ package runner
 import (
 	"context"
 	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
 	"sync"
 	"testing"
 	"time"
@@ -12,11 +16,14 @@ import (
 	"github.com/Netflix/metrics-client-go/metrics"
 	"github.com/Netflix/titus-executor/api/netflix/titus"
 	"github.com/Netflix/titus-executor/config"
+	"github.com/Netflix/titus-executor/executor/drivers"
 	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
 	"github.com/Netflix/titus-executor/launchguard/client"
 	"github.com/Netflix/titus-executor/launchguard/server"
 	"github.com/Netflix/titus-executor/uploader"
+	protobuf "github.com/golang/protobuf/proto"
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -39,6 +46,12 @@ type runtimeMock struct {
 	mu sync.Mutex
 	// subscription for one call to StartTask gets reset after each call
 	startCalled chan<- struct{}
+
+	// healthCheckErrs is consumed by HealthCheck, once it is empty, the container is healthy
+	healthCheckErrs []error
+
+	// allocatedDeviceIndex is the ENI which Prepare allocates the container, if it's set
+	allocatedDeviceIndex int
 }
 
 // test the launchGuard, it has caused too many deadlocks.
@@ -67,7 +80,7 @@ func TestHoldsLaunchesUntilStopFinishes(t *testing.T) { // nolint: gocyclo
 
 	}()
 	// one task is running
-	if err := e1.StartTask(taskID, taskInfo, 512, 1, 1024); err != nil {
+	if err := e1.StartTask(taskID, taskInfo, 512, 1, 1024, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -90,7 +103,7 @@ func TestHoldsLaunchesUntilStopFinishes(t *testing.T) { // nolint: gocyclo
 	}
 
 	go func() {
-		if err := e2.StartTask("A-New-Task", taskInfo, 512, 1, 1024); err != nil {
+		if err := e2.StartTask("A-New-Task", taskInfo, 512, 1, 1024, nil); err != nil {
 			t.Error(err)
 		}
 	}()
@@ -110,6 +123,164 @@ func TestHoldsLaunchesUntilStopFinishes(t *testing.T) { // nolint: gocyclo
 	cancel()
 }
 
+func TestHoldsLaunchesOnAllocatedENI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lgs := httptest.NewServer(server.NewLaunchGuardServer(metrics.Discard))
+	defer lgs.Close()
+	lgc, err := client.NewLaunchGuardClient(metrics.Discard, lgs.URL)
+	require.NoError(t, err)
+
+	// A task which was allocated ENI index 3 is being cleaned up
+	ce := lgc.NewRealCleanUpEvent(ctx, "3")
+	defer ce.Done()
+
+	launched := make(chan struct{})
+	r, e := mocks(ctx, t, make(chan chan<- struct{}, 1), launched)
+	r.allocatedDeviceIndex = 4
+	e.launchGuard = lgc
+	defer func() {
+		cancel()
+		<-e.StoppedChan
+	}()
+
+	// This one asks for ENI index 1, but is allocated ENI index 3 too
+	image := "titusops/alpine"
+	taskInfo := &titus.ContainerInfo{
+		ImageName:         &image,
+		NetworkConfigInfo: &titus.ContainerInfo_NetworkConfigInfo{EniLabel: protobuf.String("1")},
+	}
+	require.NoError(t, e.StartTask("Titus-123-worker-0-3", taskInfo, 512, 1, 1024, nil))
+
+	select {
+	case <-launched:
+		t.Fatal("Executor must wait until the cleanup on the allocated ENI finishes before launching")
+	case <-time.After(time.Second): // OK, expected
+	}
+
+	ce.Done()
+	select {
+	case <-launched: // OK, expected
+	case <-time.After(5 * time.Second):
+		t.Fatal("Executor did not launch the task within 5s after the cleanup finished")
+	}
+}
+
+func TestHealthCheckFailuresFailTask(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		taskID   = "Titus-123-worker-0-2"
+		image    = "titusops/alpine"
+		taskInfo = &titus.ContainerInfo{
+			ImageName:         &image,
+			IgnoreLaunchGuard: protobuf.Bool(true),
+			HealthCheckCmd:    []string{"/bin/false"},
+			MaxHealthFailures: protobuf.Uint32(2),
+		}
+		healthCheckErr = errors.New("exited with code 1")
+		kills          = make(chan chan<- struct{}, 1)
+	)
+
+	// Grant the kill which happens once the task is failed
+	go func() {
+		close(<-kills)
+	}()
+	r := &runtimeMock{
+		t:               t,
+		startCalled:     make(chan<- struct{}),
+		kills:           kills,
+		ctx:             ctx,
+		healthCheckErrs: []error{healthCheckErr, nil, healthCheckErr, healthCheckErr, healthCheckErr},
+	}
+	l := uploader.NewUploadersFromUploaderArray([]uploader.Uploader{&uploader.NoopUploader{}})
+	cfg := config.Config{
+		StatusCheckFrequency: time.Second,
+		HealthCheckFrequency: 10 * time.Millisecond,
+	}
+	e, err := WithRuntime(ctx, metrics.Discard, func(ctx context.Context, _cfg config.Config) (runtimeTypes.Runtime, error) {
+		return r, nil
+	}, l, cfg)
+	require.NoError(t, err)
+	require.NoError(t, e.StartTask(taskID, taskInfo, 512, 1, 1024, nil))
+
+	var healthUpdates []bool
+	for update := range e.UpdatesChan {
+		t.Logf("Reported status: %+v", update)
+		if update.Healthy != nil {
+			healthUpdates = append(healthUpdates, *update.Healthy)
+		}
+		if update.State == titusdriver.Failed {
+			assert.Contains(t, update.Mesg, "Health check failed 3 consecutive times")
+			break
+		}
+	}
+	assert.Equal(t, []bool{false, true, false}, healthUpdates)
+	<-e.StoppedChan
+}
+
+func TestShouldSnapshot(t *testing.T) {
+	assert.False(t, shouldSnapshot(titus.ContainerInfo_NEVER, titusdriver.Failed))
+	assert.True(t, shouldSnapshot(titus.ContainerInfo_ALWAYS, titusdriver.Running))
+	assert.True(t, shouldSnapshot(titus.ContainerInfo_SUCCESS_ONLY, titusdriver.Finished))
+	assert.False(t, shouldSnapshot(titus.ContainerInfo_SUCCESS_ONLY, titusdriver.Failed))
+	assert.True(t, shouldSnapshot(titus.ContainerInfo_ERROR_ONLY, titusdriver.Failed))
+	assert.True(t, shouldSnapshot(titus.ContainerInfo_ERROR_ONLY, titusdriver.Lost))
+	assert.False(t, shouldSnapshot(titus.ContainerInfo_ERROR_ONLY, titusdriver.Finished))
+}
+
+type recordingUploader struct {
+	uploader.NoopUploader
+	remotes []string
+}
+
+func (u *recordingUploader) Upload(local, remote string, ctypeFunc uploader.ContentTypeInferenceFunction) error {
+	u.remotes = append(u.remotes, remote)
+	return nil
+}
+
+func TestTaskLogUploaders(t *testing.T) {
+	f, err := ioutil.TempFile("", "task-log-uploaders")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	require.NoError(t, f.Close())
+
+	taskUploaders := map[string]*recordingUploader{}
+	newS3Uploader = func(l logrus.FieldLogger, bucket, prefix string) (uploader.Uploader, error) {
+		u := &recordingUploader{}
+		taskUploaders[path.Join(bucket, prefix)] = u
+		return u, nil
+	}
+	defer func() {
+		newS3Uploader = uploader.NewS3UploaderWithPrefix
+	}()
+
+	global := &recordingUploader{}
+	r := &Runner{
+		logger:       logrus.NewEntry(logrus.StandardLogger()),
+		metrics:      metrics.Discard,
+		logUploaders: uploader.NewUploadersFromUploaderArray([]uploader.Uploader{global}),
+		container: &runtimeTypes.Container{
+			TitusInfo: &titus.ContainerInfo{
+				S3LogLocations: []*titus.ContainerInfo_S3LogLocation{
+					{Bucket: protobuf.String("owner-bucket"), Prefix: protobuf.String("logs")},
+				},
+			},
+		},
+	}
+
+	assert.Empty(t, r.taskLogUploaders().Upload(f.Name(), "stdout", nil))
+	assert.Equal(t, []string{"stdout"}, global.remotes)
+	assert.Equal(t, []string{"stdout"}, taskUploaders["owner-bucket/logs"].remotes)
+
+	r.config.S3LogLocationsOnly = true
+	assert.Empty(t, r.taskLogUploaders().Upload(f.Name(), "stderr", nil))
+	assert.Equal(t, []string{"stdout"}, global.remotes)
+	assert.Equal(t, []string{"stderr"}, taskUploaders["owner-bucket/logs"].remotes)
+}
+
 func mocks(ctx context.Context, t *testing.T, killRequests chan<- chan<- struct{}, taskLaunched chan struct{}) (*runtimeMock, *Runner) {
 	lgs := httptest.NewServer(server.NewLaunchGuardServer(metrics.Discard))
 
@@ -151,6 +322,9 @@ func drain(t *testing.T, e *Runner, taskLaunched chan struct{}) {
 
 func (r *runtimeMock) Prepare(ctx context.Context, c *runtimeTypes.Container, bindMounts []string) error {
 	r.t.Log("runtimeMock.Prepare", c.TaskID)
+	if r.allocatedDeviceIndex > 0 {
+		c.Allocation.DeviceIndex = r.allocatedDeviceIndex
+	}
 	return nil
 }
 
@@ -199,8 +373,63 @@ func (r *runtimeMock) Details(c *runtimeTypes.Container) (*runtimeTypes.Details,
 	}, nil
 }
 
+func (r *runtimeMock) HealthCheck(ctx context.Context, c *runtimeTypes.Container) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.healthCheckErrs) == 0 {
+		return nil
+	}
+	err := r.healthCheckErrs[0]
+	r.healthCheckErrs = r.healthCheckErrs[1:]
+	return err
+}
+
+func (r *runtimeMock) Snapshot(ctx context.Context, c *runtimeTypes.Container, w io.Writer) error {
+	r.t.Log("runtimeMock.Snapshot", c.TaskID)
+	return nil
+}
+
 func (r *runtimeMock) Status(c *runtimeTypes.Container) (runtimeTypes.Status, error) {
 	r.t.Log("runtimeMock.Status", c.TaskID)
 	// always running is fine for these tests
 	return runtimeTypes.StatusRunning, nil
 }
+
+func TestPrepareFailedPermanently(t *testing.T) {
+	assert.True(t, prepareFailedPermanently(&runtimeTypes.BadEntryPointError{Reason: errors.New("no entrypoint")}))
+	assert.True(t, prepareFailedPermanently(&runtimeTypes.PullError{Class: runtimeTypes.PullErrorManifestUnknown, Reason: errors.New("manifest unknown")}))
+	assert.False(t, prepareFailedPermanently(&runtimeTypes.PullError{Class: runtimeTypes.PullErrorRateLimited, Reason: errors.New("toomanyrequests")}))
+	assert.False(t, prepareFailedPermanently(errors.New("something else")))
+}
+
+func TestLaunchGuardKey(t *testing.T) {
+	r := &Runner{container: &runtimeTypes.Container{
+		TitusInfo: &titus.ContainerInfo{NetworkConfigInfo: &titus.ContainerInfo_NetworkConfigInfo{EniLabel: protobuf.String("1")}},
+	}}
+	assert.Equal(t, "1", r.launchGuardKey())
+
+	// The container was allocated another ENI than the one it asked for
+	r.container.Allocation.DeviceIndex = 4
+	assert.Equal(t, "3", r.launchGuardKey())
+}
+
+func TestLockTask(t *testing.T) {
+	dir, err := ioutil.TempDir("", "task-locks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := &Runner{logger: logrus.NewEntry(logrus.StandardLogger()), config: config.Config{TaskLockDir: path.Join(dir, "tasks")}}
+	unlock, err := r.lockTask("Titus-123")
+	require.NoError(t, err)
+	lockPath := path.Join(dir, "tasks", "lockDir", "Titus-123")
+	_, err = os.Stat(lockPath)
+	assert.NoError(t, err)
+
+	// Only one executor can run the task
+	_, err = r.lockTask("Titus-123")
+	assert.Error(t, err)
+
+	unlock()
+	_, err = os.Stat(lockPath)
+	assert.True(t, os.IsNotExist(err))
+}
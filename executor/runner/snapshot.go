@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Netflix/titus-executor/api/netflix/titus"
+	"github.com/Netflix/titus-executor/executor/drivers"
+)
+
+const (
+	snapshotTimeout     = 5 * time.Minute
+	snapshotFileName    = "snapshot.tar.gz"
+	snapshotContentType = "application/gzip"
+)
+
+// shouldSnapshot decides whether the task's snapshot policy applies to the state the task finished in
+func shouldSnapshot(policy titus.ContainerInfo_SnapshotPolicy, lastStatus titusdriver.TitusTaskState) bool {
+	switch policy {
+	case titus.ContainerInfo_ALWAYS:
+		return true
+	case titus.ContainerInfo_SUCCESS_ONLY:
+		return lastStatus == titusdriver.Finished
+	case titus.ContainerInfo_ERROR_ONLY:
+		return lastStatus == titusdriver.Failed || lastStatus == titusdriver.Lost
+	default:
+		return false
+	}
+}
+
+// maybeSnapshot captures the container's writable layer, and ships it to the task's upload directory. It has
+// to be called after the container has been stopped, but prior to the runtime cleaning it up.
+func (r *Runner) maybeSnapshot() {
+	policy := r.container.TitusInfo.GetSnapshotPolicy()
+	if r.container.ID == "" || !shouldSnapshot(policy, r.lastStatus) {
+		return
+	}
+	l := r.logger.WithField("snapshotPolicy", policy.String()).WithField("taskStatus", r.lastStatus)
+	l.Info("Snapshotting container")
+
+	snapshotStartTime := time.Now()
+	if err := r.snapshot(); err != nil {
+		r.metrics.Counter("titus.executor.snapshotError", 1, nil)
+		l.Error("Unable to snapshot container: ", err)
+		return
+	}
+	r.metrics.Timer("titus.executor.snapshotTime", time.Since(snapshotStartTime), r.container.ImageTagForMetrics())
+	l.Info("Snapshot uploaded")
+}
+
+func (r *Runner) snapshot() error {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	f, err := ioutil.TempFile("", "titus-snapshot-"+r.container.TaskID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if removeErr := os.Remove(f.Name()); removeErr != nil {
+			r.logger.Warning("Unable to remove local snapshot: ", removeErr)
+		}
+	}()
+
+	err = r.runtime.Snapshot(ctx, r.container, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	remote := path.Join(r.container.UploadDir("snapshots"), snapshotFileName)
+	errs := r.logUploaders.Upload(f.Name(), remote, func(string) string {
+		return snapshotContentType
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("%+v", errs)
+	}
+	return nil
+}
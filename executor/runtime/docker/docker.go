@@ -26,18 +26,23 @@ import (
 	"github.com/Netflix/titus-executor/api/netflix/titus"
 	"github.com/Netflix/titus-executor/config"
 	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	"github.com/Netflix/titus-executor/imagecache"
+	imageCacheClient "github.com/Netflix/titus-executor/imagecache/client"
 	"github.com/Netflix/titus-executor/nvidia"
+	"github.com/Netflix/titus-executor/vpc"
+	"github.com/Netflix/titus-executor/vpc/allocate"
+	vpcContext "github.com/Netflix/titus-executor/vpc/context"
 	vpcTypes "github.com/Netflix/titus-executor/vpc/types"
 	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	docker "github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
 	"github.com/docker/go-units"
 	"github.com/ftrvxmtrx/fd"
 	"github.com/hashicorp/go-multierror"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/sys/unix"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -59,6 +64,8 @@ const (
 	titusEnvironments       = "/var/lib/titus-environments"
 	defaultNetworkBandwidth = 128 * MB
 	defaultKillWait         = 10 * time.Second
+	tiniSocketContainerDir  = "/titus-executor-sockets"
+	imageCacheUnpinTimeout  = 5 * time.Second
 )
 
 const envFileTemplateStr = `
@@ -85,11 +92,18 @@ var (
 	batchSize                  int
 	burst                      bool
 	securityConvergenceTimeout time.Duration
+	securityGroupQueueTimeout  time.Duration
+	anyInterface               bool
+	allocateIPv6               bool
 	pidLimit                   int
 	prepareTimeout             time.Duration
 	startTimeout               time.Duration
+	healthCheckTimeout         time.Duration
 	debugAllocate              bool
 	bumpTiniSchedPriority      bool
+	imageCacheURL              string
+	imagePolicyFile            string
+	cgroupMarkerDir            string
 )
 
 // Flags are the configuration for the docker runtime package
@@ -127,6 +141,27 @@ var Flags = []cli.Flag{
 		Destination: &securityConvergenceTimeout,
 		Value:       time.Second * 10,
 	},
+	cli.DurationFlag{
+		Name:        "titus.executor.networking.securityGroupQueueTimeout",
+		Destination: &securityGroupQueueTimeout,
+		Usage:       "How long to wait for an ENI in use by other security groups to drain, so its security groups can be changed. 0 doesn't wait",
+	},
+	cli.BoolFlag{
+		Name:        "titus.executor.networking.anyInterface",
+		Destination: &anyInterface,
+		Usage:       "Allocate from another ENI if the one the task asked for is in use by other security groups, draining, or out of addresses",
+	},
+	cli.BoolFlag{
+		Name:        "titus.executor.networking.allocateIPv6",
+		Destination: &allocateIPv6,
+		Usage:       "Allocate an IPv6 address for containers in the VPC driver, in addition to their IPv4 address",
+	},
+	cli.StringFlag{
+		Name:        "titus.executor.cgroupMarkerDir",
+		Value:       "/run/titus-executor/cgroups",
+		Usage:       "Where the cgroups of tasks' containers are recorded, so the reaper can remove them if the executor dies",
+		Destination: &cgroupMarkerDir,
+	},
 	cli.IntFlag{
 		Name:        "titus.executor.pidLimit",
 		Value:       100000,
@@ -142,9 +177,15 @@ var Flags = []cli.Flag{
 		Value:       time.Minute * 10,
 		Destination: &startTimeout,
 	},
+	cli.DurationFlag{
+		Name:        "titus.executor.timeouts.healthCheck",
+		Value:       time.Second * 30,
+		Destination: &healthCheckTimeout,
+	},
 	cli.BoolFlag{
 		Name:        "titus.executor.debugAllocate",
 		Destination: &debugAllocate,
+		Usage:       "Deprecated, and does nothing. It used to strace titus-vpc-tool, but allocation happens in-process now",
 	},
 	// Allow the usage of a realtime scheduling policy to be optional on systems that don't have it properly configured
 	// by default, i.e.: docker-for-mac.
@@ -155,6 +196,18 @@ var Flags = []cli.Flag{
 			"systems. Kernels with CONFIG_RT_GROUP_SCHED=y require all cgroups in the hierarchy to have some " +
 			"cpu.rt_runtime_us allocated to each one of them",
 	},
+	cli.StringFlag{
+		Name:        "titus.executor.imageCache",
+		Destination: &imageCacheURL,
+		Usage: "URL of the titus-image-cache server, i.e. http://localhost:8007, to pull images through. Images are " +
+			"pulled directly if it's not set, or if the pull through the cache fails",
+	},
+	cli.StringFlag{
+		Name:        "titus.executor.imagePolicyFile",
+		Destination: &imagePolicyFile,
+		Usage: "JSON file with the policy that images have to meet, which restricts the registries they can come " +
+			"from, requires that they're pinned by digest, or blocks digests. Any image is allowed if it's not set",
+	},
 }
 
 var (
@@ -199,6 +252,10 @@ type DockerRuntime struct { // nolint: golint
 	storageOptEnabled bool
 	pidCgroupPath     string
 	cfg               config.Config
+	imageCache        *imageCacheClient.ImageCacheClient
+	imagePolicy       *imagePolicy
+	vpcAllocator      vpcTypes.Allocator
+	vpcWirer          vpcTypes.Wirer
 }
 
 type compositeError struct {
@@ -231,6 +288,26 @@ func NewDockerRuntime(executorCtx context.Context, m metrics.Reporter, cfg confi
 		cfg:             cfg,
 	}
 
+	if imageCacheURL != "" {
+		dockerRuntime.imageCache, err = imageCacheClient.NewImageCacheClient(imageCacheURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if imagePolicyFile != "" {
+		dockerRuntime.imagePolicy, err = loadImagePolicy(imagePolicyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.UseNewNetworkDriver {
+		if err = dockerRuntime.setupVPC(executorCtx); err != nil {
+			return nil, err
+		}
+	}
+
 	dockerRuntime.pidCgroupPath, err = getOwnCgroup("pids")
 	if err != nil {
 		return nil, err
@@ -421,6 +498,10 @@ func (r *DockerRuntime) dockerConfig(c *runtimeTypes.Container, binds []string,
 		},
 	}
 	hostCfg.CgroupParent = r.pidCgroupPath
+	// Cleanups run in reverse, so the marker goes once the cgroups are gone
+	c.RegisterRuntimeCleanup(func() error {
+		return removeCgroupMarker(c.TaskID)
+	})
 	c.RegisterRuntimeCleanup(func() error {
 		return cleanupCgroups(r.pidCgroupPath)
 	})
@@ -476,11 +557,21 @@ func (r *DockerRuntime) dockerConfig(c *runtimeTypes.Container, binds []string,
 	containerCfg.Labels["titus.vpc.ipv4"] = c.Allocation.IPV4Address // deprecated
 	containerCfg.Labels["titus.net.ipv4"] = c.Allocation.IPV4Address
 
+	if c.Allocation.IPV6Address != "" {
+		containerCfg.Labels["titus.net.ipv6"] = c.Allocation.IPV6Address
+	}
+
 	// TODO(fabio): find a way to avoid regenerating the env map
 	c.Env["EC2_LOCAL_IPV4"] = c.Allocation.IPV4Address
+	if c.Allocation.IPV6Address != "" {
+		c.Env["EC2_IPV6"] = c.Allocation.IPV6Address
+	}
 
 	if r.cfg.UseNewNetworkDriver {
 		hostCfg.NetworkMode = container.NetworkMode("none")
+	} else {
+		// In the VPC driver, port mappings are setup inside of the container's network namespace by setup-container
+		setupPortBindings(c, containerCfg, hostCfg)
 	}
 
 	containerCfg.Env = getSortedEnvArray(c.Env)
@@ -488,6 +579,22 @@ func (r *DockerRuntime) dockerConfig(c *runtimeTypes.Container, binds []string,
 	return containerCfg, hostCfg, nil
 }
 
+func setupPortBindings(c *runtimeTypes.Container, containerCfg *container.Config, hostCfg *container.HostConfig) {
+	if len(c.PortMappings) == 0 {
+		return
+	}
+
+	containerCfg.ExposedPorts = nat.PortSet{}
+	hostCfg.PortBindings = nat.PortMap{}
+	for _, portMapping := range c.PortMappings {
+		port := nat.Port(fmt.Sprintf("%d/%s", portMapping.ContainerPort, portMapping.Protocol))
+		containerCfg.ExposedPorts[port] = struct{}{}
+		hostCfg.PortBindings[port] = append(hostCfg.PortBindings[port], nat.PortBinding{
+			HostPort: strconv.Itoa(int(portMapping.HostPort)),
+		})
+	}
+}
+
 func (r *DockerRuntime) setupLogs(c *runtimeTypes.Container, containerCfg *container.Config, hostCfg *container.HostConfig) {
 	// TODO(fabio): move this to a daemon-level config
 	hostCfg.LogConfig = container.LogConfig{
@@ -498,18 +605,23 @@ func (r *DockerRuntime) setupLogs(c *runtimeTypes.Container, containerCfg *conta
 	hostCfg.Init = &t
 	socketFileName := tiniSocketFileName(c)
 
-	hostCfg.Binds = append(hostCfg.Binds, r.tiniSocketDir+":/titus-executor-sockets:ro")
+	hostCfg.Binds = append(hostCfg.Binds, r.tiniSocketDir+":"+tiniSocketContainerDir+":ro")
+	setupTiniEnv(c, socketFileName)
+
+	// We should probably just add the getSortedEnvArray method to the Config struct
+	containerCfg.Env = getSortedEnvArray(c.Env)
+}
+
+// setupTiniEnv sets the environment variables which tell tini how to call back to the executor, and where to send stdio
+func setupTiniEnv(c *runtimeTypes.Container, socketFileName string) {
 	c.Env["TITUS_REDIRECT_STDERR"] = "/logs/stderr"
 	c.Env["TITUS_REDIRECT_STDOUT"] = "/logs/stdout"
-	c.Env["TITUS_UNIX_CB_PATH"] = filepath.Join("/titus-executor-sockets/", socketFileName)
+	c.Env["TITUS_UNIX_CB_PATH"] = filepath.Join(tiniSocketContainerDir, socketFileName)
 	/* Require us to send a message to tini in order to let it know we're ready for it to start the container */
 	c.Env["TITUS_CONFIRM"] = "true"
 	if tiniVerbosity > 0 {
 		c.Env["TINI_VERBOSITY"] = strconv.Itoa(tiniVerbosity)
 	}
-
-	// We should probably just add the getSortedEnvArray method to the Config struct
-	containerCfg.Env = getSortedEnvArray(c.Env)
 }
 
 func (r *DockerRuntime) hostOSPathToTiniSocket(c *runtimeTypes.Container) string {
@@ -548,40 +660,92 @@ func sleepWithCtx(parentCtx context.Context, d time.Duration) error {
 }
 
 func (r *DockerRuntime) dockerPull(ctx context.Context, c *runtimeTypes.Container) error {
-	return pullWithRetries(ctx, r.metrics, r.client, c, doDockerPull)
+	if r.imageCache != nil && r.pullThroughImageCache(ctx, c) {
+		return nil
+	}
+	return pullWithRetries(ctx, r.metrics, r.client, c, func(ctx context.Context, metrics metrics.Reporter, client *docker.Client, ref string) error {
+		return doDockerPull(ctx, metrics, client, ref, newPullProgressTracker(metrics, c))
+	})
+}
+
+// pullThroughImageCache returns true if the image was pulled through the image cache. If it wasn't, it's up to the
+// caller to pull it directly, which also gives the image not found errors that the task is failed with.
+func (r *DockerRuntime) pullThroughImageCache(ctx context.Context, c *runtimeTypes.Container) bool {
+	pullStartTime := time.Now()
+	tracker := newPullProgressTracker(r.metrics, c)
+	result, err := r.imageCache.PullWithProgress(ctx, c.QualifiedImageName(), func(msg imagecache.PullMessage) {
+		tracker.handle(pullMessage(msg))
+	})
+	if err != nil {
+		log.Warning("Unable to pull image through the image cache, pulling it directly: ", err)
+		r.metrics.Counter("titus.executor.imageCachePullError", 1, nil)
+		return false
+	}
+
+	if result.Cached {
+		r.metrics.Counter("titus.executor.imageCacheHit", 1, c.ImageTagForMetrics())
+	} else {
+		tracker.finish()
+		r.metrics.Timer("titus.executor.imagePullTime", time.Since(pullStartTime), c.ImageTagForMetrics())
+	}
+	if result.Deduplicated {
+		r.metrics.Counter("titus.executor.imageCachePullDeduplicated", 1, c.ImageTagForMetrics())
+	}
+	log.WithField("cached", result.Cached).WithField("deduplicated", result.Deduplicated).Info("Pulled image through the image cache")
+	return true
+}
+
+// pinImage keeps the image cache from evicting the task's image between pulling it, and creating the container. It
+// returns true if the image was pinned, in which case the caller has to unpin it.
+func (r *DockerRuntime) pinImage(ctx context.Context, c *runtimeTypes.Container) bool {
+	if err := r.imageCache.Pin(ctx, c.QualifiedImageName(), prepareTimeout); err != nil {
+		log.Warning("Unable to pin image in the image cache: ", err)
+		return false
+	}
+	return true
+}
+
+func (r *DockerRuntime) unpinImage(c *runtimeTypes.Container) {
+	// The prepare context can be done by now, and the pin has to be released either way
+	ctx, cancel := context.WithTimeout(context.Background(), imageCacheUnpinTimeout)
+	defer cancel()
+	if err := r.imageCache.Unpin(ctx, c.QualifiedImageName()); err != nil {
+		log.Warning("Unable to unpin image in the image cache: ", err)
+	}
 }
 
 type dockerPuller func(context.Context, metrics.Reporter, *docker.Client, string) error
 
+// pullWithRetries pulls the image, retrying according to the policy for the reason that the last pull failed for.
+// Errors with a known reason are returned as *runtimeTypes.PullError.
 func pullWithRetries(ctx context.Context, metrics metrics.Reporter, client *docker.Client, c *runtimeTypes.Container, puller dockerPuller) error {
 	var err error
 
 	pullStartTime := time.Now()
-	for sleep := 0; sleep < 5; sleep++ {
-		// The initial sleep wil be 0
-		if sleepErr := sleepWithCtx(ctx, time.Second*1<<uint(sleep)-1); sleepErr != nil {
-			return err
-		}
-
+	for attempt := 1; ; attempt++ {
 		err = puller(ctx, metrics, client, c.QualifiedImageName())
 		if err == nil {
 			metrics.Timer("titus.executor.imagePullTime", time.Since(pullStartTime), c.ImageTagForMetrics())
 			return nil
-		} else if isBadImageErr(err) {
-			return &runtimeTypes.RegistryImageNotFoundError{Reason: err}
 		}
-	}
-
-	return err
 
-}
+		err = classifyPullError(ctx, err)
+		policy, class := retryPolicyFor(err)
+		metrics.Counter("titus.executor.imagePullFailure", 1, map[string]string{"class": class})
+		if attempt >= policy.attempts {
+			log.WithField("class", class).WithField("attempts", attempt).Warning("Giving up on pulling image: ", err)
+			return err
+		}
 
-func isBadImageErr(err error) bool {
-	return strings.Contains(err.Error(), "not found") ||
-		strings.Contains(err.Error(), "invalid reference format")
+		delay := policy.delay(attempt - 1)
+		log.WithField("class", class).WithField("attempt", attempt).Infof("Retrying image pull in %s: %v", delay, err)
+		if sleepErr := sleepWithCtx(ctx, delay); sleepErr != nil {
+			return classifyPullError(ctx, err)
+		}
+	}
 }
 
-func doDockerPull(ctx context.Context, metrics metrics.Reporter, client *docker.Client, ref string) error {
+func doDockerPull(ctx context.Context, metrics metrics.Reporter, client *docker.Client, ref string, tracker *pullProgressTracker) error {
 	resp, err := client.ImagePull(ctx, ref, types.ImagePullOptions{})
 	defer func() {
 		if resp != nil {
@@ -602,93 +766,73 @@ func doDockerPull(ctx context.Context, metrics metrics.Reporter, client *docker.
 	}
 	decoder := json.NewDecoder(resp)
 
-	pullMessages := []map[string]interface{}{}
-
 	// Wait for EOF, or error..
 	for {
-		var msg map[string]interface{}
+		var msg pullMessage
 
 		if err = decoder.Decode(&msg); err == io.EOF {
 			// Success, pull is finished
+			tracker.finish()
 			return nil
 		} else if err != nil {
 			// Something unknown went wrong
 			log.Warning("Error pulling image: ", err)
-			for _, pullMessage := range pullMessages {
-				log.Warning("Pull Message: ", pullMessage)
-			}
+			tracker.logFailure()
 			return err
 		}
-		pullMessages = append(pullMessages, msg)
-		if errorMessage, ok := msg["error"]; ok {
-			log.Warning("Pull error message: ", msg)
-			return fmt.Errorf("Error while pulling Docker image: %s", errorMessage)
+		if msg.Error != "" {
+			log.Warning("Pull error message: ", msg.Error)
+			tracker.logFailure()
+			return fmt.Errorf("Error while pulling Docker image: %s", msg.Error)
 		}
+		tracker.handle(msg)
 	}
 }
 
-func prepareNetworkDriver(c *runtimeTypes.Container) error {
-	log.Printf("Configuring VPC network for %s", c.TaskID)
-
-	args := []string{
-		"allocate-network",
-		"--device-idx", strconv.Itoa(c.NormalizedENIIndex),
-		"--security-groups", strings.Join(c.SecurityGroupIDs, ","),
-		"--security-convergence-timeout", securityConvergenceTimeout.String(),
-		"--batch-size", strconv.Itoa(batchSize),
+// setupVPC sets up the allocator, and wirer that the runtime uses to connect containers to the VPC. They share
+// their state with titus-vpc-tool, so the GC, and the CLI see the executor's allocations.
+func (r *DockerRuntime) setupVPC(executorCtx context.Context) error {
+	if r.cfg.VPCLimitsFile != "" {
+		if err := vpc.LoadLimitsOverrides(r.cfg.VPCLimitsFile); err != nil {
+			return err
+		}
 	}
-
-	if debugAllocate {
-		args = append([]string{"-e", "trace=file,network,desc", "-e", "trace=!pselect6,futex,utimensat", "-s", "8192", "-tt", "-f", "-o", "allocate.trace", "/apps/titus-executor/bin/titus-vpc-tool"}, args...)
-		c.AllocationCommand = exec.Command("/usr/bin/strace", args...) // nolint: gas
-	} else {
-		c.AllocationCommand = exec.Command("/apps/titus-executor/bin/titus-vpc-tool", args...) // nolint: gas
+	vpcCtx, err := vpcContext.NewVPCContext(executorCtx, log.WithField("component", "vpc"), r.cfg.VPCStateDir)
+	if err != nil {
+		return err
 	}
+	r.vpcAllocator = allocate.NewAllocator(vpcCtx)
+	r.vpcWirer = allocate.NewWirer(vpcCtx)
+	return nil
+}
 
-	stdoutPipe, err := c.AllocationCommand.StdoutPipe()
-	c.AllocationCommand.Stderr = os.Stderr
+func (r *DockerRuntime) prepareNetworkDriver(ctx context.Context, c *runtimeTypes.Container) error {
+	log.Printf("Configuring VPC network for %s", c.TaskID)
 
-	if err != nil {
-		return err
+	req := vpcTypes.AllocationRequest{
+		DeviceIndex:                c.NormalizedENIIndex,
+		AnyInterface:               anyInterface,
+		SecurityGroups:             c.SecurityGroupIDs,
+		BatchSize:                  batchSize,
+		SecurityConvergenceTimeout: securityConvergenceTimeout,
+		SecurityGroupQueueTimeout:  securityGroupQueueTimeout,
+		IPv6:                       allocateIPv6,
 	}
-	if err := c.AllocationCommand.Start(); err != nil {
-		return err
+	lease, err := r.vpcAllocator.Allocate(ctx, req)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid security groups requested for vpc id") ||
+			strings.Contains(err.Error(), "InvalidGroup.NotFound") ||
+			strings.Contains(err.Error(), "InvalidSecurityGroupID.NotFound") {
+			return &runtimeTypes.InvalidSecurityGroupError{Reason: err}
+		}
+		return fmt.Errorf("vpc network configuration error: %v", err)
 	}
-
+	c.AllocationLease = lease
+	c.Allocation = lease.Allocation()
 	c.RegisterRuntimeCleanup(func() error {
-		_ = c.AllocationCommand.Process.Signal(unix.SIGTERM)
-		killTimer := time.AfterFunc(5*time.Minute, func() {
-			_ = c.AllocationCommand.Process.Kill()
-		})
-		_ = c.AllocationCommand.Wait()
-		killTimer.Stop()
-		_ = c.AllocationCommand.Process.Kill()
+		lease.Release()
 		return nil
 	})
-	cancelTimer := time.AfterFunc(5*time.Minute, func() {
-		log.Warning("timed out trying to allocate network")
-		_ = c.AllocationCommand.Process.Kill()
-	})
-	if err := json.NewDecoder(stdoutPipe).Decode(&c.Allocation); err != nil {
-		_ = c.AllocationCommand.Process.Kill()
-		return fmt.Errorf("Unable to read json from pipe: %+v", err)
-	}
-	if !cancelTimer.Stop() {
-		// Ruh roh, we failed to stop the timer in time.
-		return errors.New("Race condition experienced with stopping VPC allocation")
-	}
-
-	if !c.Allocation.Success {
-		_ = c.AllocationCommand.Process.Kill()
-		if (strings.Contains(c.Allocation.Error, "invalid security groups requested for vpc id")) ||
-			(strings.Contains(c.Allocation.Error, "InvalidGroup.NotFound") ||
-				(strings.Contains(c.Allocation.Error, "InvalidSecurityGroupID.NotFound"))) {
-			var invalidSg runtimeTypes.InvalidSecurityGroupError
-			invalidSg.Reason = errors.New(c.Allocation.Error)
-			return &invalidSg
-		}
-		return fmt.Errorf("vpc network configuration error: %s; %v", c.Allocation.Error, c.AllocationCommand.Wait())
-	}
 
 	log.Printf("vpc network configuration obtained %+v", c.Allocation)
 
@@ -712,6 +856,23 @@ func (r *DockerRuntime) Prepare(parentCtx context.Context, c *runtimeTypes.Conta
 		goto error
 	}
 
+	c.PortMappings, err = c.GetPortMappings()
+	if err != nil {
+		goto error
+	}
+
+	if r.imagePolicy != nil {
+		if err = r.imagePolicy.checkRef(c); err != nil {
+			r.metrics.Counter("titus.executor.imagePolicyViolation", 1, nil)
+			goto error
+		}
+	}
+
+	// Once the container is created, the image is in use, and the image cache won't evict it
+	if r.imageCache != nil && r.pinImage(ctx, c) {
+		defer r.unpinImage(c)
+	}
+
 	group.Go(func() error {
 		if pullErr := r.dockerPull(errGroupCtx, c); pullErr != nil {
 			return pullErr
@@ -723,6 +884,13 @@ func (r *DockerRuntime) Prepare(parentCtx context.Context, c *runtimeTypes.Conta
 			return inspectErr
 		}
 
+		if r.imagePolicy != nil {
+			if policyErr := r.imagePolicy.checkImage(c, imageInfo); policyErr != nil {
+				r.metrics.Counter("titus.executor.imagePolicyViolation", 1, nil)
+				return policyErr
+			}
+		}
+
 		size = r.reportDockerImageSizeMetric(c, imageInfo)
 		// Check if this image (container) has a an entrypoint, or if we
 		// were passed one
@@ -734,7 +902,7 @@ func (r *DockerRuntime) Prepare(parentCtx context.Context, c *runtimeTypes.Conta
 
 	if r.cfg.UseNewNetworkDriver {
 		group.Go(func() error {
-			return prepareNetworkDriver(c)
+			return r.prepareNetworkDriver(errGroupCtx, c)
 		})
 	} else {
 		// Don't call out to network driver for local development
@@ -772,6 +940,12 @@ func (r *DockerRuntime) Prepare(parentCtx context.Context, c *runtimeTypes.Conta
 	}
 	log.WithField("containerID", c.ID).Debug("Container successfully created")
 
+	// With a cgroup parent, dockerd puts the container's cgroup under it, named after the container
+	err = writeCgroupMarker(c.TaskID, filepath.Join(r.pidCgroupPath, c.ID))
+	if err != nil {
+		goto error
+	}
+
 	err = r.pushMetatron(parentCtx, c)
 	if err != nil {
 		goto error
@@ -929,26 +1103,47 @@ func (r *DockerRuntime) pushMetatron(parentCtx context.Context, c *runtimeTypes.
 		"taskID":    c.TaskID,
 	}).Info("Copying Metatron credentials")
 
+	tarBuf, err := metatronCredentialsTar(c)
+	if err != nil {
+		return err
+	}
+
+	return r.client.CopyToContainer(ctx, c.ID, "/", bytes.NewReader(tarBuf.Bytes()), cco)
+}
+
+// metatronCredentialsTar returns a tarball of the host's Metatron credentials path, relative to the container's root
+func metatronCredentialsTar(c *runtimeTypes.Container) (*bytes.Buffer, error) {
 	tarBuf := new(bytes.Buffer)
 	tw := tar.NewWriter(tarBuf)
-	defer func() {
-		if err := tw.Close(); err != nil {
-			log.Errorf("Failed to close tar writer while creating Metatron tar: %s", err)
-		}
-	}()
-
 	if err := metatronTarWalk(tw, c); err != nil {
-		return err
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to close tar writer while creating Metatron tar: %s", err)
 	}
 
-	return r.client.CopyToContainer(ctx, c.ID, "/", bytes.NewReader(tarBuf.Bytes()), cco)
+	return tarBuf, nil
 }
 
 func (r *DockerRuntime) pushEnvironment(c *runtimeTypes.Container) error {
+	tarBuf, err := environmentTar(c)
+	if err != nil {
+		return err
+	}
+
+	cco := types.CopyToContainerOptions{
+		AllowOverwriteDirWithFile: true,
+	}
+
+	return r.client.CopyToContainer(context.TODO(), c.ID, "/", bytes.NewReader(tarBuf.Bytes()), cco)
+}
+
+// environmentTar returns a tarball of the directories, and profile script that every container gets on top of its image
+func environmentTar(c *runtimeTypes.Container) (*bytes.Buffer, error) {
 	var envTemplateBuf, tarBuf bytes.Buffer
 
 	if err := envFileTemplate.Execute(&envTemplateBuf, c.Env); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create a new tar archive.
@@ -997,14 +1192,10 @@ func (r *DockerRuntime) pushEnvironment(c *runtimeTypes.Container) error {
 	// Make sure to check the error on Close.
 
 	if err := tw.Close(); err != nil {
-		return err
-	}
-
-	cco := types.CopyToContainerOptions{
-		AllowOverwriteDirWithFile: true,
+		return nil, err
 	}
 
-	return r.client.CopyToContainer(context.TODO(), c.ID, "/", bytes.NewReader(tarBuf.Bytes()), cco)
+	return &tarBuf, nil
 }
 
 func maybeConvertIntoBadEntryPointError(err error) error {
@@ -1333,7 +1524,7 @@ func (r *DockerRuntime) setupPostStartLogDirTiniHandleConnection(parentCtx conte
 
 func (r *DockerRuntime) setupPostStartLogDirTiniHandleConnection2(parentCtx context.Context, c *runtimeTypes.Container, cred ucred, rootFile *os.File) error {
 	if r.cfg.UseNewNetworkDriver && c.Allocation.IPV4Address != "" {
-		if err := setupNetworking(c, cred); err != nil {
+		if err := r.setupNetworking(parentCtx, c, cred); err != nil {
 			return err
 		}
 	}
@@ -1367,25 +1558,24 @@ func (r *DockerRuntime) setupPostStartLogDirTiniHandleConnection2(parentCtx cont
 	return nil
 }
 
-func setupNetworkingArgs(c *runtimeTypes.Container) []string {
+func wiringRequest(c *runtimeTypes.Container) vpcTypes.WiringRequest {
 	bw := uint64(c.BandwidthLimitMbps) * 1000 * 1000
 	if bw == 0 {
 		bw = defaultNetworkBandwidth
 	}
-	args := []string{
-		"setup-container",
-		"--bandwidth", strconv.FormatUint(bw, 10),
-		"--netns", "3",
+	req := vpcTypes.WiringRequest{
+		Allocation: c.Allocation,
+		Bandwidth:  bw,
+		Burst:      burst || c.TitusInfo.GetAllowNetworkBursting(),
 	}
-	if burst || c.TitusInfo.GetAllowNetworkBursting() {
-		args = append(args, "--burst")
+	for _, portMapping := range c.PortMappings {
+		req.PortMappings = append(req.PortMappings, vpcTypes.PortMapping{HostPort: portMapping.HostPort, ContainerPort: portMapping.ContainerPort, Protocol: portMapping.Protocol})
 	}
-	return args
+	return req
 }
 
-func setupNetworking(c *runtimeTypes.Container, cred ucred) error {
+func (r *DockerRuntime) setupNetworking(ctx context.Context, c *runtimeTypes.Container, cred ucred) error {
 	log.Info("Setting up container network")
-	var result vpcTypes.WiringStatus
 
 	netnsFile, err := os.Open(filepath.Join("/proc/", strconv.Itoa(int(cred.pid)), "ns", "net"))
 	if err != nil {
@@ -1393,53 +1583,25 @@ func setupNetworking(c *runtimeTypes.Container, cred ucred) error {
 	}
 	defer shouldClose(netnsFile)
 
-	c.SetupCommand = exec.Command("/apps/titus-executor/bin/titus-vpc-tool", setupNetworkingArgs(c)...) // nolint: gas
-	stdin, err := c.SetupCommand.StdinPipe()
-	if err != nil {
-		return err
-	}
-	stdout, err := c.SetupCommand.StdoutPipe()
-	if err != nil {
-		return err
-	}
-	c.AllocationCommand.Stderr = os.Stderr
-	c.SetupCommand.ExtraFiles = []*os.File{netnsFile}
-
-	err = c.SetupCommand.Start()
+	req := wiringRequest(c)
+	req.NetnsFD = int(netnsFile.Fd())
+	wiring, err := r.vpcWirer.Wire(ctx, req)
 	if err != nil {
-		return err
+		return fmt.Errorf("Network setup error: %v", err)
 	}
-
+	c.Wiring = wiring
 	c.RegisterRuntimeCleanup(func() error {
-		_ = c.SetupCommand.Process.Signal(unix.SIGTERM)
-		killTimer := time.AfterFunc(1*time.Minute, func() {
-			_ = c.SetupCommand.Process.Kill()
-		})
-		_ = c.SetupCommand.Wait()
-		killTimer.Stop()
-		_ = c.SetupCommand.Process.Kill()
+		wiring.Teardown()
 		return nil
 	})
 
-	cancelTimer := time.AfterFunc(5*time.Minute, func() {
-		log.Warning("timed out trying to setup container network")
-		_ = c.SetupCommand.Process.Kill()
+	// Cleanups run in reverse, so this stops before the wiring is torn down
+	stopTrafficStats := make(chan struct{})
+	go reportTrafficStats(r.metrics, c.TaskID, wiring, stopTrafficStats)
+	c.RegisterRuntimeCleanup(func() error {
+		close(stopTrafficStats)
+		return nil
 	})
-	if err := json.NewEncoder(stdin).Encode(c.Allocation); err != nil {
-		_ = c.SetupCommand.Process.Kill()
-		return err
-	}
-	if err := json.NewDecoder(stdout).Decode(&result); err != nil {
-		_ = c.SetupCommand.Process.Kill()
-		return fmt.Errorf("Unable to read json from pipe during setup-container: %+v", err)
-	}
-	if !cancelTimer.Stop() {
-		return errors.New("Race condition experienced with container network setup")
-	}
-	if !result.Success {
-		_ = c.SetupCommand.Process.Kill()
-		return fmt.Errorf("Network setup error: %s", result.Error)
-	}
 
 	return nil
 }
@@ -1499,17 +1661,23 @@ func (r *DockerRuntime) setupGPU(c *runtimeTypes.Container, dockerCfg *container
 // Details gets additional network info about a container
 func (r *DockerRuntime) Details(c *runtimeTypes.Container) (*runtimeTypes.Details, error) {
 	details := &runtimeTypes.Details{
-		IPAddresses: make(map[string]string),
+		IPAddresses:  make(map[string]string),
+		PortMappings: c.PortMappings,
 	}
 
 	if c.Allocation.IPV4Address != "" {
 		details.IPAddresses["nfvpc"] = c.Allocation.IPV4Address
+		if c.Allocation.IPV6Address != "" {
+			details.IPAddresses["nfvpc-ipv6"] = c.Allocation.IPV6Address
+		}
 		details.NetworkConfiguration = &runtimeTypes.NetworkConfigurationDetails{
-			IsRoutableIP: true,
-			IPAddress:    c.Allocation.IPV4Address,
-			EniIPAddress: c.Allocation.IPV4Address,
-			EniID:        c.Allocation.ENI,
-			ResourceID:   fmt.Sprintf("resource-eni-%d", c.Allocation.DeviceIndex-1),
+			IsRoutableIP:   true,
+			IPAddress:      c.Allocation.IPV4Address,
+			EniIPAddress:   c.Allocation.IPV4Address,
+			EniIPv6Address: c.Allocation.IPV6Address,
+			EniID:          c.Allocation.ENI,
+			ResourceID:     fmt.Sprintf("resource-eni-%d", c.Allocation.DeviceIndex-1),
+			SecurityGroups: c.Allocation.SecurityGroups,
 		}
 	} else {
 		ci, err := r.client.ContainerInspect(context.TODO(), c.ID)
@@ -1575,6 +1743,43 @@ func (r *DockerRuntime) dockerStatus(c *runtimeTypes.Container) (runtimeTypes.St
 	return runtimeTypes.StatusFailed, fmt.Errorf("exited with code %d", ci.State.ExitCode)
 }
 
+// HealthCheck execs the task's health check command inside of the container, and waits for it to exit
+func (r *DockerRuntime) HealthCheck(parentCtx context.Context, c *runtimeTypes.Container) error {
+	ctx, cancel := context.WithTimeout(parentCtx, healthCheckTimeout)
+	defer cancel()
+
+	execConfig := types.ExecConfig{
+		Cmd:    c.TitusInfo.GetHealthCheckCmd(),
+		Detach: true,
+	}
+	execCreateResponse, err := r.client.ContainerExecCreate(ctx, c.ID, execConfig)
+	if err != nil {
+		r.metrics.Counter("titus.executor.dockerExecCreateError", 1, nil)
+		return &runtimeTypes.HealthCheckFailedError{Reason: err}
+	}
+
+	if err = r.client.ContainerExecStart(ctx, execCreateResponse.ID, types.ExecStartCheck{Detach: true}); err != nil {
+		r.metrics.Counter("titus.executor.dockerExecStartError", 1, nil)
+		return &runtimeTypes.HealthCheckFailedError{Reason: err}
+	}
+
+	for {
+		execInspect, err := r.client.ContainerExecInspect(ctx, execCreateResponse.ID)
+		if err != nil {
+			return &runtimeTypes.HealthCheckFailedError{Reason: err}
+		}
+		if !execInspect.Running {
+			if execInspect.ExitCode != 0 {
+				return &runtimeTypes.HealthCheckFailedError{Reason: fmt.Errorf("exited with code %d", execInspect.ExitCode)}
+			}
+			return nil
+		}
+		if err = sleepWithCtx(ctx, 100*time.Millisecond); err != nil {
+			return &runtimeTypes.HealthCheckFailedError{Reason: err}
+		}
+	}
+}
+
 // Kill uses the Docker API to terminate a container and notifies the VPC driver to tear down its networking
 func (r *DockerRuntime) Kill(c *runtimeTypes.Container) error {
 	log.Infof("Killing %s", c.TaskID)
@@ -1611,28 +1816,28 @@ func (r *DockerRuntime) Kill(c *runtimeTypes.Container) error {
 	}
 
 stopped:
-	if c.SetupCommand != nil {
-		_ = c.SetupCommand.Process.Signal(unix.SIGTERM)
-	}
-	if c.AllocationCommand != nil {
-		_ = c.AllocationCommand.Process.Signal(unix.SIGTERM)
-		time.AfterFunc(5*time.Second, func() {
-			_ = c.AllocationCommand.Process.Kill()
-		})
+	releaseContainerResources(c)
+
+	return errs.ErrorOrNil()
+}
 
+// releaseContainerResources tears down the container's networking, and gives back its GPUs once it has stopped
+func releaseContainerResources(c *runtimeTypes.Container) {
+	if c.Wiring != nil {
+		c.Wiring.Teardown()
+	}
+	if c.AllocationLease != nil {
 		log.WithField("taskId", c.TaskID).Info("Waiting for deallocation to finish")
-		_ = c.AllocationCommand.Wait()
+		c.AllocationLease.Release()
 		log.WithField("taskId", c.TaskID).Info("Deallocation finished")
 	} else {
-		log.WithField("taskId", c.TaskID).Info("No need to deallocate, no allocation command")
+		log.WithField("taskId", c.TaskID).Info("No need to deallocate, no allocation")
 	}
 
 	if c.TitusInfo.GetNumGpus() > 0 {
 		numDealloc := c.GPUInfo.Deallocate()
 		log.Infof("Deallocated %d GPU devices for task %s", numDealloc, c.TaskID)
 	}
-
-	return errs.ErrorOrNil()
 }
 
 // Cleanup runs the registered callbacks for a container
@@ -1682,3 +1887,19 @@ func shouldClose(c io.Closer) {
 		log.Error("Could not close: ", err)
 	}
 }
+
+// writeCgroupMarker records the cgroup that the task's container runs in. The reaper only removes cgroups which it has
+// a marker for, once their task is gone.
+func writeCgroupMarker(taskID, cgroup string) error {
+	if err := os.MkdirAll(cgroupMarkerDir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cgroupMarkerDir, taskID), []byte(cgroup), 0600)
+}
+
+func removeCgroupMarker(taskID string) error {
+	if err := os.Remove(filepath.Join(cgroupMarkerDir, taskID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -9,7 +9,12 @@ import (
 	"bytes"
 
 	"github.com/Netflix/metrics-client-go/metrics"
+	"github.com/Netflix/titus-executor/api/netflix/titus"
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	vpcTypes "github.com/Netflix/titus-executor/vpc/types"
+	"github.com/docker/docker/api/types/container"
 	docker "github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -107,3 +112,46 @@ func TestValidEnvironmentKeys(t *testing.T) {
 	assert.False(t, environmentVariableKeyRegexp.MatchString("foo-bar"))
 	assert.False(t, environmentVariableKeyRegexp.MatchString("0"))
 }
+
+func TestSetupPortBindings(t *testing.T) {
+	c := &runtimeTypes.Container{
+		PortMappings: []runtimeTypes.PortMapping{
+			{HostPort: 31000, ContainerPort: 8080, Protocol: runtimeTypes.ProtocolTCP},
+			{HostPort: 31001, ContainerPort: 53, Protocol: runtimeTypes.ProtocolUDP},
+		},
+	}
+	containerCfg := &container.Config{}
+	hostCfg := &container.HostConfig{}
+	setupPortBindings(c, containerCfg, hostCfg)
+
+	assert.Equal(t, nat.PortSet{"8080/tcp": struct{}{}, "53/udp": struct{}{}}, containerCfg.ExposedPorts)
+	assert.Equal(t, nat.PortMap{"8080/tcp": []nat.PortBinding{{HostPort: "31000"}}, "53/udp": []nat.PortBinding{{HostPort: "31001"}}}, hostCfg.PortBindings)
+}
+
+func TestWiringRequestPortMappings(t *testing.T) {
+	c := &runtimeTypes.Container{
+		TitusInfo:    &titus.ContainerInfo{},
+		PortMappings: []runtimeTypes.PortMapping{{HostPort: 31000, ContainerPort: 53, Protocol: runtimeTypes.ProtocolUDP}},
+	}
+	req := wiringRequest(c)
+	assert.Equal(t, []vpcTypes.PortMapping{{HostPort: 31000, ContainerPort: 53, Protocol: vpcTypes.ProtocolUDP}}, req.PortMappings)
+	assert.Equal(t, uint64(defaultNetworkBandwidth), req.Bandwidth)
+}
+
+func TestSnapshotCandidates(t *testing.T) {
+	changes := []container.ContainerChangeResponseItem{
+		{Kind: changeModified, Path: "/var"},
+		{Kind: changeAdded, Path: "/var/lib/app/data"},
+		{Kind: changeAdded, Path: "/var/lib/app"},
+		{Kind: changeModified, Path: "/etc/hosts"},
+		{Kind: changeDeleted, Path: "/etc/motd"},
+		{Kind: changeAdded, Path: "/logs/stdout"},
+		{Kind: changeModified, Path: "/logs"},
+	}
+
+	assert.Equal(t, []snapshotCandidate{
+		{path: "/etc/hosts", modified: true},
+		{path: "/var", modified: true},
+		{path: "/var/lib/app", modified: false},
+	}, snapshotCandidates(changes))
+}
@@ -0,0 +1,148 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// imagePolicy restricts which images tasks can run. It's loaded from the JSON file that
+// titus.executor.imagePolicyFile points to.
+type imagePolicy struct {
+	// AllowedPrefixes are the registries, and repositories that images can come from, i.e. "registry.example.com" or
+	// "registry.example.com/titusops". The registry has to match exactly, and the repository matches itself, and the
+	// repositories under it, so "registry.example.com/titusops" doesn't match "registry.example.com/titusops-dev/app".
+	// Any image is allowed if there are none.
+	AllowedPrefixes []string `json:"allowedPrefixes"`
+	// DeniedPrefixes take precedence over AllowedPrefixes
+	DeniedPrefixes []string `json:"deniedPrefixes"`
+	// RequireDigest only allows tasks which pin their image by digest, rather than referring to it by a tag
+	RequireDigest bool `json:"requireDigest"`
+	// BlockedDigests are matched against the digests of the image once it's pulled, and its ID, so that tags which
+	// resolve to them are blocked too
+	BlockedDigests []string `json:"blockedDigests"`
+}
+
+func loadImagePolicy(path string) (*imagePolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer shouldClose(f)
+
+	policy := &imagePolicy{}
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("Unable to parse image policy %s: %v", path, err)
+	}
+	for _, prefix := range append(append([]string{}, policy.AllowedPrefixes...), policy.DeniedPrefixes...) {
+		if _, err = parseRepoPrefix(prefix); err != nil {
+			return nil, fmt.Errorf("Unable to parse image policy %s: %v", path, err)
+		}
+	}
+	return policy, nil
+}
+
+// repoPrefix is an entry of AllowedPrefixes, or DeniedPrefixes split into the registry, and the repository path, which
+// is empty if the entry is for the whole registry
+type repoPrefix struct {
+	domain string
+	path   string
+}
+
+func parseRepoPrefix(prefix string) (repoPrefix, error) {
+	parts := strings.SplitN(strings.TrimSuffix(prefix, "/"), "/", 2)
+	if parts[0] == "" {
+		return repoPrefix{}, fmt.Errorf("Invalid image prefix %q", prefix)
+	}
+	rp := repoPrefix{domain: parts[0]}
+	if len(parts) == 2 {
+		rp.path = parts[1]
+	}
+	return rp, nil
+}
+
+func (rp repoPrefix) matches(named reference.Named) bool {
+	if reference.Domain(named) != rp.domain {
+		return false
+	}
+	path := reference.Path(named)
+	return rp.path == "" || path == rp.path || strings.HasPrefix(path, rp.path+"/")
+}
+
+func matchesAnyPrefix(named reference.Named, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		// The prefixes are validated when the policy is loaded
+		if rp, err := parseRepoPrefix(prefix); err == nil && rp.matches(named) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRef checks the image that the task refers to, before it's pulled
+func (p *imagePolicy) checkRef(c *runtimeTypes.Container) error {
+	ref := c.QualifiedImageName()
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return &runtimeTypes.ImagePolicyViolationError{Reason: fmt.Errorf("Unable to parse image %s: %v", ref, err)}
+	}
+	if matchesAnyPrefix(named, p.DeniedPrefixes) {
+		return &runtimeTypes.ImagePolicyViolationError{Reason: fmt.Errorf("Image %s is denied", ref)}
+	}
+	if len(p.AllowedPrefixes) > 0 && !matchesAnyPrefix(named, p.AllowedPrefixes) {
+		return &runtimeTypes.ImagePolicyViolationError{Reason: fmt.Errorf("Image %s is not from an allowed registry", ref)}
+	}
+	digest := c.TitusInfo.GetImageDigest()
+	if p.RequireDigest && digest == "" {
+		return &runtimeTypes.ImagePolicyViolationError{Reason: fmt.Errorf("Image %s is not pinned by digest", ref)}
+	}
+	if digest != "" && p.blocked(digest) {
+		return &runtimeTypes.ImagePolicyViolationError{Reason: fmt.Errorf("Image %s is blocked", ref)}
+	}
+	return nil
+}
+
+// checkImage checks the image once it's pulled, and tags have been resolved to digests
+func (p *imagePolicy) checkImage(c *runtimeTypes.Container, imageInfo types.ImageInspect) error {
+	if p.blocked(imageInfo.ID) {
+		return &runtimeTypes.ImagePolicyViolationError{Reason: fmt.Errorf("Image %s resolved to blocked image %s", c.QualifiedImageName(), imageInfo.ID)}
+	}
+	digests := []string{}
+	for _, repoDigest := range imageInfo.RepoDigests {
+		if idx := strings.LastIndex(repoDigest, "@"); idx != -1 {
+			digests = append(digests, repoDigest[idx+1:])
+		}
+	}
+	if err := p.checkDigests(c, digests); err != nil {
+		return err
+	}
+	log.WithField("image", c.QualifiedImageName()).WithField("id", imageInfo.ID).WithField("repoDigests", imageInfo.RepoDigests).Info("Image allowed by image policy")
+	return nil
+}
+
+// checkDigests checks the manifest digests that the task's image resolved to, once it's pulled
+func (p *imagePolicy) checkDigests(c *runtimeTypes.Container, digests []string) error {
+	for _, digest := range digests {
+		if p.blocked(digest) {
+			return &runtimeTypes.ImagePolicyViolationError{Reason: fmt.Errorf("Image %s resolved to blocked digest %s", c.QualifiedImageName(), digest)}
+		}
+	}
+	return nil
+}
+
+func (p *imagePolicy) blocked(digest string) bool {
+	for _, blockedDigest := range p.BlockedDigests {
+		if digest == blockedDigest {
+			return true
+		}
+	}
+	return false
+}
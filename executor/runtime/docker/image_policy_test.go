@@ -0,0 +1,117 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Netflix/titus-executor/api/netflix/titus"
+	"github.com/Netflix/titus-executor/config"
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	goodDigest = "sha256:" + strings.Repeat("a", 64)
+	badDigest  = "sha256:" + strings.Repeat("b", 64)
+)
+
+func newPolicyTestContainer(image, version, digest string) *runtimeTypes.Container {
+	titusInfo := &titus.ContainerInfo{
+		ImageName: proto.String(image),
+		Version:   proto.String(version),
+	}
+	if digest != "" {
+		titusInfo.ImageDigest = proto.String(digest)
+	}
+	return &runtimeTypes.Container{
+		TitusInfo: titusInfo,
+		Config:    config.Config{DockerRegistry: "registry.example.com"},
+	}
+}
+
+func TestImagePolicyCheckRef(t *testing.T) {
+	policy := &imagePolicy{
+		AllowedPrefixes: []string{"registry.example.com/titusops/", "registry.example.com/apps/"},
+		DeniedPrefixes:  []string{"registry.example.com/apps/untrusted"},
+		RequireDigest:   true,
+		BlockedDigests:  []string{badDigest},
+	}
+	testCases := []struct {
+		name    string
+		c       *runtimeTypes.Container
+		allowed bool
+	}{
+		{"pinned", newPolicyTestContainer("titusops/alpine", "latest", goodDigest), true},
+		{"not pinned", newPolicyTestContainer("titusops/alpine", "latest", ""), false},
+		{"not allowed", newPolicyTestContainer("other/alpine", "latest", goodDigest), false},
+		{"denied", newPolicyTestContainer("apps/untrusted", "latest", goodDigest), false},
+		{"denied nested", newPolicyTestContainer("apps/untrusted/app", "latest", goodDigest), false},
+		{"not under denied", newPolicyTestContainer("apps/untrusted-app", "latest", goodDigest), true},
+		{"blocked", newPolicyTestContainer("apps/app", "latest", badDigest), false},
+	}
+	for _, tc := range testCases {
+		err := policy.checkRef(tc.c)
+		if tc.allowed {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.IsType(t, &runtimeTypes.ImagePolicyViolationError{}, err, tc.name)
+		}
+	}
+
+	// Registries, and repositories only match on their boundaries
+	policy = &imagePolicy{AllowedPrefixes: []string{"registry.example.com/titusops"}}
+	for _, registry := range []string{"registry.example.com.evil.com", "registry.example.com:5000"} {
+		c := newPolicyTestContainer("titusops/alpine", "latest", "")
+		c.Config.DockerRegistry = registry
+		assert.IsType(t, &runtimeTypes.ImagePolicyViolationError{}, policy.checkRef(c), registry)
+	}
+	assert.IsType(t, &runtimeTypes.ImagePolicyViolationError{}, policy.checkRef(newPolicyTestContainer("titusops-dev/alpine", "latest", "")))
+	assert.NoError(t, policy.checkRef(newPolicyTestContainer("titusops/alpine", "latest", "")))
+
+	// An empty policy allows everything
+	assert.NoError(t, (&imagePolicy{}).checkRef(newPolicyTestContainer("other/alpine", "latest", "")))
+}
+
+func TestImagePolicyCheckImage(t *testing.T) {
+	policy := &imagePolicy{BlockedDigests: []string{badDigest, "sha256:badid"}}
+	c := newPolicyTestContainer("titusops/alpine", "latest", "")
+
+	assert.NoError(t, policy.checkImage(c, types.ImageInspect{
+		ID:          "sha256:goodid",
+		RepoDigests: []string{"registry.example.com/titusops/alpine@" + goodDigest},
+	}))
+	// The tag resolved to a blocked digest
+	assert.IsType(t, &runtimeTypes.ImagePolicyViolationError{}, policy.checkImage(c, types.ImageInspect{
+		ID:          "sha256:goodid",
+		RepoDigests: []string{"registry.example.com/titusops/alpine@" + badDigest},
+	}))
+	assert.IsType(t, &runtimeTypes.ImagePolicyViolationError{}, policy.checkImage(c, types.ImageInspect{ID: "sha256:badid"}))
+}
+
+func TestLoadImagePolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "policy.json")
+	require.NoError(t, ioutil.WriteFile(policyFile, []byte(`{"allowedPrefixes": ["registry.example.com/"], "requireDigest": true}`), 0644))
+	policy, err := loadImagePolicy(policyFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry.example.com/"}, policy.AllowedPrefixes)
+	assert.True(t, policy.RequireDigest)
+
+	// Typos shouldn't silently turn a part of the policy off
+	require.NoError(t, ioutil.WriteFile(policyFile, []byte(`{"requireDigests": true}`), 0644))
+	_, err = loadImagePolicy(policyFile)
+	assert.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(policyFile, []byte(`{"deniedPrefixes": ["/titusops"]}`), 0644))
+	_, err = loadImagePolicy(policyFile)
+	assert.Error(t, err)
+}
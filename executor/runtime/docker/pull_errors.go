@@ -0,0 +1,88 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+)
+
+// pullRetryPolicy is how pulls which fail for a given reason are retried
+type pullRetryPolicy struct {
+	// attempts is how many times the image is pulled in total, before giving up
+	attempts int
+	// backoff is how long to wait before the first retry. It doubles with every retry, up to maxBackoff.
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+func (p pullRetryPolicy) delay(retry int) time.Duration {
+	delay := p.backoff << uint(retry)
+	if p.maxBackoff > 0 && delay > p.maxBackoff {
+		return p.maxBackoff
+	}
+	return delay
+}
+
+var (
+	// unknownPullErrorPolicy is for errors which couldn't be classified
+	unknownPullErrorPolicy = pullRetryPolicy{attempts: 5, backoff: 2 * time.Second}
+	pullRetryPolicies      = map[runtimeTypes.PullErrorClass]pullRetryPolicy{
+		// Credentials can be refreshed underneath us, so it's worth one more try
+		runtimeTypes.PullErrorAuth:                {attempts: 2, backoff: 5 * time.Second},
+		runtimeTypes.PullErrorRateLimited:         {attempts: 5, backoff: 10 * time.Second, maxBackoff: time.Minute},
+		runtimeTypes.PullErrorManifestUnknown:     {attempts: 1},
+		runtimeTypes.PullErrorInvalidReference:    {attempts: 1},
+		runtimeTypes.PullErrorRegistryUnreachable: {attempts: 6, backoff: 2 * time.Second, maxBackoff: 30 * time.Second},
+		// The task is better off on another agent than waiting for the reaper to free up space on this one
+		runtimeTypes.PullErrorDiskFull: {attempts: 1},
+		runtimeTypes.PullErrorTimeout:  {attempts: 1},
+	}
+)
+
+// pullErrorPatterns are matched against the lowercased error from dockerd, or skopeo. The first entry with a match wins,
+// so the more specific patterns come first. "not found" turns up in all sorts of errors, including transient ones from
+// proxies in front of the registry, so it's only taken to mean that the image doesn't exist once nothing else matched.
+var pullErrorPatterns = []struct {
+	class    runtimeTypes.PullErrorClass
+	patterns []string
+}{
+	{runtimeTypes.PullErrorDiskFull, []string{"no space left on device", "disk quota exceeded"}},
+	{runtimeTypes.PullErrorRateLimited, []string{"toomanyrequests", "too many requests", "rate limit"}},
+	{runtimeTypes.PullErrorAuth, []string{"unauthorized", "authentication required", "access denied", "no basic auth credentials", "denied:"}},
+	{runtimeTypes.PullErrorInvalidReference, []string{"invalid reference format"}},
+	// These are the registry's error codes for images which don't exist
+	{runtimeTypes.PullErrorManifestUnknown, []string{"manifest unknown", "name unknown"}},
+	{runtimeTypes.PullErrorRegistryUnreachable, []string{"no such host", "connection refused", "connection reset", "network is unreachable",
+		"i/o timeout", "tls handshake timeout", "server misbehaving", "502 bad gateway", "503 service unavailable", "504 gateway timeout"}},
+	{runtimeTypes.PullErrorTimeout, []string{"context deadline exceeded"}},
+	{runtimeTypes.PullErrorManifestUnknown, []string{"not found"}},
+}
+
+// classifyPullError returns a *runtimeTypes.PullError if it knows why the pull failed, and the original error if not
+func classifyPullError(ctx context.Context, err error) error {
+	if _, ok := err.(*runtimeTypes.PullError); ok {
+		return err
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return &runtimeTypes.PullError{Class: runtimeTypes.PullErrorTimeout, Reason: err}
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, classPatterns := range pullErrorPatterns {
+		for _, pattern := range classPatterns.patterns {
+			if strings.Contains(msg, pattern) {
+				return &runtimeTypes.PullError{Class: classPatterns.class, Reason: err}
+			}
+		}
+	}
+	return err
+}
+
+func retryPolicyFor(err error) (pullRetryPolicy, string) {
+	if pullErr, ok := err.(*runtimeTypes.PullError); ok {
+		return pullRetryPolicies[pullErr.Class], string(pullErr.Class)
+	}
+	return unknownPullErrorPolicy, "unknown"
+}
@@ -0,0 +1,91 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	docker "github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyPullError(t *testing.T) {
+	testCases := []struct {
+		msg   string
+		class runtimeTypes.PullErrorClass
+	}{
+		{"Error response from daemon: Get https://registry/v2/: unauthorized: authentication required", runtimeTypes.PullErrorAuth},
+		{"Error response from daemon: pull access denied for foo, repository does not exist or may require 'docker login'", runtimeTypes.PullErrorAuth},
+		{"toomanyrequests: You have reached your pull rate limit", runtimeTypes.PullErrorRateLimited},
+		{"Error response from daemon: manifest for foo:bar not found", runtimeTypes.PullErrorManifestUnknown},
+		{"manifest unknown: manifest unknown", runtimeTypes.PullErrorManifestUnknown},
+		{"invalid reference format: repository name must be lowercase", runtimeTypes.PullErrorInvalidReference},
+		{"Get https://registry/v2/: dial tcp: lookup registry: no such host", runtimeTypes.PullErrorRegistryUnreachable},
+		{"Get https://registry/v2/: net/http: TLS handshake timeout", runtimeTypes.PullErrorRegistryUnreachable},
+		{"Error while pulling Docker image: write /var/lib/docker/tmp/layer: no space left on device", runtimeTypes.PullErrorDiskFull},
+		{"name unknown: repository name not known to registry", runtimeTypes.PullErrorManifestUnknown},
+		// A proxy in front of the registry saying "not found" in a transient error doesn't mean the image doesn't exist
+		{"Get https://registry/v2/foo/manifests/bar: 503 Service Unavailable: upstream not found", runtimeTypes.PullErrorRegistryUnreachable},
+		{"Get https://registry/v2/foo/manifests/bar: dial tcp 10.0.0.1:443: i/o timeout (route not found)", runtimeTypes.PullErrorRegistryUnreachable},
+		// These were what isBadImageErr turned into RegistryImageNotFoundError
+		{"Error response from daemon: repository foo not found: does not exist or no pull access", runtimeTypes.PullErrorManifestUnknown},
+		{"Error: image library/foo:bar not found", runtimeTypes.PullErrorManifestUnknown},
+		{"Error parsing reference: \"Foo:bar\" is not a valid repository/tag: invalid reference format", runtimeTypes.PullErrorInvalidReference},
+	}
+	for _, tc := range testCases {
+		err := classifyPullError(context.Background(), errors.New(tc.msg))
+		pullErr, ok := err.(*runtimeTypes.PullError)
+		require.True(t, ok, tc.msg)
+		assert.Equal(t, tc.class, pullErr.Class, tc.msg)
+	}
+
+	unknownErr := errors.New("something unexpected")
+	assert.Equal(t, unknownErr, classifyPullError(context.Background(), unknownErr))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	err := classifyPullError(ctx, unknownErr)
+	require.IsType(t, &runtimeTypes.PullError{}, err)
+	assert.Equal(t, runtimeTypes.PullErrorTimeout, err.(*runtimeTypes.PullError).Class)
+}
+
+func TestDockerPullPermanentErrorNotRetried(t *testing.T) {
+	retries := 0
+	fakePuller := func(context.Context, metrics.Reporter, *docker.Client, string) error {
+		retries++
+		return errors.New("manifest for foo:bar not found")
+	}
+	err := pullWithRetries(context.Background(), metrics.Discard, nil, nil, fakePuller)
+	assert.Equal(t, 1, retries)
+	require.IsType(t, &runtimeTypes.PullError{}, err)
+	assert.True(t, err.(*runtimeTypes.PullError).Permanent())
+	assert.Contains(t, err.Error(), "Image does not exist in registry")
+}
+
+func TestDockerPullBadImageNotRetried(t *testing.T) {
+	// Both of the errors which isBadImageErr used to catch are permanent, and fail the task as the image not existing
+	for _, msg := range []string{"Error: image library/foo:bar not found", "invalid reference format: repository name must be lowercase"} {
+		retries := 0
+		fakePuller := func(context.Context, metrics.Reporter, *docker.Client, string) error {
+			retries++
+			return errors.New(msg)
+		}
+		err := pullWithRetries(context.Background(), metrics.Discard, nil, nil, fakePuller)
+		assert.Equal(t, 1, retries, msg)
+		require.IsType(t, &runtimeTypes.PullError{}, err, msg)
+		assert.True(t, err.(*runtimeTypes.PullError).Permanent(), msg)
+	}
+}
+
+func TestPullRetryPolicyDelay(t *testing.T) {
+	policy := pullRetryPolicies[runtimeTypes.PullErrorRateLimited]
+	assert.Equal(t, 10*time.Second, policy.delay(0))
+	assert.Equal(t, 40*time.Second, policy.delay(2))
+	assert.Equal(t, time.Minute, policy.delay(3))
+	assert.Equal(t, 16*time.Second, unknownPullErrorPolicy.delay(3))
+}
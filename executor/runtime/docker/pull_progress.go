@@ -0,0 +1,183 @@
+package docker
+
+import (
+	"time"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// pullProgressInterval is how often the progress of a pull is reported.
+	pullProgressInterval = 10 * time.Second
+)
+
+// pullMessage is a message from the JSON stream which dockerd sends while it pulls an image
+type pullMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error string `json:"error"`
+}
+
+// layerProgress tracks a single layer of an image through the pull
+type layerProgress struct {
+	cached        bool
+	done          bool
+	current       int64
+	total         int64
+	downloadStart time.Time
+	downloadEnd   time.Time
+	extractStart  time.Time
+	extractEnd    time.Time
+}
+
+// pullProgressTracker keeps track of the layers of an image as dockerd pulls it, reports the progress of the pull,
+// and emits metrics about the layers once it's done
+type pullProgressTracker struct {
+	metrics    metrics.Reporter
+	tags       map[string]string
+	report     runtimeTypes.PullProgressFunc
+	start      time.Time
+	lastReport time.Time
+	layers     map[string]*layerProgress
+}
+
+func newPullProgressTracker(m metrics.Reporter, c *runtimeTypes.Container) *pullProgressTracker {
+	tracker := &pullProgressTracker{
+		metrics: m,
+		start:   time.Now(),
+		layers:  make(map[string]*layerProgress),
+	}
+	if c != nil {
+		tracker.report = c.OnPullProgress
+		if c.TitusInfo != nil && c.TitusInfo.ImageName != nil {
+			tracker.tags = c.ImageTagForMetrics()
+		}
+	}
+	tracker.lastReport = tracker.start
+	return tracker
+}
+
+func (t *pullProgressTracker) layer(id string) *layerProgress {
+	l, ok := t.layers[id]
+	if !ok {
+		l = &layerProgress{}
+		t.layers[id] = l
+	}
+	return l
+}
+
+func (t *pullProgressTracker) handle(msg pullMessage) {
+	now := time.Now()
+	switch msg.Status {
+	case "Pulling fs layer", "Waiting":
+		t.layer(msg.ID)
+	case "Already exists":
+		l := t.layer(msg.ID)
+		l.cached = true
+		l.done = true
+	case "Downloading":
+		l := t.layer(msg.ID)
+		if l.downloadStart.IsZero() {
+			l.downloadStart = now
+		}
+		l.current = msg.ProgressDetail.Current
+		l.total = msg.ProgressDetail.Total
+	case "Download complete":
+		l := t.layer(msg.ID)
+		l.downloadEnd = now
+		l.current = l.total
+	case "Extracting":
+		l := t.layer(msg.ID)
+		if l.extractStart.IsZero() {
+			l.extractStart = now
+		}
+	case "Pull complete":
+		l := t.layer(msg.ID)
+		l.extractEnd = now
+		l.done = true
+	default:
+		// These are messages about the image as a whole, like "Pulling from ...", or "Digest: ..."
+		return
+	}
+
+	if now.Sub(t.lastReport) >= pullProgressInterval {
+		t.lastReport = now
+		t.doReport()
+	}
+}
+
+func (t *pullProgressTracker) progress() runtimeTypes.PullProgress {
+	progress := runtimeTypes.PullProgress{
+		Layers:  len(t.layers),
+		Elapsed: time.Since(t.start),
+	}
+	for _, l := range t.layers {
+		if l.cached {
+			progress.LayersCached++
+		}
+		if l.done {
+			progress.LayersDone++
+		}
+		progress.BytesTotal += l.total
+		progress.BytesDone += l.current
+	}
+	return progress
+}
+
+func (t *pullProgressTracker) doReport() {
+	if t.report != nil {
+		t.report(t.progress())
+	}
+}
+
+// finish reports the final progress of a successful pull, and emits metrics about its layers
+func (t *pullProgressTracker) finish() {
+	t.doReport()
+
+	var downloaded, cached int
+	var bytesDownloaded int64
+	var downloadStart, downloadEnd time.Time
+	for _, l := range t.layers {
+		if l.cached {
+			cached++
+			continue
+		}
+		if l.downloadStart.IsZero() || l.downloadEnd.IsZero() {
+			continue
+		}
+		downloaded++
+		bytesDownloaded += l.total
+		t.metrics.Timer("titus.executor.imagePull.layerDownloadTime", l.downloadEnd.Sub(l.downloadStart), t.tags)
+		if !l.extractStart.IsZero() && !l.extractEnd.IsZero() {
+			t.metrics.Timer("titus.executor.imagePull.layerExtractTime", l.extractEnd.Sub(l.extractStart), t.tags)
+		}
+		if downloadStart.IsZero() || l.downloadStart.Before(downloadStart) {
+			downloadStart = l.downloadStart
+		}
+		if l.downloadEnd.After(downloadEnd) {
+			downloadEnd = l.downloadEnd
+		}
+	}
+
+	t.metrics.Counter("titus.executor.imagePull.layersCached", cached, t.tags)
+	t.metrics.Counter("titus.executor.imagePull.layersDownloaded", downloaded, t.tags)
+	t.metrics.Counter("titus.executor.imagePull.bytesDownloaded", int(bytesDownloaded), t.tags)
+	// Layers are downloaded in parallel, so the throughput is over the time that any of them were being downloaded
+	if downloadTime := downloadEnd.Sub(downloadStart); downloaded > 0 && downloadTime > 0 {
+		t.metrics.Gauge("titus.executor.imagePull.bytesPerSecond", int(float64(bytesDownloaded)/downloadTime.Seconds()), t.tags)
+	}
+}
+
+// logFailure logs where the pull got to, in place of the raw messages from dockerd
+func (t *pullProgressTracker) logFailure() {
+	log.WithField("progress", t.progress().String()).Warning("Image pull failed")
+	for id, l := range t.layers {
+		log.WithField("layer", id).WithField("cached", l.cached).WithField("done", l.done).WithField("current", l.current).WithField("total", l.total).Warning("Layer progress")
+	}
+}
@@ -0,0 +1,148 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	"github.com/Netflix/titus-executor/api/netflix/titus"
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	"github.com/Netflix/titus-executor/imagecache"
+	imageCacheClient "github.com/Netflix/titus-executor/imagecache/client"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPullMessage(id, status string, current, total int64) pullMessage {
+	msg := pullMessage{ID: id, Status: status}
+	msg.ProgressDetail.Current = current
+	msg.ProgressDetail.Total = total
+	return msg
+}
+
+func TestPullProgressTracker(t *testing.T) {
+	oldPullProgressInterval := pullProgressInterval
+	pullProgressInterval = 0
+	defer func() {
+		pullProgressInterval = oldPullProgressInterval
+	}()
+
+	reports := []runtimeTypes.PullProgress{}
+	c := &runtimeTypes.Container{
+		OnPullProgress: func(progress runtimeTypes.PullProgress) {
+			reports = append(reports, progress)
+		},
+	}
+	tracker := newPullProgressTracker(metrics.Discard, c)
+
+	messages := []pullMessage{
+		newPullMessage("latest", "Pulling from titusops/alpine", 0, 0),
+		newPullMessage("aaa", "Already exists", 0, 0),
+		newPullMessage("bbb", "Pulling fs layer", 0, 0),
+		newPullMessage("ccc", "Waiting", 0, 0),
+		newPullMessage("bbb", "Downloading", 512, 2048),
+	}
+	for _, msg := range messages {
+		tracker.handle(msg)
+	}
+	assert.Equal(t, 3, tracker.progress().Layers)
+	assert.Equal(t, 1, tracker.progress().LayersCached)
+	assert.Equal(t, 1, tracker.progress().LayersDone)
+	assert.Equal(t, int64(512), tracker.progress().BytesDone)
+	assert.Equal(t, int64(2048), tracker.progress().BytesTotal)
+
+	messages = []pullMessage{
+		newPullMessage("bbb", "Download complete", 0, 0),
+		newPullMessage("ccc", "Downloading", 100, 1024),
+		newPullMessage("ccc", "Download complete", 0, 0),
+		newPullMessage("bbb", "Extracting", 1024, 2048),
+		newPullMessage("bbb", "Pull complete", 0, 0),
+		newPullMessage("ccc", "Extracting", 1024, 1024),
+		newPullMessage("ccc", "Pull complete", 0, 0),
+		newPullMessage("", "Digest: sha256:abc", 0, 0),
+	}
+	for _, msg := range messages {
+		tracker.handle(msg)
+	}
+	tracker.finish()
+
+	progress := tracker.progress()
+	assert.Equal(t, 3, progress.LayersDone)
+	assert.Equal(t, int64(3072), progress.BytesDone)
+	assert.Equal(t, int64(3072), progress.BytesTotal)
+
+	// Every layer message is reported with the interval at 0, along with the final report
+	assert.Len(t, reports, 12)
+	last := reports[len(reports)-1]
+	assert.Equal(t, 3, last.LayersDone)
+}
+
+func TestPullProgressTrackerThrottled(t *testing.T) {
+	reported := 0
+	c := &runtimeTypes.Container{
+		OnPullProgress: func(runtimeTypes.PullProgress) {
+			reported++
+		},
+	}
+	tracker := newPullProgressTracker(metrics.Discard, c)
+	tracker.handle(newPullMessage("aaa", "Downloading", 1, 10))
+	tracker.handle(newPullMessage("aaa", "Downloading", 2, 10))
+	assert.Equal(t, 0, reported)
+
+	tracker.lastReport = time.Now().Add(-pullProgressInterval)
+	tracker.handle(newPullMessage("aaa", "Downloading", 3, 10))
+	assert.Equal(t, 1, reported)
+}
+
+func TestPullProgressString(t *testing.T) {
+	progress := runtimeTypes.PullProgress{
+		Layers:       5,
+		LayersCached: 2,
+		LayersDone:   3,
+		BytesTotal:   300 << 20,
+		BytesDone:    120 << 20,
+		Elapsed:      90*time.Second + time.Millisecond,
+	}
+	assert.Equal(t, "3/5 layers (2 cached), 120/300 MiB, 1m30s", progress.String())
+}
+
+func TestPullThroughImageCacheReportsProgress(t *testing.T) {
+	oldPullProgressInterval := pullProgressInterval
+	pullProgressInterval = 0
+	defer func() {
+		pullProgressInterval = oldPullProgressInterval
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("progress"))
+		encoder := json.NewEncoder(w)
+		for _, msg := range []pullMessage{newPullMessage("aaa", "Downloading", 512, 1024), newPullMessage("aaa", "Pull complete", 0, 0)} {
+			progress := imagecache.PullMessage(msg)
+			assert.NoError(t, encoder.Encode(imagecache.PullStreamMessage{Progress: &progress}))
+		}
+		assert.NoError(t, encoder.Encode(imagecache.PullStreamMessage{Result: &imagecache.PullResult{ID: "sha256:image1"}}))
+	}))
+	defer server.Close()
+	icc, err := imageCacheClient.NewImageCacheClient(server.URL)
+	require.NoError(t, err)
+
+	reports := []runtimeTypes.PullProgress{}
+	c := &runtimeTypes.Container{
+		TitusInfo: &titus.ContainerInfo{ImageName: proto.String("titusops/alpine"), Version: proto.String("latest")},
+		OnPullProgress: func(progress runtimeTypes.PullProgress) {
+			reports = append(reports, progress)
+		},
+	}
+	r := &DockerRuntime{metrics: metrics.Discard, imageCache: icc}
+	require.True(t, r.pullThroughImageCache(context.Background(), c))
+	require.NotEmpty(t, reports)
+	last := reports[len(reports)-1]
+	assert.Equal(t, 1, last.Layers)
+	assert.Equal(t, 1, last.LayersDone)
+	assert.Equal(t, int64(1024), last.BytesTotal)
+}
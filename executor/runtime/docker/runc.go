@@ -0,0 +1,885 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	"github.com/Netflix/titus-executor/api/netflix/titus"
+	"github.com/Netflix/titus-executor/config"
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	"github.com/Netflix/titus-executor/fslocker"
+	docker "github.com/docker/docker/client"
+	"github.com/hashicorp/go-multierror"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	runcImageTag = "titus"
+	// runcImageLocksDir is in the image store, but isn't an image
+	runcImageLocksDir = "locks"
+	// This is where dockerd puts its init (tini) in the container too
+	runcInitContainerPath = "/sbin/docker-init"
+)
+
+var (
+	runcBinary           string
+	runcBundleRoot       string
+	runcImageStore       string
+	runcImageStoreMaxAge time.Duration
+	runcInitBinary       string
+	skopeoBinary         string
+	umociBinary          string
+)
+
+// RuncFlags are the configuration for the runc runtime
+var RuncFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:        "titus.executor.runc.binary",
+		Value:       "runc",
+		Destination: &runcBinary,
+	},
+	cli.StringFlag{
+		Name:        "titus.executor.runc.bundleRoot",
+		Value:       "/run/titus-executor/bundles",
+		Destination: &runcBundleRoot,
+	},
+	cli.StringFlag{
+		Name:        "titus.executor.runc.imageStore",
+		Value:       "/var/lib/titus-executor/images",
+		Destination: &runcImageStore,
+	},
+	cli.DurationFlag{
+		Name:        "titus.executor.runc.imageStoreMaxAge",
+		Usage:       "How long an image in the image store can go unused before it's garbage collected",
+		Value:       72 * time.Hour,
+		Destination: &runcImageStoreMaxAge,
+	},
+	cli.StringFlag{
+		Name:        "titus.executor.runc.initBinary",
+		Value:       "/usr/bin/docker-init",
+		Destination: &runcInitBinary,
+	},
+	cli.StringFlag{
+		Name:        "titus.executor.runc.skopeo",
+		Value:       "skopeo",
+		Destination: &skopeoBinary,
+	},
+	cli.StringFlag{
+		Name:        "titus.executor.runc.umoci",
+		Value:       "umoci",
+		Destination: &umociBinary,
+	},
+}
+
+// RuncRuntime implements the Runtime interface by calling runc directly, instead of going through dockerd. Images are
+// fetched with skopeo, and unpacked into an OCI bundle with umoci. It lives alongside the Docker runtime because
+// it shares the tini handoff, VPC, and EFS plumbing with it.
+type RuncRuntime struct {
+	// common is a Docker runtime without a client, and it must only be used for the plumbing which doesn't talk to dockerd
+	common *DockerRuntime
+
+	// imageLocker serializes fetching, unpacking, and garbage collecting each image in the image store, across executors
+	imageLocker *fslocker.FSLocker
+
+	sync.Mutex
+	processes map[string]*runcProcess
+}
+
+// runcProcess is a `runc run` process, which lives as long as the container does
+type runcProcess struct {
+	cmd    *exec.Cmd
+	exited chan struct{}
+	// err is only valid once exited is closed
+	err error
+}
+
+// NewRuncRuntime creates a runtime which runs containers with runc
+func NewRuncRuntime(executorCtx context.Context, m metrics.Reporter, cfg config.Config) (runtimeTypes.Runtime, error) {
+	for _, binary := range []string{runcBinary, skopeoBinary, umociBinary} {
+		if _, err := exec.LookPath(binary); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := os.Stat(runcInitBinary); err != nil {
+		return nil, err
+	}
+
+	common := &DockerRuntime{
+		metrics:     m,
+		cfg:         cfg,
+		tiniEnabled: true,
+	}
+
+	var err error
+	common.pidCgroupPath, err = getOwnCgroup("pids")
+	if err != nil {
+		return nil, err
+	}
+
+	common.awsRegion = os.Getenv("EC2_REGION")
+
+	if imagePolicyFile != "" {
+		common.imagePolicy, err = loadImagePolicy(imagePolicyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.UseNewNetworkDriver {
+		if err = common.setupVPC(executorCtx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = setupLoggingInfra(common); err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{runcBundleRoot, runcImageStore} {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	imageLocker, err := fslocker.NewFSLocker(filepath.Join(runcImageStore, runcImageLocksDir))
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-executorCtx.Done()
+		if cleanupErr := os.RemoveAll(common.tiniSocketDir); cleanupErr != nil {
+			log.Errorf("Could not cleanup tini socket directory %s because: %v", common.tiniSocketDir, cleanupErr)
+		}
+	}()
+
+	r := &RuncRuntime{
+		common:      common,
+		imageLocker: imageLocker,
+		processes:   make(map[string]*runcProcess),
+	}
+	go r.gcImageStore(time.Now())
+	return r, nil
+}
+
+func (r *RuncRuntime) bundleDir(c *runtimeTypes.Container) string {
+	return filepath.Join(runcBundleRoot, c.TaskID)
+}
+
+// imageLayoutName returns the name of the OCI image layout that an image is fetched into. Every image gets its own
+// layout, so concurrent fetches of different images don't race on the layout's index.
+func imageLayoutName(ref string) string {
+	sum := sha256.Sum256([]byte(ref))
+	return hex.EncodeToString(sum[:])
+}
+
+func imageLayoutDir(ref string) string {
+	return filepath.Join(runcImageStore, imageLayoutName(ref))
+}
+
+func (r *RuncRuntime) cgroupPath(c *runtimeTypes.Container) string {
+	return filepath.Join(r.common.pidCgroupPath, c.TaskID)
+}
+
+// fetchAndUnpackImage holds the image's lock while the image is fetched, and unpacked, so concurrent fetches of the
+// same image don't race on its layout, and it isn't garbage collected out from under the unpack
+func (r *RuncRuntime) fetchAndUnpackImage(ctx context.Context, c *runtimeTypes.Container) error {
+	var lockTimeout *time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		lockTimeout = &timeout
+	}
+	lock, err := r.imageLocker.ExclusiveLock(imageLayoutName(c.QualifiedImageName()), lockTimeout)
+	if err != nil {
+		return fmt.Errorf("Unable to lock image %s: %v", c.QualifiedImageName(), err)
+	}
+	defer lock.Unlock()
+
+	if err = r.fetchImage(ctx, c); err != nil {
+		return err
+	}
+	if r.common.imagePolicy != nil {
+		if err = r.checkImagePolicy(c); err != nil {
+			return err
+		}
+	}
+	if err = r.unpackImage(ctx, c); err != nil {
+		return err
+	}
+	// The layout's mtime is when it was last used, for garbage collection
+	now := time.Now()
+	return os.Chtimes(imageLayoutDir(c.QualifiedImageName()), now, now)
+}
+
+// checkImagePolicy checks the manifest that the image was fetched as against the image policy, before it's unpacked
+func (r *RuncRuntime) checkImagePolicy(c *runtimeTypes.Container) error {
+	digest, err := layoutManifestDigest(imageLayoutDir(c.QualifiedImageName()))
+	if err != nil {
+		return err
+	}
+	if err = r.common.imagePolicy.checkDigests(c, []string{digest}); err != nil {
+		r.common.metrics.Counter("titus.executor.imagePolicyViolation", 1, nil)
+		return err
+	}
+	log.WithField("image", c.QualifiedImageName()).WithField("digest", digest).Info("Image allowed by image policy")
+	return nil
+}
+
+// layoutManifestDigest returns the digest of the manifest that runcImageTag refers to in the OCI image layout
+func layoutManifestDigest(layout string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(layout, "index.json"))
+	if err != nil {
+		return "", err
+	}
+	var index struct {
+		Manifests []struct {
+			Digest      string            `json:"digest"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"manifests"`
+	}
+	if err = json.Unmarshal(data, &index); err != nil {
+		return "", fmt.Errorf("Unable to parse image index of %s: %v", layout, err)
+	}
+	for _, manifest := range index.Manifests {
+		if manifest.Annotations["org.opencontainers.image.ref.name"] == runcImageTag {
+			return manifest.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("Image index of %s has no manifest for %s", layout, runcImageTag)
+}
+
+// gcImageStore removes the images which haven't been used for runcImageStoreMaxAge. Images which are locked are being
+// fetched, or unpacked, so they're left alone.
+func (r *RuncRuntime) gcImageStore(now time.Time) {
+	entries, err := ioutil.ReadDir(runcImageStore)
+	if err != nil {
+		log.Warning("Unable to list image store for garbage collection: ", err)
+		return
+	}
+	noWait := time.Duration(0)
+	for _, entry := range entries {
+		if entry.Name() == runcImageLocksDir || !entry.IsDir() || now.Sub(entry.ModTime()) < runcImageStoreMaxAge {
+			continue
+		}
+		lock, err := r.imageLocker.ExclusiveLock(entry.Name(), &noWait)
+		if err != nil {
+			continue
+		}
+		// Check again, now that nobody can be using it
+		if fi, statErr := os.Stat(filepath.Join(runcImageStore, entry.Name())); statErr == nil && now.Sub(fi.ModTime()) >= runcImageStoreMaxAge {
+			log.WithField("layout", entry.Name()).Info("Removing unused image from image store")
+			if err = os.RemoveAll(filepath.Join(runcImageStore, entry.Name())); err != nil {
+				r.common.metrics.Counter("titus.executor.runcImageStoreGCError", 1, nil)
+				log.Warningf("Unable to remove image %s: %v", entry.Name(), err)
+			}
+		}
+		lock.Unlock()
+	}
+}
+
+func (r *RuncRuntime) fetchImage(ctx context.Context, c *runtimeTypes.Container) error {
+	layout := imageLayoutDir(c.QualifiedImageName())
+	puller := func(ctx context.Context, _ metrics.Reporter, _ *docker.Client, ref string) error {
+		output, err := exec.CommandContext(ctx, skopeoBinary, "copy", "docker://"+ref, "oci:"+layout+":"+runcImageTag).CombinedOutput() // nolint: gas
+		if err != nil {
+			r.common.metrics.Counter("titus.executor.runcFetchImageError", 1, nil)
+			log.Warningf("Error fetching image '%s', due to reason: %v: %s", ref, err, string(output))
+			return fmt.Errorf("Error while fetching image: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+
+	return pullWithRetries(ctx, r.common.metrics, nil, c, puller)
+}
+
+func (r *RuncRuntime) unpackImage(ctx context.Context, c *runtimeTypes.Container) error {
+	layout := imageLayoutDir(c.QualifiedImageName())
+	output, err := exec.CommandContext(ctx, umociBinary, "unpack", "--image", layout+":"+runcImageTag, r.bundleDir(c)).CombinedOutput() // nolint: gas
+	if err != nil {
+		return fmt.Errorf("Unable to unpack image: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Prepare fetches the image, unpacks it into a bundle, and writes the container's OCI runtime spec into the bundle
+func (r *RuncRuntime) Prepare(parentCtx context.Context, c *runtimeTypes.Container, binds []string) error {
+	log.WithField("prepareTimeout", prepareTimeout).Info("Preparing container")
+	ctx, cancel := context.WithTimeout(parentCtx, prepareTimeout)
+	defer cancel()
+	prepareStartTime := time.Now()
+
+	err := r.prepare(ctx, c, binds)
+	if err != nil {
+		log.Error("Unable to create container: ", err)
+		r.common.metrics.Counter("titus.executor.runcCreateContainerError", 1, nil)
+		return err
+	}
+	r.common.metrics.Timer("titus.executor.runcCreateTime", time.Since(prepareStartTime), c.ImageTagForMetrics())
+	return nil
+}
+
+// checkSupported fails tasks which ask for something the runc runtime can't do, before anything is set up for them
+func (r *RuncRuntime) checkSupported(c *runtimeTypes.Container) error {
+	if c.TitusInfo.GetNumGpus() > 0 {
+		return errors.New("GPUs are not supported by the runc runtime")
+	}
+	if c.TitusInfo.GetSnapshotPolicy() != titus.ContainerInfo_NEVER {
+		return errors.New("Snapshots are not supported by the runc runtime")
+	}
+	if !r.common.cfg.UseNewNetworkDriver {
+		// Without the VPC driver, containers share the host's network namespace, so ports can't be remapped
+		for _, portMapping := range c.PortMappings {
+			if portMapping.HostPort != portMapping.ContainerPort {
+				return fmt.Errorf("Port mapping %d:%d needs the VPC driver with the runc runtime", portMapping.HostPort, portMapping.ContainerPort)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *RuncRuntime) prepare(ctx context.Context, c *runtimeTypes.Container, binds []string) error {
+	var err error
+	c.PortMappings, err = c.GetPortMappings()
+	if err != nil {
+		return err
+	}
+	if err = r.checkSupported(c); err != nil {
+		return err
+	}
+	if r.common.imagePolicy != nil {
+		if err = r.common.imagePolicy.checkRef(c); err != nil {
+			r.common.metrics.Counter("titus.executor.imagePolicyViolation", 1, nil)
+			return err
+		}
+	}
+
+	if r.common.cfg.UseNewNetworkDriver {
+		if err = r.common.prepareNetworkDriver(ctx, c); err != nil {
+			return err
+		}
+	} else {
+		// Don't call out to network driver for local development
+		mockIP := "1.2.3.4"
+		log.Printf("Mocking networking configuration in dev mode to IP %s", mockIP)
+		c.Allocation.IPV4Address = mockIP
+	}
+
+	// The bundle is the container as far as runc is concerned, so we use the task ID for both
+	c.ID = c.TaskID
+	// Cleanups run in reverse, so the marker goes once the bundle, and the container's cgroup are gone
+	c.RegisterRuntimeCleanup(func() error {
+		return removeCgroupMarker(c.TaskID)
+	})
+	if err = writeCgroupMarker(c.TaskID, r.cgroupPath(c)); err != nil {
+		return err
+	}
+	c.RegisterRuntimeCleanup(func() error {
+		return os.RemoveAll(r.bundleDir(c))
+	})
+	if err = r.fetchAndUnpackImage(ctx, c); err != nil {
+		return err
+	}
+
+	if err = r.writeSpec(c, binds); err != nil {
+		return err
+	}
+
+	rootfs := filepath.Join(r.bundleDir(c), "rootfs")
+	if c.MetatronConfig != nil {
+		if err = untar(rootfs, bytes.NewReader(c.MetatronConfig.TruststoreTarBuf.Bytes())); err != nil {
+			return err
+		}
+		tarBuf, err := metatronCredentialsTar(c)
+		if err != nil {
+			return err
+		}
+		if err = untar(rootfs, tarBuf); err != nil {
+			return err
+		}
+	}
+
+	if err = r.common.createTitusEnvironmentFile(c); err != nil {
+		return err
+	}
+	if err = r.common.createTitusContainerConfigFile(c); err != nil {
+		return err
+	}
+
+	tarBuf, err := environmentTar(c)
+	if err != nil {
+		return err
+	}
+	return untar(rootfs, tarBuf)
+}
+
+func (r *RuncRuntime) writeSpec(c *runtimeTypes.Container, binds []string) error {
+	configFile := filepath.Join(r.bundleDir(c), "config.json")
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	spec := &specs.Spec{}
+	if err = json.Unmarshal(data, spec); err != nil {
+		return err
+	}
+
+	if err = r.runcSpec(c, binds, spec); err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(configFile, data, 0600)
+}
+
+// runcSpec applies the task's configuration on top of the spec that was generated from the image's configuration
+func (r *RuncRuntime) runcSpec(c *runtimeTypes.Container, binds []string, spec *specs.Spec) error { // nolint: gocyclo
+	entrypoint, err := c.GetEntrypointFromProto()
+	if err != nil {
+		return err
+	}
+
+	if c.TitusInfo.IamProfile == nil || c.TitusInfo.GetIamProfile() == "" {
+		return ErrMissingIAMRole
+	}
+	c.Env["TITUS_IAM_ROLE"] = c.TitusInfo.GetIamProfile()
+	c.Env["EC2_LOCAL_IPV4"] = c.Allocation.IPV4Address
+	if c.Allocation.IPV6Address != "" {
+		c.Env["EC2_IPV6"] = c.Allocation.IPV6Address
+	}
+	setupTiniEnv(c, tiniSocketFileName(c))
+
+	if spec.Process == nil {
+		spec.Process = &specs.Process{}
+	}
+	if entrypoint != nil {
+		spec.Process.Args = entrypoint
+	}
+	if len(spec.Process.Args) == 0 {
+		return NoEntrypointError
+	}
+	spec.Process.Args = append([]string{runcInitContainerPath, "--"}, spec.Process.Args...)
+	spec.Process.Env = mergeEnv(spec.Process.Env, c.Env)
+	spec.Process.Terminal = false
+
+	coreLimit := (c.Resources.Disk * MiB) + 1*GiB
+	spec.Process.Rlimits = append(spec.Process.Rlimits, specs.POSIXRlimit{Type: "RLIMIT_CORE", Soft: coreLimit, Hard: coreLimit})
+	if spec.Process.Capabilities == nil {
+		spec.Process.Capabilities = &specs.LinuxCapabilities{}
+	}
+	if capabilities := c.TitusInfo.GetCapabilities(); capabilities != nil {
+		for _, add := range capabilities.GetAdd() {
+			addCapability(spec.Process.Capabilities, "CAP_"+add.String())
+		}
+		for _, drop := range capabilities.GetDrop() {
+			dropCapability(spec.Process.Capabilities, "CAP_"+drop.String())
+		}
+	}
+
+	spec.Hostname = strings.ToLower(c.TaskID)
+	spec.Annotations = c.Labels
+
+	bindMounts, err := parseBinds(binds)
+	if err != nil {
+		return err
+	}
+	spec.Mounts = append(spec.Mounts, bindMounts...)
+	spec.Mounts = append(spec.Mounts,
+		specs.Mount{Destination: tiniSocketContainerDir, Type: "bind", Source: r.common.tiniSocketDir, Options: []string{"rbind", "ro"}},
+		specs.Mount{Destination: runcInitContainerPath, Type: "bind", Source: runcInitBinary, Options: []string{"bind", "ro"}},
+	)
+
+	if spec.Linux == nil {
+		spec.Linux = &specs.Linux{}
+	}
+	spec.Linux.CgroupsPath = r.cgroupPath(c)
+	spec.Linux.Sysctl = map[string]string{
+		"net.ipv4.tcp_ecn":                   "1",
+		"net.ipv6.conf.all.disable_ipv6":     "0",
+		"net.ipv6.conf.default.disable_ipv6": "0",
+		"net.ipv6.conf.lo.disable_ipv6":      "0",
+	}
+
+	var devices []specs.LinuxDeviceCgroup
+	if spec.Linux.Resources != nil {
+		devices = spec.Linux.Resources.Devices
+	}
+	memory := c.Resources.Mem * MiB
+	cpuShares := uint64(100 * c.Resources.CPU)
+	spec.Linux.Resources = &specs.LinuxResources{
+		Devices: devices,
+		Memory:  &specs.LinuxMemory{Limit: &memory, Swap: &memory},
+		CPU:     &specs.LinuxCPU{Shares: &cpuShares},
+		Pids:    &specs.LinuxPids{Limit: int64(pidLimit)},
+	}
+
+	if !r.common.cfg.UseNewNetworkDriver {
+		// There's no bridge without dockerd, so in dev mode containers share the host's network namespace
+		namespaces := spec.Linux.Namespaces[:0]
+		for _, ns := range spec.Linux.Namespaces {
+			if ns.Type != specs.NetworkNamespace {
+				namespaces = append(namespaces, ns)
+			}
+		}
+		spec.Linux.Namespaces = namespaces
+	}
+
+	return nil
+}
+
+// mergeEnv overrides the image's environment variables with the task's
+func mergeEnv(imageEnv []string, env map[string]string) []string {
+	merged := make(map[string]string, len(imageEnv)+len(env))
+	for _, kv := range imageEnv {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			merged[parts[0]] = parts[1]
+		}
+	}
+	for key, val := range env {
+		merged[key] = val
+	}
+	return getSortedEnvArray(merged)
+}
+
+func addCapability(capabilities *specs.LinuxCapabilities, capability string) {
+	for _, set := range []*[]string{&capabilities.Bounding, &capabilities.Effective, &capabilities.Inheritable, &capabilities.Permitted} {
+		if !containsString(*set, capability) {
+			*set = append(*set, capability)
+		}
+	}
+}
+
+func dropCapability(capabilities *specs.LinuxCapabilities, capability string) {
+	for _, set := range []*[]string{&capabilities.Bounding, &capabilities.Effective, &capabilities.Inheritable, &capabilities.Permitted, &capabilities.Ambient} {
+		filtered := (*set)[:0]
+		for _, existing := range *set {
+			if existing != capability {
+				filtered = append(filtered, existing)
+			}
+		}
+		*set = filtered
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBinds converts Docker formatted "src:dst[:mode]" binds into OCI mounts
+func parseBinds(binds []string) ([]specs.Mount, error) {
+	mounts := make([]specs.Mount, 0, len(binds))
+	for _, bind := range binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("Invalid bind mount: %s", bind)
+		}
+		options := []string{"rbind", "rw"}
+		if len(parts) == 3 && parts[2] == "ro" {
+			options = []string{"rbind", "ro"}
+		}
+		mounts = append(mounts, specs.Mount{Destination: parts[1], Type: "bind", Source: parts[0], Options: options})
+	}
+	return mounts, nil
+}
+
+// untar extracts the directories, and regular files of a tarball into root. Entries cannot escape root, either with
+// "..", or through symlinks which the image put in root.
+func untar(root string, r io.Reader) error {
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var target string
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if target, err = mkdirAllNoFollow(root, header.Name, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+			// MkdirAll doesn't change the mode of directories which already exist, or apply it through the umask
+			if err = os.Chmod(target, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if _, err = mkdirAllNoFollow(root, filepath.Dir(filepath.Clean("/"+header.Name)), 0755); err != nil {
+				return err
+			}
+			if target, err = secureJoin(root, header.Name); err != nil {
+				return err
+			}
+			if err = writeFileFromReader(target, os.FileMode(header.Mode), tarReader); err != nil {
+				return err
+			}
+		default:
+			log.WithField("name", header.Name).Warning("Skipping unsupported tar entry type: ", header.Typeflag)
+		}
+	}
+}
+
+// secureJoin joins name onto root, and fails if any part of the path that exists under root is a symlink. The image
+// controls the contents of root, so following a symlink could write anywhere on the host. The bundle isn't running
+// yet, so nothing can swap a component for a symlink after it's checked.
+func secureJoin(root, name string) (string, error) {
+	target := root
+	for _, component := range strings.Split(filepath.Clean("/"+name), "/") {
+		if component == "" {
+			continue
+		}
+		target = filepath.Join(target, component)
+		fi, err := os.Lstat(target)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("Refusing to write %s, as %s is a symlink", name, strings.TrimPrefix(target, root))
+		}
+	}
+	return target, nil
+}
+
+// mkdirAllNoFollow is os.MkdirAll, for a path under root which mustn't go through symlinks
+func mkdirAllNoFollow(root, name string, mode os.FileMode) (string, error) {
+	target, err := secureJoin(root, name)
+	if err != nil {
+		return "", err
+	}
+	return target, os.MkdirAll(target, mode)
+}
+
+func writeFileFromReader(target string, mode os.FileMode, r io.Reader) error {
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, mode) // nolint: gas
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Start runs `runc run` for the bundle, and hands off to tini the same way as the Docker runtime
+func (r *RuncRuntime) Start(parentCtx context.Context, c *runtimeTypes.Container) (string, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, startTimeout)
+	defer cancel()
+
+	entry := log.WithField("taskID", c.TaskID)
+	entry.Info("Starting")
+	efsMountInfos, err := r.common.processEFSMounts(c)
+	if err != nil {
+		return "", err
+	}
+
+	listener, err := r.common.setupPreStartTini(ctx, c)
+	if err != nil {
+		return "", err
+	}
+
+	runcStartStartTime := time.Now()
+	// The container has to outlive the start context, so this isn't tied to ctx
+	cmd := exec.Command(runcBinary, "run", "--bundle", r.bundleDir(c), c.ID) // nolint: gas
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err = cmd.Start(); err != nil {
+		entry.Error("Error starting: ", err)
+		r.common.metrics.Counter("titus.executor.runcStartContainerError", 1, nil)
+		return "", err
+	}
+	process := &runcProcess{cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		process.err = cmd.Wait()
+		close(process.exited)
+	}()
+	r.Lock()
+	r.processes[c.ID] = process
+	r.Unlock()
+
+	// This can block for up the the full ctx timeout
+	logDir, containerCred, rootFile, unixConn, err := r.common.setupPostStartLogDirTini(ctx, listener, c)
+	if err != nil {
+		select {
+		case <-process.exited:
+			return "", maybeConvertIntoBadEntryPointError(fmt.Errorf("oci runtime error: %v", process.err))
+		default:
+			return "", err
+		}
+	}
+	r.common.metrics.Timer("titus.executor.runcStartTime", time.Since(runcStartStartTime), c.ImageTagForMetrics())
+	c.Pid = int(containerCred.pid)
+
+	err = r.common.setupEFSMounts(ctx, c, rootFile, containerCred, efsMountInfos)
+	if err != nil {
+		return "", err
+	}
+
+	err = launchTini(unixConn)
+	if err != nil {
+		shouldClose(unixConn)
+		return "", err
+	}
+	return logDir, nil
+}
+
+func (r *RuncRuntime) process(c *runtimeTypes.Container) *runcProcess {
+	r.Lock()
+	defer r.Unlock()
+	return r.processes[c.ID]
+}
+
+// Kill sends SIGTERM to the container, and SIGKILL to everything in it if it doesn't exit within the kill wait
+func (r *RuncRuntime) Kill(c *runtimeTypes.Container) error {
+	log.Infof("Killing %s", c.TaskID)
+
+	var errs *multierror.Error
+
+	containerStopTimeout := time.Second * time.Duration(c.TitusInfo.GetKillWaitSeconds())
+	if containerStopTimeout == 0 {
+		containerStopTimeout = defaultKillWait
+	}
+
+	process := r.process(c)
+	if process == nil {
+		goto stopped
+	}
+
+	if output, err := exec.Command(runcBinary, "kill", c.ID, "TERM").CombinedOutput(); err != nil { // nolint: gas
+		log.Errorf("container %s : stop %v: %s", c.TaskID, err, string(output))
+	}
+	select {
+	case <-process.exited:
+		goto stopped
+	case <-time.After(containerStopTimeout):
+	}
+
+	if output, err := exec.Command(runcBinary, "kill", "--all", c.ID, "KILL").CombinedOutput(); err != nil { // nolint: gas
+		r.common.metrics.Counter("titus.executor.runcKillContainerError", 1, nil)
+		log.Errorf("container %s : kill %v: %s", c.TaskID, err, string(output))
+		errs = multierror.Append(errs, err)
+	}
+	<-process.exited
+
+stopped:
+	releaseContainerResources(c)
+
+	return errs.ErrorOrNil()
+}
+
+// Cleanup deletes the container from runc, and runs the registered callbacks for a container
+func (r *RuncRuntime) Cleanup(c *runtimeTypes.Container) error {
+	errs := []error{}
+
+	if c.ID != "" {
+		if output, err := exec.Command(runcBinary, "delete", "--force", c.ID).CombinedOutput(); err != nil { // nolint: gas
+			r.common.metrics.Counter("titus.executor.runcRemoveContainerError", 1, nil)
+			log.Errorf("Failed to remove container '%s': %v: %s", c.TaskID, err, string(output))
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, c.RuntimeCleanup()...)
+
+	if len(errs) > 0 {
+		return &compositeError{errs}
+	}
+
+	return nil
+}
+
+// Details returns the container's addresses. Without dockerd, the only addresses are the VPC ones
+func (r *RuncRuntime) Details(c *runtimeTypes.Container) (*runtimeTypes.Details, error) {
+	details := &runtimeTypes.Details{
+		IPAddresses:  make(map[string]string),
+		PortMappings: c.PortMappings,
+	}
+
+	if r.common.cfg.UseNewNetworkDriver && c.Allocation.IPV4Address != "" {
+		details.IPAddresses["nfvpc"] = c.Allocation.IPV4Address
+		if c.Allocation.IPV6Address != "" {
+			details.IPAddresses["nfvpc-ipv6"] = c.Allocation.IPV6Address
+		}
+		details.NetworkConfiguration = &runtimeTypes.NetworkConfigurationDetails{
+			IsRoutableIP:   true,
+			IPAddress:      c.Allocation.IPV4Address,
+			EniIPAddress:   c.Allocation.IPV4Address,
+			EniIPv6Address: c.Allocation.IPV6Address,
+			EniID:          c.Allocation.ENI,
+			ResourceID:     fmt.Sprintf("resource-eni-%d", c.Allocation.DeviceIndex-1),
+			SecurityGroups: c.Allocation.SecurityGroups,
+		}
+	}
+
+	return details, nil
+}
+
+// Status returns the status of the container, based on the `runc run` process
+func (r *RuncRuntime) Status(c *runtimeTypes.Container) (runtimeTypes.Status, error) {
+	process := r.process(c)
+	if process == nil {
+		return runtimeTypes.StatusUnknown, errors.New("Container not started")
+	}
+
+	select {
+	case <-process.exited:
+	default:
+		return runtimeTypes.StatusRunning, nil
+	}
+
+	log.Printf("container %s : not running : %v", c.TaskID, process.err)
+	if process.err == nil {
+		return runtimeTypes.StatusFinished, nil
+	}
+	if exitErr, ok := process.err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return runtimeTypes.StatusFailed, fmt.Errorf("exited with code %d", status.ExitStatus())
+		}
+	}
+	return runtimeTypes.StatusFailed, process.err
+}
+
+// HealthCheck runs the task's health check command inside of the container with `runc exec`
+func (r *RuncRuntime) HealthCheck(parentCtx context.Context, c *runtimeTypes.Container) error {
+	ctx, cancel := context.WithTimeout(parentCtx, healthCheckTimeout)
+	defer cancel()
+
+	args := append([]string{"exec", c.ID}, c.TitusInfo.GetHealthCheckCmd()...)
+	if output, err := exec.CommandContext(ctx, runcBinary, args...).CombinedOutput(); err != nil { // nolint: gas
+		return &runtimeTypes.HealthCheckFailedError{Reason: fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))}
+	}
+	return nil
+}
+
+// Snapshot is not supported, as the bundle's rootfs is a full copy of the image, rather than a layer on top of it.
+// Tasks which ask for snapshots are failed in Prepare.
+func (r *RuncRuntime) Snapshot(ctx context.Context, c *runtimeTypes.Container, w io.Writer) error {
+	return errors.New("Snapshots are not supported by the runc runtime")
+}
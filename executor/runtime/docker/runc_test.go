@@ -0,0 +1,229 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	"github.com/Netflix/titus-executor/api/netflix/titus"
+	"github.com/Netflix/titus-executor/config"
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	"github.com/Netflix/titus-executor/fslocker"
+	protobuf "github.com/golang/protobuf/proto"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuncSpec(t *testing.T) {
+	r := &RuncRuntime{
+		common: &DockerRuntime{
+			tiniSocketDir: "/var/tmp/titus-executor-sockets",
+			pidCgroupPath: "/titus",
+		},
+	}
+	c := &runtimeTypes.Container{
+		TaskID: "Titus-123",
+		Env:    map[string]string{"FOO": "task"},
+		Labels: map[string]string{},
+		TitusInfo: &titus.ContainerInfo{
+			IamProfile: protobuf.String("arn:aws:iam::0:role/DefaultContainerRole"),
+			Capabilities: &titus.ContainerInfo_Capabilities{
+				Add: []titus.ContainerInfo_Capabilities_Capability{titus.ContainerInfo_Capabilities_NET_ADMIN},
+			},
+		},
+		Resources: &runtimeTypes.Resources{Mem: 1024, CPU: 2},
+	}
+	spec := &specs.Spec{
+		Process: &specs.Process{
+			Args: []string{"/bin/sleep", "1"},
+			Env:  []string{"FOO=image", "PATH=/bin"},
+		},
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace}, {Type: specs.NetworkNamespace}},
+		},
+	}
+
+	require.NoError(t, r.runcSpec(c, []string{"/host:/container:ro"}, spec))
+	assert.Equal(t, []string{runcInitContainerPath, "--", "/bin/sleep", "1"}, spec.Process.Args)
+	assert.Contains(t, spec.Process.Env, "FOO=task")
+	assert.Contains(t, spec.Process.Env, "PATH=/bin")
+	assert.NotContains(t, spec.Process.Env, "FOO=image")
+	assert.Contains(t, spec.Process.Capabilities.Effective, "CAP_NET_ADMIN")
+	assert.Equal(t, "titus-123", spec.Hostname)
+	assert.Equal(t, "/titus/Titus-123", spec.Linux.CgroupsPath)
+	assert.Equal(t, int64(1024*MiB), *spec.Linux.Resources.Memory.Limit)
+	assert.Equal(t, uint64(200), *spec.Linux.Resources.CPU.Shares)
+	assert.Contains(t, spec.Mounts, specs.Mount{Destination: "/container", Type: "bind", Source: "/host", Options: []string{"rbind", "ro"}})
+	// Dev mode shares the host's network namespace
+	assert.Equal(t, []specs.LinuxNamespace{{Type: specs.PIDNamespace}}, spec.Linux.Namespaces)
+}
+
+func TestRuncSpecNoEntrypoint(t *testing.T) {
+	r := &RuncRuntime{common: &DockerRuntime{cfg: config.Config{UseNewNetworkDriver: true}}}
+	c := &runtimeTypes.Container{
+		TaskID: "Titus-123",
+		Env:    map[string]string{},
+		TitusInfo: &titus.ContainerInfo{
+			IamProfile: protobuf.String("arn:aws:iam::0:role/DefaultContainerRole"),
+		},
+		Resources: &runtimeTypes.Resources{},
+	}
+
+	assert.Equal(t, NoEntrypointError, r.runcSpec(c, nil, &specs.Spec{}))
+}
+
+func TestParseBinds(t *testing.T) {
+	mounts, err := parseBinds([]string{"/a:/b", "/c:/d:ro"})
+	require.NoError(t, err)
+	assert.Equal(t, []specs.Mount{
+		{Destination: "/b", Type: "bind", Source: "/a", Options: []string{"rbind", "rw"}},
+		{Destination: "/d", Type: "bind", Source: "/c", Options: []string{"rbind", "ro"}},
+	}, mounts)
+
+	_, err = parseBinds([]string{"/a"})
+	assert.Error(t, err)
+}
+
+func TestUntarStaysInRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "untar")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	contents := []byte("hello")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../../etc/escaped", Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write(contents)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	require.NoError(t, untar(root, &buf))
+	data, err := ioutil.ReadFile(filepath.Join(root, "etc", "escaped"))
+	require.NoError(t, err)
+	assert.Equal(t, contents, data)
+}
+
+func TestUntarRefusesSymlinks(t *testing.T) {
+	root, err := ioutil.TempDir("", "untar")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	outside, err := ioutil.TempDir("", "outside")
+	require.NoError(t, err)
+	defer os.RemoveAll(outside)
+
+	// The image's rootfs has a directory, and a file which point outside of it
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "etc")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "passwd"), filepath.Join(root, "passwd")))
+
+	for _, name := range []string{"etc/escaped", "etc/dir/escaped", "passwd"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		contents := []byte("hello")
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}))
+		_, err = tw.Write(contents)
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		assert.Error(t, untar(root, &buf), name)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "etc/", Mode: 0777, Typeflag: tar.TypeDir}))
+	require.NoError(t, tw.Close())
+	assert.Error(t, untar(root, &buf))
+
+	entries, err := ioutil.ReadDir(outside)
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+	fi, err := os.Stat(outside)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+}
+
+func TestGCImageStore(t *testing.T) {
+	oldImageStore, oldMaxAge := runcImageStore, runcImageStoreMaxAge
+	defer func() {
+		runcImageStore, runcImageStoreMaxAge = oldImageStore, oldMaxAge
+	}()
+	var err error
+	runcImageStore, err = ioutil.TempDir("", "images")
+	require.NoError(t, err)
+	defer os.RemoveAll(runcImageStore)
+	runcImageStoreMaxAge = time.Hour
+
+	locker, err := fslocker.NewFSLocker(filepath.Join(runcImageStore, runcImageLocksDir))
+	require.NoError(t, err)
+	r := &RuncRuntime{common: &DockerRuntime{metrics: metrics.Discard}, imageLocker: locker}
+
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	for _, name := range []string{"unused", "inuse", "recent"} {
+		require.NoError(t, os.Mkdir(filepath.Join(runcImageStore, name), 0700))
+	}
+	require.NoError(t, os.Chtimes(filepath.Join(runcImageStore, "unused"), old, old))
+	require.NoError(t, os.Chtimes(filepath.Join(runcImageStore, "inuse"), old, old))
+
+	noWait := time.Duration(0)
+	lock, err := locker.ExclusiveLock("inuse", &noWait)
+	require.NoError(t, err)
+	defer lock.Unlock()
+
+	r.gcImageStore(now)
+	for name, exists := range map[string]bool{"unused": false, "inuse": true, "recent": true, runcImageLocksDir: true} {
+		_, err = os.Stat(filepath.Join(runcImageStore, name))
+		assert.Equal(t, exists, err == nil, name)
+	}
+}
+
+func TestRuncCheckSupported(t *testing.T) {
+	r := &RuncRuntime{common: &DockerRuntime{cfg: config.Config{}}}
+	newContainer := func(info *titus.ContainerInfo) *runtimeTypes.Container {
+		return &runtimeTypes.Container{TitusInfo: info}
+	}
+
+	assert.NoError(t, r.checkSupported(newContainer(&titus.ContainerInfo{})))
+	assert.Error(t, r.checkSupported(newContainer(&titus.ContainerInfo{NumGpus: protobuf.Uint32(1)})))
+	assert.Error(t, r.checkSupported(newContainer(&titus.ContainerInfo{SnapshotPolicy: titus.ContainerInfo_ALWAYS.Enum()})))
+
+	c := newContainer(&titus.ContainerInfo{})
+	c.PortMappings = []runtimeTypes.PortMapping{{HostPort: 8080, ContainerPort: 8080}}
+	assert.NoError(t, r.checkSupported(c))
+	c.PortMappings = []runtimeTypes.PortMapping{{HostPort: 8080, ContainerPort: 80}}
+	assert.Error(t, r.checkSupported(c))
+	r.common.cfg.UseNewNetworkDriver = true
+	assert.NoError(t, r.checkSupported(c))
+}
+
+func TestRuncCheckImagePolicy(t *testing.T) {
+	oldImageStore := runcImageStore
+	defer func() {
+		runcImageStore = oldImageStore
+	}()
+	var err error
+	runcImageStore, err = ioutil.TempDir("", "images")
+	require.NoError(t, err)
+	defer os.RemoveAll(runcImageStore)
+
+	r := &RuncRuntime{common: &DockerRuntime{metrics: metrics.Discard, imagePolicy: &imagePolicy{BlockedDigests: []string{"sha256:bad"}}}}
+	c := newPolicyTestContainer("titusops/alpine", "latest", "")
+	layout := imageLayoutDir(c.QualifiedImageName())
+	require.NoError(t, os.Mkdir(layout, 0700))
+	writeIndex := func(digest string) {
+		index := `{"schemaVersion": 2, "manifests": [` +
+			`{"digest": "sha256:other", "annotations": {"org.opencontainers.image.ref.name": "other"}}, ` +
+			`{"digest": "` + digest + `", "annotations": {"org.opencontainers.image.ref.name": "` + runcImageTag + `"}}]}`
+		require.NoError(t, ioutil.WriteFile(filepath.Join(layout, "index.json"), []byte(index), 0600))
+	}
+
+	writeIndex("sha256:good")
+	assert.NoError(t, r.checkImagePolicy(c))
+	// The tag was fetched as a blocked manifest
+	writeIndex("sha256:bad")
+	assert.IsType(t, &runtimeTypes.ImagePolicyViolationError{}, r.checkImagePolicy(c))
+}
@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	"github.com/docker/docker/api/types/container"
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of changes returned by the Docker container diff API
+const (
+	changeModified = 0
+	changeAdded    = 1
+	changeDeleted  = 2
+)
+
+// The logs directory is already shipped by the log watcher, so there's no reason to snapshot it again
+var snapshotExcludedPaths = []string{"/logs"}
+
+type snapshotCandidate struct {
+	path     string
+	modified bool
+}
+
+// snapshotCandidates returns the paths which should be copied out of the container to capture its writable layer.
+// Added paths which are inside of another added path are skipped, because copying the parent copies them as well.
+// Modified paths need to be checked by the caller, as directories are marked as modified when their children change.
+func snapshotCandidates(changes []container.ContainerChangeResponseItem) []snapshotCandidate {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	candidates := []snapshotCandidate{}
+	addedDirs := []string{}
+	for _, change := range changes {
+		if change.Kind == changeDeleted || isExcludedFromSnapshot(change.Path) {
+			continue
+		}
+		if hasPathPrefix(change.Path, addedDirs) {
+			continue
+		}
+		if change.Kind == changeAdded {
+			addedDirs = append(addedDirs, change.Path)
+		}
+		candidates = append(candidates, snapshotCandidate{path: change.Path, modified: change.Kind == changeModified})
+	}
+
+	return candidates
+}
+
+func isExcludedFromSnapshot(p string) bool {
+	return hasPathPrefix(p, snapshotExcludedPaths)
+}
+
+func hasPathPrefix(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot writes a gzipped tarball of the files that the container added, or modified on top of its image
+func (r *DockerRuntime) Snapshot(ctx context.Context, c *runtimeTypes.Container, w io.Writer) error {
+	changes, err := r.client.ContainerDiff(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, candidate := range snapshotCandidates(changes) {
+		if candidate.modified {
+			stat, err := r.client.ContainerStatPath(ctx, c.ID, candidate.path)
+			if err != nil {
+				return err
+			}
+			// Only the changed children of a directory are interesting
+			if stat.Mode.IsDir() {
+				continue
+			}
+		}
+		if err = r.copyIntoSnapshot(ctx, c, candidate.path, tarWriter); err != nil {
+			return err
+		}
+	}
+
+	if err = tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// copyIntoSnapshot copies a path out of the container, and rewrites the tar entries to be relative to the container's root
+func (r *DockerRuntime) copyIntoSnapshot(ctx context.Context, c *runtimeTypes.Container, srcPath string, tarWriter *tar.Writer) error {
+	reader, _, err := r.client.CopyFromContainer(ctx, c.ID, srcPath)
+	if err != nil {
+		// The file could have been removed between the diff, and the copy
+		log.WithField("taskID", c.TaskID).WithField("path", srcPath).Warning("Unable to copy path into snapshot: ", err)
+		return nil
+	}
+	defer shouldClose(reader)
+
+	parentDir := strings.TrimPrefix(path.Dir(srcPath), "/")
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		header.Name = path.Join(parentDir, header.Name)
+		if header.FileInfo().Mode()&os.ModeDir != 0 {
+			header.Name = header.Name + "/"
+		}
+		if err = tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err = io.Copy(tarWriter, tarReader); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package docker
+
+import (
+	"time"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	vpcTypes "github.com/Netflix/titus-executor/vpc/types"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// trafficStatsInterval is how often the container's traffic is reported.
+	trafficStatsInterval = time.Minute
+)
+
+// reportTrafficStats publishes the container's traffic until stop is closed. The counters are reported as how much
+// they've gone up since the last report.
+func reportTrafficStats(m metrics.Reporter, taskID string, wiring vpcTypes.Wiring, stop <-chan struct{}) {
+	ticker := time.NewTicker(trafficStatsInterval)
+	defer ticker.Stop()
+
+	var last vpcTypes.TrafficStats
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		stats, err := wiring.Stats()
+		if err != nil {
+			log.WithField("taskID", taskID).Debug("Unable to get traffic stats: ", err)
+			continue
+		}
+		reportClassStats(m, taskID, "egress", last.Egress, stats.Egress)
+		reportClassStats(m, taskID, "ingress", last.Ingress, stats.Ingress)
+		last = *stats
+	}
+}
+
+func reportClassStats(m metrics.Reporter, taskID, direction string, last, current vpcTypes.ClassStats) {
+	tags := map[string]string{"taskId": taskID, "direction": direction}
+	m.Counter("titus.executor.network.bytes", int(counterDelta(last.Bytes, current.Bytes)), tags)
+	m.Counter("titus.executor.network.packets", int(counterDelta(last.Packets, current.Packets)), tags)
+	m.Counter("titus.executor.network.drops", int(counterDelta(last.Drops, current.Drops)), tags)
+	m.Counter("titus.executor.network.overlimits", int(counterDelta(last.Overlimits, current.Overlimits)), tags)
+}
+
+// counterDelta returns how much the counter has gone up. If it's gone down, either because it wrapped, or because the
+// class was replaced, it's counted from 0.
+func counterDelta(last, current uint64) uint64 {
+	if current < last {
+		return current
+	}
+	return current - last
+}
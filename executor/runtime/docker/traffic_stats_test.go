@@ -0,0 +1,91 @@
+package docker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	vpcTypes "github.com/Netflix/titus-executor/vpc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeWiring struct {
+	mu    sync.Mutex
+	stats []vpcTypes.TrafficStats
+}
+
+func (w *fakeWiring) Stats() (*vpcTypes.TrafficStats, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats[0]
+	if len(w.stats) > 1 {
+		w.stats = w.stats[1:]
+	}
+	return &stats, nil
+}
+
+func (w *fakeWiring) Teardown() {
+}
+
+type recordingReporter struct {
+	mu       sync.Mutex
+	counters map[string]int
+}
+
+func (r *recordingReporter) Flush() {
+}
+
+func (r *recordingReporter) Counter(name string, value int, tags map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[name+"."+tags["direction"]+"."+tags["taskId"]] += value
+}
+
+func (r *recordingReporter) Gauge(name string, value int, tags map[string]string) {
+}
+
+func (r *recordingReporter) Timer(name string, value time.Duration, tags map[string]string) {
+}
+
+func (r *recordingReporter) counter(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[name]
+}
+
+func TestReportTrafficStats(t *testing.T) {
+	oldTrafficStatsInterval := trafficStatsInterval
+	trafficStatsInterval = time.Millisecond
+	defer func() { trafficStatsInterval = oldTrafficStatsInterval }()
+
+	wiring := &fakeWiring{stats: []vpcTypes.TrafficStats{
+		{Egress: vpcTypes.ClassStats{Bytes: 100, Packets: 1}, Ingress: vpcTypes.ClassStats{Bytes: 1000, Drops: 2}},
+		{Egress: vpcTypes.ClassStats{Bytes: 300, Packets: 3}, Ingress: vpcTypes.ClassStats{Bytes: 1500, Drops: 2}},
+	}}
+	m := &recordingReporter{counters: make(map[string]int)}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		reportTrafficStats(m, "task", wiring, stop)
+		close(done)
+	}()
+
+	// Once all of the stats have been read, the counters stop going up
+	deadline := time.Now().Add(5 * time.Second)
+	for m.counter("titus.executor.network.bytes.ingress.task") != 1500 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	assert.Equal(t, 1500, m.counter("titus.executor.network.bytes.ingress.task"))
+	assert.Equal(t, 300, m.counter("titus.executor.network.bytes.egress.task"))
+	assert.Equal(t, 3, m.counter("titus.executor.network.packets.egress.task"))
+	assert.Equal(t, 2, m.counter("titus.executor.network.drops.ingress.task"))
+}
+
+func TestCounterDelta(t *testing.T) {
+	assert.Equal(t, uint64(5), counterDelta(10, 15))
+	// The class was replaced, or the counter wrapped
+	assert.Equal(t, uint64(3), counterDelta(10, 3))
+}
@@ -0,0 +1,120 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	runtimeTypes "github.com/Netflix/titus-executor/executor/runtime/types"
+	vpcTypes "github.com/Netflix/titus-executor/vpc/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLease struct {
+	allocation vpcTypes.Allocation
+	released   int
+}
+
+func (l *fakeLease) Allocation() vpcTypes.Allocation {
+	return l.allocation
+}
+
+func (l *fakeLease) Release() {
+	l.released++
+}
+
+type fakeAllocator struct {
+	req   vpcTypes.AllocationRequest
+	lease *fakeLease
+	err   error
+}
+
+func (a *fakeAllocator) Allocate(ctx context.Context, req vpcTypes.AllocationRequest) (vpcTypes.Lease, error) {
+	a.req = req
+	if a.err != nil {
+		return nil, a.err
+	}
+	return a.lease, nil
+}
+
+func TestPrepareNetworkDriver(t *testing.T) {
+	allocator := &fakeAllocator{
+		lease: &fakeLease{allocation: vpcTypes.Allocation{IPV4Address: "1.2.3.4", DeviceIndex: 2, Success: true, ENI: "eni-1"}},
+	}
+	r := &DockerRuntime{vpcAllocator: allocator}
+	c := &runtimeTypes.Container{
+		TaskID:             "task",
+		NormalizedENIIndex: 2,
+		SecurityGroupIDs:   []string{"sg-1", "sg-2"},
+	}
+
+	require.NoError(t, r.prepareNetworkDriver(context.Background(), c))
+	assert.Equal(t, 2, allocator.req.DeviceIndex)
+	assert.Equal(t, []string{"sg-1", "sg-2"}, allocator.req.SecurityGroups)
+	assert.Equal(t, "1.2.3.4", c.Allocation.IPV4Address)
+	assert.Equal(t, "eni-1", c.Allocation.ENI)
+
+	releaseContainerResources(c)
+	releaseContainerResources(c)
+	// The lease handles being released more than once
+	assert.Equal(t, 2, allocator.lease.released)
+}
+
+func TestPrepareNetworkDriverInvalidSecurityGroup(t *testing.T) {
+	allocator := &fakeAllocator{
+		err: errors.New("InvalidSecurityGroupID.NotFound: The security group 'sg-1' does not exist"),
+	}
+	r := &DockerRuntime{vpcAllocator: allocator}
+	c := &runtimeTypes.Container{TaskID: "task", NormalizedENIIndex: 1, SecurityGroupIDs: []string{"sg-1"}}
+
+	err := r.prepareNetworkDriver(context.Background(), c)
+	assert.IsType(t, &runtimeTypes.InvalidSecurityGroupError{}, err)
+	assert.Nil(t, c.AllocationLease)
+}
+
+func TestPrepareNetworkDriverIPv6(t *testing.T) {
+	allocateIPv6 = true
+	defer func() { allocateIPv6 = false }()
+
+	allocator := &fakeAllocator{
+		lease: &fakeLease{allocation: vpcTypes.Allocation{IPV4Address: "1.2.3.4", IPV6Address: "2600:1f18::1234", DeviceIndex: 1, Success: true, ENI: "eni-1"}},
+	}
+	r := &DockerRuntime{vpcAllocator: allocator}
+	c := &runtimeTypes.Container{TaskID: "task", NormalizedENIIndex: 1}
+
+	require.NoError(t, r.prepareNetworkDriver(context.Background(), c))
+	assert.True(t, allocator.req.IPv6)
+
+	details, err := r.Details(c)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", details.IPAddresses["nfvpc"])
+	assert.Equal(t, "2600:1f18::1234", details.IPAddresses["nfvpc-ipv6"])
+	assert.Equal(t, "1.2.3.4", details.NetworkConfiguration.EniIPAddress)
+	assert.Equal(t, "2600:1f18::1234", details.NetworkConfiguration.EniIPv6Address)
+}
+
+func TestPrepareNetworkDriverAnyInterface(t *testing.T) {
+	anyInterface, securityGroupQueueTimeout = true, time.Minute
+	defer func() {
+		anyInterface, securityGroupQueueTimeout = false, 0
+	}()
+
+	allocator := &fakeAllocator{
+		lease: &fakeLease{allocation: vpcTypes.Allocation{IPV4Address: "1.2.3.4", DeviceIndex: 3, Success: true, ENI: "eni-3", SecurityGroups: []string{"sg-1", "sg-2"}}},
+	}
+	r := &DockerRuntime{vpcAllocator: allocator}
+	c := &runtimeTypes.Container{TaskID: "task", NormalizedENIIndex: 1, SecurityGroupIDs: []string{"sg-2", "sg-1"}}
+
+	require.NoError(t, r.prepareNetworkDriver(context.Background(), c))
+	assert.True(t, allocator.req.AnyInterface)
+	assert.Equal(t, time.Minute, allocator.req.SecurityGroupQueueTimeout)
+
+	// The details are of the ENI the allocator chose, rather than the one the task asked for
+	details, err := r.Details(c)
+	require.NoError(t, err)
+	assert.Equal(t, "eni-3", details.NetworkConfiguration.EniID)
+	assert.Equal(t, "resource-eni-2", details.NetworkConfiguration.ResourceID)
+	assert.Equal(t, []string{"sg-1", "sg-2"}, details.NetworkConfiguration.SecurityGroups)
+}
@@ -5,14 +5,18 @@ import "fmt"
 import (
 	"github.com/Netflix/titus-executor/config"
 
-	"os/exec"
+	"errors"
+	"strconv"
+	"strings"
 
 	"github.com/Netflix/titus-executor/api/netflix/titus"
 	"github.com/Netflix/titus-executor/executor/metatron"
 	vpcTypes "github.com/Netflix/titus-executor/vpc/types"
 
 	"context"
+	"io"
 	"path/filepath"
+	"time"
 
 	// The purpose of this is to tell gometalinter to keep vendoring this package
 	_ "github.com/Netflix/titus-api-definitions/src/main/proto/netflix/titus"
@@ -52,6 +56,85 @@ func (e *InvalidSecurityGroupError) Error() string {
 	return fmt.Sprintf("Invalid security group : %s", e.Reason)
 }
 
+// HealthCheckFailedError represents an error where the health check command
+// could not be run inside of the container, or exited with a non-zero status
+type HealthCheckFailedError struct {
+	Reason error
+}
+
+// Error returns a string describing an error
+func (e *HealthCheckFailedError) Error() string {
+	return fmt.Sprintf("Health check failed : %s", e.Reason)
+}
+
+// InvalidPortMappingError represents an error where the requested container ports
+// cannot be mapped onto the host ports allocated to the task.
+type InvalidPortMappingError struct {
+	Reason error
+}
+
+// Error returns a string describing an error
+func (e *InvalidPortMappingError) Error() string {
+	return fmt.Sprintf("Invalid port mapping : %s", e.Reason)
+}
+
+// ImagePolicyViolationError represents an error where the image the task
+// refers to, or the digest which it resolves to isn't allowed by the image policy
+type ImagePolicyViolationError struct {
+	Reason error
+}
+
+// Error returns a string describing an error
+func (e *ImagePolicyViolationError) Error() string {
+	return fmt.Sprintf("Image policy violation : %s", e.Reason)
+}
+
+// PullErrorClass is why an image pull failed. It decides how the pull is retried, and whether the task is failed, or
+// lost.
+type PullErrorClass string
+
+// The reasons that image pulls fail for
+const (
+	PullErrorAuth                PullErrorClass = "auth"
+	PullErrorRateLimited         PullErrorClass = "rateLimited"
+	PullErrorManifestUnknown     PullErrorClass = "manifestUnknown"
+	PullErrorInvalidReference    PullErrorClass = "invalidReference"
+	PullErrorRegistryUnreachable PullErrorClass = "registryUnreachable"
+	PullErrorDiskFull            PullErrorClass = "diskFull"
+	PullErrorTimeout             PullErrorClass = "timeout"
+)
+
+var pullErrorMessages = map[PullErrorClass]string{
+	PullErrorAuth:                "Not authorized to pull image",
+	PullErrorRateLimited:         "Image registry is rate limiting pulls",
+	PullErrorManifestUnknown:     "Image does not exist in registry",
+	PullErrorInvalidReference:    "Invalid image name",
+	PullErrorRegistryUnreachable: "Unable to reach image registry",
+	PullErrorDiskFull:            "Not enough disk space to pull image",
+	PullErrorTimeout:             "Timed out pulling image",
+}
+
+// PullError represents an image pull which failed for a known reason
+type PullError struct {
+	Class  PullErrorClass
+	Reason error
+}
+
+// Error returns a string describing the error
+func (e *PullError) Error() string {
+	return fmt.Sprintf("%s : %s", pullErrorMessages[e.Class], e.Reason)
+}
+
+// Permanent returns true if pulling the image again, on this, or any other agent, won't help, so the task should be
+// failed, rather than lost
+func (e *PullError) Permanent() bool {
+	switch e.Class {
+	case PullErrorAuth, PullErrorManifestUnknown, PullErrorInvalidReference:
+		return true
+	}
+	return false
+}
+
 // CleanupFunc can be registered to be called on container teardown, errors are reported, but not acted upon
 type CleanupFunc func() error
 
@@ -69,7 +152,6 @@ type Container struct { // nolint: maligned
 	TaskID    string
 	Env       map[string]string
 	Labels    map[string]string
-	Ports     []string
 	TitusInfo *titus.ContainerInfo
 	Resources *Resources
 
@@ -90,8 +172,16 @@ type Container struct { // nolint: maligned
 	// GPU devices
 	GPUInfo GPUContainer
 
-	AllocationCommand *exec.Cmd
-	SetupCommand      *exec.Cmd
+	// PortMappings are populated by the runtime from GetPortMappings during Prepare
+	PortMappings []PortMapping
+
+	// AllocationLease holds the container's IP address until it's released, once the container has stopped
+	AllocationLease vpcTypes.Lease
+	// Wiring is the container's network namespace's connection to its allocation
+	Wiring vpcTypes.Wiring
+
+	// OnPullProgress is called periodically while the runtime pulls the image, if it's set
+	OnPullProgress PullProgressFunc
 
 	Config config.Config
 }
@@ -163,6 +253,74 @@ func (c *Container) GetEntrypointFromProto() ([]string, error) {
 	return cmd, nil
 }
 
+// GetPortMappings pairs the comma separated container ports from the protobuf with the host ports allocated
+// to the task, in order. Container ports are TCP, unless they have a "/udp" suffix, i.e. "53/udp". If no container
+// ports are specified, every host port is mapped to the same container port, over TCP.
+func (c *Container) GetPortMappings() ([]PortMapping, error) {
+	hostPorts := c.Resources.HostPorts
+	for _, hostPort := range hostPorts {
+		if hostPort == 0 {
+			return nil, &InvalidPortMappingError{Reason: errors.New("host port 0 is not valid")}
+		}
+	}
+	containerPortsStr := strings.TrimSpace(c.TitusInfo.GetContainerPorts())
+	if containerPortsStr == "" {
+		portMappings := make([]PortMapping, 0, len(hostPorts))
+		for _, hostPort := range hostPorts {
+			portMappings = append(portMappings, PortMapping{HostPort: hostPort, ContainerPort: hostPort, Protocol: ProtocolTCP})
+		}
+		return portMappings, nil
+	}
+
+	containerPorts := strings.Split(containerPortsStr, ",")
+	if len(containerPorts) != len(hostPorts) {
+		return nil, &InvalidPortMappingError{
+			Reason: fmt.Errorf("%d container ports requested, but %d host ports allocated", len(containerPorts), len(hostPorts)),
+		}
+	}
+
+	portMappings := make([]PortMapping, 0, len(hostPorts))
+	for idx, containerPortStr := range containerPorts {
+		protocol := ProtocolTCP
+		containerPortStr = strings.TrimSpace(containerPortStr)
+		if slash := strings.Index(containerPortStr, "/"); slash != -1 {
+			protocol = strings.ToLower(containerPortStr[slash+1:])
+			containerPortStr = containerPortStr[:slash]
+		}
+		if protocol != ProtocolTCP && protocol != ProtocolUDP {
+			return nil, &InvalidPortMappingError{Reason: fmt.Errorf("protocol %q is not supported, only %s and %s are", protocol, ProtocolTCP, ProtocolUDP)}
+		}
+		containerPort, err := strconv.ParseUint(containerPortStr, 10, 16)
+		if err != nil {
+			return nil, &InvalidPortMappingError{Reason: err}
+		}
+		if containerPort == 0 {
+			return nil, &InvalidPortMappingError{Reason: errors.New("container port 0 is not valid")}
+		}
+		portMappings = append(portMappings, PortMapping{HostPort: hostPorts[idx], ContainerPort: uint16(containerPort), Protocol: protocol})
+	}
+	return portMappings, nil
+}
+
+// PullProgress describes how far along pulling a task's image is
+type PullProgress struct {
+	Layers       int
+	LayersCached int
+	// LayersDone includes the cached layers
+	LayersDone int
+	// BytesTotal only counts the layers whose size dockerd has told us about so far
+	BytesTotal int64
+	BytesDone  int64
+	Elapsed    time.Duration
+}
+
+func (p PullProgress) String() string {
+	return fmt.Sprintf("%d/%d layers (%d cached), %d/%d MiB, %s", p.LayersDone, p.Layers, p.LayersCached, p.BytesDone>>20, p.BytesTotal>>20, p.Elapsed.Truncate(time.Second))
+}
+
+// PullProgressFunc is called with the progress of an image pull
+type PullProgressFunc func(PullProgress)
+
 // Resources specify constraints to be applied to a Container
 type Resources struct {
 	Mem       int64 // in MiB
@@ -171,13 +329,32 @@ type Resources struct {
 	HostPorts []uint16
 }
 
+// Protocols that ports can be mapped for
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// PortMapping maps a port allocated to the task on the host to a port the container listens on
+type PortMapping struct {
+	HostPort      uint16 `json:"hostPort"`
+	ContainerPort uint16 `json:"containerPort"`
+	// Protocol is either ProtocolTCP, or ProtocolUDP
+	Protocol string `json:"protocol"`
+}
+
 // NetworkConfigurationDetails used to pass results back to master
 type NetworkConfigurationDetails struct {
 	IsRoutableIP bool
 	IPAddress    string
 	EniIPAddress string
-	EniID        string
-	ResourceID   string
+	// EniIPv6Address is empty unless the container was allocated an IPv6 address
+	EniIPv6Address string
+	// EniID, and ResourceID are of the ENI the container was allocated on, which may not be the one it asked for
+	EniID      string
+	ResourceID string
+	// SecurityGroups are the security groups of the ENI, sorted
+	SecurityGroups []string
 }
 
 // Details contains additional details about a container that are
@@ -185,6 +362,7 @@ type NetworkConfigurationDetails struct {
 type Details struct {
 	IPAddresses          map[string]string `json:"ipAddresses,omitempty"`
 	NetworkConfiguration *NetworkConfigurationDetails
+	PortMappings         []PortMapping `json:"portMappings,omitempty"`
 }
 
 // Runtime is the containerization engine
@@ -206,6 +384,10 @@ type Runtime interface {
 	Details(*Container) (*Details, error)
 	// Status of a Container
 	Status(*Container) (Status, error)
+	// HealthCheck runs the container's health check command, and returns an error if the container is unhealthy
+	HealthCheck(context.Context, *Container) error
+	// Snapshot writes a gzipped tarball of the container's writable layer. It must be called before Cleanup.
+	Snapshot(context.Context, *Container, io.Writer) error
 }
 
 // Status represent a containers state
@@ -30,3 +30,41 @@ func TestEntrypointParse(t *testing.T) {
 	assert.EqualValues(t, result, expectedSlice)
 
 }
+
+func TestPortMappingsDefaultToHostPorts(t *testing.T) {
+	c := Container{
+		TitusInfo: &titus.ContainerInfo{},
+		Resources: &Resources{HostPorts: []uint16{31000, 31001}},
+	}
+
+	portMappings, err := c.GetPortMappings()
+	assert.NoError(t, err)
+	assert.Equal(t, []PortMapping{{HostPort: 31000, ContainerPort: 31000, Protocol: ProtocolTCP}, {HostPort: 31001, ContainerPort: 31001, Protocol: ProtocolTCP}}, portMappings)
+}
+
+func TestPortMappingsFromContainerPorts(t *testing.T) {
+	containerPorts := "8080, 7001/tcp, 53/udp"
+	c := Container{
+		TitusInfo: &titus.ContainerInfo{ContainerPorts: &containerPorts},
+		Resources: &Resources{HostPorts: []uint16{31000, 31001, 31002}},
+	}
+
+	portMappings, err := c.GetPortMappings()
+	assert.NoError(t, err)
+	assert.Equal(t, []PortMapping{
+		{HostPort: 31000, ContainerPort: 8080, Protocol: ProtocolTCP},
+		{HostPort: 31001, ContainerPort: 7001, Protocol: ProtocolTCP},
+		{HostPort: 31002, ContainerPort: 53, Protocol: ProtocolUDP},
+	}, portMappings)
+}
+
+func TestPortMappingsInvalid(t *testing.T) {
+	for _, containerPorts := range []string{"8080", "8080,notaport", "8080,0", "8080,70000", "8080,7001/sctp", "8080,/udp"} {
+		c := Container{
+			TitusInfo: &titus.ContainerInfo{ContainerPorts: &containerPorts},
+			Resources: &Resources{HostPorts: []uint16{31000, 31001}},
+		}
+		_, err := c.GetPortMappings()
+		assert.IsType(t, &InvalidPortMappingError{}, err, containerPorts)
+	}
+}
@@ -0,0 +1,149 @@
+package filesystems
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/Netflix/titus-executor/filesystems/xattr"
+	"github.com/Netflix/titus-executor/uploader"
+	log "github.com/sirupsen/logrus"
+)
+
+// Log files with these extensions are already compressed, so they're uploaded as they are
+var compressedExtensions = map[string]struct{}{
+	".gz":  {},
+	".tgz": {},
+	".bz2": {},
+	".xz":  {},
+	".zst": {},
+	".zip": {},
+}
+
+func hasCompressedExtension(fileName string) bool {
+	_, ok := compressedExtensions[strings.ToLower(filepath.Ext(fileName))]
+	return ok
+}
+
+// isLocallyCompressed returns true if the watcher compressed the file on the host after uploading it
+func isLocallyCompressed(fileName string) bool {
+	return xattr.GetContentEncoding(fileName) != ""
+}
+
+// contentEncoding returns the content encoding which log files are compressed with, or "" if they aren't
+func (c Compression) contentEncoding() string {
+	switch c {
+	case CompressionGzip:
+		return uploader.ContentEncodingGzip
+	case CompressionZstd:
+		return uploader.ContentEncodingZstd
+	}
+	return ""
+}
+
+// uploadPartOfFile uploads part of a stdio file, compressed if the log policy says to
+func (w *Watcher) uploadPartOfFile(file *os.File, start, length int64, remote string) []error {
+	if contentEncoding := w.compression.contentEncoding(); contentEncoding != "" {
+		return w.uploaders.UploadPartOfFileCompressed(file, start, length, remote, "", contentEncoding)
+	}
+	return w.uploaders.UploadPartOfFile(file, start, length, remote, "")
+}
+
+// uploadFile uploads a log file, compressed if the log policy says to, and it isn't already
+func (w *Watcher) uploadFile(fileName, remote string) []error {
+	if contentEncoding := w.compression.contentEncoding(); contentEncoding != "" && !hasCompressedExtension(fileName) {
+		return w.uploaders.UploadCompressed(fileName, remote, contentEncoding, xattr.GetMimeType)
+	}
+	return w.uploaders.Upload(fileName, remote, xattr.GetMimeType)
+}
+
+// maybeCompressInPlace replaces a log file which has been uploaded compressed, but is kept on the host, with a
+// compressed copy of itself, so that it takes up less space. Darion decompresses it when it's served.
+func (w *Watcher) maybeCompressInPlace(fileName string) {
+	contentEncoding := w.compression.contentEncoding()
+	if contentEncoding == "" || hasCompressedExtension(fileName) {
+		return
+	}
+
+	compressedFileName := fileName + uploader.Extension(contentEncoding)
+	compressedSize, err := compressFile(fileName, compressedFileName, contentEncoding)
+	if err != nil {
+		log.Warningf("Could not compress %s on the host because: %v", fileName, err)
+		if err = os.Remove(compressedFileName); err != nil && !os.IsNotExist(err) {
+			log.Warningf("Could not remove %s because: %v", compressedFileName, err)
+		}
+		return
+	}
+
+	fi, err := os.Stat(fileName)
+	if err != nil {
+		log.Warningf("Could not stat %s because: %v", fileName, err)
+		return
+	}
+	if err = os.Remove(fileName); err != nil {
+		w.metrics.Counter("titus.executor.logsWatchRemoveError", 1, nil)
+		log.Warningf("Could not remove %s after compressing it because: %v", fileName, err)
+		return
+	}
+	if fi.Size() > compressedSize {
+		w.countReclaimedBytes(fi.Size() - compressedSize)
+	}
+}
+
+// compressFile writes a copy of fileName compressed with contentEncoding, with the same owner, mode, mime type, and upload
+// time, and returns its size
+func compressFile(fileName, compressedFileName, contentEncoding string) (int64, error) {
+	in, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer shouldClose(in)
+	fi, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := os.OpenFile(compressedFileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	defer shouldClose(out)
+
+	cw, err := uploader.NewCompressWriter(out, contentEncoding)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = io.Copy(cw, in); err != nil {
+		return 0, err
+	}
+	if err = cw.Close(); err != nil {
+		return 0, err
+	}
+
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if err = out.Chown(int(stat.Uid), int(stat.Gid)); err != nil {
+			return 0, err
+		}
+	}
+	for _, key := range []string{xattr.MimeTypeAttr, UploadedAttr} {
+		value, getErr := xattr.FGetXattr(in, key)
+		if getErr != nil {
+			continue
+		}
+		if err = xattr.FSetXattr(out, key, value); err != nil {
+			return 0, err
+		}
+	}
+	// The content encoding is set last, because it's what marks the copy as complete
+	if err = xattr.FSetXattr(out, xattr.ContentEncodingAttr, []byte(contentEncoding)); err != nil {
+		return 0, err
+	}
+
+	outFi, err := out.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return outFi.Size(), nil
+}
@@ -0,0 +1,124 @@
+package filesystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix/titus-executor/filesystems/xattr"
+	"github.com/Netflix/titus-executor/uploader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readCompressed(t *testing.T, fileName, contentEncoding string) string {
+	f, err := os.Open(fileName)
+	require.NoError(t, err)
+	defer mustClose(f)
+	r, err := uploader.NewDecompressReader(f, contentEncoding)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func readGzipped(t *testing.T, fileName string) string {
+	return readCompressed(t, fileName, uploader.ContentEncodingGzip)
+}
+
+func TestGzipStdioSegment(t *testing.T) {
+	t.Parallel()
+	localDir, err := ioutil.TempDir(".", "src-logs-")
+	require.NoError(t, err)
+	defer mustRemoveAll(t, localDir)
+	uploadDir, err := ioutil.TempDir(".", "dst-logs-")
+	require.NoError(t, err)
+	defer mustRemoveAll(t, uploadDir)
+
+	contents := strings.Repeat(strings.Repeat("a", 127)+"\n", 64)
+	fileName := filepath.Join(localDir, logFileName)
+	require.NoError(t, ioutil.WriteFile(fileName, []byte(contents+"active\n"), 0644))
+	file, err := os.OpenFile(fileName, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer mustClose(file)
+	require.NoError(t, xattr.FSetXattr(file, StdioAttr, []byte("8192")))
+	suffix := time.Now().Add(-time.Hour).UTC().Format(backupFileTimeFormat)
+	require.NoError(t, xattr.FSetXattr(file, VirtualFilePrefixWithSeparator+suffix, []byte("0,8192")))
+
+	w := makeWatcher(localDir, uploadDir)
+	w.compression = CompressionGzip
+	w.doStdioUploadAndReclaim(normalRotate, file)
+
+	remote := filepath.Join(uploadDir, logFileName+"."+suffix+uploader.GzipExtension)
+	assert.Equal(t, contents, readGzipped(t, remote))
+	assert.Equal(t, uploader.ContentEncodingGzip, xattr.GetContentEncoding(remote))
+}
+
+func TestZstdStdioSegment(t *testing.T) {
+	t.Parallel()
+	localDir, err := ioutil.TempDir(".", "src-logs-")
+	require.NoError(t, err)
+	defer mustRemoveAll(t, localDir)
+	uploadDir, err := ioutil.TempDir(".", "dst-logs-")
+	require.NoError(t, err)
+	defer mustRemoveAll(t, uploadDir)
+
+	contents := strings.Repeat(strings.Repeat("a", 127)+"\n", 64)
+	fileName := filepath.Join(localDir, logFileName)
+	require.NoError(t, ioutil.WriteFile(fileName, []byte(contents+"active\n"), 0644))
+	file, err := os.OpenFile(fileName, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer mustClose(file)
+	require.NoError(t, xattr.FSetXattr(file, StdioAttr, []byte("8192")))
+	suffix := time.Now().Add(-time.Hour).UTC().Format(backupFileTimeFormat)
+	require.NoError(t, xattr.FSetXattr(file, VirtualFilePrefixWithSeparator+suffix, []byte("0,8192")))
+
+	w := makeWatcher(localDir, uploadDir)
+	w.compression = CompressionZstd
+	w.doStdioUploadAndReclaim(normalRotate, file)
+
+	remote := filepath.Join(uploadDir, logFileName+"."+suffix+uploader.ZstdExtension)
+	assert.Equal(t, contents, readCompressed(t, remote, uploader.ContentEncodingZstd))
+	assert.Equal(t, uploader.ContentEncodingZstd, xattr.GetContentEncoding(remote))
+}
+
+func TestGzipLogFileKeptLocally(t *testing.T) {
+	t.Parallel()
+	localDir, err := ioutil.TempDir(".", "src-logs-")
+	require.NoError(t, err)
+	defer mustRemoveAll(t, localDir)
+	uploadDir, err := ioutil.TempDir(".", "dst-logs-")
+	require.NoError(t, err)
+	defer mustRemoveAll(t, uploadDir)
+
+	contents := strings.Repeat("hello prana\n", 1000)
+	logFile := filepath.Join(localDir, "prana-log-20161001-19.log")
+	require.NoError(t, ioutil.WriteFile(logFile, []byte(contents), 0644))
+	// Logs which are already compressed are uploaded as they are
+	alreadyCompressed := filepath.Join(localDir, "app-20161001.log.gz")
+	require.NoError(t, ioutil.WriteFile(alreadyCompressed, []byte("not really gzip"), 0644))
+
+	w := makeWatcher(localDir, uploadDir)
+	w.compression = CompressionGzip
+	w.keepLocalFileAfterUpload = true
+	w.uploadLogfile(logFile)
+	w.uploadLogfile(alreadyCompressed)
+
+	assert.Equal(t, contents, readGzipped(t, filepath.Join(uploadDir, "prana-log-20161001-19.log.gz")))
+	data, err := ioutil.ReadFile(filepath.Join(uploadDir, "app-20161001.log.gz"))
+	require.NoError(t, err)
+	assert.Equal(t, "not really gzip", string(data))
+
+	// The local copy was swapped for a compressed one, which isn't uploaded again
+	_, err = os.Stat(logFile)
+	assert.True(t, os.IsNotExist(err))
+	compressed := logFile + uploader.GzipExtension
+	assert.Equal(t, contents, readGzipped(t, compressed))
+	assert.True(t, isLocallyCompressed(compressed))
+	_, err = xattr.GetXattr(compressed, UploadedAttr)
+	assert.NoError(t, err)
+	assert.False(t, isLocallyCompressed(alreadyCompressed))
+}
@@ -0,0 +1,186 @@
+package filesystems
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Netflix/titus-executor/config"
+	units "github.com/docker/go-units"
+	log "github.com/sirupsen/logrus"
+)
+
+// Env vars that tasks can set to override the executor's log policy
+const (
+	StdioRotateSizeEnv     = "TITUS_LOG_STDIO_ROTATE_SIZE"
+	MaxLocalBytesEnv       = "TITUS_LOG_MAX_LOCAL_BYTES"
+	UploadCheckIntervalEnv = "TITUS_LOG_UPLOAD_CHECK_INTERVAL"
+	UploadThresholdEnv     = "TITUS_LOG_UPLOAD_THRESHOLD_TIME"
+	CompressionEnv         = "TITUS_LOG_COMPRESSION"
+)
+
+const (
+	// Rotating smaller segments than this would mostly churn xattrs
+	minStdioRotateSize = 1000000
+	// Checking more often than this would hammer the uploaders for tasks with lots of log files
+	minUploadCheckInterval = time.Minute
+)
+
+// Compression is how log files are compressed before they're uploaded
+type Compression string
+
+const (
+	// CompressionNone uploads log files as they are
+	CompressionNone Compression = "none"
+	// CompressionGzip compresses stdio segments, and log files which aren't already compressed, with gzip as they're
+	// uploaded, and appends ".gz" to their names
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd is like CompressionGzip, but with zstd, and ".zst"
+	CompressionZstd Compression = "zstd"
+)
+
+// LogPolicy is how a task's logs are rotated, uploaded, and reclaimed
+type LogPolicy struct {
+	// StdioRotateSize is how big the active part of a stdio file gets before it's rotated into a segment
+	StdioRotateSize int64
+	// MaxLocalBytes bounds the bytes of rotated stdio segments kept on the host. Once they go over it, the oldest ones
+	// are uploaded and reclaimed without waiting for UploadThreshold. 0 means unlimited.
+	MaxLocalBytes int64
+	// UploadCheckInterval is how often log files are checked to see if they need to be uploaded
+	UploadCheckInterval time.Duration
+	// UploadThreshold is how long a log file, or stdio segment, must be untouched before it's uploaded
+	UploadThreshold time.Duration
+	Compression     Compression
+}
+
+// InvalidLogPolicyError is returned if a task's log policy is malformed, or out of bounds
+type InvalidLogPolicyError struct {
+	Reason error
+}
+
+func (e *InvalidLogPolicyError) Error() string {
+	return fmt.Sprintf("Invalid log policy : %s", e.Reason.Error())
+}
+
+// DefaultLogPolicy returns the executor's log policy, which tasks get unless they override it
+func DefaultLogPolicy(cfg config.Config) LogPolicy {
+	policy := LogPolicy{
+		StdioRotateSize:     cfg.StdioRotateSize,
+		MaxLocalBytes:       cfg.LogMaxLocalBytes,
+		UploadCheckInterval: cfg.LogUploadCheckInterval,
+		UploadThreshold:     cfg.LogUploadThresholdTime,
+		Compression:         Compression(cfg.LogCompression),
+	}
+	if policy.Compression == "" {
+		policy.Compression = CompressionNone
+	}
+	return policy
+}
+
+// LogPolicyForTask returns the executor's log policy, with the overrides in the task's env applied. Sizes can be
+// human readable, i.e. "64MB", and durations are in Go's format, i.e. "30m". Overrides are clamped to the maximums that
+// the executor is configured with, and a task can't lift the executor's max local bytes by setting it to unlimited.
+// Policies with overrides are validated, the executor's own is trusted.
+func LogPolicyForTask(cfg config.Config, env map[string]string) (LogPolicy, error) {
+	policy := DefaultLogPolicy(cfg)
+	overridden := false
+	for _, key := range []string{StdioRotateSizeEnv, MaxLocalBytesEnv, UploadCheckIntervalEnv, UploadThresholdEnv, CompressionEnv} {
+		if _, ok := env[key]; ok {
+			overridden = true
+		}
+	}
+	if !overridden {
+		return policy, nil
+	}
+
+	var err error
+	if val, ok := env[StdioRotateSizeEnv]; ok {
+		if policy.StdioRotateSize, err = units.FromHumanSize(val); err != nil {
+			return policy, &InvalidLogPolicyError{Reason: fmt.Errorf("%s: %v", StdioRotateSizeEnv, err)}
+		}
+	}
+	if val, ok := env[MaxLocalBytesEnv]; ok {
+		if policy.MaxLocalBytes, err = units.FromHumanSize(val); err != nil {
+			return policy, &InvalidLogPolicyError{Reason: fmt.Errorf("%s: %v", MaxLocalBytesEnv, err)}
+		}
+	}
+	if val, ok := env[UploadCheckIntervalEnv]; ok {
+		if policy.UploadCheckInterval, err = time.ParseDuration(val); err != nil {
+			return policy, &InvalidLogPolicyError{Reason: fmt.Errorf("%s: %v", UploadCheckIntervalEnv, err)}
+		}
+	}
+	if val, ok := env[UploadThresholdEnv]; ok {
+		if policy.UploadThreshold, err = time.ParseDuration(val); err != nil {
+			return policy, &InvalidLogPolicyError{Reason: fmt.Errorf("%s: %v", UploadThresholdEnv, err)}
+		}
+	}
+	if val, ok := env[CompressionEnv]; ok {
+		policy.Compression = Compression(val)
+	}
+
+	if err = clampLogPolicy(cfg, &policy); err != nil {
+		return policy, &InvalidLogPolicyError{Reason: err}
+	}
+	if err = policy.Validate(); err != nil {
+		return policy, &InvalidLogPolicyError{Reason: err}
+	}
+	return policy, nil
+}
+
+func clampLogPolicy(cfg config.Config, policy *LogPolicy) error {
+	if cfg.LogMaxLocalBytes > 0 {
+		if policy.MaxLocalBytes == 0 {
+			return fmt.Errorf("%s: unlimited isn't allowed, the executor's limit is %d bytes", MaxLocalBytesEnv, cfg.LogMaxLocalBytes)
+		}
+		policy.MaxLocalBytes = clampInt64(MaxLocalBytesEnv, policy.MaxLocalBytes, cfg.LogMaxLocalBytes)
+	}
+	if cfg.MaxStdioRotateSize > 0 {
+		policy.StdioRotateSize = clampInt64(StdioRotateSizeEnv, policy.StdioRotateSize, cfg.MaxStdioRotateSize)
+	}
+	if cfg.MaxLogUploadThresholdTime > 0 {
+		policy.UploadThreshold = clampDuration(UploadThresholdEnv, policy.UploadThreshold, cfg.MaxLogUploadThresholdTime)
+		// Checking less often than that would hold uploads past it anyway
+		policy.UploadCheckInterval = clampDuration(UploadCheckIntervalEnv, policy.UploadCheckInterval, cfg.MaxLogUploadThresholdTime)
+	}
+	return nil
+}
+
+func clampInt64(name string, val, max int64) int64 {
+	if val > max {
+		log.WithField("requested", val).WithField("max", max).Infof("%s is over the executor's maximum, using the maximum", name)
+		return max
+	}
+	return val
+}
+
+func clampDuration(name string, val, max time.Duration) time.Duration {
+	if val > max {
+		log.WithField("requested", val).WithField("max", max).Infof("%s is over the executor's maximum, using the maximum", name)
+		return max
+	}
+	return val
+}
+
+// Validate returns an error if the policy is out of bounds
+func (p LogPolicy) Validate() error {
+	if p.StdioRotateSize < minStdioRotateSize {
+		return fmt.Errorf("stdio rotate size %d is less than the minimum of %d bytes", p.StdioRotateSize, minStdioRotateSize)
+	}
+	if p.MaxLocalBytes < 0 {
+		return fmt.Errorf("max local bytes %d is negative", p.MaxLocalBytes)
+	}
+	if p.MaxLocalBytes > 0 && p.MaxLocalBytes < p.StdioRotateSize {
+		return fmt.Errorf("max local bytes %d is less than the stdio rotate size %d, so every segment would be uploaded as soon as it's rotated", p.MaxLocalBytes, p.StdioRotateSize)
+	}
+	if p.UploadCheckInterval < minUploadCheckInterval {
+		return fmt.Errorf("upload check interval %s is less than the minimum of %s", p.UploadCheckInterval, minUploadCheckInterval)
+	}
+	if p.UploadThreshold < 0 {
+		return fmt.Errorf("upload threshold %s is negative", p.UploadThreshold)
+	}
+	switch p.Compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return fmt.Errorf("unsupported compression %q", p.Compression)
+	}
+	return nil
+}
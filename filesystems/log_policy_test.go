@@ -0,0 +1,131 @@
+package filesystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix/titus-executor/config"
+	"github.com/Netflix/titus-executor/filesystems/xattr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogPolicyForTask(t *testing.T) {
+	cfg, err := config.GenerateConfiguration(nil)
+	require.NoError(t, err)
+
+	policy, err := LogPolicyForTask(*cfg, nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLogPolicy(*cfg), policy)
+	assert.Equal(t, int64(256000000), policy.StdioRotateSize)
+
+	policy, err = LogPolicyForTask(*cfg, map[string]string{
+		StdioRotateSizeEnv:     "64MB",
+		MaxLocalBytesEnv:       "1GB",
+		UploadCheckIntervalEnv: "5m",
+		UploadThresholdEnv:     "1h",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, LogPolicy{
+		StdioRotateSize:     64000000,
+		MaxLocalBytes:       1000000000,
+		UploadCheckInterval: 5 * time.Minute,
+		UploadThreshold:     time.Hour,
+		Compression:         CompressionNone,
+	}, policy)
+}
+
+func TestLogPolicyForTaskClamped(t *testing.T) {
+	cfg, err := config.GenerateConfiguration(nil)
+	require.NoError(t, err)
+	cfg.LogMaxLocalBytes = 2000000000
+
+	policy, err := LogPolicyForTask(*cfg, map[string]string{
+		StdioRotateSizeEnv:     "10GB",
+		MaxLocalBytesEnv:       "100GB",
+		UploadCheckIntervalEnv: "72h",
+		UploadThresholdEnv:     "720h",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, cfg.MaxStdioRotateSize, policy.StdioRotateSize)
+	assert.Equal(t, cfg.LogMaxLocalBytes, policy.MaxLocalBytes)
+	assert.Equal(t, cfg.MaxLogUploadThresholdTime, policy.UploadCheckInterval)
+	assert.Equal(t, cfg.MaxLogUploadThresholdTime, policy.UploadThreshold)
+
+	// Tasks can tighten the executor's limit, but not lift it
+	policy, err = LogPolicyForTask(*cfg, map[string]string{MaxLocalBytesEnv: "1GB"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000000000), policy.MaxLocalBytes)
+	_, err = LogPolicyForTask(*cfg, map[string]string{MaxLocalBytesEnv: "0"})
+	assert.IsType(t, &InvalidLogPolicyError{}, err)
+}
+
+func TestLogPolicyForTaskInvalid(t *testing.T) {
+	cfg, err := config.GenerateConfiguration(nil)
+	require.NoError(t, err)
+
+	for _, env := range []map[string]string{
+		{StdioRotateSizeEnv: "lots"},
+		{StdioRotateSizeEnv: "1KB"},
+		{MaxLocalBytesEnv: "100MB"},
+		{UploadCheckIntervalEnv: "1s"},
+		{UploadThresholdEnv: "-1h"},
+		{CompressionEnv: "brotli"},
+	} {
+		_, err = LogPolicyForTask(*cfg, env)
+		assert.IsType(t, &InvalidLogPolicyError{}, err, "env: %v", env)
+	}
+}
+
+func TestMaxLocalBytes(t *testing.T) {
+	t.Parallel()
+	localDir, err := ioutil.TempDir(".", "src-logs-")
+	require.NoError(t, err)
+	defer mustRemoveAll(t, localDir)
+	uploadDir, err := ioutil.TempDir(".", "dst-logs-")
+	require.NoError(t, err)
+	defer mustRemoveAll(t, uploadDir)
+
+	// Two 8KB segments, so their holes are block aligned. They were just rotated, so they're too young to be uploaded on
+	// their own.
+	fileName := filepath.Join(localDir, logFileName)
+	require.NoError(t, ioutil.WriteFile(fileName, []byte(strings.Repeat(strings.Repeat("a", 127)+"\n", 128)), 0644))
+	// The watcher opens stdio files without O_APPEND, because holes can't be punched through file descriptors with it
+	file, err := os.OpenFile(fileName, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer mustClose(file)
+	require.NoError(t, xattr.FSetXattr(file, StdioAttr, []byte("16384")))
+	now := time.Now().UTC()
+	older := now.Add(-time.Second).Format(backupFileTimeFormat)
+	newer := now.Format(backupFileTimeFormat)
+	require.NoError(t, xattr.FSetXattr(file, VirtualFilePrefixWithSeparator+older, []byte("0,8192")))
+	require.NoError(t, xattr.FSetXattr(file, VirtualFilePrefixWithSeparator+newer, []byte("8192,8192")))
+
+	w := makeWatcher(localDir, uploadDir)
+	w.UploadThreshold = time.Hour
+	w.maxLocalBytes = 12000
+	w.doStdioUploadAndReclaim(normalRotate, file)
+
+	// Only the older segment had to go to get under the limit
+	uploaded, err := ioutil.ReadDir(uploadDir)
+	require.NoError(t, err)
+	require.Len(t, uploaded, 1)
+	assert.Equal(t, logFileName+"."+older, uploaded[0].Name())
+
+	xattrs, err := xattr.FListXattrs(file)
+	require.NoError(t, err)
+	_, ok := xattrs[VirtualFilePrefixWithSeparator+older]
+	assert.False(t, ok, "older segment should have been reclaimed")
+	_, ok = xattrs[VirtualFilePrefixWithSeparator+newer]
+	assert.True(t, ok, "newer segment should have been kept")
+}
+
+func mustRemoveAll(t *testing.T, dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -33,15 +33,20 @@ const (
 	// VirtualFilePrefixWithSeparator is the VirtualFilePrefix with a "." appended (the namespace separator
 	VirtualFilePrefixWithSeparator = VirtualFilePrefix + "."
 	// StdioAttr is the attribute that states this is a stdio, rotatable file
-	StdioAttr                    = "user.stdio"
+	StdioAttr = "user.stdio"
+	// UploadedAttr is set to the time a file was uploaded at, if it's kept around after being uploaded. For stdio files,
+	// it's the active part of the file which was uploaded.
+	UploadedAttr = "user.titus.uploaded"
+	// UploadedAttrPrefixWithSeparator is followed by a virtual file's suffix, and is set to the time the virtual file was uploaded at
+	UploadedAttrPrefixWithSeparator = UploadedAttr + "."
+	// UploadedTimeFormat is the format of the upload time in the uploaded attributes
+	UploadedTimeFormat           = time.RFC3339
 	waitForDieAfterContextCancel = time.Minute
 )
 
 var (
 	// This is a variable so we can change it as neccessary during tests
-	rotateSize int64 = 256000000
-	maxSeek    int64 = 16777216 // 16MB
-
+	maxSeek int64 = 16777216 // 16MB
 )
 
 type stdioRotateMode int
@@ -92,6 +97,9 @@ type Watcher struct {
 	// UploadThreshold returns how long a file must be untouched prior to uploading it
 	UploadThreshold          time.Duration
 	stdioLogCheckInterval    time.Duration
+	stdioRotateSize          int64
+	maxLocalBytes            int64
+	compression              Compression
 	keepLocalFileAfterUpload bool
 	retError                 error
 	dieCh                    chan struct{}
@@ -100,16 +108,20 @@ type Watcher struct {
 	shutdownOnce             sync.Once
 }
 
-// NewWatcher returns a fully instantiated instance of Watcher, which will run until Stop is called.
-func NewWatcher(m metrics.Reporter, localDir, uploadDir, uploadRegexpStr string, uploaders *uploader.Uploaders, cfg config.Config) (*Watcher, error) {
+// NewWatcher returns a fully instantiated instance of Watcher, which will run until Stop is called. The policy should
+// come from LogPolicyForTask, or DefaultLogPolicy.
+func NewWatcher(m metrics.Reporter, localDir, uploadDir, uploadRegexpStr string, uploaders *uploader.Uploaders, cfg config.Config, policy LogPolicy) (*Watcher, error) {
 	watcher := &Watcher{
 		metrics:                  m,
 		localDir:                 localDir,
 		uploadDir:                uploadDir,
 		uploaders:                uploaders,
-		UploadCheckInterval:      cfg.LogUploadCheckInterval,
-		UploadThreshold:          cfg.LogUploadThresholdTime,
+		UploadCheckInterval:      policy.UploadCheckInterval,
+		UploadThreshold:          policy.UploadThreshold,
 		stdioLogCheckInterval:    cfg.StdioLogCheckInterval,
+		stdioRotateSize:          policy.StdioRotateSize,
+		maxLocalBytes:            policy.MaxLocalBytes,
+		compression:              policy.Compression,
 		keepLocalFileAfterUpload: cfg.KeepLocalFileAfterUpload,
 	}
 
@@ -351,9 +363,14 @@ func (w *Watcher) doFinalStdioUploadAndReclaim(file *os.File) {
 
 	fullRemoteFilePath := path.Join(w.uploadDir, path.Base(file.Name()))
 
-	if err := w.uploaders.UploadPartOfFile(file, cutLoc, math.MaxInt64, fullRemoteFilePath, ""); err != nil {
+	if err := w.uploadPartOfFile(file, cutLoc, math.MaxInt64, fullRemoteFilePath); err != nil {
 		w.metrics.Counter("titus.executor.logsUploadError", 1, nil)
 		log.Errorf("watch: error uploading %s: %s", file.Name(), err)
+	} else {
+		markUploaded(file, UploadedAttr)
+		if size, err := getSize(file); err == nil && size > cutLoc {
+			w.countUploadedBytes(size - cutLoc)
+		}
 	}
 }
 
@@ -377,17 +394,34 @@ func (w *Watcher) doStdioUploadAndReclaim(mode stdioRotateMode, file *os.File) {
 	// We make the holes from 0 because the holes have to be block aligned
 
 	// It seems the sort order (or iteration order) of Go's maps is unstable as well, so this is difficult to test for.
+	var virtualFiles []virtualFile
+	var localBytes int64
 	for _, xattrKey := range keys {
 		if !strings.HasPrefix(xattrKey, VirtualFilePrefixWithSeparator) {
 			continue
 		}
 		start, len, err := FetchStartAndLen(xattrKey, file)
 		if err == nil {
-			w.doStdioUploadAndReclaimVirtualFile(mode, start, len, xattrKey, file)
+			virtualFiles = append(virtualFiles, virtualFile{xattrKey: xattrKey, start: start, length: len})
+			localBytes += len
+		}
+	}
+
+	// The virtual files are oldest first, so if we're over the local limit, the oldest ones go first
+	for _, vf := range virtualFiles {
+		overLimit := w.maxLocalBytes > 0 && localBytes > w.maxLocalBytes
+		if w.doStdioUploadAndReclaimVirtualFile(mode, overLimit, vf.start, vf.length, vf.xattrKey, file) {
+			localBytes -= vf.length
 		}
 	}
 }
 
+// virtualFile is a segment of a stdio file which has been rotated out, but not reclaimed yet
+type virtualFile struct {
+	xattrKey      string
+	start, length int64
+}
+
 // FetchStartAndLen returns the virtual file start and length for a given xattrKey (a key that includes the prefix)
 func FetchStartAndLen(xattrKey string, file *os.File) (int64, int64, error) {
 	xattrValBytes, err := xattr.FGetXattr(file, xattrKey)
@@ -417,49 +451,64 @@ func FetchStartAndLen(xattrKey string, file *os.File) (int64, int64, error) {
 	return start, length, nil
 }
 
-func (w *Watcher) doStdioUploadAndReclaimVirtualFile(mode stdioRotateMode, start, length int64, xattrKey string, file *os.File) {
+// doStdioUploadAndReclaimVirtualFile uploads the virtual file if it's old enough, or the stdio file is over its local
+// limit, and returns whether its bytes were reclaimed
+func (w *Watcher) doStdioUploadAndReclaimVirtualFile(mode stdioRotateMode, overLimit bool, start, length int64, xattrKey string, file *os.File) bool {
 
 	log.WithField("start", start).WithField("length", length).WithField("xattrKey", xattrKey).WithField("filename", file.Name()).Debug("Stdio upload and reclaim")
 	virtualFileSuffix := strings.TrimPrefix(xattrKey, VirtualFilePrefixWithSeparator)
 	virtualFileName := strings.Join([]string{path.Base(file.Name()), virtualFileSuffix}, ".")
 
-	creationTime, err := time.Parse(backupFileTimeFormat, virtualFileSuffix)
+	creationTime, err := VirtualFileCreationTime(virtualFileSuffix)
 	if err != nil {
 		log.Errorf("Could not parse virtual file suffix '%s' because: %v", virtualFileSuffix, err)
-		return
+		return false
 	}
 
 	now := time.Now()
 	age := now.Sub(creationTime)
 
 	if mode == normalRotate && now.Add(-1*w.UploadThreshold).Before(creationTime) {
-		log.Debugf("Virtual file %s of real file %s not old enough to upload and discard because only %s old", virtualFileName, file.Name(), age.String())
-		return
+		if !overLimit || w.keepLocalFileAfterUpload {
+			log.Debugf("Virtual file %s of real file %s not old enough to upload and discard because only %s old", virtualFileName, file.Name(), age.String())
+			return false
+		}
+		log.Infof("Uploading virtual file %s of real file %s early, because the rotated stdio on the host is over %d bytes", virtualFileName, file.Name(), w.maxLocalBytes)
+		w.metrics.Counter("titus.executor.logsReclaimedEarly", 1, nil)
 	}
 
 	log.Debugf("Uploading virtual file %s of real file %s because it is %s old", virtualFileName, file.Name(), age.String())
 	// This relies on the fact that the stdio files are always in the root directory
 	fullRemoteFilePath := path.Join(w.uploadDir, virtualFileName)
 
-	if err := w.uploaders.UploadPartOfFile(file, start, length, fullRemoteFilePath, ""); err != nil {
+	if err := w.uploadPartOfFile(file, start, length, fullRemoteFilePath); err != nil {
 		w.metrics.Counter("titus.executor.logsUploadError", 1, nil)
 		log.Errorf("watch: error uploading %s's %s: %s", file.Name(), virtualFileName, err)
+	} else {
+		w.countUploadedBytes(length)
+		if w.keepLocalFileAfterUpload {
+			markUploaded(file, UploadedAttrPrefixWithSeparator+virtualFileSuffix)
+		}
 	}
 
-	if !w.keepLocalFileAfterUpload {
-		holeSize := start + length - 1
-		log.WithField("filename", file.Name()).WithField("xattrKey", xattrKey).WithField("holeSize", holeSize).Debug("Deleting old file")
-		err = xattr.FDelXattr(file, xattrKey)
-		if err != nil {
-			log.Errorf("Could not delete attr %s on file %s, not punching hole because: %v", xattrKey, file.Name(), err)
-		}
+	if w.keepLocalFileAfterUpload {
+		return false
+	}
 
-		err = xattr.MakeHole(file, 0, holeSize)
-		if err != nil {
-			log.Errorf("Could not make hole in file %s, because: %v", file.Name(), err)
-		}
+	holeSize := start + length - 1
+	log.WithField("filename", file.Name()).WithField("xattrKey", xattrKey).WithField("holeSize", holeSize).Debug("Deleting old file")
+	err = xattr.FDelXattr(file, xattrKey)
+	if err != nil {
+		log.Errorf("Could not delete attr %s on file %s, not punching hole because: %v", xattrKey, file.Name(), err)
 	}
 
+	err = xattr.MakeHole(file, 0, holeSize)
+	if err != nil {
+		log.Errorf("Could not make hole in file %s, because: %v", file.Name(), err)
+		return false
+	}
+	w.countReclaimedBytes(length)
+	return true
 }
 
 func (w *Watcher) doStdioRotate(file *os.File) {
@@ -476,15 +525,19 @@ func (w *Watcher) doStdioRotate(file *os.File) {
 	}
 	log.WithField("fileName", file.Name()).WithField("currentSize", currentSize).WithField("currentOffset", currentOffset).Debug("doing stdio rotate")
 
-	if currentSize-currentOffset < rotateSize {
+	if currentSize-currentOffset < w.stdioRotateSize {
 		log.Debugf("Not rotating %s, because current size only %d bytes, and current offset %d, total delta: %d", file.Name(), currentSize, currentOffset, currentSize-currentOffset)
 		return
 	}
 
 	// 4. Find an appropriate place to cut
-	cutLoc, err := getCutOffset(file)
+	cutLoc, err := getCutOffset(file, currentOffset, currentSize)
 	if err != nil {
 		log.Errorf("Could not get file for '%s' because: %v", file.Name(), err)
+		return
+	}
+	if cutLoc <= currentOffset {
+		return
 	}
 
 	// 5. Update stdioattr, and write a new virtual file record
@@ -495,6 +548,25 @@ func (w *Watcher) doStdioRotate(file *os.File) {
 	}
 }
 
+// VirtualFileCreationTime returns when a virtual file was rotated out of its stdio file, given the virtual file's suffix
+func VirtualFileCreationTime(virtualFileSuffix string) (time.Time, error) {
+	return time.Parse(backupFileTimeFormat, virtualFileSuffix)
+}
+
+// markUploaded records when a file, or one of its virtual files was uploaded, so that it can be told apart from logs
+// which are only on the host
+func markUploaded(file *os.File, key string) {
+	if err := xattr.FSetXattr(file, key, []byte(time.Now().UTC().Format(UploadedTimeFormat))); err != nil {
+		log.Warningf("Could not mark %s as uploaded because: %v", file.Name(), err)
+	}
+}
+
+func markPathUploaded(fileName string) {
+	if err := xattr.SetXattr(fileName, UploadedAttr, []byte(time.Now().UTC().Format(UploadedTimeFormat))); err != nil {
+		log.Warningf("Could not mark %s as uploaded because: %v", fileName, err)
+	}
+}
+
 // updateFile creates a new xattr and sets the current stdioattr to start at cutLoc
 func updateFile(currentOffset, cutLoc int64, file *os.File) error {
 	now := time.Now()
@@ -534,11 +606,17 @@ func getSize(file *os.File) (int64, error) {
 	return stat.Size, nil
 }
 
-// getCutOffset returns the cut offset where a newline exists. If err is not nil, and the returned value is 0, it means no valid cut location was found
-func getCutOffset(file *os.File) (int64, error) {
-	buf := make([]byte, maxSeek)
+// getCutOffset returns the cut offset where a newline exists, searching back from the end of the file, but not past
+// currentOffset. If err is nil, and the returned value is 0, it means no valid cut location was found
+func getCutOffset(file *os.File, currentOffset, currentSize int64) (int64, error) {
+	searchSize := maxSeek
+	// With small rotate sizes, the active part of the file can be smaller than the window we search for a newline in
+	if currentSize-currentOffset < searchSize {
+		searchSize = currentSize - currentOffset
+	}
+	buf := make([]byte, searchSize)
 
-	seekOffset, err := file.Seek(-1*maxSeek, io.SeekEnd)
+	seekOffset, err := file.Seek(currentSize-searchSize, io.SeekStart)
 	if err != nil {
 		log.Errorf("Could not seek in file %s becuse %v", file.Name(), err)
 		return 0, err
@@ -553,9 +631,9 @@ func getCutOffset(file *os.File) (int64, error) {
 		return 0, err
 	}
 
-	newLineIdx := bytes.LastIndexByte(buf, '\n')
+	newLineIdx := bytes.LastIndexByte(buf[:n], '\n')
 	if newLineIdx == -1 {
-		log.Errorf("Could not rotate %s because no newline found in last %d bytes", file.Name(), maxSeek)
+		log.Errorf("Could not rotate %s because no newline found in last %d bytes", file.Name(), searchSize)
 		return 0, nil
 	}
 
@@ -608,11 +686,21 @@ func (w *Watcher) uploadAllLogFiles() error {
 			errs = append(errs, err)
 			continue
 		}
-		if CheckFileForStdio(logFile) {
+		// Files which were compressed on the host have already been uploaded
+		if CheckFileForStdio(logFile) || isLocallyCompressed(logFile) {
 			continue
 		}
 
-		errs2 := w.uploaders.Upload(logFile, path.Join(w.uploadDir, remoteFilePath), xattr.GetMimeType)
+		errs2 := w.uploadFile(logFile, path.Join(w.uploadDir, remoteFilePath))
+		if len(errs2) == 0 {
+			markPathUploaded(logFile)
+			if fi, err := os.Stat(logFile); err == nil {
+				w.countUploadedBytes(fi.Size())
+			}
+			if w.keepLocalFileAfterUpload {
+				w.maybeCompressInPlace(logFile)
+			}
+		}
 		errs = append(errs, errs2...)
 
 		// Collect any errors from the upload and append to single error to return
@@ -638,7 +726,7 @@ func (w *Watcher) uploadAllLogFiles() error {
 // task is running. Currently, no error is returned to the caller,
 // it is just logged.
 func (w *Watcher) uploadLogfile(fileToUpload string) {
-	if w.shouldRotate(path.Base(fileToUpload)) {
+	if w.shouldRotate(path.Base(fileToUpload)) && !isLocallyCompressed(fileToUpload) {
 		// FIXME set content type
 		log.Info("Uploading ", fileToUpload)
 		remoteFilePath, err := filepath.Rel(w.localDir, fileToUpload)
@@ -647,19 +735,42 @@ func (w *Watcher) uploadLogfile(fileToUpload string) {
 			return
 		}
 
-		if err := w.uploaders.Upload(fileToUpload, path.Join(w.uploadDir, remoteFilePath), xattr.GetMimeType); err != nil {
+		var size int64
+		if fi, err := os.Stat(fileToUpload); err == nil {
+			size = fi.Size()
+		}
+
+		if err := w.uploadFile(fileToUpload, path.Join(w.uploadDir, remoteFilePath)); err != nil {
 			w.metrics.Counter("titus.executor.logsUploadError", 1, nil)
 			log.Printf("watch : error uploading %s : %s\n", fileToUpload, err)
+		} else {
+			w.countUploadedBytes(size)
+			if w.keepLocalFileAfterUpload {
+				markPathUploaded(fileToUpload)
+				w.maybeCompressInPlace(fileToUpload)
+			}
 		}
 		if !w.keepLocalFileAfterUpload {
 			if err := os.Remove(fileToUpload); err != nil {
 				w.metrics.Counter("titus.executor.logsWatchRemoveError", 1, nil)
 				log.Printf("watch : error removing %s : %s\n", fileToUpload, err)
+			} else {
+				w.countReclaimedBytes(size)
 			}
 		}
 	}
 }
 
+// countUploadedBytes and countReclaimedBytes let us compare how much of the logs tasks write make it off the host, to
+// how much is freed up on it
+func (w *Watcher) countUploadedBytes(n int64) {
+	w.metrics.Counter("titus.executor.logsUploadedBytes", int(n), nil)
+}
+
+func (w *Watcher) countReclaimedBytes(n int64) {
+	w.metrics.Counter("titus.executor.logsReclaimedBytes", int(n), nil)
+}
+
 func buildFileListInDir(dirName string, checkModifiedTimeThreshold bool, uploadThreshold time.Duration) ([]string, error) {
 	return buildFileListInDir2(dirName, []string{}, checkModifiedTimeThreshold, uploadThreshold)
 }
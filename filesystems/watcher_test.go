@@ -15,6 +15,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Netflix/metrics-client-go/metrics"
 	"github.com/Netflix/titus-executor/filesystems/xattr"
 	"github.com/Netflix/titus-executor/uploader"
 	"github.com/sirupsen/logrus"
@@ -30,6 +31,7 @@ func makeWatcher(localDir, uploadDir string) *Watcher {
 	copyUploader := uploader.CopyUploader{Dir: "."}
 	uploaders := uploader.NewUploadersFromUploaderArray([]uploader.Uploader{&copyUploader})
 	return &Watcher{
+		metrics:                  metrics.Discard,
 		localDir:                 localDir,
 		uploadDir:                uploadDir,
 		uploadRegexp:             nil,
@@ -37,6 +39,7 @@ func makeWatcher(localDir, uploadDir string) *Watcher {
 		UploadThreshold:          time.Duration(time.Second * 10),
 		UploadCheckInterval:      time.Duration(time.Second * 2),
 		stdioLogCheckInterval:    time.Duration(time.Second * 2),
+		stdioRotateSize:          testRotateSize,
 		keepLocalFileAfterUpload: false,
 	}
 }
@@ -274,9 +277,10 @@ func TestRotateRegexp(t *testing.T) { // nolint: gocyclo
 // The logfile to use during the testing of log rotate code
 const logFileName = "stderr"
 
+const testRotateSize int64 = 5000011 // 5MB-ish -- this is a prime number intentionally
+
 func TestLogRotate(t *testing.T) {
 	t.Parallel()
-	rotateSize = 5000011 // 5MB-ish -- this is a prime number intentionally
 	maxSeek = 1000003
 	tmpLogDir, err := ioutil.TempDir(".", "src-logs-")
 	if err != nil {
@@ -331,7 +335,7 @@ func setupLogRotate(tmpLogDir, destLogDir string, t *testing.T) (*Watcher, []byt
 		logrus.Debugf("Begin write at %d bytes", l1)
 		tmpBuf := []byte{}
 		// We do * 2 here, because we want to ensure there's enough space for a \n and to trigger rotation
-		for int64(len(tmpBuf)) < rotateSize*2 {
+		for int64(len(tmpBuf)) < testRotateSize*2 {
 			idx := rand.Intn(len(loremIpsum))
 			tmpBuf = append(tmpBuf, loremIpsum[idx]...)
 		}
@@ -361,8 +365,8 @@ func checkOngoingLogRotate(tmpLogDir, destLoc string, buf []byte, t *testing.T)
 
 	movedFiles := []string{}
 	for _, f := range files {
-		if f.Size() < int64(rotateSize) {
-			t.Fatalf("Rotated incorrect amount in file %s, file size: %d, expect size at least: %d", f.Name(), f.Size(), rotateSize)
+		if f.Size() < testRotateSize {
+			t.Fatalf("Rotated incorrect amount in file %s, file size: %d, expect size at least: %d", f.Name(), f.Size(), testRotateSize)
 		}
 		movedFiles = append(movedFiles, f.Name())
 		filepaths[f.Name()] = filepath.Join(destLoc, f.Name())
@@ -20,6 +20,8 @@ const (
 	unusedArg    = 0
 	// MimeTypeAttr is the key of the xattr for file mime types
 	MimeTypeAttr = "user.mime_type"
+	// ContentEncodingAttr is the key of the xattr for the encoding of compressed files, i.e. "gzip"
+	ContentEncodingAttr = "user.content_encoding"
 )
 
 // ssize_t is variable across systems, but on ours it's int64, we use the same name as the system for consistency
@@ -160,6 +162,15 @@ func pointerToByteSlice(byteSlice []byte) uintptr {
 	return uintptr(unsafe.Pointer(&byteSlice[0])) // nolint: gas
 }
 
+// GetContentEncoding returns the content encoding of a compressed file, or "" if it isn't compressed
+func GetContentEncoding(filename string) string {
+	encoding, err := GetXattr(filename, ContentEncodingAttr)
+	if err != nil {
+		return ""
+	}
+	return string(encoding)
+}
+
 // GetMimeType tries to get the mime type for a given filename based on the xattr
 func GetMimeType(filename string) string {
 	mimeType, err := GetXattr(filename, MimeTypeAttr)
@@ -74,6 +74,7 @@ fi
 
 cat <<-EOF >/tmp/post-install.sh
 systemctl enable titus-darion.service
+systemctl enable titus-image-cache.service
 systemctl enable titus-launchguard.service
 systemctl enable titus-reaper.service
 systemctl enable titus-setup-networking.timer
@@ -40,14 +40,15 @@ trap terminate_titus_docker EXIT
 
 go_pkg="${GO_PKG:-github.com/Netflix/titus-executor}"
 debug=${DEBUG:-false}
+container_runtime=${CONTAINER_RUNTIME:-docker}
 
 log "Running a docker daemon named $titus_agent_name"
 docker run --privileged --security-opt seccomp=unconfined -v /sys/fs/cgroup:/sys/fs/cgroup:ro \
   -v "$PWD":/go/src/${go_pkg} -w /go/src/${go_pkg} --rm --name "$titus_agent_name" -e DEBUG=${debug} \
   -e SHORT_CIRCUIT_QUITELITE=true --label "$run_id" -d titusoss/titus-agent
 
-log "Running integration tests against the $titus_agent_name daemon"
+log "Running integration tests with the $container_runtime container runtime in $titus_agent_name"
 # --privileged is needed here since we are reading FDs from a unix socket
-docker exec --privileged -e DEBUG=${debug} -e SHORT_CIRCUIT_QUITELITE=true "$titus_agent_name" \
+docker exec --privileged -e DEBUG=${debug} -e SHORT_CIRCUIT_QUITELITE=true -e CONTAINER_RUNTIME=${container_runtime} "$titus_agent_name" \
   go test -timeout 3m ${TEST_FLAGS:-} ./executor/mock/standalone/... -standalone=true 2>&1 | \
   tee >(go-junit-report > "${TEST_DOCKER_OUTPUT:-test-standalone-docker.xml}") | tee > test-standalone.log
@@ -0,0 +1,550 @@
+package imagecache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	docker "github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+	netcontext "golang.org/x/net/context"
+)
+
+const (
+	// minEvictionAge keeps images which were just pulled, but whose containers haven't been created yet from being evicted
+	minEvictionAge = 10 * time.Minute
+	pullTimeout    = 30 * time.Minute
+	// pullMessageBuffer is how many progress messages can be queued up for a caller waiting on a pull. Past that,
+	// messages are dropped, rather than holding up the pull for everyone else.
+	pullMessageBuffer = 64
+)
+
+var (
+	// evictionInterval is how often the disk budget is enforced.
+	evictionInterval = time.Minute
+)
+
+// DockerClient is the subset of the Docker client that the cache uses. The vendored client still takes the x/net
+// context, rather than the standard library one.
+type DockerClient interface {
+	ImagePull(ctx netcontext.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error)
+	ImageInspectWithRaw(ctx netcontext.Context, imageID string) (types.ImageInspect, []byte, error)
+	ImageList(ctx netcontext.Context, options types.ImageListOptions) ([]types.ImageSummary, error)
+	ImageRemove(ctx netcontext.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error)
+	ContainerList(ctx netcontext.Context, options types.ContainerListOptions) ([]types.Container, error)
+}
+
+var _ DockerClient = (*docker.Client)(nil)
+
+type inflightPull struct {
+	done   chan struct{}
+	result PullResult
+	err    error
+
+	// layers has the last message about each layer, in the order the layers were first seen, which is replayed to
+	// callers which join the pull part of the way through. These, and the subscribers are protected by the cache's
+	// mutex.
+	layers      map[string]PullMessage
+	layerOrder  []string
+	subscribers map[chan PullMessage]struct{}
+}
+
+func newInflightPull() *inflightPull {
+	return &inflightPull{
+		done:        make(chan struct{}),
+		layers:      make(map[string]PullMessage),
+		subscribers: make(map[chan PullMessage]struct{}),
+	}
+}
+
+// subscribe returns a channel of the progress messages of the pull, starting with where each layer has got to
+func (pull *inflightPull) subscribe() chan PullMessage {
+	messages := make(chan PullMessage, len(pull.layerOrder)+pullMessageBuffer)
+	for _, id := range pull.layerOrder {
+		messages <- pull.layers[id]
+	}
+	pull.subscribers[messages] = struct{}{}
+	return messages
+}
+
+// pin keeps an image from being evicted. Pins are counted, as several tasks can use the same image, and they expire, so
+// that an image isn't pinned forever by a caller which went away without unpinning it.
+type pin struct {
+	count   int
+	expires time.Time
+}
+
+// Cache manages the images on a host. It deduplicates concurrent pulls of the same image, and keeps the images which
+// aren't used by any container within a disk budget, by evicting the least recently used ones.
+type Cache struct {
+	client DockerClient
+	m      metrics.Reporter
+	// budget is in bytes, and images are never evicted if it's 0
+	budget int64
+
+	mu       sync.Mutex
+	inflight map[string]*inflightPull
+	lastUsed map[string]time.Time
+	pins     map[string]*pin
+
+	pulls        int64
+	pullErrors   int64
+	hits         int64
+	deduplicated int64
+	prefetches   int64
+	evictions    int64
+}
+
+// New creates a cache of the images of the Docker daemon behind client
+func New(client DockerClient, m metrics.Reporter, budget int64) *Cache {
+	return &Cache{
+		client:   client,
+		m:        m,
+		budget:   budget,
+		inflight: make(map[string]*inflightPull),
+		lastUsed: make(map[string]time.Time),
+		pins:     make(map[string]*pin),
+	}
+}
+
+// Run enforces the disk budget until the context is cancelled
+func (c *Cache) Run(ctx context.Context) {
+	if c.budget <= 0 {
+		log.Info("No disk budget set, images will not be evicted")
+		return
+	}
+
+	ticker := time.NewTicker(evictionInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.evict(ctx); err != nil {
+			log.Error("Unable to evict images: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// isDigestRef returns true if the reference is to an image by its digest, rather than a tag which can move
+func isDigestRef(ref string) bool {
+	return strings.Contains(ref, "@")
+}
+
+// pullKey returns the key under which pulls of ref are deduplicated, so that references which only differ in how
+// they're spelled (an implied registry, or tag) share a pull. References which can't be parsed are used as is.
+func pullKey(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+	if canonical, ok := named.(reference.Canonical); ok {
+		return named.Name() + "@" + canonical.Digest().String()
+	}
+	return reference.TagNameOnly(named).String()
+}
+
+// Pull makes sure that the image is present. Images referenced by digest which are already present aren't pulled
+// again, and if there's a pull of the same image in progress, this waits for it instead of starting another one.
+func (c *Cache) Pull(ctx context.Context, ref string) (*PullResult, error) {
+	return c.PullWithProgress(ctx, ref, nil)
+}
+
+// PullWithProgress is Pull, but it also calls progress with the messages from dockerd while the image is pulled.
+// Progress is best effort: if the caller falls behind, messages are dropped.
+func (c *Cache) PullWithProgress(ctx context.Context, ref string, progress func(PullMessage)) (*PullResult, error) {
+	if isDigestRef(ref) {
+		if id, ok := c.present(ctx, ref); ok {
+			c.mu.Lock()
+			c.hits++
+			c.lastUsed[id] = time.Now()
+			c.mu.Unlock()
+			c.m.Counter("titus.imageCache.hit", 1, nil)
+			return &PullResult{ID: id, Cached: true}, nil
+		}
+	}
+
+	key := pullKey(ref)
+	c.mu.Lock()
+	pull, deduplicated := c.inflight[key]
+	if deduplicated {
+		c.deduplicated++
+		c.m.Counter("titus.imageCache.deduplicated", 1, nil)
+	} else {
+		pull = newInflightPull()
+		c.inflight[key] = pull
+		c.pulls++
+		// The pull isn't tied to the context of the request, so that the other requests waiting on it aren't failed
+		// if this one goes away
+		go c.doPull(key, ref, pull)
+	}
+	// If progress isn't wanted, messages stays nil, and is never ready
+	var messages chan PullMessage
+	if progress != nil {
+		messages = pull.subscribe()
+		defer c.unsubscribe(pull, messages)
+	}
+	c.mu.Unlock()
+
+	for waiting := true; waiting; {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case msg := <-messages:
+			progress(msg)
+		case <-pull.done:
+			waiting = false
+		}
+	}
+	// Every message is published before the pull is done, so what's left is all buffered
+	for drained := false; !drained; {
+		select {
+		case msg := <-messages:
+			progress(msg)
+		default:
+			drained = true
+		}
+	}
+	if pull.err != nil {
+		return nil, pull.err
+	}
+	result := pull.result
+	result.Deduplicated = deduplicated
+	return &result, nil
+}
+
+// Prefetch pulls the image in the background
+func (c *Cache) Prefetch(ref string) {
+	c.mu.Lock()
+	c.prefetches++
+	c.mu.Unlock()
+	c.m.Counter("titus.imageCache.prefetch", 1, nil)
+
+	go func() {
+		if _, err := c.Pull(context.Background(), ref); err != nil {
+			log.WithField("ref", ref).Warning("Unable to prefetch image: ", err)
+		}
+	}()
+}
+
+func (c *Cache) doPull(key, ref string, pull *inflightPull) {
+	ctx, cancel := context.WithTimeout(context.Background(), pullTimeout)
+	defer cancel()
+
+	start := time.Now()
+	pull.err = c.pullFromRegistry(ctx, pull, ref)
+	if pull.err == nil {
+		var inspect types.ImageInspect
+		if inspect, _, pull.err = c.client.ImageInspectWithRaw(ctx, ref); pull.err == nil {
+			pull.result.ID = inspect.ID
+		}
+	}
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if pull.err == nil {
+		c.lastUsed[pull.result.ID] = time.Now()
+	} else {
+		c.pullErrors++
+	}
+	c.mu.Unlock()
+
+	if pull.err == nil {
+		c.m.Timer("titus.imageCache.pullTime", time.Since(start), nil)
+	} else {
+		c.m.Counter("titus.imageCache.pullError", 1, nil)
+		log.WithField("ref", ref).Warning("Unable to pull image: ", pull.err)
+	}
+	close(pull.done)
+}
+
+func (c *Cache) pullFromRegistry(ctx context.Context, pull *inflightPull, ref string) error {
+	resp, err := c.client.ImagePull(ctx, ref, types.ImagePullOptions{})
+	if err != nil {
+		return err
+	}
+	defer shouldClose(resp)
+
+	decoder := json.NewDecoder(resp)
+	for {
+		var msg PullMessage
+		if err = decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("Error while pulling Docker image: %s", msg.Error)
+		}
+		c.publish(pull, msg)
+	}
+}
+
+// publish passes a progress message on to the callers waiting on the pull, without blocking on any of them
+func (c *Cache) publish(pull *inflightPull, msg PullMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if msg.ID != "" {
+		if _, ok := pull.layers[msg.ID]; !ok {
+			pull.layerOrder = append(pull.layerOrder, msg.ID)
+		}
+		pull.layers[msg.ID] = msg
+	}
+	for messages := range pull.subscribers {
+		select {
+		case messages <- msg:
+		default:
+		}
+	}
+}
+
+func (c *Cache) unsubscribe(pull *inflightPull, messages chan PullMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(pull.subscribers, messages)
+}
+
+// Pin keeps the image that ref refers to from being evicted until it's unpinned, or the lease runs out. Callers pin
+// an image before they pull it, whether or not they pull it through the cache, and unpin it once they've created a
+// container from it, as from then on it's in use.
+func (c *Cache) Pin(ref string, lease time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pins[ref]
+	if !ok {
+		p = &pin{}
+		c.pins[ref] = p
+	}
+	p.count++
+	if expires := time.Now().Add(lease); expires.After(p.expires) {
+		p.expires = expires
+	}
+}
+
+// Unpin releases a pin taken by Pin. The image stays pinned until every pin on it is released.
+func (c *Cache) Unpin(ref string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pins[ref]
+	if !ok {
+		return
+	}
+	p.count--
+	if p.count <= 0 {
+		delete(c.pins, ref)
+	}
+}
+
+// pinnedImages returns the IDs of the pinned images which are present, and drops the pins which have expired
+func (c *Cache) pinnedImages(ctx context.Context) map[string]bool {
+	now := time.Now()
+	refs := []string{}
+	c.mu.Lock()
+	for ref, p := range c.pins {
+		if now.After(p.expires) {
+			log.WithField("ref", ref).Warning("Pin expired without being released")
+			delete(c.pins, ref)
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	c.mu.Unlock()
+
+	pinned := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		if id, ok := c.present(ctx, ref); ok {
+			pinned[id] = true
+		}
+	}
+	return pinned
+}
+
+func (c *Cache) present(ctx context.Context, ref string) (string, bool) {
+	inspect, _, err := c.client.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		if !docker.IsErrImageNotFound(err) {
+			log.WithField("ref", ref).Warning("Unable to inspect image: ", err)
+		}
+		return "", false
+	}
+	return inspect.ID, true
+}
+
+// Lookup returns the image if it's present, and nil if it isn't
+func (c *Cache) Lookup(ctx context.Context, ref string) (*Image, error) {
+	inspect, _, err := c.client.ImageInspectWithRaw(ctx, ref)
+	if docker.IsErrImageNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	inUse, err := c.imagesInUse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	lastUsed := c.lastUsed[inspect.ID]
+	c.mu.Unlock()
+	return &Image{
+		ID:          inspect.ID,
+		RepoTags:    inspect.RepoTags,
+		RepoDigests: inspect.RepoDigests,
+		Size:        inspect.Size,
+		LastUsed:    lastUsed,
+		InUse:       inUse[inspect.ID],
+	}, nil
+}
+
+// imagesInUse returns the IDs of the images which have containers, whether or not they're running. It also marks
+// these images as used.
+func (c *Cache) imagesInUse(ctx context.Context) (map[string]bool, error) {
+	containers, err := c.client.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	inUse := make(map[string]bool, len(containers))
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, container := range containers {
+		inUse[container.ImageID] = true
+		c.lastUsed[container.ImageID] = now
+	}
+	return inUse, nil
+}
+
+// images returns all of the images, along with when they were last used. Images which haven't been used since the
+// cache started are treated as if they were last used when they were created.
+func (c *Cache) images(ctx context.Context) ([]Image, error) {
+	inUse, err := c.imagesInUse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	summaries, err := c.client.ImageList(ctx, types.ImageListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	images := make([]Image, 0, len(summaries))
+	for _, summary := range summaries {
+		lastUsed, ok := c.lastUsed[summary.ID]
+		if !ok {
+			lastUsed = time.Unix(summary.Created, 0)
+		}
+		images = append(images, Image{
+			ID:          summary.ID,
+			RepoTags:    summary.RepoTags,
+			RepoDigests: summary.RepoDigests,
+			Size:        summary.Size,
+			LastUsed:    lastUsed,
+			InUse:       inUse[summary.ID],
+		})
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].LastUsed.Before(images[j].LastUsed)
+	})
+	return images, nil
+}
+
+// evict removes the least recently used images which aren't in use until the images fit in the budget. The size of
+// an image includes the layers it shares with other images, so this errs on the side of evicting too much.
+func (c *Cache) evict(ctx context.Context) error {
+	images, err := c.images(ctx)
+	if err != nil {
+		return err
+	}
+	var used int64
+	for _, image := range images {
+		used += image.Size
+	}
+	c.m.Gauge("titus.imageCache.usedBytes", int(used), nil)
+	if c.budget <= 0 || used <= c.budget {
+		return nil
+	}
+
+	pinned := c.pinnedImages(ctx)
+	var result error
+	for _, image := range images {
+		if used <= c.budget {
+			break
+		}
+		if image.InUse || pinned[image.ID] || time.Since(image.LastUsed) < minEvictionAge {
+			continue
+		}
+		log.WithField("id", image.ID).WithField("tags", image.RepoTags).WithField("lastUsed", image.LastUsed).Info("Evicting image")
+		// A container could have been created from the image since it was listed, in which case docker refuses to
+		// remove it, and it's left for a later pass
+		if _, err = c.client.ImageRemove(ctx, image.ID, types.ImageRemoveOptions{PruneChildren: true}); isConflict(err) {
+			log.WithField("id", image.ID).Info("Not evicting image, as it's in use: ", err)
+			continue
+		} else if err != nil {
+			log.WithField("id", image.ID).Warning("Unable to evict image: ", err)
+			result = err
+			continue
+		}
+		used -= image.Size
+		c.mu.Lock()
+		c.evictions++
+		delete(c.lastUsed, image.ID)
+		c.mu.Unlock()
+		c.m.Counter("titus.imageCache.eviction", 1, nil)
+	}
+
+	if used > c.budget {
+		log.WithField("used", used).WithField("budget", c.budget).Warning("Images in use, or used recently don't fit in the disk budget")
+	}
+	return result
+}
+
+// isConflict returns true if docker refused to remove an image because a container is using it. The docker client
+// doesn't keep the status code of the response, so this goes by the message.
+func isConflict(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "conflict:")
+}
+
+// Stats returns the images on the host, and counters of what the cache has done
+func (c *Cache) Stats(ctx context.Context) (*Stats, error) {
+	images, err := c.images(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := &Stats{
+		BudgetBytes:  c.budget,
+		Images:       images,
+		PullsActive:  len(c.inflight),
+		Pins:         len(c.pins),
+		Pulls:        c.pulls,
+		PullErrors:   c.pullErrors,
+		Hits:         c.hits,
+		Deduplicated: c.deduplicated,
+		Prefetches:   c.prefetches,
+		Evictions:    c.evictions,
+	}
+	for _, image := range images {
+		stats.UsedBytes += image.Size
+	}
+	return stats, nil
+}
+
+func shouldClose(closer io.Closer) {
+	if err := closer.Close(); err != nil {
+		log.Warning("Failed to close: ", err)
+	}
+}
@@ -0,0 +1,377 @@
+package imagecache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netcontext "golang.org/x/net/context"
+)
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return fmt.Sprintf("Error: No such image: %s", string(e))
+}
+
+func (e notFoundError) NotFound() bool {
+	return true
+}
+
+// fakeDocker is a Docker daemon which pulls images out of registry
+type fakeDocker struct {
+	sync.Mutex
+	registry   map[string]types.ImageSummary
+	images     map[string]types.ImageSummary
+	refs       map[string]string
+	containers []types.Container
+	pulls      int
+	removed    []string
+	// Images which containers were created from after they were listed
+	conflicts map[string]bool
+	// If set, pulls block until it's closed
+	releasePulls chan struct{}
+}
+
+func newFakeDocker() *fakeDocker {
+	return &fakeDocker{
+		registry: make(map[string]types.ImageSummary),
+		images:   make(map[string]types.ImageSummary),
+		refs:     make(map[string]string),
+	}
+}
+
+func (f *fakeDocker) addImage(ref string, image types.ImageSummary) {
+	f.images[image.ID] = image
+	f.refs[ref] = image.ID
+}
+
+func (f *fakeDocker) ImagePull(ctx netcontext.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	f.Lock()
+	f.pulls++
+	f.Unlock()
+	if f.releasePulls != nil {
+		<-f.releasePulls
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	image, ok := f.registry[ref]
+	if !ok {
+		return ioutil.NopCloser(strings.NewReader(`{"error":"manifest for ` + ref + ` not found"}`)), nil
+	}
+	f.addImage(ref, image)
+	return ioutil.NopCloser(strings.NewReader(`{"status":"Pulling fs layer","id":"aaa"}` + "\n" + `{"status":"Pull complete","id":"aaa"}`)), nil
+}
+
+func (f *fakeDocker) ImageInspectWithRaw(ctx netcontext.Context, imageID string) (types.ImageInspect, []byte, error) {
+	f.Lock()
+	defer f.Unlock()
+	if id, ok := f.refs[imageID]; ok {
+		imageID = id
+	}
+	image, ok := f.images[imageID]
+	if !ok {
+		return types.ImageInspect{}, nil, notFoundError(imageID)
+	}
+	return types.ImageInspect{ID: image.ID, RepoTags: image.RepoTags, Size: image.Size}, nil, nil
+}
+
+func (f *fakeDocker) ImageList(ctx netcontext.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	f.Lock()
+	defer f.Unlock()
+	images := []types.ImageSummary{}
+	for _, image := range f.images {
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+func (f *fakeDocker) ImageRemove(ctx netcontext.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.conflicts[imageID] && !options.Force {
+		return nil, fmt.Errorf("Error response from daemon: conflict: unable to delete %s (cannot be forced) - image is being used by running container", imageID)
+	}
+	delete(f.images, imageID)
+	f.removed = append(f.removed, imageID)
+	return []types.ImageDeleteResponseItem{{Deleted: imageID}}, nil
+}
+
+func (f *fakeDocker) ContainerList(ctx netcontext.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.containers, nil
+}
+
+func TestPullDigestPresent(t *testing.T) {
+	fd := newFakeDocker()
+	ref := "titusops/alpine@sha256:abc"
+	fd.addImage(ref, types.ImageSummary{ID: "sha256:image1"})
+	cache := New(fd, metrics.Discard, 0)
+
+	result, err := cache.Pull(context.Background(), ref)
+	require.NoError(t, err)
+	assert.True(t, result.Cached)
+	assert.Equal(t, "sha256:image1", result.ID)
+	assert.Equal(t, 0, fd.pulls)
+}
+
+func TestPullTagAlwaysPulls(t *testing.T) {
+	fd := newFakeDocker()
+	ref := "titusops/alpine:latest"
+	fd.addImage(ref, types.ImageSummary{ID: "sha256:image1"})
+	fd.registry[ref] = types.ImageSummary{ID: "sha256:image2"}
+	cache := New(fd, metrics.Discard, 0)
+
+	result, err := cache.Pull(context.Background(), ref)
+	require.NoError(t, err)
+	assert.False(t, result.Cached)
+	assert.Equal(t, "sha256:image2", result.ID)
+	assert.Equal(t, 1, fd.pulls)
+}
+
+func TestPullDeduplicated(t *testing.T) {
+	fd := newFakeDocker()
+	ref := "titusops/alpine@sha256:abc"
+	fd.registry[ref] = types.ImageSummary{ID: "sha256:image1"}
+	fd.releasePulls = make(chan struct{})
+	cache := New(fd, metrics.Discard, 0)
+
+	results := make(chan *PullResult, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			result, err := cache.Pull(context.Background(), ref)
+			assert.NoError(t, err)
+			results <- result
+		}()
+	}
+	require.NoError(t, waitFor(func() bool {
+		stats, err := cache.Stats(context.Background())
+		return err == nil && stats.Deduplicated == 2
+	}))
+	close(fd.releasePulls)
+
+	deduplicated := 0
+	for i := 0; i < 3; i++ {
+		result := <-results
+		require.NotNil(t, result)
+		assert.Equal(t, "sha256:image1", result.ID)
+		if result.Deduplicated {
+			deduplicated++
+		}
+	}
+	assert.Equal(t, 2, deduplicated)
+	assert.Equal(t, 1, fd.pulls)
+}
+
+func TestPullDeduplicatedNormalized(t *testing.T) {
+	fd := newFakeDocker()
+	fd.registry["alpine"] = types.ImageSummary{ID: "sha256:image1"}
+	fd.releasePulls = make(chan struct{})
+	cache := New(fd, metrics.Discard, 0)
+
+	results := make(chan *PullResult, 2)
+	for _, ref := range []string{"alpine", "docker.io/library/alpine:latest"} {
+		go func(ref string) {
+			result, err := cache.Pull(context.Background(), ref)
+			assert.NoError(t, err)
+			results <- result
+		}(ref)
+		// The first reference is the one which is pulled
+		require.NoError(t, waitFor(func() bool {
+			stats, err := cache.Stats(context.Background())
+			return err == nil && stats.PullsActive == 1
+		}))
+	}
+	require.NoError(t, waitFor(func() bool {
+		stats, err := cache.Stats(context.Background())
+		return err == nil && stats.Deduplicated == 1
+	}))
+	close(fd.releasePulls)
+
+	for i := 0; i < 2; i++ {
+		result := <-results
+		require.NotNil(t, result)
+		assert.Equal(t, "sha256:image1", result.ID)
+	}
+	assert.Equal(t, 1, fd.pulls)
+}
+
+func TestPullKey(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	assert.Equal(t, "docker.io/library/alpine:latest", pullKey("alpine"))
+	assert.Equal(t, "docker.io/library/alpine:latest", pullKey("docker.io/library/alpine:latest"))
+	assert.Equal(t, "docker.io/titusops/alpine@"+digest, pullKey("titusops/alpine@"+digest))
+	assert.Equal(t, "docker.io/titusops/alpine@"+digest, pullKey("titusops/alpine:3.7@"+digest))
+	assert.Equal(t, "Not A Reference", pullKey("Not A Reference"))
+}
+
+func TestPullError(t *testing.T) {
+	fd := newFakeDocker()
+	cache := New(fd, metrics.Discard, 0)
+
+	_, err := cache.Pull(context.Background(), "titusops/missing:latest")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+
+	stats, err := cache.Stats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.PullErrors)
+	assert.Equal(t, 0, stats.PullsActive)
+}
+
+func TestEvict(t *testing.T) {
+	fd := newFakeDocker()
+	old := time.Now().Add(-time.Hour).Unix()
+	fd.addImage("oldest:latest", types.ImageSummary{ID: "oldest", Size: 100, Created: old - 100})
+	fd.addImage("old:latest", types.ImageSummary{ID: "old", Size: 100, Created: old})
+	fd.addImage("inuse:latest", types.ImageSummary{ID: "inuse", Size: 100, Created: old - 200})
+	fd.addImage("new:latest", types.ImageSummary{ID: "new", Size: 100, Created: old - 300})
+	fd.containers = []types.Container{{ID: "container", ImageID: "inuse"}}
+	cache := New(fd, metrics.Discard, 250)
+	// This was pulled through the cache, so it's recent, even though the image is old
+	cache.lastUsed["new"] = time.Now()
+
+	require.NoError(t, cache.evict(context.Background()))
+	assert.Equal(t, []string{"oldest", "old"}, fd.removed)
+
+	stats, err := cache.Stats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), stats.UsedBytes)
+	assert.Equal(t, int64(2), stats.Evictions)
+}
+
+func TestEvictInUse(t *testing.T) {
+	fd := newFakeDocker()
+	old := time.Now().Add(-time.Hour).Unix()
+	fd.addImage("raced:latest", types.ImageSummary{ID: "raced", Size: 100, Created: old - 100})
+	fd.addImage("old:latest", types.ImageSummary{ID: "old", Size: 100, Created: old})
+	fd.conflicts = map[string]bool{"raced": true}
+	cache := New(fd, metrics.Discard, 100)
+
+	require.NoError(t, cache.evict(context.Background()))
+	assert.Equal(t, []string{"old"}, fd.removed)
+	_, ok := fd.images["raced"]
+	assert.True(t, ok)
+}
+
+func TestEvictPinned(t *testing.T) {
+	fd := newFakeDocker()
+	old := time.Now().Add(-time.Hour).Unix()
+	fd.addImage("pinned:latest", types.ImageSummary{ID: "pinned", Size: 100, Created: old - 100})
+	fd.addImage("expired:latest", types.ImageSummary{ID: "expired", Size: 100, Created: old - 50})
+	fd.addImage("old:latest", types.ImageSummary{ID: "old", Size: 100, Created: old})
+	cache := New(fd, metrics.Discard, 100)
+	// Pulled directly by the executor, so the cache doesn't know it was just used
+	cache.Pin("pinned:latest", time.Hour)
+	cache.Pin("expired:latest", time.Hour)
+	cache.pins["expired:latest"].expires = time.Now().Add(-time.Second)
+
+	require.NoError(t, cache.evict(context.Background()))
+	assert.Equal(t, []string{"expired", "old"}, fd.removed)
+	_, ok := cache.pins["expired:latest"]
+	assert.False(t, ok)
+}
+
+func TestUnpin(t *testing.T) {
+	fd := newFakeDocker()
+	fd.addImage("pinned:latest", types.ImageSummary{ID: "pinned", Size: 100})
+	cache := New(fd, metrics.Discard, 50)
+	cache.Pin("pinned:latest", time.Hour)
+	cache.Pin("pinned:latest", time.Hour)
+
+	cache.Unpin("pinned:latest")
+	require.NoError(t, cache.evict(context.Background()))
+	assert.Empty(t, fd.removed)
+
+	cache.Unpin("pinned:latest")
+	require.NoError(t, cache.evict(context.Background()))
+	assert.Equal(t, []string{"pinned"}, fd.removed)
+}
+
+func TestPullWithProgress(t *testing.T) {
+	fd := newFakeDocker()
+	ref := "titusops/alpine:latest"
+	fd.registry[ref] = types.ImageSummary{ID: "sha256:image1"}
+	cache := New(fd, metrics.Discard, 0)
+
+	messages := []PullMessage{}
+	result, err := cache.PullWithProgress(context.Background(), ref, func(msg PullMessage) {
+		messages = append(messages, msg)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:image1", result.ID)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "aaa", messages[0].ID)
+	assert.Equal(t, "Pulling fs layer", messages[0].Status)
+	assert.Equal(t, "Pull complete", messages[1].Status)
+}
+
+func TestPullWithProgressReplaysLayers(t *testing.T) {
+	cache := New(newFakeDocker(), metrics.Discard, 0)
+	pull := newInflightPull()
+	cache.publish(pull, PullMessage{ID: "aaa", Status: "Pulling fs layer"})
+	cache.publish(pull, PullMessage{ID: "bbb", Status: "Pulling fs layer"})
+	cache.publish(pull, PullMessage{ID: "aaa", Status: "Download complete"})
+
+	// A caller which joins the pull now gets where each layer has got to, and then the messages as they come in
+	messages := pull.subscribe()
+	cache.publish(pull, PullMessage{ID: "bbb", Status: "Download complete"})
+	cache.unsubscribe(pull, messages)
+	cache.publish(pull, PullMessage{ID: "aaa", Status: "Pull complete"})
+	close(messages)
+
+	received := []PullMessage{}
+	for msg := range messages {
+		received = append(received, msg)
+	}
+	require.Len(t, received, 3)
+	assert.Equal(t, PullMessage{ID: "aaa", Status: "Download complete"}, received[0])
+	assert.Equal(t, PullMessage{ID: "bbb", Status: "Pulling fs layer"}, received[1])
+	assert.Equal(t, PullMessage{ID: "bbb", Status: "Download complete"}, received[2])
+}
+
+func TestEvictWithinBudget(t *testing.T) {
+	fd := newFakeDocker()
+	fd.addImage("old:latest", types.ImageSummary{ID: "old", Size: 100})
+	cache := New(fd, metrics.Discard, 100)
+
+	require.NoError(t, cache.evict(context.Background()))
+	assert.Empty(t, fd.removed)
+}
+
+func TestLookup(t *testing.T) {
+	fd := newFakeDocker()
+	fd.addImage("titusops/alpine:latest", types.ImageSummary{ID: "sha256:image1", Size: 100})
+	fd.containers = []types.Container{{ID: "container", ImageID: "sha256:image1"}}
+	cache := New(fd, metrics.Discard, 0)
+
+	image, err := cache.Lookup(context.Background(), "titusops/alpine:latest")
+	require.NoError(t, err)
+	require.NotNil(t, image)
+	assert.Equal(t, "sha256:image1", image.ID)
+	assert.True(t, image.InUse)
+
+	image, err = cache.Lookup(context.Background(), "titusops/missing:latest")
+	require.NoError(t, err)
+	assert.Nil(t, image)
+}
+
+func waitFor(f func() bool) error {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if f() {
+			return nil
+		}
+	}
+	return fmt.Errorf("Timed out")
+}
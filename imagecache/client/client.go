@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/Netflix/titus-executor/imagecache"
+	log "github.com/sirupsen/logrus"
+)
+
+// ImageCacheClient talks to the image cache server on the host
+type ImageCacheClient struct {
+	httpClient http.Client
+	url        *neturl.URL
+}
+
+// NewImageCacheClient instantiates a new client for the image cache server
+func NewImageCacheClient(baseuri string) (*ImageCacheClient, error) {
+	url, err := neturl.Parse(baseuri)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageCacheClient{
+		// Pulls can take as long as they take, the caller's context bounds them
+		httpClient: http.Client{Timeout: 0},
+		url:        url,
+	}, nil
+}
+
+func (icc *ImageCacheClient) do(ctx context.Context, method, path string, query neturl.Values) (*http.Response, error) {
+	url := *icc.url
+	url.Path = path
+	url.RawQuery = query.Encode()
+	request, err := http.NewRequest(method, url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return icc.httpClient.Do(request.WithContext(ctx))
+}
+
+func statusError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("Image cache request failed, status: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// Pull blocks until the image is present on the host
+func (icc *ImageCacheClient) Pull(ctx context.Context, ref string) (*imagecache.PullResult, error) {
+	resp, err := icc.do(ctx, "POST", "/images/pull", neturl.Values{"ref": {ref}})
+	if err != nil {
+		return nil, err
+	}
+	defer shouldClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	var result imagecache.PullResult
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// PullWithProgress is Pull, but it also calls progress with the messages from dockerd while the image is pulled
+func (icc *ImageCacheClient) PullWithProgress(ctx context.Context, ref string, progress func(imagecache.PullMessage)) (*imagecache.PullResult, error) {
+	resp, err := icc.do(ctx, "POST", "/images/pull", neturl.Values{"ref": {ref}, "progress": {"true"}})
+	if err != nil {
+		return nil, err
+	}
+	defer shouldClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg imagecache.PullStreamMessage
+		if err = decoder.Decode(&msg); err == io.EOF {
+			return nil, fmt.Errorf("Image cache closed the pull of %s without a result", ref)
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case msg.Progress != nil:
+			progress(*msg.Progress)
+		case msg.Result != nil:
+			return msg.Result, nil
+		default:
+			return nil, fmt.Errorf("Image cache pull failed: %s", msg.Error)
+		}
+	}
+}
+
+// Pin keeps the image from being evicted until it's unpinned, or the lease runs out
+func (icc *ImageCacheClient) Pin(ctx context.Context, ref string, lease time.Duration) error {
+	resp, err := icc.do(ctx, "POST", "/images/pin", neturl.Values{"ref": {ref}, "lease": {lease.String()}})
+	if err != nil {
+		return err
+	}
+	defer shouldClose(resp.Body)
+	if resp.StatusCode != http.StatusNoContent {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// Unpin releases a pin taken by Pin
+func (icc *ImageCacheClient) Unpin(ctx context.Context, ref string) error {
+	resp, err := icc.do(ctx, "DELETE", "/images/pin", neturl.Values{"ref": {ref}})
+	if err != nil {
+		return err
+	}
+	defer shouldClose(resp.Body)
+	if resp.StatusCode != http.StatusNoContent {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// Prefetch asks the cache to pull the image in the background
+func (icc *ImageCacheClient) Prefetch(ctx context.Context, ref string) error {
+	resp, err := icc.do(ctx, "POST", "/images/prefetch", neturl.Values{"ref": {ref}})
+	if err != nil {
+		return err
+	}
+	defer shouldClose(resp.Body)
+	if resp.StatusCode != http.StatusAccepted {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// Lookup returns the image if it's present on the host, and nil if it isn't
+func (icc *ImageCacheClient) Lookup(ctx context.Context, ref string) (*imagecache.Image, error) {
+	resp, err := icc.do(ctx, "GET", "/images", neturl.Values{"ref": {ref}})
+	if err != nil {
+		return nil, err
+	}
+	defer shouldClose(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	var image imagecache.Image
+	if err = json.NewDecoder(resp.Body).Decode(&image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// Stats returns the state of the cache
+func (icc *ImageCacheClient) Stats(ctx context.Context) (*imagecache.Stats, error) {
+	resp, err := icc.do(ctx, "GET", "/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer shouldClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	var stats imagecache.Stats
+	if err = json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func shouldClose(closeable io.Closer) {
+	if err := closeable.Close(); err != nil {
+		log.Errorf("Unable to close %v because: %v", closeable, err)
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Netflix/titus-executor/imagecache"
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// ImageCacheServer serves the image cache over HTTP. Images are identified by the ref query parameter, which takes
+// anything that docker pull does. Pulls with the progress query parameter set to true stream newline delimited
+// imagecache.PullStreamMessage, rather than returning the result once the pull is done.
+type ImageCacheServer struct {
+	router *mux.Router
+	cache  *imagecache.Cache
+}
+
+// NewImageCacheServer is a mechanism by which to instantiate ImageCacheServer state
+func NewImageCacheServer(cache *imagecache.Cache) *ImageCacheServer {
+	ics := &ImageCacheServer{
+		cache: cache,
+	}
+	ics.router = mux.NewRouter()
+	ics.router.HandleFunc("/images/pull", ics.pull).Methods("POST")
+	ics.router.HandleFunc("/images/prefetch", ics.prefetch).Methods("POST")
+	ics.router.HandleFunc("/images/pin", ics.pin).Methods("POST")
+	ics.router.HandleFunc("/images/pin", ics.unpin).Methods("DELETE")
+	ics.router.HandleFunc("/images", ics.lookup).Methods("GET")
+	ics.router.HandleFunc("/stats", ics.stats).Methods("GET")
+
+	return ics
+}
+
+func (ics *ImageCacheServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	id := uuid.New()
+	log.WithField("id", id).WithField("url", req.URL).WithField("method", req.Method).Debug("Starting")
+	ics.router.ServeHTTP(resp, req)
+	log.WithField("id", id).WithField("url", req.URL).Debug("Stopping")
+}
+
+func getRef(resp http.ResponseWriter, req *http.Request) (string, bool) {
+	ref := req.URL.Query().Get("ref")
+	if ref == "" {
+		http.Error(resp, "ref parameter missing", http.StatusBadRequest)
+		return "", false
+	}
+	return ref, true
+}
+
+func (ics *ImageCacheServer) pull(resp http.ResponseWriter, req *http.Request) {
+	ref, ok := getRef(resp, req)
+	if !ok {
+		return
+	}
+	if req.URL.Query().Get("progress") == "true" {
+		ics.pullWithProgress(resp, req, ref)
+		return
+	}
+	result, err := ics.cache.Pull(req.Context(), ref)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(resp, result)
+}
+
+// pullWithProgress streams the progress of the pull. The status is sent before the outcome of the pull is known, so
+// a failed pull is reported by the last message, rather than the status.
+func (ics *ImageCacheServer) pullWithProgress(resp http.ResponseWriter, req *http.Request, ref string) {
+	resp.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := resp.(http.Flusher)
+	encoder := json.NewEncoder(resp)
+	write := func(msg imagecache.PullStreamMessage) {
+		if err := encoder.Encode(msg); err != nil {
+			log.Error("Unable to write response: ", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	result, err := ics.cache.PullWithProgress(req.Context(), ref, func(msg imagecache.PullMessage) {
+		write(imagecache.PullStreamMessage{Progress: &msg})
+	})
+	if err != nil {
+		write(imagecache.PullStreamMessage{Error: err.Error()})
+		return
+	}
+	write(imagecache.PullStreamMessage{Result: result})
+}
+
+func (ics *ImageCacheServer) pin(resp http.ResponseWriter, req *http.Request) {
+	ref, ok := getRef(resp, req)
+	if !ok {
+		return
+	}
+	lease, err := time.ParseDuration(req.URL.Query().Get("lease"))
+	if err != nil || lease <= 0 {
+		http.Error(resp, "lease parameter missing, or invalid", http.StatusBadRequest)
+		return
+	}
+	ics.cache.Pin(ref, lease)
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (ics *ImageCacheServer) unpin(resp http.ResponseWriter, req *http.Request) {
+	ref, ok := getRef(resp, req)
+	if !ok {
+		return
+	}
+	ics.cache.Unpin(ref)
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (ics *ImageCacheServer) prefetch(resp http.ResponseWriter, req *http.Request) {
+	ref, ok := getRef(resp, req)
+	if !ok {
+		return
+	}
+	ics.cache.Prefetch(ref)
+	resp.WriteHeader(http.StatusAccepted)
+}
+
+func (ics *ImageCacheServer) lookup(resp http.ResponseWriter, req *http.Request) {
+	ref, ok := getRef(resp, req)
+	if !ok {
+		return
+	}
+	image, err := ics.cache.Lookup(req.Context(), ref)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if image == nil {
+		http.NotFound(resp, req)
+		return
+	}
+	writeJSON(resp, image)
+}
+
+func (ics *ImageCacheServer) stats(resp http.ResponseWriter, req *http.Request) {
+	stats, err := ics.cache.Stats(req.Context())
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(resp, stats)
+}
+
+func writeJSON(resp http.ResponseWriter, v interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(v); err != nil {
+		log.Error("Unable to write response: ", err)
+	}
+}
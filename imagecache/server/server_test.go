@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	"github.com/Netflix/titus-executor/imagecache"
+	"github.com/Netflix/titus-executor/imagecache/client"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netcontext "golang.org/x/net/context"
+)
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string {
+	return "Error: No such image"
+}
+
+func (notFoundError) NotFound() bool {
+	return true
+}
+
+// fakeDocker has a single image, which can be pulled by its tag
+type fakeDocker struct {
+	pulled bool
+}
+
+func (f *fakeDocker) ImagePull(ctx netcontext.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	if ref != "titusops/alpine:latest" {
+		return ioutil.NopCloser(strings.NewReader(`{"error":"not found"}`)), nil
+	}
+	f.pulled = true
+	return ioutil.NopCloser(strings.NewReader(`{"status":"Pull complete"}`)), nil
+}
+
+func (f *fakeDocker) ImageInspectWithRaw(ctx netcontext.Context, imageID string) (types.ImageInspect, []byte, error) {
+	if !f.pulled || imageID != "titusops/alpine:latest" {
+		return types.ImageInspect{}, nil, notFoundError{}
+	}
+	return types.ImageInspect{ID: "sha256:image1", Size: 100}, nil, nil
+}
+
+func (f *fakeDocker) ImageList(ctx netcontext.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	if !f.pulled {
+		return nil, nil
+	}
+	return []types.ImageSummary{{ID: "sha256:image1", Size: 100}}, nil
+}
+
+func (f *fakeDocker) ImageRemove(ctx netcontext.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	return nil, nil
+}
+
+func (f *fakeDocker) ContainerList(ctx netcontext.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	return nil, nil
+}
+
+func TestImageCacheServer(t *testing.T) {
+	server := httptest.NewServer(NewImageCacheServer(imagecache.New(&fakeDocker{}, metrics.Discard, 1000)))
+	defer server.Close()
+	c, err := client.NewImageCacheClient(server.URL)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	image, err := c.Lookup(ctx, "titusops/alpine:latest")
+	require.NoError(t, err)
+	assert.Nil(t, image)
+
+	result, err := c.Pull(ctx, "titusops/alpine:latest")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:image1", result.ID)
+
+	image, err = c.Lookup(ctx, "titusops/alpine:latest")
+	require.NoError(t, err)
+	require.NotNil(t, image)
+	assert.Equal(t, int64(100), image.Size)
+
+	_, err = c.Pull(ctx, "titusops/missing:latest")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+
+	stats, err := c.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), stats.BudgetBytes)
+	assert.Equal(t, int64(100), stats.UsedBytes)
+	assert.Equal(t, int64(2), stats.Pulls)
+	assert.Equal(t, int64(1), stats.PullErrors)
+}
+
+func TestImageCacheServerPullWithProgress(t *testing.T) {
+	server := httptest.NewServer(NewImageCacheServer(imagecache.New(&fakeDocker{}, metrics.Discard, 0)))
+	defer server.Close()
+	c, err := client.NewImageCacheClient(server.URL)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	messages := []imagecache.PullMessage{}
+	result, err := c.PullWithProgress(ctx, "titusops/alpine:latest", func(msg imagecache.PullMessage) {
+		messages = append(messages, msg)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:image1", result.ID)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "Pull complete", messages[0].Status)
+
+	_, err = c.PullWithProgress(ctx, "titusops/missing:latest", func(imagecache.PullMessage) {})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+}
+
+func TestImageCacheServerPin(t *testing.T) {
+	server := httptest.NewServer(NewImageCacheServer(imagecache.New(&fakeDocker{}, metrics.Discard, 0)))
+	defer server.Close()
+	c, err := client.NewImageCacheClient(server.URL)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, c.Pin(ctx, "titusops/alpine:latest", time.Minute))
+	stats, err := c.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Pins)
+
+	require.NoError(t, c.Unpin(ctx, "titusops/alpine:latest"))
+	stats, err = c.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Pins)
+
+	assert.Error(t, c.Pin(ctx, "titusops/alpine:latest", 0))
+}
+
+func TestMissingRef(t *testing.T) {
+	server := httptest.NewServer(NewImageCacheServer(imagecache.New(&fakeDocker{}, metrics.Discard, 0)))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/images/pull", "", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,57 @@
+package imagecache
+
+import "time"
+
+// Image is an image that's present on the host
+type Image struct {
+	ID          string    `json:"id"`
+	RepoTags    []string  `json:"repoTags,omitempty"`
+	RepoDigests []string  `json:"repoDigests,omitempty"`
+	Size        int64     `json:"size"`
+	LastUsed    time.Time `json:"lastUsed"`
+	InUse       bool      `json:"inUse"`
+}
+
+// PullResult is the outcome of a successful pull through the cache
+type PullResult struct {
+	ID string `json:"id"`
+	// Cached is true if the image was already present, and it wasn't pulled from the registry
+	Cached bool `json:"cached"`
+	// Deduplicated is true if the pull was joined to another pull of the same image which was already in progress
+	Deduplicated bool `json:"deduplicated"`
+}
+
+// Stats describes the state of the cache
+type Stats struct {
+	BudgetBytes  int64   `json:"budgetBytes"`
+	UsedBytes    int64   `json:"usedBytes"`
+	Images       []Image `json:"images"`
+	PullsActive  int     `json:"pullsActive"`
+	Pins         int     `json:"pins"`
+	Pulls        int64   `json:"pulls"`
+	PullErrors   int64   `json:"pullErrors"`
+	Hits         int64   `json:"hits"`
+	Deduplicated int64   `json:"deduplicated"`
+	Prefetches   int64   `json:"prefetches"`
+	Evictions    int64   `json:"evictions"`
+}
+
+// PullMessage is a message from the JSON stream which dockerd sends while it pulls an image. The cache passes these
+// on to the callers waiting on the pull, so that they can track its progress.
+type PullMessage struct {
+	ID             string `json:"id,omitempty"`
+	Status         string `json:"status,omitempty"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error string `json:"error,omitempty"`
+}
+
+// PullStreamMessage is a line of the stream which the server sends in response to a pull with progress. Every line but
+// the last carries a Progress message, and the last carries either the Result, or the Error that the pull failed with.
+type PullStreamMessage struct {
+	Progress *PullMessage `json:"progress,omitempty"`
+	Result   *PullResult  `json:"result,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
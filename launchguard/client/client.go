@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -22,7 +23,10 @@ const (
 	MaxLaunchTime = 10 * time.Minute
 	// RefreshWindow is how often launch events should be renewed, or will be considered dead by the server
 	RefreshWindow = time.Second
-	defaultKey    = "default"
+	// RestartGracePeriod is how long cleanup events keep heartbeating through an unreachable server, in case it's
+	// restarting. The server journals cleanup events, and waits this long after their last heartbeat for them to resume.
+	RestartGracePeriod = 30 * time.Second
+	defaultKey         = "default"
 )
 
 // LaunchGuardClient coordinates the starting and shutting down of containers
@@ -39,7 +43,8 @@ var (
 
 // ClientLaunchEvent is used to synchronize launching containers
 type ClientLaunchEvent struct { // nolint: golint
-	ch chan struct{}
+	ch     chan struct{}
+	result core.LaunchResult
 }
 
 // Launch returns a channel which will be closed once you're allowed to launch
@@ -47,6 +52,16 @@ func (cle *ClientLaunchEvent) Launch() <-chan struct{} {
 	return cle.ch
 }
 
+// Result says why the launch was given clearance, once the Launch channel is closed
+func (cle *ClientLaunchEvent) Result() core.LaunchResult {
+	return cle.result
+}
+
+func (cle *ClientLaunchEvent) finish(result core.LaunchResult) {
+	cle.result = result
+	close(cle.ch)
+}
+
 // ClientCleanupEvent should be used when tearing a container down
 type ClientCleanupEvent struct { // nolint: golint
 	once   sync.Once
@@ -63,10 +78,21 @@ func (cce *ClientCleanupEvent) Done() {
 		close(cce.ch)
 	})
 }
+
+// serverUnreachableError is returned if the request never made it to the server, as opposed to the server rejecting it
+type serverUnreachableError struct {
+	Reason error
+}
+
+func (e *serverUnreachableError) Error() string {
+	return fmt.Sprintf("Launchguard server unreachable : %s", e.Reason.Error())
+}
+
 func (cce *ClientCleanupEvent) run(ctx context.Context) {
 	ticker := time.NewTicker(RefreshWindow)
 	defer ticker.Stop()
 	defer cce.cancel()
+	lastHeartbeat := time.Now()
 	for {
 		select {
 		case <-ctx.Done():
@@ -78,10 +104,17 @@ func (cce *ClientCleanupEvent) run(ctx context.Context) {
 			}
 			return
 		case <-ticker.C:
-			if err := cce.heartbeat(ctx); err != nil {
-				log.Warning("Heartbeat failed, assuming cleanup event is done: ", err)
-				return
+			err := cce.heartbeat(ctx)
+			if err == nil {
+				lastHeartbeat = time.Now()
+				continue
+			}
+			if _, ok := err.(*serverUnreachableError); ok && time.Since(lastHeartbeat) < RestartGracePeriod {
+				log.Debug("Heartbeat failed, retrying in case the server is restarting: ", err)
+				continue
 			}
+			log.Warning("Heartbeat failed, assuming cleanup event is done: ", err)
+			return
 		}
 	}
 }
@@ -97,7 +130,7 @@ func (cce *ClientCleanupEvent) heartbeat(ctx context.Context) error {
 	resp, err := cce.lgc.httpClient.Do(request.WithContext(ctx))
 
 	if err != nil {
-		return fmt.Errorf("Heartbeat failed: %v", err)
+		return &serverUnreachableError{Reason: err}
 	}
 
 	shouldClose(resp.Body)
@@ -189,7 +222,9 @@ func (lgc *LaunchGuardClient) NewRealCleanUpEvent(parentCtx context.Context, key
 }
 
 // NewLaunchEvent returns a launch event. This launch event creation may block for up to MaxLaunchTime,
-// but you must look at the channel in order to get actual clearance to launch
+// but you must look at the channel in order to get actual clearance to launch. Errors talking to the server give
+// clearance straight away, with a LaunchServerError result. If the server goes away once the launch event is queued,
+// it's queued again for up to RestartGracePeriod, in case the server is restarting.
 func (lgc *LaunchGuardClient) NewLaunchEvent(parentCtx context.Context, key string) core.LaunchEvent {
 	if key == "" {
 		key = defaultKey
@@ -198,34 +233,99 @@ func (lgc *LaunchGuardClient) NewLaunchEvent(parentCtx context.Context, key stri
 	var url url.URL
 
 	ctx, cancel := context.WithTimeout(parentCtx, MaxLaunchTime)
-	launchEvent := &ClientLaunchEvent{make(chan struct{})}
+	launchEvent := &ClientLaunchEvent{ch: make(chan struct{})}
 
 	url = *lgc.url
 	url.Path = fmt.Sprintf("/launchguard/%s/launchevent", key)
 	log.Info(url)
-	request, err := http.NewRequest("GET", url.String(), nil)
-	if err != nil {
-		panic("Unable to create request")
-	}
-	resp, err := lgc.httpClient.Do(request.WithContext(ctx))
+	body, err := lgc.queueLaunch(ctx, url)
 	if err != nil {
 		log.Error("Error creating client-side launch event: ", err)
-		close(launchEvent.ch)
+		launchEvent.finish(launchResultForError(ctx, err))
 		cancel()
 		return launchEvent
 	}
 	go func() {
 		defer cancel()
-		defer close(launchEvent.ch)
+		launchEvent.finish(lgc.waitForLaunch(ctx, url, body))
+	}()
+	return launchEvent
+}
+
+// queueLaunch queues a launch event on the server, and returns the body which the launch result is written to once
+// the launch is given clearance
+func (lgc *LaunchGuardClient) queueLaunch(ctx context.Context, url url.URL) (io.ReadCloser, error) {
+	request, err := http.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		panic("Unable to create request")
+	}
+	resp, err := lgc.httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, &serverUnreachableError{Reason: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		shouldClose(resp.Body)
+		return nil, fmt.Errorf("status: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
 
-		buf, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
+// waitForLaunch reads the launch result from body, and queues the launch event again if the server goes away before
+// it's written
+func (lgc *LaunchGuardClient) waitForLaunch(ctx context.Context, url url.URL, body io.ReadCloser) core.LaunchResult {
+	for {
+		buf, err := ioutil.ReadAll(body)
+		shouldClose(body)
+		if err == nil {
+			log.Debug("Launch (client) event finished: ", string(buf))
+			return parseLaunchResult(buf)
+		}
+		if ctx.Err() != nil {
 			log.Error("Error reading client-side launch event info: ", err)
+			return launchResultForError(ctx, err)
 		}
-		shouldClose(resp.Body)
-		log.Debug("Launch (client) event finished: ", string(buf))
-	}()
-	return launchEvent
+
+		lastReached := time.Now()
+		for body = nil; body == nil; {
+			log.Debug("Launch event lost, queueing it again in case the server is restarting: ", err)
+			select {
+			case <-ctx.Done():
+				return launchResultForError(ctx, ctx.Err())
+			case <-time.After(RefreshWindow):
+			}
+			body, err = lgc.queueLaunch(ctx, url)
+			if _, ok := err.(*serverUnreachableError); err != nil && (!ok || time.Since(lastReached) >= RestartGracePeriod) {
+				log.Error("Error queueing client-side launch event again: ", err)
+				return launchResultForError(ctx, err)
+			}
+		}
+	}
+}
+
+// launchResultForError tells apart us giving up on the launch guard from the launch guard failing us
+func launchResultForError(ctx context.Context, err error) core.LaunchResult {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return core.LaunchResult{Reason: core.LaunchTimedOut}
+	case context.Canceled:
+		return core.LaunchResult{Reason: core.LaunchCancelled}
+	}
+	return core.LaunchResult{Reason: core.LaunchServerError, Error: err.Error()}
+}
+
+func parseLaunchResult(buf []byte) core.LaunchResult {
+	// Older servers only say "launch", or "timeout" if they stopped waiting
+	switch string(buf) {
+	case "launch":
+		return core.LaunchResult{Reason: core.LaunchCleared}
+	case "timeout":
+		return core.LaunchResult{Reason: core.LaunchTimedOut}
+	}
+	var result core.LaunchResult
+	if err := json.Unmarshal(buf, &result); err != nil {
+		return core.LaunchResult{Reason: core.LaunchServerError, Error: fmt.Sprintf("Unable to parse launch result: %v", err)}
+	}
+	return result
 }
 
 func shouldClose(closeable io.Closer) {
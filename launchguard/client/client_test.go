@@ -0,0 +1,15 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/Netflix/titus-executor/launchguard/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLaunchResult(t *testing.T) {
+	assert.Equal(t, core.LaunchResult{Reason: core.LaunchCleared}, parseLaunchResult([]byte("launch")))
+	assert.Equal(t, core.LaunchResult{Reason: core.LaunchTimedOut}, parseLaunchResult([]byte("timeout")))
+	assert.Equal(t, core.LaunchResult{Reason: core.LaunchExpired}, parseLaunchResult([]byte(`{"reason":"expired"}`)))
+	assert.Equal(t, core.LaunchServerError, parseLaunchResult([]byte("garbage")).Reason)
+}
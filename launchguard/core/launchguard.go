@@ -29,7 +29,9 @@ type LaunchGuard struct {
 	metrics          metrics.Reporter
 	cleanUpEventChan chan cleanUpEvent
 	launchEventChan  chan launchEvent
-	events           []launchGuardEvent
+	// events is only mutated by the loop, eventsLock is held while it does so, so QueuedEvents can read it
+	eventsLock sync.Mutex
+	events     []launchGuardEvent
 	// The purpose of the Ticker is to bump the state so we can report the depth metric
 	ticker *time.Ticker
 }
@@ -72,10 +74,10 @@ func (lg *LaunchGuard) loop() {
 func (lg *LaunchGuard) dispatchEmpty() launchGuardStateMachineState {
 	select {
 	case myCleanUpEvent := <-lg.cleanUpEventChan:
-		lg.events = append(lg.events, myCleanUpEvent)
+		lg.pushEvent(myCleanUpEvent)
 		return waitingOnCleanupEventState
 	case myLaunchEvent := <-lg.launchEventChan:
-		lg.events = append(lg.events, myLaunchEvent)
+		lg.pushEvent(myLaunchEvent)
 		return doLaunchState
 	case <-lg.ticker.C:
 		return emptyState
@@ -88,15 +90,17 @@ func (lg *LaunchGuard) dispatchWaitingOnCleanupEvent() launchGuardStateMachineSt
 	case <-lastCleanUpEvent.done():
 		if lastCleanUpEvent.ctx.Err() == context.DeadlineExceeded {
 			lg.metrics.Counter("titus.executor.launchGuard.deadlineExceededError", 1, nil)
+		} else if lastCleanUpEvent.expired {
+			lg.metrics.Counter("titus.executor.launchGuard.expiredError", 1, nil)
 		}
 		// Remove event from the wait queue
-		lg.events = lg.events[1:]
+		lg.popEvent()
 		return lg.determineStateAfter()
 	case myCleanupEvent := <-lg.cleanUpEventChan:
-		lg.events = append(lg.events, myCleanupEvent)
+		lg.pushEvent(myCleanupEvent)
 		return waitingOnCleanupEventState
 	case myLaunchEvent := <-lg.launchEventChan:
-		lg.events = append(lg.events, myLaunchEvent)
+		lg.pushEvent(myLaunchEvent)
 		return waitingOnCleanupEventState
 	case <-lg.ticker.C:
 		return waitingOnCleanupEventState
@@ -119,10 +123,50 @@ func (lg *LaunchGuard) determineStateAfter() launchGuardStateMachineState {
 func (lg *LaunchGuard) doLaunch() launchGuardStateMachineState {
 	event := lg.events[0].(launchEvent)
 	event.notifyLaunch()
-	lg.events = lg.events[1:]
+	lg.popEvent()
 	return lg.determineStateAfter()
 }
 
+func (lg *LaunchGuard) pushEvent(event launchGuardEvent) {
+	lg.eventsLock.Lock()
+	defer lg.eventsLock.Unlock()
+	if myLaunchEvent, ok := event.(*realLaunchEvent); ok {
+		for _, queuedEvent := range lg.events {
+			if myCleanUpEvent, ok := queuedEvent.(*RealCleanUpEvent); ok {
+				myLaunchEvent.blockedBy = append(myLaunchEvent.blockedBy, myCleanUpEvent)
+			}
+		}
+	}
+	lg.events = append(lg.events, event)
+}
+
+func (lg *LaunchGuard) popEvent() {
+	lg.eventsLock.Lock()
+	defer lg.eventsLock.Unlock()
+	lg.events = lg.events[1:]
+}
+
+// QueuedEvents returns the events in the launch guard's queue, in the order they'll be handled. The event at the head
+// of the queue is either the cleanup event being waited on, or a launch event about to be given clearance.
+func (lg *LaunchGuard) QueuedEvents() []QueuedEvent {
+	lg.eventsLock.Lock()
+	defer lg.eventsLock.Unlock()
+	queuedEvents := make([]QueuedEvent, 0, len(lg.events))
+	for _, event := range lg.events {
+		switch e := event.(type) {
+		case *RealCleanUpEvent:
+			queuedEvents = append(queuedEvents, QueuedEvent{Type: CleanUpEventType, ID: e.id, CreatedAt: e.createdAt})
+		case *realLaunchEvent:
+			queuedEvents = append(queuedEvents, QueuedEvent{Type: LaunchEventType, CreatedAt: e.createdAt})
+		case cleanUpEvent:
+			queuedEvents = append(queuedEvents, QueuedEvent{Type: CleanUpEventType})
+		case launchEvent:
+			queuedEvents = append(queuedEvents, QueuedEvent{Type: LaunchEventType})
+		}
+	}
+	return queuedEvents
+}
+
 type launchGuardEvent interface{}
 
 var (
@@ -135,22 +179,32 @@ var (
 
 // RealCleanUpEvent should be used when the launchGuard is actually needed (kill)
 type RealCleanUpEvent struct {
+	id string
 	// We wait for this to read as closed
 	createdAt time.Time
 	ctx       context.Context
 	metrics   metrics.Reporter
 	cancel    context.CancelFunc
 	once      sync.Once
+	// expired is set before ctx is cancelled, so it can be read once done() is closed
+	expired bool
 }
 
 // NewRealCleanUpEvent must be used to instantiate new real cleanup events
 func NewRealCleanUpEvent(parentCtx context.Context, lg *LaunchGuard) cleanUpEvent { // nolint: golint
+	return NewRealCleanUpEventWithID(parentCtx, lg, "", time.Now())
+}
+
+// NewRealCleanUpEventWithID is like NewRealCleanUpEvent, but the event is listed under id in QueuedEvents, and its age
+// is counted from createdAt, so events which are restored keep the age they had
+func NewRealCleanUpEventWithID(parentCtx context.Context, lg *LaunchGuard, id string, createdAt time.Time) cleanUpEvent { // nolint: golint
 	ctx, cancel := context.WithCancel(parentCtx)
 	event := &RealCleanUpEvent{
+		id:        id,
 		ctx:       ctx,
 		metrics:   lg.metrics,
 		cancel:    cancel,
-		createdAt: time.Now(),
+		createdAt: createdAt,
 		once:      sync.Once{},
 	}
 	lg.cleanUpEventChan <- event
@@ -166,6 +220,16 @@ func (ce *RealCleanUpEvent) Done() {
 	})
 }
 
+// Expire is like Done, but the launches queued behind the event are told it was abandoned rather than finished. It
+// does nothing if the event is already done.
+func (ce *RealCleanUpEvent) Expire() {
+	ce.once.Do(func() {
+		ce.metrics.Timer("titus.executor.cleanUpEvent.timeInQueue", time.Since(ce.createdAt), nil)
+		ce.expired = true
+		ce.cancel()
+	})
+}
+
 func (ce *RealCleanUpEvent) done() <-chan struct{} {
 	return ce.ctx.Done()
 }
@@ -175,6 +239,9 @@ type NoopCleanUpEvent struct{}
 
 // Done does nothing
 func (ce *NoopCleanUpEvent) Done() {}
+
+// Expire does nothing
+func (ce *NoopCleanUpEvent) Expire() {}
 func (ce *NoopCleanUpEvent) done() <-chan struct{} {
 	c := make(chan struct{})
 	close(c)
@@ -194,6 +261,9 @@ type realLaunchEvent struct {
 	createdAt  time.Time
 	internalCh chan struct{}
 	once       sync.Once
+	// blockedBy is the cleanup events which were ahead of this one when it was queued
+	blockedBy []*RealCleanUpEvent
+	result    LaunchResult
 }
 
 // NewLaunchEvent must be used to instantiate new LaunchEvents
@@ -213,10 +283,26 @@ func (ce *realLaunchEvent) Launch() <-chan struct{} {
 	return ce.internalCh
 }
 
+func (ce *realLaunchEvent) Result() LaunchResult {
+	return ce.result
+}
+
 func (ce *realLaunchEvent) notifyLaunch() {
 	ce.once.Do(
 		func() {
 			ce.metrics.Timer("titus.executor.launchEvent.timeInQueue", time.Since(ce.createdAt), nil)
+			ce.result = LaunchResult{Reason: LaunchCleared}
+			for _, myCleanUpEvent := range ce.blockedBy {
+				if myCleanUpEvent.ctx.Err() == context.DeadlineExceeded {
+					ce.result.Reason = LaunchTimedOut
+				} else if myCleanUpEvent.expired && ce.result.Reason != LaunchTimedOut {
+					ce.result.Reason = LaunchExpired
+				}
+				ce.result.BlockedBy = append(ce.result.BlockedBy, BlockingCleanUpEvent{
+					ID:  myCleanUpEvent.id,
+					Age: time.Since(myCleanUpEvent.createdAt),
+				})
+			}
 			close(ce.internalCh)
 		})
 }
@@ -230,3 +316,8 @@ func (ce *NoopLaunchEvent) Launch() <-chan struct{} {
 	close(c)
 	return c
 }
+
+// Result is always LaunchCleared, as there was nothing to wait on
+func (ce *NoopLaunchEvent) Result() LaunchResult {
+	return LaunchResult{Reason: LaunchCleared}
+}
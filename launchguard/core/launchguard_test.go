@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/Netflix/metrics-client-go/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNoopEvent(t *testing.T) {
@@ -108,6 +110,10 @@ func (le *testLaunchEvent) Launch() <-chan struct{} {
 	return c
 }
 
+func (le *testLaunchEvent) Result() LaunchResult {
+	return LaunchResult{Reason: LaunchCleared}
+}
+
 func TestLaunchGuard(t *testing.T) {
 	timer := time.AfterFunc(10*time.Second, func() {
 		t.Fatal("Event timed out")
@@ -148,3 +154,42 @@ func TestLaunchGuard(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestLaunchResult(t *testing.T) {
+	lg := NewLaunchGuard(metrics.Discard)
+	le := NewLaunchEvent(lg)
+	<-le.Launch()
+	assert.Equal(t, LaunchResult{Reason: LaunchCleared}, le.Result())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	ce1 := NewRealCleanUpEventWithID(context.Background(), lg, "ce1", time.Now())
+	NewRealCleanUpEventWithID(ctx, lg, "ce2", time.Now())
+	le = NewLaunchEvent(lg)
+	ce1.Done()
+	// ce2 is never done, so the launch is only given clearance once it times out
+	<-le.Launch()
+	result := le.Result()
+	assert.Equal(t, LaunchTimedOut, result.Reason)
+	require.Len(t, result.BlockedBy, 2)
+	assert.Equal(t, "ce1", result.BlockedBy[0].ID)
+	assert.Equal(t, "ce2", result.BlockedBy[1].ID)
+	assert.True(t, result.BlockedBy[1].Age >= 100*time.Millisecond)
+	assert.Contains(t, result.String(), "timed_out, blocked by cleanup events ce1")
+}
+
+func TestLaunchResultExpired(t *testing.T) {
+	lg := NewLaunchGuard(metrics.Discard)
+	ce1 := NewRealCleanUpEventWithID(context.Background(), lg, "ce1", time.Now())
+	ce2 := NewRealCleanUpEventWithID(context.Background(), lg, "ce2", time.Now())
+	le := NewLaunchEvent(lg)
+	ce1.Done()
+	ce2.Expire()
+	// Done after Expire doesn't change the outcome
+	ce2.Done()
+	<-le.Launch()
+	result := le.Result()
+	assert.Equal(t, LaunchExpired, result.Reason)
+	require.Len(t, result.BlockedBy, 2)
+	assert.Contains(t, result.String(), "expired, blocked by cleanup events ce1")
+}
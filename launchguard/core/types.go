@@ -1,8 +1,34 @@
 package core
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QueuedEventType is the kind of event waiting in a launch guard's queue
+type QueuedEventType string
+
+const (
+	// CleanUpEventType is a container being torn down, which holds up the launches queued behind it
+	CleanUpEventType QueuedEventType = "cleanup"
+	// LaunchEventType is a container waiting for clearance to launch
+	LaunchEventType QueuedEventType = "launch"
+)
+
+// QueuedEvent describes an event waiting in a launch guard's queue
+type QueuedEvent struct {
+	Type QueuedEventType `json:"type"`
+	// ID is only set for cleanup events which were created with one
+	ID        string    `json:"id,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
 // cleanUpEvent should be used when tearing a container down
 type cleanUpEvent interface {
 	CleanUpEvent
+	// Expire is like Done, but for cleanup events which were abandoned rather than finished
+	Expire()
 	done() <-chan struct{}
 }
 
@@ -20,4 +46,56 @@ type CleanUpEvent interface {
 // LaunchEvent is used to synchronize launching containers
 type LaunchEvent interface {
 	Launch() <-chan struct{}
+	// Result says why the launch was given clearance. It must only be called once the Launch channel is closed.
+	Result() LaunchResult
+}
+
+// LaunchResultReason is why a launch event was given clearance
+type LaunchResultReason string
+
+const (
+	// LaunchCleared means every cleanup event ahead of the launch finished
+	LaunchCleared LaunchResultReason = "cleared"
+	// LaunchTimedOut means the launch stopped waiting on a cleanup event which ran past its maximum lifetime
+	LaunchTimedOut LaunchResultReason = "timed_out"
+	// LaunchExpired means a cleanup event ahead of the launch stopped heartbeating, so it was abandoned
+	LaunchExpired LaunchResultReason = "expired"
+	// LaunchServerError means the launch guard couldn't be reached, or failed, so the launch went ahead unguarded
+	LaunchServerError LaunchResultReason = "server_error"
+	// LaunchCancelled means whoever was waiting on the launch gave up
+	LaunchCancelled LaunchResultReason = "cancelled"
+)
+
+// BlockingCleanUpEvent is a cleanup event which was queued ahead of a launch event
+type BlockingCleanUpEvent struct {
+	ID string `json:"id,omitempty"`
+	// Age is how long the cleanup event had been around for when the launch was given clearance
+	Age time.Duration `json:"age"`
+}
+
+// LaunchResult is why a launch event was given clearance, and what it was waiting on
+type LaunchResult struct {
+	Reason    LaunchResultReason     `json:"reason"`
+	BlockedBy []BlockingCleanUpEvent `json:"blockedBy,omitempty"`
+	// Error is only set for LaunchServerError
+	Error string `json:"error,omitempty"`
+}
+
+func (r LaunchResult) String() string {
+	msg := string(r.Reason)
+	if r.Error != "" {
+		msg = fmt.Sprintf("%s: %s", msg, r.Error)
+	}
+	if len(r.BlockedBy) == 0 {
+		return msg
+	}
+	blockedBy := make([]string, len(r.BlockedBy))
+	for idx, event := range r.BlockedBy {
+		id := event.ID
+		if id == "" {
+			id = "unknown"
+		}
+		blockedBy[idx] = fmt.Sprintf("%s (%s)", id, event.Age.Round(time.Second))
+	}
+	return fmt.Sprintf("%s, blocked by cleanup events %s", msg, strings.Join(blockedBy, ", "))
 }
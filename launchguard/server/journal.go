@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// journalEntry is a cleanup event, as it's recorded on disk
+type journalEntry struct {
+	Key           string    `json:"key"`
+	ID            string    `json:"id"`
+	StartedAt     time.Time `json:"startedAt"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+}
+
+type journalEntryKey struct {
+	key string
+	id  string
+}
+
+// journal records the outstanding cleanup events in a file, which is rewritten every time one of them changes, so that
+// they can be restored if the server restarts. A nil journal records nothing.
+type journal struct {
+	sync.Mutex
+	path    string
+	m       metrics.Reporter
+	entries map[journalEntryKey]journalEntry
+}
+
+// loadJournal reads the journal at path, creating its directory if needed. A missing journal is an empty one, and a
+// corrupt one is logged, and started afresh, as refusing to start would leave launches unguarded anyway.
+func loadJournal(m metrics.Reporter, path string) (*journal, error) {
+	j := &journal{
+		path:    path,
+		m:       m,
+		entries: make(map[journalEntryKey]journalEntry),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []journalEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		m.Counter("titus.executor.launchGuard.journalCorrupt", 1, nil)
+		log.Error("Launchguard journal corrupt, starting with an empty one: ", err)
+		return j, nil
+	}
+	for _, entry := range entries {
+		j.entries[journalEntryKey{key: entry.Key, id: entry.ID}] = entry
+	}
+	return j, nil
+}
+
+// restorable returns the journaled cleanup events, oldest first, which are still within their maximum lifetime, and
+// have heartbeated within expiry. The rest are dropped from the journal.
+func (j *journal) restorable(now time.Time, maxLifetime, expiry time.Duration) []journalEntry {
+	if j == nil {
+		return nil
+	}
+	j.Lock()
+	defer j.Unlock()
+
+	entries := make([]journalEntry, 0, len(j.entries))
+	for k, entry := range j.entries {
+		if now.Sub(entry.StartedAt) >= maxLifetime || now.Sub(entry.LastHeartbeat) >= expiry {
+			log.WithField("key", entry.Key).WithField("id", entry.ID).Info("Dropping expired cleanup event from journal")
+			delete(j.entries, k)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, k int) bool {
+		return entries[i].StartedAt.Before(entries[k].StartedAt)
+	})
+	j.write()
+	return entries
+}
+
+func (j *journal) record(entry journalEntry) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.entries[journalEntryKey{key: entry.Key, id: entry.ID}] = entry
+	j.write()
+}
+
+func (j *journal) heartbeat(key, id string, now time.Time) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	k := journalEntryKey{key: key, id: id}
+	if entry, ok := j.entries[k]; ok {
+		entry.LastHeartbeat = now
+		j.entries[k] = entry
+		j.write()
+	}
+}
+
+func (j *journal) remove(key, id string) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	delete(j.entries, journalEntryKey{key: key, id: id})
+	j.write()
+}
+
+// write must be called with the journal locked. Failures are logged, the in-memory state carries on regardless.
+func (j *journal) write() {
+	entries := make([]journalEntry, 0, len(j.entries))
+	for _, entry := range j.entries {
+		entries = append(entries, entry)
+	}
+	data, err := json.Marshal(entries)
+	if err == nil {
+		err = atomicWrite(j.path, data)
+	}
+	if err != nil {
+		j.m.Counter("titus.executor.launchGuard.journalWriteError", 1, nil)
+		log.Error("Unable to write launchguard journal: ", err)
+	}
+}
+
+// atomicWrite writes the temporary file next to path, so the rename doesn't cross filesystems
+func atomicWrite(path string, data []byte) error {
+	tempfile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err = tempfile.Write(data); err != nil {
+		_ = tempfile.Close()
+		_ = os.Remove(tempfile.Name())
+		return err
+	}
+	if err = tempfile.Close(); err != nil {
+		_ = os.Remove(tempfile.Name())
+		return err
+	}
+	return os.Rename(tempfile.Name(), path)
+}
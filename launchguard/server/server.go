@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"sync"
 	"time"
@@ -17,6 +18,10 @@ import (
 type cleanupRoutine struct {
 	once          sync.Once
 	heartbeatChan chan struct{}
+	expireChan    chan struct{}
+	startedAt     time.Time
+	// lastHeartbeat is protected by the launchGuardContainer's lock
+	lastHeartbeat time.Time
 }
 
 type launchGuardContainer struct {
@@ -31,6 +36,15 @@ type LaunchGuardServer struct {
 	router       *mux.Router
 	m            metrics.Reporter
 	launchguards map[string]*launchGuardContainer
+	journal      *journal
+}
+
+// QueuedEvent is an event waiting in a launch guard's queue, as it's listed by the server. Cleanup events which are
+// still heartbeating have their start, and last heartbeat times.
+type QueuedEvent struct {
+	core.QueuedEvent
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	LastHeartbeat *time.Time `json:"lastHeartbeat,omitempty"`
 }
 
 // NewLaunchGuardServer is a mechanism by which to instantiate LaunchGuardServer state
@@ -41,14 +55,45 @@ func NewLaunchGuardServer(m metrics.Reporter) *LaunchGuardServer {
 	}
 	lgs.router = mux.NewRouter()
 	//
+	lgs.router.HandleFunc("/launchguard", lgs.listLaunchGuards).Methods("GET")
+	lgs.router.HandleFunc("/launchguard/{key}", lgs.listLaunchGuard).Methods("GET")
 	lgs.router.HandleFunc("/launchguard/{key}/launchevent", lgs.newLaunchEvent).Methods("GET")
 	lgs.router.HandleFunc("/launchguard/{key}/cleanupevent/{id}", lgs.newCleanupEvent).Methods("PUT")
 	lgs.router.HandleFunc("/launchguard/{key}/cleanupevent/{id}/heartbeat", lgs.heartBeatCleanupEvent).Methods("POST")
 	lgs.router.HandleFunc("/launchguard/{key}/cleanupevent/{id}", lgs.removeCleanupEvent).Methods("DELETE")
+	lgs.router.HandleFunc("/launchguard/{key}/cleanupevent/{id}/expire", lgs.expireCleanupEvent).Methods("POST")
 
 	return lgs
 }
 
+// NewPersistentLaunchGuardServer is like NewLaunchGuardServer, but cleanup events are journaled to journalPath. The
+// cleanup events in the journal from a previous run are restored, in the order they were started, if they're within
+// client.MaxLaunchTime of being started, and client.RestartGracePeriod of their last heartbeat. They expire if they
+// don't resume heartbeating within the rest of that grace period.
+func NewPersistentLaunchGuardServer(m metrics.Reporter, journalPath string) (*LaunchGuardServer, error) {
+	j, err := loadJournal(m, journalPath)
+	if err != nil {
+		return nil, err
+	}
+	lgs := NewLaunchGuardServer(m)
+	lgs.journal = j
+
+	now := time.Now()
+	entries := j.restorable(now, client.MaxLaunchTime, client.RestartGracePeriod)
+	for _, entry := range entries {
+		log.WithField("key", entry.Key).WithField("id", entry.ID).Info("Restoring cleanup event from journal")
+		lgc := lgs.getLaunchGuardContainer(entry.Key)
+		lgc.Lock()
+		cr := newCleanupRoutine(lgs, lgc, entry.Key, entry.ID, entry.StartedAt, client.RestartGracePeriod-now.Sub(entry.LastHeartbeat))
+		cr.lastHeartbeat = entry.LastHeartbeat
+		lgc.cleanupEvents[entry.ID] = cr
+		lgc.Unlock()
+	}
+	m.Counter("titus.executor.launchGuard.restoredCleanupEvents", len(entries), nil)
+
+	return lgs, nil
+}
+
 func (lgs *LaunchGuardServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	id := uuid.New()
 	log.WithField("id", id).WithField("url", req.URL).WithField("method", req.Method).Debug("Starting")
@@ -69,6 +114,62 @@ func (lgs *LaunchGuardServer) getLaunchGuardContainer(key string) *launchGuardCo
 	return lgs.launchguards[key]
 }
 
+func (lgs *LaunchGuardServer) listLaunchGuards(resp http.ResponseWriter, req *http.Request) {
+	lgs.Lock()
+	keys := make([]string, 0, len(lgs.launchguards))
+	for key := range lgs.launchguards {
+		keys = append(keys, key)
+	}
+	lgs.Unlock()
+
+	queues := make(map[string][]QueuedEvent, len(keys))
+	for _, key := range keys {
+		queues[key] = lgs.queuedEvents(key)
+	}
+	writeJSON(resp, queues)
+}
+
+func (lgs *LaunchGuardServer) listLaunchGuard(resp http.ResponseWriter, req *http.Request) {
+	key := mux.Vars(req)["key"]
+	lgs.Lock()
+	_, ok := lgs.launchguards[key]
+	lgs.Unlock()
+	if !ok {
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(resp, lgs.queuedEvents(key))
+}
+
+func (lgs *LaunchGuardServer) queuedEvents(key string) []QueuedEvent {
+	lgc := lgs.getLaunchGuardContainer(key)
+	coreEvents := lgc.lg.QueuedEvents()
+
+	lgc.RLock()
+	defer lgc.RUnlock()
+	queuedEvents := make([]QueuedEvent, len(coreEvents))
+	for idx, coreEvent := range coreEvents {
+		queuedEvents[idx].QueuedEvent = coreEvent
+		if coreEvent.ID == "" {
+			continue
+		}
+		if cr, ok := lgc.cleanupEvents[coreEvent.ID]; ok {
+			startedAt, lastHeartbeat := cr.startedAt, cr.lastHeartbeat
+			queuedEvents[idx].StartedAt = &startedAt
+			queuedEvents[idx].LastHeartbeat = &lastHeartbeat
+		}
+	}
+	return queuedEvents
+}
+
+func writeJSON(resp http.ResponseWriter, val interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(resp).Encode(val); err != nil {
+		log.Error("Cannot write: ", err)
+	}
+}
+
 func (lgs *LaunchGuardServer) newLaunchEvent(resp http.ResponseWriter, req *http.Request) {
 	lgc := lgs.getLaunchGuardContainer(mux.Vars(req)["key"])
 
@@ -77,37 +178,45 @@ func (lgs *LaunchGuardServer) newLaunchEvent(resp http.ResponseWriter, req *http
 	resp.WriteHeader(http.StatusOK)
 	resp.(http.Flusher).Flush()
 
+	// The result is written once the launch is given clearance, the status has already gone out so the client knows it's
+	// been queued
+	var result core.LaunchResult
 	select {
 	case <-launchEvent.Launch():
-		_, err := resp.Write([]byte("launch"))
-		if err != nil {
-			log.Error("Cannot write: ", err)
-		}
+		result = launchEvent.Result()
 	case <-req.Context().Done():
-		_, err := resp.Write([]byte("timeout"))
-		if err != nil {
-			log.Error("Cannot write: ", err)
-		}
+		result = core.LaunchResult{Reason: core.LaunchCancelled}
+	}
+	if err := json.NewEncoder(resp).Encode(result); err != nil {
+		log.Error("Cannot write: ", err)
 	}
 }
 
 func (lgs *LaunchGuardServer) newCleanupEvent(resp http.ResponseWriter, req *http.Request) {
-	lgc := lgs.getLaunchGuardContainer(mux.Vars(req)["key"])
+	key := mux.Vars(req)["key"]
+	lgc := lgs.getLaunchGuardContainer(key)
 	lgc.Lock()
 	defer lgc.Unlock()
 	id := mux.Vars(req)["id"]
-	lgc.cleanupEvents[id] = newCleanupRoutine(lgc, id)
+	now := time.Now()
+	lgs.journal.record(journalEntry{Key: key, ID: id, StartedAt: now, LastHeartbeat: now})
+	cr := newCleanupRoutine(lgs, lgc, key, id, now, client.RefreshWindow*3)
+	cr.lastHeartbeat = now
+	lgc.cleanupEvents[id] = cr
 	resp.WriteHeader(http.StatusCreated)
 
 }
 
 func (lgs *LaunchGuardServer) heartBeatCleanupEvent(resp http.ResponseWriter, req *http.Request) {
-	lgc := lgs.getLaunchGuardContainer(mux.Vars(req)["key"])
+	key := mux.Vars(req)["key"]
+	lgc := lgs.getLaunchGuardContainer(key)
 	lgc.Lock()
 	defer lgc.Unlock()
 	id := mux.Vars(req)["id"]
 	if myCleanupRoutine, ok := lgc.cleanupEvents[id]; ok {
 		myCleanupRoutine.heartBeat()
+		myCleanupRoutine.lastHeartbeat = time.Now()
+		lgs.journal.heartbeat(key, id, myCleanupRoutine.lastHeartbeat)
 		resp.WriteHeader(http.StatusAccepted)
 	} else {
 		resp.WriteHeader(http.StatusNotFound)
@@ -129,12 +238,33 @@ func (lgs *LaunchGuardServer) removeCleanupEvent(resp http.ResponseWriter, req *
 	}
 }
 
-func newCleanupRoutine(lgc *launchGuardContainer, id string) *cleanupRoutine {
+// expireCleanupEvent is like removeCleanupEvent, but the launches queued behind the cleanup event are told it was
+// abandoned, rather than finished
+func (lgs *LaunchGuardServer) expireCleanupEvent(resp http.ResponseWriter, req *http.Request) {
+	lgc := lgs.getLaunchGuardContainer(mux.Vars(req)["key"])
+	lgc.Lock()
+	defer lgc.Unlock()
+	id := mux.Vars(req)["id"]
+	if myCleanupRoutine, ok := lgc.cleanupEvents[id]; ok {
+		myCleanupRoutine.once.Do(func() {
+			close(myCleanupRoutine.expireChan)
+		})
+		resp.WriteHeader(http.StatusOK)
+	} else {
+		resp.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newCleanupRoutine must be called with the launchGuardContainer locked. The cleanup event expires if it isn't
+// heartbeated within firstExpiry, and is given up on client.MaxLaunchTime after startedAt.
+func newCleanupRoutine(lgs *LaunchGuardServer, lgc *launchGuardContainer, key, id string, startedAt time.Time, firstExpiry time.Duration) *cleanupRoutine {
 	cr := &cleanupRoutine{
 		heartbeatChan: make(chan struct{}),
+		expireChan:    make(chan struct{}),
+		startedAt:     startedAt,
 	}
 	waitCh := make(chan struct{})
-	go cr.run(lgc, id, waitCh)
+	go cr.run(lgs, lgc, key, id, firstExpiry, waitCh)
 	<-waitCh
 	return cr
 }
@@ -147,28 +277,37 @@ func (cr *cleanupRoutine) heartBeat() {
 	}
 }
 
-func (cr *cleanupRoutine) run(lgc *launchGuardContainer, id string, waitCh chan struct{}) {
+func (cr *cleanupRoutine) run(lgs *LaunchGuardServer, lgc *launchGuardContainer, key, id string, firstExpiry time.Duration, waitCh chan struct{}) {
 	log.WithField("id", id).Info("Cleanup event initializing")
 	defer log.WithField("id", id).Info("Cleanup event cleaning up")
 
-	ctx, cancel := context.WithTimeout(context.Background(), client.MaxLaunchTime)
+	ctx, cancel := context.WithDeadline(context.Background(), cr.startedAt.Add(client.MaxLaunchTime))
 	defer cancel()
-	cleanupEvent := core.NewRealCleanUpEvent(ctx, lgc.lg)
+	cleanupEvent := core.NewRealCleanUpEventWithID(ctx, lgc.lg, id, cr.startedAt)
 	defer cleanupEvent.Done()
 	close(waitCh)
 
-	timer := time.NewTimer(client.RefreshWindow * 3)
+	timer := time.NewTimer(firstExpiry)
 	defer timer.Stop()
 	defer func() {
 		lgc.Lock()
 		defer lgc.Unlock()
-		delete(lgc.cleanupEvents, id)
+		// The ID may have been reused by a newer cleanup event
+		if lgc.cleanupEvents[id] == cr {
+			delete(lgc.cleanupEvents, id)
+			lgs.journal.remove(key, id)
+		}
 	}()
 
 	for {
 		select {
 		case <-timer.C:
 			log.WithField("id", id).Warning("Launchguard cleanup expired")
+			cleanupEvent.Expire()
+			return
+		case <-cr.expireChan:
+			log.WithField("id", id).Warning("Launchguard cleanup expired by request")
+			cleanupEvent.Expire()
 			return
 		case _, ok := <-cr.heartbeatChan:
 			if !ok {
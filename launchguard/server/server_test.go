@@ -2,13 +2,22 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/Netflix/metrics-client-go/metrics"
 	"github.com/Netflix/titus-executor/launchguard/client"
+	"github.com/Netflix/titus-executor/launchguard/core"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func init() {
@@ -78,3 +87,209 @@ func TestBasicOrdering(t *testing.T) {
 	ce9.Done()
 
 }
+
+func TestLaunchResult(t *testing.T) {
+	server := httptest.NewServer(NewLaunchGuardServer(metrics.Discard))
+
+	c, err := client.NewLaunchGuardClient(metrics.Discard, server.URL)
+	require.NoError(t, err)
+	ce := c.NewRealCleanUpEvent(context.TODO(), "test")
+	le := c.NewLaunchEvent(context.TODO(), "test")
+	queuedEvents := getQueuedEvents(t, server.URL+"/launchguard/test")
+	require.Len(t, queuedEvents, 2)
+	ce.Done()
+	<-le.Launch()
+	result := le.Result()
+	assert.Equal(t, core.LaunchCleared, result.Reason)
+	require.Len(t, result.BlockedBy, 1)
+	assert.Equal(t, queuedEvents[0].ID, result.BlockedBy[0].ID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ce = c.NewRealCleanUpEvent(context.TODO(), "test")
+	le = c.NewLaunchEvent(ctx, "test")
+	cancel()
+	<-le.Launch()
+	assert.Equal(t, core.LaunchCancelled, le.Result().Reason)
+	ce.Done()
+
+	// The cleanup event is expired, rather than done
+	ce = c.NewRealCleanUpEvent(context.TODO(), "test")
+	le = c.NewLaunchEvent(context.TODO(), "test")
+	queuedEvents = getQueuedEvents(t, server.URL+"/launchguard/test")
+	require.Len(t, queuedEvents, 2)
+	resp, err := http.Post(server.URL+"/launchguard/test/cleanupevent/"+queuedEvents[0].ID+"/expire", "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, resp.Body.Close())
+	<-le.Launch()
+	assert.Equal(t, core.LaunchExpired, le.Result().Reason)
+	ce.Done()
+
+	// Nothing's listening anymore
+	server.Close()
+	le = c.NewLaunchEvent(context.TODO(), "test")
+	<-le.Launch()
+	assert.Equal(t, core.LaunchServerError, le.Result().Reason)
+	assert.NotEmpty(t, le.Result().Error)
+}
+
+func getQueuedEvents(t *testing.T, url string) []QueuedEvent {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint: errcheck
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var queuedEvents []QueuedEvent
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&queuedEvents))
+	return queuedEvents
+}
+
+func TestListLaunchGuard(t *testing.T) {
+	server := httptest.NewServer(NewLaunchGuardServer(metrics.Discard))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/launchguard/test")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, resp.Body.Close())
+
+	c, err := client.NewLaunchGuardClient(metrics.Discard, server.URL)
+	require.NoError(t, err)
+	ce := c.NewRealCleanUpEvent(context.TODO(), "test")
+	le := c.NewLaunchEvent(context.TODO(), "test")
+
+	queuedEvents := getQueuedEvents(t, server.URL+"/launchguard/test")
+	require.Len(t, queuedEvents, 2)
+	assert.Equal(t, core.CleanUpEventType, queuedEvents[0].Type)
+	assert.NotEmpty(t, queuedEvents[0].ID)
+	assert.NotNil(t, queuedEvents[0].StartedAt)
+	assert.NotNil(t, queuedEvents[0].LastHeartbeat)
+	assert.Equal(t, core.LaunchEventType, queuedEvents[1].Type)
+
+	resp, err = http.Get(server.URL + "/launchguard")
+	require.NoError(t, err)
+	var queues map[string][]QueuedEvent
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&queues))
+	assert.NoError(t, resp.Body.Close())
+	assert.Len(t, queues["test"], 2)
+
+	ce.Done()
+	<-le.Launch()
+}
+
+func TestRestoreFromJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "launchguard-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	journalPath := filepath.Join(dir, "journal.json")
+
+	now := time.Now()
+	data, err := json.Marshal([]journalEntry{
+		{Key: "test", ID: "newer", StartedAt: now.Add(-time.Second), LastHeartbeat: now},
+		{Key: "test", ID: "older", StartedAt: now.Add(-time.Minute), LastHeartbeat: now},
+		{Key: "test", ID: "stale", StartedAt: now.Add(-time.Minute), LastHeartbeat: now.Add(-client.RestartGracePeriod)},
+		{Key: "test", ID: "ancient", StartedAt: now.Add(-client.MaxLaunchTime), LastHeartbeat: now},
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(journalPath, data, 0644))
+
+	lgs, err := NewPersistentLaunchGuardServer(metrics.Discard, journalPath)
+	require.NoError(t, err)
+	server := httptest.NewServer(lgs)
+	defer server.Close()
+
+	// Only the cleanup events which haven't expired come back, in the order they were started
+	queuedEvents := getQueuedEvents(t, server.URL+"/launchguard/test")
+	require.Len(t, queuedEvents, 2)
+	assert.Equal(t, "older", queuedEvents[0].ID)
+	assert.Equal(t, "newer", queuedEvents[1].ID)
+	// They keep the age they had before the restart
+	assert.WithinDuration(t, now.Add(-time.Minute), queuedEvents[0].CreatedAt, time.Millisecond)
+	assert.WithinDuration(t, now.Add(-time.Second), queuedEvents[1].CreatedAt, time.Millisecond)
+
+	c, err := client.NewLaunchGuardClient(metrics.Discard, server.URL)
+	require.NoError(t, err)
+	le := c.NewLaunchEvent(context.TODO(), "test")
+	ensureChannelNotClosed(t, le.Launch())
+
+	for _, id := range []string{"older", "newer"} {
+		req, err := http.NewRequest("DELETE", server.URL+"/launchguard/test/cleanupevent/"+id, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NoError(t, resp.Body.Close())
+	}
+	<-le.Launch()
+
+	// Removed cleanup events are dropped from the journal
+	j, err := loadJournal(metrics.Discard, journalPath)
+	require.NoError(t, err)
+	assert.Empty(t, j.entries)
+}
+
+func TestJournalCleanupEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "launchguard-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	journalPath := filepath.Join(dir, "journal.json")
+
+	lgs, err := NewPersistentLaunchGuardServer(metrics.Discard, journalPath)
+	require.NoError(t, err)
+	server := httptest.NewServer(lgs)
+	defer server.Close()
+
+	c, err := client.NewLaunchGuardClient(metrics.Discard, server.URL)
+	require.NoError(t, err)
+	ce := c.NewRealCleanUpEvent(context.TODO(), "test")
+
+	j, err := loadJournal(metrics.Discard, journalPath)
+	require.NoError(t, err)
+	require.Len(t, j.entries, 1)
+	for k, entry := range j.entries {
+		assert.Equal(t, "test", k.key)
+		assert.Equal(t, entry.StartedAt, entry.LastHeartbeat)
+	}
+	ce.Done()
+}
+
+func TestRestartWithQueuedLaunch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "launchguard-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	journalPath := filepath.Join(dir, "journal.json")
+
+	serve := func(addr string) (*http.Server, string) {
+		lgs, err := NewPersistentLaunchGuardServer(metrics.Discard, journalPath)
+		require.NoError(t, err)
+		l, err := net.Listen("tcp", addr)
+		require.NoError(t, err)
+		server := &http.Server{Handler: lgs}
+		go server.Serve(l) // nolint: errcheck
+		return server, l.Addr().String()
+	}
+	server, addr := serve("127.0.0.1:0")
+
+	c, err := client.NewLaunchGuardClient(metrics.Discard, "http://"+addr)
+	require.NoError(t, err)
+	ce := c.NewRealCleanUpEvent(context.TODO(), "test")
+	le := c.NewLaunchEvent(context.TODO(), "test")
+
+	// The launch is queued again behind the restored cleanup event, rather than being given clearance
+	require.NoError(t, server.Close())
+	server, _ = serve(addr)
+	defer server.Close() // nolint: errcheck
+	for start := time.Now(); ; time.Sleep(100 * time.Millisecond) {
+		queuedEvents := getQueuedEvents(t, "http://"+addr+"/launchguard/test")
+		if len(queuedEvents) == 2 {
+			assert.Equal(t, core.CleanUpEventType, queuedEvents[0].Type)
+			assert.Equal(t, core.LaunchEventType, queuedEvents[1].Type)
+			break
+		}
+		require.True(t, time.Since(start) < client.RestartGracePeriod, "launch event wasn't queued again")
+	}
+	ensureChannelNotClosed(t, le.Launch())
+
+	ce.Done()
+	<-le.Launch()
+	assert.Equal(t, core.LaunchCleared, le.Result().Reason)
+}
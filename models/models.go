@@ -1,5 +1,9 @@
 package models
 
+import (
+	"time"
+)
+
 // CurrentState data structure that is exposed in get-current-state endpoint
 type CurrentState struct {
 	Tasks map[string]string
@@ -8,6 +12,10 @@ type CurrentState struct {
 const (
 	// ExecutorPidLabel is the executor's os.Getpid()
 	ExecutorPidLabel = "titus.executor.pid"
+	// ExecutorPidStartTimeLabel is the time the executor process started after boot, in clock ticks, so the PID can't be mistaken for a reused one
+	ExecutorPidStartTimeLabel = "titus.executor.pid.starttime"
+	// ExecutorProcessNameLabel is the base name of the executor's argv[0]
+	ExecutorProcessNameLabel = "titus.executor.process.name"
 	// ExecutorHTTPListenerAddressLabel is the IP:Port that the ephemeral HTTP listener is working on
 	ExecutorHTTPListenerAddressLabel = "titus.executor.http.listener.address"
 	// TaskIDLabel is the The canonical TASK ID
@@ -15,3 +23,68 @@ const (
 	// NetworkContainerIDLabel is the container ID of the network pod
 	NetworkContainerIDLabel = "titus.network_container_id"
 )
+
+// TaskStatus is a status update that the executor sent for a task
+type TaskStatus struct {
+	State     string    `json:"state"`
+	Message   string    `json:"message"`
+	Healthy   *bool     `json:"healthy,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// TaskResources are the resources allocated to a task
+type TaskResources struct {
+	// Mem is in MiB
+	Mem       int64    `json:"mem"`
+	CPU       int64    `json:"cpu"`
+	Disk      uint64   `json:"disk"`
+	HostPorts []uint16 `json:"hostPorts,omitempty"`
+}
+
+// TaskPortMapping maps a port allocated to the task on the host to a port the container listens on
+type TaskPortMapping struct {
+	HostPort      uint16 `json:"hostPort"`
+	ContainerPort uint16 `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+}
+
+// TaskNetworkConfiguration is the network configuration that the executor reported for a task
+type TaskNetworkConfiguration struct {
+	IsRoutableIP   bool     `json:"isRoutableIP"`
+	IPAddress      string   `json:"ipAddress,omitempty"`
+	EniIPAddress   string   `json:"eniIPAddress,omitempty"`
+	EniIPv6Address string   `json:"eniIPv6Address,omitempty"`
+	EniID          string   `json:"eniID,omitempty"`
+	ResourceID     string   `json:"resourceID,omitempty"`
+	SecurityGroups []string `json:"securityGroups,omitempty"`
+}
+
+// TaskDetails are the details of a task's container which the executor reported once it was started
+type TaskDetails struct {
+	IPAddresses          map[string]string         `json:"ipAddresses,omitempty"`
+	NetworkConfiguration *TaskNetworkConfiguration `json:"networkConfiguration,omitempty"`
+	PortMappings         []TaskPortMapping         `json:"portMappings,omitempty"`
+}
+
+// TaskAllocation is the IP address, and ENI that the VPC driver allocated to a task
+type TaskAllocation struct {
+	IPV4Address string `json:"ipv4Address"`
+	IPV6Address string `json:"ipv6Address,omitempty"`
+	DeviceIndex int    `json:"deviceIndex"`
+	ENI         string `json:"eni"`
+	// SecurityGroups are the security groups of the ENI, sorted
+	SecurityGroups []string `json:"securityGroups,omitempty"`
+}
+
+// TaskState data structure that is exposed in the get-task-state endpoint
+type TaskState struct {
+	TaskID      string         `json:"taskId"`
+	ContainerID string         `json:"containerId,omitempty"`
+	State       string         `json:"state"`
+	Resources   *TaskResources `json:"resources,omitempty"`
+	Details     *TaskDetails   `json:"details,omitempty"`
+	Allocation  TaskAllocation `json:"allocation"`
+	GPUDevices  []string       `json:"gpuDevices,omitempty"`
+	// Updates are the most recent status updates sent for the task, oldest first
+	Updates []TaskStatus `json:"updates"`
+}
@@ -0,0 +1,142 @@
+// Package procinfo reads information about processes from procfs
+package procinfo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// These are the indexes of fields in /proc/[pid]/stat, after the comm field
+const (
+	parentPidField = 1
+	startTimeField = 19
+)
+
+// StartTime returns the time the process started after system boot, in clock ticks. Together with the PID, it
+// uniquely identifies a process, since PIDs can be reused.
+func StartTime(pid int) (uint64, error) {
+	stat, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+
+	return parseStartTime(string(stat))
+}
+
+func parseStartTime(stat string) (uint64, error) {
+	field, err := statField(stat, startTimeField)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(field, 10, 64)
+}
+
+// ParentPid returns the PID of the process's parent
+func ParentPid(pid int) (int, error) {
+	stat, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+
+	return parseParentPid(string(stat))
+}
+
+func parseParentPid(stat string) (int, error) {
+	field, err := statField(stat, parentPidField)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(field)
+}
+
+func statField(stat string, idx int) (string, error) {
+	// The comm field is in parentheses, and can contain spaces, or parentheses itself
+	commEnd := strings.LastIndex(stat, ")")
+	if commEnd == -1 {
+		return "", fmt.Errorf("Unable to parse process stat: %s", stat)
+	}
+	fields := strings.Fields(stat[commEnd+1:])
+	if len(fields) <= idx {
+		return "", fmt.Errorf("Unable to parse process stat, not enough fields: %s", stat)
+	}
+
+	return fields[idx], nil
+}
+
+// Cmdline returns the arguments the process was started with
+func Cmdline(pid int) ([]string, error) {
+	cmdline, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{}
+	for _, arg := range bytes.Split(bytes.TrimSuffix(cmdline, []byte{0}), []byte{0}) {
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
+
+// Flock is a flock(2) lock which is held on a file
+type Flock struct {
+	Pid       int
+	Exclusive bool
+	// Major, Minor, and Inode identify the locked file
+	Major uint32
+	Minor uint32
+	Inode uint64
+}
+
+// Flocks returns the flock(2) locks which are currently held on the system. Waiters for locks are not included.
+func Flocks() ([]Flock, error) {
+	locks, err := ioutil.ReadFile("/proc/locks")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFlocks(string(locks))
+}
+
+func parseFlocks(locks string) ([]Flock, error) {
+	ret := []Flock{}
+	for _, line := range strings.Split(locks, "\n") {
+		// 1: FLOCK  ADVISORY  WRITE 1234 00:13:5678 0 EOF
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[1] != "FLOCK" {
+			// This is either a different kind of lock, or a waiter (which has "->" as its second field)
+			continue
+		}
+		pid, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse lock pid: %s", line)
+		}
+		file := strings.Split(fields[5], ":")
+		if len(file) != 3 {
+			return nil, fmt.Errorf("Unable to parse locked file: %s", line)
+		}
+		major, err := strconv.ParseUint(file[0], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse locked file major: %s", line)
+		}
+		minor, err := strconv.ParseUint(file[1], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse locked file minor: %s", line)
+		}
+		inode, err := strconv.ParseUint(file[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse locked file inode: %s", line)
+		}
+		ret = append(ret, Flock{
+			Pid:       pid,
+			Exclusive: fields[3] == "WRITE",
+			Major:     uint32(major),
+			Minor:     uint32(minor),
+			Inode:     inode,
+		})
+	}
+	return ret, nil
+}
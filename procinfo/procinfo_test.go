@@ -0,0 +1,60 @@
+package procinfo
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStartTime(t *testing.T) {
+	stat := "1234 (my (weird) proc) S 1 1234 1234 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 987654 1000 100 18446744073709551615"
+	startTime, err := parseStartTime(stat)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(987654), startTime)
+
+	_, err = parseStartTime("1234 (short) S 1")
+	assert.Error(t, err)
+}
+
+func TestParseParentPid(t *testing.T) {
+	ppid, err := parseParentPid("1234 (my (weird) proc) S 42 1234 1234 0 -1")
+	require.NoError(t, err)
+	assert.Equal(t, 42, ppid)
+}
+
+func TestParseFlocks(t *testing.T) {
+	locks := `1: POSIX  ADVISORY  WRITE 999 08:01:100 0 EOF
+2: FLOCK  ADVISORY  WRITE 1234 00:2d:5678 0 EOF
+2: -> FLOCK  ADVISORY  WRITE 4321 00:2d:5678 0 EOF
+3: FLOCK  ADVISORY  READ 1235 fd:01:42 0 EOF
+`
+	flocks, err := parseFlocks(locks)
+	require.NoError(t, err)
+	assert.Equal(t, []Flock{
+		{Pid: 1234, Exclusive: true, Major: 0, Minor: 0x2d, Inode: 5678},
+		{Pid: 1235, Exclusive: false, Major: 0xfd, Minor: 1, Inode: 42},
+	}, flocks)
+
+	_, err = parseFlocks("1: FLOCK  ADVISORY  WRITE 1234 garbage 0 EOF")
+	assert.Error(t, err)
+}
+
+func TestOwnProcess(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("procfs is only available on Linux")
+	}
+	startTime, err := StartTime(os.Getpid())
+	require.NoError(t, err)
+	assert.NotEqual(t, uint64(0), startTime)
+
+	cmdline, err := Cmdline(os.Getpid())
+	require.NoError(t, err)
+	assert.Equal(t, os.Args, cmdline)
+
+	ppid, err := ParentPid(os.Getpid())
+	require.NoError(t, err)
+	assert.Equal(t, os.Getppid(), ppid)
+}
@@ -0,0 +1,475 @@
+package reaper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Netflix/titus-executor/procinfo"
+)
+
+const (
+	// leakGracePeriod is how old a resource has to be before it's considered leaked, so we don't race with executors
+	// which are still setting up their task
+	leakGracePeriod = 30 * time.Minute
+	// maxOrphanedLockCycles is how many reap cycles in a row a lock has to be held by a process outside of any
+	// executor, before the process is terminated
+	maxOrphanedLockCycles = 3
+	// defaultExecutorProcessName is the executor's name on hosts where the containers don't carry the label
+	defaultExecutorProcessName = "titus-executor"
+	// titusBinaryPrefix starts the names of all of our binaries
+	titusBinaryPrefix = "titus-"
+)
+
+// These are the kinds of resources that are garbage collected. They're also used in metric names.
+const (
+	titusInitsResource        = "titusInits"
+	titusEnvironmentsResource = "titusEnvironments"
+	tiniSocketsResource       = "tiniSockets"
+	passportsResource         = "passports"
+	cgroupsResource           = "cgroups"
+	ipLocksResource           = "ipLocks"
+	gpuLocksResource          = "gpuLocks"
+)
+
+// Leak is a resource on the host which outlived the task it belonged to
+type Leak struct {
+	Resource string `json:"resource"`
+	Path     string `json:"path"`
+	TaskID   string `json:"taskId,omitempty"`
+	// Pid is the orphaned process which holds a leaked lock
+	Pid     int    `json:"pid,omitempty"`
+	Removed bool   `json:"removed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HostPaths are where the executor and the VPC tool keep their per-task resources
+type HostPaths struct {
+	TitusInits        string
+	TitusEnvironments string
+	Passports         string
+	RuncBundles       string
+	// TaskLocks is the lock directory of the fslocker which executors hold their task's lock in
+	TaskLocks string
+	// CgroupMarkers is where executors record the cgroups of their tasks' containers
+	CgroupMarkers string
+	// These are globs
+	TiniSockets string
+	IPLocks     string
+	GPULocks    string
+}
+
+// DefaultHostPaths are where the executor and the VPC tool keep their per-task resources, unless configured otherwise
+var DefaultHostPaths = HostPaths{
+	TitusInits:        "/var/lib/titus-inits",
+	TitusEnvironments: "/var/lib/titus-environments",
+	Passports:         "/run/titus-client-passports",
+	RuncBundles:       "/run/titus-executor/bundles",
+	TaskLocks:         "/run/titus-executor/tasks/lockDir",
+	CgroupMarkers:     "/run/titus-executor/cgroups",
+	TiniSockets:       "/var/tmp/titus-executor-sockets*",
+	IPLocks:           "/run/titus-vpc-tool/fslocker/lockDir/interfaces/*/ip*-addresses/*",
+	GPULocks:          "/run/titus-executor-nvidia/lockDir/dev/*",
+}
+
+// fileID identifies a file the same way /proc/locks does
+type fileID struct {
+	major uint32
+	minor uint32
+	inode uint64
+}
+
+// liveTasks is what's known to be running on the host in this reap cycle
+type liveTasks struct {
+	taskIDs map[string]struct{}
+	// executorNames are the process names that executors run under
+	executorNames map[string]struct{}
+	// flocks are the flock(2) locks held on the host
+	flocks []procinfo.Flock
+}
+
+func newLiveTasks() *liveTasks {
+	return &liveTasks{
+		taskIDs:       make(map[string]struct{}),
+		executorNames: map[string]struct{}{defaultExecutorProcessName: {}},
+	}
+}
+
+func (lt *liveTasks) has(taskID string) bool {
+	_, ok := lt.taskIDs[taskID]
+	return ok
+}
+
+// addRuncBundles adds the tasks which are run by the runc runtime. They don't have Docker containers, but they have
+// a bundle for as long as they're running.
+func (lt *liveTasks) addRuncBundles(dir string) error {
+	names, err := readDirNames(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		lt.taskIDs[name] = struct{}{}
+	}
+	return nil
+}
+
+// addLockedTasks adds the tasks whose executor holds their task lock. Executors take the lock before they set up
+// anything for the task, and hold it until they've cleaned up, so this covers tasks which don't have a container yet.
+func (lt *liveTasks) addLockedTasks(dir string) error {
+	names, err := readDirNames(dir)
+	if err != nil {
+		return err
+	}
+	held := make(map[fileID]struct{}, len(lt.flocks))
+	for _, flock := range lt.flocks {
+		held[fileID{major: flock.Major, minor: flock.Minor, inode: flock.Inode}] = struct{}{}
+	}
+	for _, name := range names {
+		// The lock can be released, and removed while we're looking at it
+		id, err := lockFileID(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if _, ok := held[id]; ok {
+			lt.taskIDs[name] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// collectGarbage finds the per-task resources that the executors failed to clean up, and removes them
+func (reaper *Reaper) collectGarbage(live *liveTasks) []Leak {
+	leaks := []Leak{}
+	leaks = append(leaks, reaper.gcTaskEntries(titusInitsResource, reaper.paths.TitusInits, live, taskIDFromName, unmountTitusInit)...)
+	leaks = append(leaks, reaper.gcTaskEntries(titusEnvironmentsResource, reaper.paths.TitusEnvironments, live, taskIDFromEnvironment, os.Remove)...)
+	leaks = append(leaks, reaper.gcTaskEntries(passportsResource, reaper.paths.Passports, live, taskIDFromName, os.RemoveAll)...)
+	leaks = append(leaks, reaper.gcTiniSockets(live)...)
+	leaks = append(leaks, reaper.gcCgroups(live)...)
+
+	orphanedLocks := reaper.orphanedLocks
+	reaper.orphanedLocks = make(map[string]int)
+	leaks = append(leaks, reaper.gcLocks(ipLocksResource, reaper.paths.IPLocks, live, orphanedLocks)...)
+	leaks = append(leaks, reaper.gcLocks(gpuLocksResource, reaper.paths.GPULocks, live, orphanedLocks)...)
+
+	return leaks
+}
+
+func taskIDFromName(name string) string {
+	return name
+}
+
+// taskIDFromEnvironment maps $titusEnvironments/$taskID.{json,env} to the task ID
+func taskIDFromEnvironment(name string) string {
+	switch ext := filepath.Ext(name); ext {
+	case ".json", ".env":
+		return strings.TrimSuffix(name, ext)
+	default:
+		return ""
+	}
+}
+
+func taskIDFromSocket(name string) string {
+	if !strings.HasSuffix(name, ".socket") {
+		return ""
+	}
+	return strings.TrimSuffix(name, ".socket")
+}
+
+// gcTaskEntries removes the entries in dir which belong to tasks that aren't running anymore
+func (reaper *Reaper) gcTaskEntries(resource, dir string, live *liveTasks, taskIDOf func(string) string, remove func(string) error) []Leak {
+	names, err := readDirNames(dir)
+	if err != nil {
+		reaper.log.WithField("resource", resource).Warning("Unable to list resources: ", err)
+		return nil
+	}
+
+	leaks := []Leak{}
+	for _, name := range names {
+		taskID := taskIDOf(name)
+		if taskID == "" || strings.HasPrefix(name, ".") || live.has(taskID) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		// The stat can fail for a titusInits bind mount whose process is gone. It's certainly leaked then.
+		if fi, err := os.Lstat(path); err == nil && time.Since(fi.ModTime()) < leakGracePeriod {
+			continue
+		}
+		leak := Leak{Resource: resource, Path: path, TaskID: taskID}
+		reaper.removeLeak(&leak, func() error { return remove(path) })
+		leaks = append(leaks, leak)
+	}
+	return leaks
+}
+
+// gcTiniSockets removes the sockets of tasks which aren't running anymore. Every executor has its own socket
+// directory, which is removed once its socket is gone too.
+func (reaper *Reaper) gcTiniSockets(live *liveTasks) []Leak {
+	dirs, err := filepath.Glob(reaper.paths.TiniSockets)
+	if err != nil {
+		reaper.log.WithField("resource", tiniSocketsResource).Warning("Unable to list resources: ", err)
+		return nil
+	}
+
+	leaks := []Leak{}
+	for _, dir := range dirs {
+		dirLeaks := reaper.gcTaskEntries(tiniSocketsResource, dir, live, taskIDFromSocket, os.Remove)
+		leaks = append(leaks, dirLeaks...)
+		if len(dirLeaks) == 0 || reaper.dryRun {
+			continue
+		}
+		// A directory without any sockets in it can belong to an executor which hasn't started its container yet,
+		// so it's only removed if it held a leaked socket
+		if names, err := readDirNames(dir); err == nil && len(names) == 0 {
+			if err = os.Remove(dir); err != nil {
+				reaper.log.WithField("dir", dir).Warning("Unable to remove tini socket directory: ", err)
+			}
+		}
+	}
+	return leaks
+}
+
+// gcCgroups removes the cgroups which executors recorded for their tasks' containers, once the task is gone. Only the
+// recorded cgroups, and their children are removed, and only if they don't have any processes in them.
+func (reaper *Reaper) gcCgroups(live *liveTasks) []Leak {
+	l := reaper.log.WithField("resource", cgroupsResource)
+	names, err := readDirNames(reaper.paths.CgroupMarkers)
+	if err != nil {
+		l.Warning("Unable to list cgroup markers: ", err)
+		return nil
+	}
+
+	var mountpoints []string
+	leaks := []Leak{}
+	for _, taskID := range names {
+		if strings.HasPrefix(taskID, ".") || live.has(taskID) {
+			continue
+		}
+		marker := filepath.Join(reaper.paths.CgroupMarkers, taskID)
+		if fi, err := os.Lstat(marker); err != nil || time.Since(fi.ModTime()) < leakGracePeriod {
+			continue
+		}
+		cgroup, err := readCgroupMarker(marker)
+		if err != nil {
+			l.WithField("marker", marker).Warning("Invalid cgroup marker: ", err)
+			continue
+		}
+		if mountpoints == nil {
+			if mountpoints, err = reaper.cgroupMountpoints(); err != nil {
+				l.Warning("Unable to list cgroup mounts: ", err)
+				return leaks
+			}
+		}
+
+		// The same cgroup shows up in every hierarchy
+		dirs := []string{}
+		for _, mountpoint := range mountpoints {
+			dir := filepath.Join(mountpoint, cgroup)
+			if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+				dirs = append(dirs, dir)
+			}
+		}
+		if hasProcesses, err := cgroupsHaveProcesses(dirs); err != nil || hasProcesses {
+			l.WithField("cgroup", cgroup).WithField("taskID", taskID).Warning("Task is gone, but its cgroup isn't empty")
+			continue
+		}
+
+		leak := Leak{Resource: cgroupsResource, Path: cgroup, TaskID: taskID}
+		reaper.removeLeak(&leak, func() error {
+			for _, dir := range dirs {
+				if err := removeCgroup(dir); err != nil {
+					return err
+				}
+			}
+			return os.Remove(marker)
+		})
+		leaks = append(leaks, leak)
+	}
+	return leaks
+}
+
+// readCgroupMarker reads the cgroup that an executor recorded. It has to be an absolute, clean path below the root
+// cgroup, so a bad marker can't make us remove anything outside of the cgroup hierarchies.
+func readCgroupMarker(marker string) (string, error) {
+	data, err := ioutil.ReadFile(marker) // nolint: gosec
+	if err != nil {
+		return "", err
+	}
+	cgroup := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(cgroup) || filepath.Clean(cgroup) != cgroup || cgroup == "/" {
+		return "", fmt.Errorf("%q is not a cgroup below the root", cgroup)
+	}
+	return cgroup, nil
+}
+
+func cgroupsHaveProcesses(dirs []string) (bool, error) {
+	for _, dir := range dirs {
+		if hasProcesses, err := cgroupHasProcesses(dir); err != nil || hasProcesses {
+			return hasProcesses, err
+		}
+	}
+	return false, nil
+}
+
+func cgroupHasProcesses(dir string) (bool, error) {
+	hasProcesses := false
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || fi.Name() != "cgroup.procs" {
+			return nil
+		}
+		procs, err := ioutil.ReadFile(path) // nolint: gosec
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(procs)) != "" {
+			hasProcesses = true
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return hasProcesses, err
+}
+
+// removeCgroup removes a cgroup, and its children. Cgroups are removed with rmdir, and only once they're empty.
+func removeCgroup(dir string) error {
+	names, err := readDirNames(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		child := filepath.Join(dir, name)
+		if fi, err := os.Lstat(child); err == nil && fi.IsDir() {
+			if err = removeCgroup(child); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Remove(dir)
+}
+
+// gcLocks terminates titus processes which hold fslocker locks, and aren't part of any executor. This happens when an
+// executor dies, and leaves behind its children, like titus-vpc-tool allocate-network. Locks held by other processes
+// are only reported, as they may be something the reaper doesn't know about.
+func (reaper *Reaper) gcLocks(resource, pattern string, live *liveTasks, orphanedLocks map[string]int) []Leak {
+	paths, err := filepath.Glob(pattern)
+	if err != nil || len(paths) == 0 {
+		return nil
+	}
+	l := reaper.log.WithField("resource", resource)
+
+	lockPaths := make(map[fileID]string, len(paths))
+	for _, path := range paths {
+		id, err := lockFileID(path)
+		if err != nil {
+			l.WithField("path", path).Warning("Unable to stat lock: ", err)
+			continue
+		}
+		lockPaths[id] = path
+	}
+
+	leaks := []Leak{}
+	for _, flock := range live.flocks {
+		path, ok := lockPaths[fileID{major: flock.Major, minor: flock.Minor, inode: flock.Inode}]
+		if !ok {
+			continue
+		}
+		orphaned, err := isOrphaned(flock.Pid, live)
+		if err != nil || !orphaned {
+			continue
+		}
+		startTime, err := procinfo.StartTime(flock.Pid)
+		if err != nil {
+			continue
+		}
+
+		key := fmt.Sprintf("%s:%d:%d", path, flock.Pid, startTime)
+		cycles := orphanedLocks[key] + 1
+		reaper.orphanedLocks[key] = cycles
+		if cycles < maxOrphanedLockCycles {
+			l.WithField("path", path).WithField("pid", flock.Pid).Debug("Lock is held by an orphaned process")
+			continue
+		}
+
+		leak := Leak{Resource: resource, Path: path, Pid: flock.Pid}
+		if isTitusProcess(flock.Pid) {
+			reaper.removeLeak(&leak, func() error {
+				return syscall.Kill(flock.Pid, syscall.SIGTERM)
+			})
+		} else {
+			reaper.reportLeak(&leak, "held by pid %d, which isn't a titus process", flock.Pid)
+		}
+		leaks = append(leaks, leak)
+	}
+	return leaks
+}
+
+// isOrphaned checks that a process is neither an executor, nor descended from one
+func isOrphaned(pid int, live *liveTasks) (bool, error) {
+	// This is bounded, in case a PID gets reused for a process' ancestor while we're walking the tree
+	for i := 0; i < 100 && pid > 1; i++ {
+		cmdline, err := procinfo.Cmdline(pid)
+		if err != nil {
+			return false, err
+		}
+		if len(cmdline) > 0 {
+			if _, ok := live.executorNames[filepath.Base(cmdline[0])]; ok {
+				return false, nil
+			}
+		}
+		if pid, err = procinfo.ParentPid(pid); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// isTitusProcess checks that a process is running one of our binaries, which are all named titus-*
+func isTitusProcess(pid int) bool {
+	cmdline, err := procinfo.Cmdline(pid)
+	return err == nil && len(cmdline) > 0 && strings.HasPrefix(filepath.Base(cmdline[0]), titusBinaryPrefix)
+}
+
+// reportLeak records a leaked resource which the reaper won't remove by itself
+func (reaper *Reaper) reportLeak(leak *Leak, format string, args ...interface{}) {
+	leak.Error = fmt.Sprintf(format, args...)
+	reaper.log.WithField("resource", leak.Resource).WithField("path", leak.Path).WithField("taskID", leak.TaskID).Warning("Not removing leaked resource: ", leak.Error)
+	reaper.reporter.Counter("titusAgent.gc."+leak.Resource+".leaked", 1, nil)
+}
+
+func (reaper *Reaper) removeLeak(leak *Leak, remove func() error) {
+	l := reaper.log.WithField("resource", leak.Resource).WithField("path", leak.Path).WithField("taskID", leak.TaskID)
+	metricPrefix := "titusAgent.gc." + leak.Resource
+	reaper.reporter.Counter(metricPrefix+".leaked", 1, nil)
+	if reaper.dryRun {
+		l.Info("Would remove leaked resource, but running in dry-run mode")
+		return
+	}
+
+	if err := remove(); err != nil {
+		l.Warning("Unable to remove leaked resource: ", err)
+		leak.Error = err.Error()
+		reaper.reporter.Counter(metricPrefix+".errors", 1, nil)
+		return
+	}
+	l.Info("Removed leaked resource")
+	leak.Removed = true
+	reaper.reporter.Counter(metricPrefix+".removed", 1, nil)
+}
+
+// readDirNames lists a directory, without stat'ing its entries, since some of them may be dead bind mounts
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer shouldClose(f)
+	return f.Readdirnames(-1)
+}
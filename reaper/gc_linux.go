@@ -0,0 +1,40 @@
+package reaper
+
+import (
+	"os"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"golang.org/x/sys/unix"
+)
+
+// umountNoFollow is UMOUNT_NOFOLLOW, which isn't in x/sys/unix
+const umountNoFollow = 0x8
+
+// unmountTitusInit removes the bind mount of the container's /proc/$PID that setupSystemPods made
+func unmountTitusInit(path string) error {
+	// EINVAL means that it isn't mounted anymore, and only the mountpoint has to be removed
+	if err := unix.Unmount(path, unix.MNT_DETACH|umountNoFollow); err != nil && err != unix.EINVAL {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func cgroupMountpoints() ([]string, error) {
+	mounts, err := cgroups.GetCgroupMounts(true)
+	if err != nil {
+		return nil, err
+	}
+	mountpoints := make([]string, len(mounts))
+	for idx, mount := range mounts {
+		mountpoints[idx] = mount.Mountpoint
+	}
+	return mountpoints, nil
+}
+
+func lockFileID(path string) (fileID, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return fileID{}, err
+	}
+	return fileID{major: unix.Major(stat.Dev), minor: unix.Minor(stat.Dev), inode: stat.Ino}, nil
+}
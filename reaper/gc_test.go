@@ -0,0 +1,244 @@
+package reaper
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Netflix/metrics-client-go/metrics"
+	"github.com/Netflix/titus-executor/fslocker"
+	"github.com/Netflix/titus-executor/procinfo"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReaper(t *testing.T, dryRun bool) (*Reaper, string) {
+	root, err := ioutil.TempDir("", "reaper-gc")
+	require.NoError(t, err)
+
+	paths := HostPaths{
+		TitusInits:        filepath.Join(root, "titus-inits"),
+		TitusEnvironments: filepath.Join(root, "titus-environments"),
+		Passports:         filepath.Join(root, "titus-client-passports"),
+		RuncBundles:       filepath.Join(root, "bundles"),
+		TaskLocks:         filepath.Join(root, "tasks", "lockDir"),
+		CgroupMarkers:     filepath.Join(root, "cgroups"),
+		TiniSockets:       filepath.Join(root, "titus-executor-sockets*"),
+		IPLocks:           filepath.Join(root, "vpc", "lockDir", "interfaces", "*", "ip-addresses", "*"),
+		GPULocks:          filepath.Join(root, "nvidia", "lockDir", "dev", "*"),
+	}
+	reaper := &Reaper{
+		reporter:   metrics.Discard,
+		log:        *log.NewEntry(log.New()),
+		dryRun:     dryRun,
+		httpClient: &http.Client{Timeout: executorAPITimeout},
+		paths:      paths,
+		cgroupMountpoints: func() ([]string, error) {
+			return []string{filepath.Join(root, "cgroup", "pids"), filepath.Join(root, "cgroup", "memory")}, nil
+		},
+		orphanedLocks: make(map[string]int),
+
+		unresponsiveCycles: make(map[string]int),
+	}
+	return reaper, root
+}
+
+// touch creates a file, and backdates it so it's past the grace period unless it's meant to be new
+func touch(t *testing.T, path string, isNew bool) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+	if !isNew {
+		old := time.Now().Add(-2 * leakGracePeriod)
+		require.NoError(t, os.Chtimes(path, old, old))
+	}
+}
+
+func leakedPaths(leaks []Leak) []string {
+	paths := []string{}
+	for _, leak := range leaks {
+		paths = append(paths, leak.Path)
+	}
+	return paths
+}
+
+func TestCollectGarbageTaskFiles(t *testing.T) {
+	reaper, root := newTestReaper(t, false)
+	defer os.RemoveAll(root)
+
+	envs := reaper.paths.TitusEnvironments
+	touch(t, filepath.Join(envs, "live-task.json"), false)
+	touch(t, filepath.Join(envs, "dead-task.json"), false)
+	touch(t, filepath.Join(envs, "dead-task.env"), false)
+	touch(t, filepath.Join(envs, "new-task.env"), true)
+	touch(t, filepath.Join(envs, ".gitkeep"), false)
+	touch(t, filepath.Join(reaper.paths.Passports, "dead-task", "passport"), false)
+	old := time.Now().Add(-2 * leakGracePeriod)
+	require.NoError(t, os.Chtimes(filepath.Join(reaper.paths.Passports, "dead-task"), old, old))
+	sockets := filepath.Join(root, "titus-executor-sockets123")
+	touch(t, filepath.Join(sockets, "dead-task.socket"), false)
+	touch(t, filepath.Join(reaper.paths.RuncBundles, "runc-task", "config.json"), false)
+	touch(t, filepath.Join(envs, "runc-task.json"), false)
+
+	live := newLiveTasks()
+	live.taskIDs["live-task"] = struct{}{}
+	require.NoError(t, live.addRuncBundles(reaper.paths.RuncBundles))
+
+	leaks := reaper.collectGarbage(live)
+	assert.Len(t, leaks, 4)
+	assert.Contains(t, leakedPaths(leaks), filepath.Join(envs, "dead-task.json"))
+	assert.Contains(t, leakedPaths(leaks), filepath.Join(envs, "dead-task.env"))
+	assert.Contains(t, leakedPaths(leaks), filepath.Join(reaper.paths.Passports, "dead-task"))
+	assert.Contains(t, leakedPaths(leaks), filepath.Join(sockets, "dead-task.socket"))
+	for _, leak := range leaks {
+		assert.True(t, leak.Removed, leak.Path)
+		assert.Equal(t, "dead-task", leak.TaskID)
+		_, err := os.Stat(leak.Path)
+		assert.True(t, os.IsNotExist(err), leak.Path)
+	}
+
+	for _, name := range []string{"live-task.json", "new-task.env", ".gitkeep", "runc-task.json"} {
+		_, err := os.Stat(filepath.Join(envs, name))
+		assert.NoError(t, err, name)
+	}
+	// The socket directory is gone once its last socket is
+	_, err := os.Stat(sockets)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCollectGarbageDryRun(t *testing.T) {
+	reaper, root := newTestReaper(t, true)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(reaper.paths.TitusEnvironments, "dead-task.json")
+	touch(t, path, false)
+
+	leaks := reaper.collectGarbage(newLiveTasks())
+	require.Len(t, leaks, 1)
+	assert.False(t, leaks[0].Removed)
+	_, err := os.Stat(path)
+	assert.NoError(t, err)
+}
+
+func TestCollectGarbageOrphanedLock(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Lock holders can only be found on Linux")
+	}
+	// The test process holds the lock, and it isn't a titus binary, so it's reported rather than terminated
+	reaper, root := newTestReaper(t, false)
+	defer os.RemoveAll(root)
+
+	locker, err := fslocker.NewFSLocker(filepath.Join(root, "nvidia"))
+	require.NoError(t, err)
+	timeout := time.Duration(0)
+	lock, err := locker.ExclusiveLock("/dev/nvidia0", &timeout)
+	require.NoError(t, err)
+	defer lock.Unlock()
+
+	live := newLiveTasks()
+	live.flocks, err = procinfo.Flocks()
+	require.NoError(t, err)
+	for i := 1; i < maxOrphanedLockCycles; i++ {
+		assert.Len(t, reaper.collectGarbage(live), 0)
+	}
+	leaks := reaper.collectGarbage(live)
+	require.Len(t, leaks, 1)
+	assert.Equal(t, gpuLocksResource, leaks[0].Resource)
+	assert.Equal(t, filepath.Join(root, "nvidia", "lockDir", "dev", "nvidia0"), leaks[0].Path)
+	assert.Equal(t, os.Getpid(), leaks[0].Pid)
+	assert.False(t, leaks[0].Removed)
+	assert.Contains(t, leaks[0].Error, "isn't a titus process")
+
+	// Locks held by executors aren't leaked
+	live.executorNames[filepath.Base(os.Args[0])] = struct{}{}
+	assert.Len(t, reaper.collectGarbage(live), 0)
+	assert.Len(t, reaper.orphanedLocks, 0)
+}
+
+func TestLockedTasksAreLive(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Lock holders can only be found on Linux")
+	}
+	reaper, root := newTestReaper(t, false)
+	defer os.RemoveAll(root)
+
+	// The executor is waiting on launch guard, so the task has passports, but no container
+	locker, err := fslocker.NewFSLocker(filepath.Dir(reaper.paths.TaskLocks))
+	require.NoError(t, err)
+	timeout := time.Duration(0)
+	lock, err := locker.ExclusiveLock("waiting-task", &timeout)
+	require.NoError(t, err)
+	defer lock.Unlock()
+	passports := filepath.Join(reaper.paths.Passports, "waiting-task")
+	touch(t, filepath.Join(passports, "passport"), false)
+	old := time.Now().Add(-2 * leakGracePeriod)
+	require.NoError(t, os.Chtimes(passports, old, old))
+
+	live := newLiveTasks()
+	live.flocks, err = procinfo.Flocks()
+	require.NoError(t, err)
+	require.NoError(t, live.addLockedTasks(reaper.paths.TaskLocks))
+	assert.True(t, live.has("waiting-task"))
+	assert.Len(t, reaper.collectGarbage(live), 0)
+
+	// Once the executor is gone, so is its lock
+	lock.Unlock()
+	live = newLiveTasks()
+	live.flocks, err = procinfo.Flocks()
+	require.NoError(t, err)
+	require.NoError(t, live.addLockedTasks(reaper.paths.TaskLocks))
+	assert.False(t, live.has("waiting-task"))
+	assert.Equal(t, []string{passports}, leakedPaths(reaper.collectGarbage(live)))
+}
+
+func TestCollectGarbageCgroups(t *testing.T) {
+	reaper, root := newTestReaper(t, false)
+	defer os.RemoveAll(root)
+	mountpoints, err := reaper.cgroupMountpoints()
+	require.NoError(t, err)
+
+	writeMarker := func(taskID, cgroup string) {
+		marker := filepath.Join(reaper.paths.CgroupMarkers, taskID)
+		require.NoError(t, os.MkdirAll(reaper.paths.CgroupMarkers, 0700))
+		require.NoError(t, ioutil.WriteFile(marker, []byte(cgroup), 0600))
+		old := time.Now().Add(-2 * leakGracePeriod)
+		require.NoError(t, os.Chtimes(marker, old, old))
+	}
+	for _, mountpoint := range mountpoints {
+		// The executors' own cgroups, and everything next to them belong to someone else
+		// Unlike in cgroupfs, directories here can only be removed once they're empty
+		require.NoError(t, os.MkdirAll(filepath.Join(mountpoint, "mesos", "executor-1", "dead-container", "child"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(mountpoint, "mesos", "executor-2", "live-container"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(mountpoint, "mesos", "other"), 0755))
+	}
+	busy := filepath.Join(mountpoints[0], "mesos", "executor-3", "busy-container", "cgroup.procs")
+	touch(t, busy, false)
+	require.NoError(t, ioutil.WriteFile(busy, []byte("1234\n"), 0644))
+	writeMarker("dead-task", "/mesos/executor-1/dead-container")
+	writeMarker("live-task", "/mesos/executor-2/live-container")
+	writeMarker("busy-task", "/mesos/executor-3/busy-container")
+	writeMarker("bad-task", "/mesos/../..")
+
+	live := newLiveTasks()
+	live.taskIDs["live-task"] = struct{}{}
+	leaks := reaper.gcCgroups(live)
+	require.Len(t, leaks, 1)
+	assert.Equal(t, Leak{Resource: cgroupsResource, Path: "/mesos/executor-1/dead-container", TaskID: "dead-task", Removed: true}, leaks[0])
+
+	for _, mountpoint := range mountpoints {
+		_, err = os.Stat(filepath.Join(mountpoint, "mesos", "executor-1", "dead-container"))
+		assert.True(t, os.IsNotExist(err))
+		for _, cgroup := range []string{"executor-1", "executor-2/live-container", "other"} {
+			_, err = os.Stat(filepath.Join(mountpoint, "mesos", cgroup))
+			assert.NoError(t, err, cgroup)
+		}
+	}
+	_, err = os.Stat(filepath.Dir(busy))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(reaper.paths.CgroupMarkers, "dead-task"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package reaper
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("Unsupported on this platform")
+
+func unmountTitusInit(path string) error {
+	return os.Remove(path)
+}
+
+func cgroupMountpoints() ([]string, error) {
+	return nil, errUnsupported
+}
+
+func lockFileID(path string) (fileID, error) {
+	return fileID{}, errUnsupported
+}
@@ -0,0 +1,112 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Netflix/titus-executor/launchguard/client"
+	"github.com/Netflix/titus-executor/launchguard/core"
+	"github.com/Netflix/titus-executor/launchguard/server"
+)
+
+const (
+	defaultLaunchGuardURL = "http://localhost:8006"
+
+	launchGuardCleanupEventsResource = "launchGuardCleanupEvents"
+	launchGuardLaunchEventsResource  = "launchGuardLaunchEvents"
+)
+
+// gcLaunchGuard reconciles the launch guard server's queues. Launches stop waiting on launch guard after
+// client.MaxLaunchTime, and the server gives up on cleanup events then too, so any event that's still queued past
+// that is stale. Stale cleanup events are expired, as they hold up every launch queued behind them. Stale launch
+// events go away when their executor disconnects, so they're only reported.
+func (reaper *Reaper) gcLaunchGuard(ctx context.Context) []Leak {
+	queues, err := reaper.launchGuardQueues(ctx)
+	if err != nil {
+		reaper.log.WithField("resource", launchGuardCleanupEventsResource).Warning("Unable to list launch guard events: ", err)
+		return nil
+	}
+
+	leaks := []Leak{}
+	for key, events := range queues {
+		for _, event := range events {
+			switch event.Type {
+			case core.CleanUpEventType:
+				if !isStaleCleanupEvent(event) {
+					continue
+				}
+				leak := Leak{Resource: launchGuardCleanupEventsResource, Path: fmt.Sprintf("%s/%s", key, event.ID)}
+				keyCopy, id := key, event.ID
+				reaper.removeLeak(&leak, func() error {
+					return reaper.expireCleanupEvent(ctx, keyCopy, id)
+				})
+				leaks = append(leaks, leak)
+			case core.LaunchEventType:
+				if time.Since(event.CreatedAt) < client.MaxLaunchTime {
+					continue
+				}
+				leak := Leak{Resource: launchGuardLaunchEventsResource, Path: key}
+				reaper.reportLeak(&leak, "launch event was created at %s", event.CreatedAt.Format(time.RFC3339))
+				leaks = append(leaks, leak)
+			}
+		}
+	}
+	return leaks
+}
+
+// isStaleCleanupEvent checks whether a cleanup event has outlived client.MaxLaunchTime, or has stopped heartbeating
+// for longer than the server's restart grace period
+func isStaleCleanupEvent(event server.QueuedEvent) bool {
+	startedAt := event.CreatedAt
+	if event.StartedAt != nil {
+		startedAt = *event.StartedAt
+	}
+	if time.Since(startedAt) > client.MaxLaunchTime {
+		return true
+	}
+	return event.LastHeartbeat != nil && time.Since(*event.LastHeartbeat) > client.RestartGracePeriod
+}
+
+func (reaper *Reaper) launchGuardQueues(ctx context.Context) (map[string][]server.QueuedEvent, error) {
+	req, err := http.NewRequest("GET", reaper.launchGuardURL+"/launchguard", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := reaper.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer shouldClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Launch guard server returned %s", resp.Status)
+	}
+
+	queues := make(map[string][]server.QueuedEvent)
+	if err = json.NewDecoder(resp.Body).Decode(&queues); err != nil {
+		return nil, err
+	}
+	return queues, nil
+}
+
+// expireCleanupEvent has the server give up on a cleanup event, so the launches queued behind it are told it was
+// abandoned, rather than finished
+func (reaper *Reaper) expireCleanupEvent(ctx context.Context, key, id string) error {
+	eventURL := fmt.Sprintf("%s/launchguard/%s/cleanupevent/%s/expire", reaper.launchGuardURL, url.PathEscape(key), url.PathEscape(id))
+	req, err := http.NewRequest("POST", eventURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := reaper.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer shouldClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Launch guard server returned %s", resp.Status)
+	}
+	return nil
+}
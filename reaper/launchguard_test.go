@@ -0,0 +1,75 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix/titus-executor/launchguard/client"
+	"github.com/Netflix/titus-executor/launchguard/core"
+	"github.com/Netflix/titus-executor/launchguard/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCLaunchGuard(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	stale := now.Add(-2 * client.MaxLaunchTime)
+	recent := now.Add(-time.Second)
+	queues := map[string][]server.QueuedEvent{
+		"eni-label": {
+			{QueuedEvent: core.QueuedEvent{Type: core.CleanUpEventType, ID: "hung", CreatedAt: stale}, StartedAt: &stale, LastHeartbeat: &recent},
+			{QueuedEvent: core.QueuedEvent{Type: core.CleanUpEventType, ID: "active", CreatedAt: recent}, StartedAt: &recent, LastHeartbeat: &recent},
+			{QueuedEvent: core.QueuedEvent{Type: core.LaunchEventType, CreatedAt: stale}},
+			{QueuedEvent: core.QueuedEvent{Type: core.LaunchEventType, CreatedAt: recent}},
+		},
+	}
+	var expired []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/launchguard":
+			assert.NoError(t, json.NewEncoder(w).Encode(queues))
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/expire"):
+			expired = append(expired, r.URL.Path)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	reaper, root := newTestReaper(t, true)
+	defer os.RemoveAll(root)
+	reaper.launchGuardURL = srv.URL
+	leaks := reaper.gcLaunchGuard(ctx)
+	require.Len(t, leaks, 2)
+	assert.Empty(t, expired)
+
+	reaper.dryRun = false
+	leaks = reaper.gcLaunchGuard(ctx)
+	require.Len(t, leaks, 2)
+	assert.Equal(t, []string{"/launchguard/eni-label/cleanupevent/hung/expire"}, expired)
+	for _, leak := range leaks {
+		switch leak.Resource {
+		case launchGuardCleanupEventsResource:
+			assert.Equal(t, "eni-label/hung", leak.Path)
+			assert.True(t, leak.Removed)
+		case launchGuardLaunchEventsResource:
+			assert.Equal(t, "eni-label", leak.Path)
+			assert.False(t, leak.Removed)
+		default:
+			t.Fatal("Unexpected leak: ", leak)
+		}
+	}
+
+	// An unreachable server isn't fatal
+	srv.Close()
+	assert.Empty(t, reaper.gcLaunchGuard(ctx))
+}
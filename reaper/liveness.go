@@ -0,0 +1,171 @@
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Netflix/titus-executor/models"
+	"github.com/Netflix/titus-executor/procinfo"
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	executorAPITimeout = 10 * time.Second
+	// stuckShutdownTimeout is how long the executor has to remove a container after it reported the task as terminal
+	stuckShutdownTimeout = 15 * time.Minute
+	// maxUnresponsiveCycles is how many reap cycles in a row the executor's API can be unresponsive, before we consider it hung
+	maxUnresponsiveCycles = 3
+)
+
+// Verdict is the reaper's decision about a single container, and the evidence it was based on
+type Verdict struct {
+	ContainerID string `json:"containerId"`
+	TaskID      string `json:"taskId"`
+	Terminate   bool   `json:"terminate"`
+	// ExecutorUnresponsive is set when the executor is alive, but its API didn't answer
+	ExecutorUnresponsive bool `json:"executorUnresponsive,omitempty"`
+	// Reasons are why the container should be terminated
+	Reasons []string `json:"reasons,omitempty"`
+	// Warnings are inconsistencies that aren't enough to terminate the container on their own
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (v *Verdict) terminate(format string, args ...interface{}) {
+	v.Terminate = true
+	v.Reasons = append(v.Reasons, fmt.Sprintf(format, args...))
+}
+
+func (v *Verdict) warn(format string, args ...interface{}) {
+	v.Warnings = append(v.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Report is the outcome of a single reap cycle
+type Report struct {
+	Timestamp time.Time `json:"timestamp"`
+	DryRun    bool      `json:"dryRun"`
+	Verdicts  []Verdict `json:"verdicts"`
+	Leaks     []Leak    `json:"leaks"`
+}
+
+func evaluate(ctx context.Context, client *http.Client, container types.ContainerJSON) Verdict {
+	labels := container.Config.Labels
+	verdict := Verdict{
+		TaskID: labels[models.TaskIDLabel],
+	}
+	if container.ContainerJSONBase != nil {
+		verdict.ContainerID = container.ID
+	}
+
+	/*
+		Steps:
+		1. Check if the executor PID exists, and that it's still the process which launched the container
+		2. Check if we can hit the Executor bind URI, and find out about the container
+		3. Check if the container status is "right"
+	*/
+	executorPid := labels[models.ExecutorPidLabel]
+	if !isPidAlive(executorPid) {
+		verdict.terminate("executor (pid %s) is not running", executorPid)
+		return verdict
+	}
+
+	// isPidAlive would have panicked if this didn't parse
+	pid, _ := strconv.Atoi(executorPid)
+	checkExecutorProcess(pid, labels, &verdict)
+	if verdict.Terminate {
+		return verdict
+	}
+
+	if address, ok := labels[models.ExecutorHTTPListenerAddressLabel]; ok {
+		checkExecutorAPI(ctx, client, address, container, &verdict)
+	}
+
+	return verdict
+}
+
+// checkExecutorProcess makes sure that the process is the executor which launched the container, and not a new process which reused its PID
+func checkExecutorProcess(pid int, labels map[string]string, verdict *Verdict) {
+	if expectedStartTimeStr, ok := labels[models.ExecutorPidStartTimeLabel]; ok {
+		expectedStartTime, err := strconv.ParseUint(expectedStartTimeStr, 10, 64)
+		if err != nil {
+			verdict.warn("unable to parse executor start time label %q: %v", expectedStartTimeStr, err)
+		} else if startTime, err := procinfo.StartTime(pid); err != nil {
+			verdict.warn("unable to read the start time of pid %d: %v", pid, err)
+		} else if startTime != expectedStartTime {
+			verdict.terminate("pid %d was reused: it started at %d, but the executor started at %d", pid, startTime, expectedStartTime)
+			return
+		}
+	}
+
+	if expectedName, ok := labels[models.ExecutorProcessNameLabel]; ok {
+		cmdline, err := procinfo.Cmdline(pid)
+		if err != nil {
+			verdict.warn("unable to read the cmdline of pid %d: %v", pid, err)
+		} else if len(cmdline) == 0 || filepath.Base(cmdline[0]) != expectedName {
+			verdict.terminate("pid %d is running %v, and not the executor (%s)", pid, cmdline, expectedName)
+		}
+	}
+}
+
+// checkExecutorAPI asks the executor about the task, and reconciles what it says with Docker's view of the container
+func checkExecutorAPI(ctx context.Context, client *http.Client, address string, container types.ContainerJSON, verdict *Verdict) {
+	taskURL := fmt.Sprintf("http://%s/tasks/%s", address, url.PathEscape(verdict.TaskID))
+	req, err := http.NewRequest("GET", taskURL, nil)
+	if err != nil {
+		verdict.warn("unable to build executor API request: %v", err)
+		return
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		verdict.ExecutorUnresponsive = true
+		verdict.warn("executor API at %s is unresponsive: %v", address, err)
+		return
+	}
+	defer shouldClose(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		verdict.terminate("executor at %s doesn't know about the task", address)
+		return
+	default:
+		verdict.warn("executor API at %s returned %s", address, resp.Status)
+		return
+	}
+
+	var taskState models.TaskState
+	if err = json.NewDecoder(resp.Body).Decode(&taskState); err != nil {
+		verdict.warn("unable to decode executor API response: %v", err)
+		return
+	}
+
+	running := container.ContainerJSONBase != nil && container.State != nil && container.State.Running
+	if isTerminalState(taskState.State) && running && len(taskState.Updates) > 0 {
+		lastUpdate := taskState.Updates[len(taskState.Updates)-1]
+		if time.Since(lastUpdate.Timestamp) > stuckShutdownTimeout {
+			verdict.terminate("executor reported the task as %s at %s, but the container is still running", taskState.State, lastUpdate.Timestamp)
+			return
+		}
+	}
+	if taskState.State == "TASK_RUNNING" && !running {
+		verdict.warn("executor reports the task as running, but the container isn't")
+	}
+
+	if ip := container.Config.Labels["titus.net.ipv4"]; ip != "" && taskState.Allocation.IPV4Address != "" && ip != taskState.Allocation.IPV4Address {
+		verdict.warn("container is labeled with IP %s, but the executor allocated %s", ip, taskState.Allocation.IPV4Address)
+	}
+}
+
+func isTerminalState(state string) bool {
+	switch state {
+	case "TASK_FINISHED", "TASK_FAILED", "TASK_KILLED", "TASK_LOST":
+		return true
+	default:
+		return false
+	}
+}
@@ -2,6 +2,9 @@ package reaper
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"net/http"
 	"os"
 	"strconv"
 	"syscall"
@@ -9,34 +12,68 @@ import (
 
 	"github.com/Netflix/metrics-client-go/metrics"
 	"github.com/Netflix/titus-executor/models"
+	"github.com/Netflix/titus-executor/procinfo"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	log "github.com/sirupsen/logrus"
+	netcontext "golang.org/x/net/context"
 
 	docker "github.com/docker/docker/client"
 )
 
+// containerAPI is the part of the Docker client that the reaper uses to inspect, and terminate containers
+type containerAPI interface {
+	ContainerInspect(ctx netcontext.Context, containerID string) (types.ContainerJSON, error)
+	ContainerStop(ctx netcontext.Context, containerID string, timeout *time.Duration) error
+	ContainerRemove(ctx netcontext.Context, containerID string, options types.ContainerRemoveOptions) error
+}
+
 // Reaper is a holder struct for the internal configuration of the reaper
 type Reaper struct {
 	reporter metrics.Reporter
 	log      log.Entry
+	// dryRun makes the reaper only report the containers it would terminate
+	dryRun       bool
+	reportWriter io.Writer
+	httpClient   *http.Client
+	// launchGuardURL is the launch guard server that the executors on the host use
+	launchGuardURL string
+	// unresponsiveCycles counts the consecutive reap cycles in which a container's executor didn't answer its API
+	unresponsiveCycles map[string]int
+
+	paths HostPaths
+	// cgroupMountpoints lists where the cgroup hierarchies are mounted
+	cgroupMountpoints func() ([]string, error)
+	// orphanedLocks counts the consecutive reap cycles in which a lock was held by a process outside of any executor
+	orphanedLocks map[string]int
 }
 
-func newReaper(ctx context.Context) *Reaper {
+func newReaper(ctx context.Context, dryRun bool, paths HostPaths) *Reaper {
 	l := log.NewEntry(log.New())
 
 	return &Reaper{
-		reporter: metrics.New(ctx, l, nil),
-		log:      *l,
+		reporter:     metrics.New(ctx, l, nil),
+		log:          *l,
+		dryRun:       dryRun,
+		reportWriter: os.Stdout,
+		httpClient:   &http.Client{Timeout: executorAPITimeout},
+
+		launchGuardURL: defaultLaunchGuardURL,
+
+		unresponsiveCycles: make(map[string]int),
+
+		paths:             paths,
+		cgroupMountpoints: cgroupMountpoints,
+		orphanedLocks:     make(map[string]int),
 	}
 
 }
 
-// RunReaper runs the reap loop
-func RunReaper(dockerHost string) {
+// RunReaper runs the reap loop. paths must match where the executors on the host keep their per-task resources.
+func RunReaper(dockerHost string, dryRun bool, paths HostPaths) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	reaper := newReaper(ctx)
+	reaper := newReaper(ctx, dryRun, paths)
 	reaper.watchLoop(ctx, dockerHost)
 }
 
@@ -85,10 +122,46 @@ func (reaper *Reaper) runReapCycle(parentCtx context.Context, dockerClient *dock
 		reaper.log.Fatal("Unable to get containers: ", err)
 	}
 
+	report := Report{
+		Timestamp: time.Now(),
+		DryRun:    reaper.dryRun,
+		Verdicts:  []Verdict{},
+	}
+	live := newLiveTasks()
+	for _, container := range containers {
+		if taskID, ok := container.Labels[models.TaskIDLabel]; ok {
+			live.taskIDs[taskID] = struct{}{}
+		}
+		if name, ok := container.Labels[models.ExecutorProcessNameLabel]; ok {
+			live.executorNames[name] = struct{}{}
+		}
+	}
+	if err := live.addRuncBundles(reaper.paths.RuncBundles); err != nil {
+		reaper.log.Fatal("Unable to list runc bundles: ", err)
+	}
+	// Without the held locks, tasks which don't have a container yet would look dead
+	if live.flocks, err = procinfo.Flocks(); err != nil {
+		reaper.log.Fatal("Unable to list held locks: ", err)
+	}
+	if err := live.addLockedTasks(reaper.paths.TaskLocks); err != nil {
+		reaper.log.Fatal("Unable to list task locks: ", err)
+	}
+
 	titusContainers := filterTitusContainers(containers)
+	unresponsiveCycles := reaper.unresponsiveCycles
+	reaper.unresponsiveCycles = make(map[string]int)
 	/* Now we have to inspect these to get the container JSON */
 	for _, container := range titusContainers {
-		reaper.processContainer(ctx, container, dockerClient)
+		if verdict := reaper.processContainer(ctx, container, dockerClient, unresponsiveCycles[container.ID]); verdict != nil {
+			report.Verdicts = append(report.Verdicts, *verdict)
+		}
+	}
+	/* The resources of containers terminated in this cycle are collected once they're past the grace period */
+	report.Leaks = reaper.collectGarbage(live)
+	report.Leaks = append(report.Leaks, reaper.gcLaunchGuard(ctx)...)
+
+	if err := json.NewEncoder(reaper.reportWriter).Encode(report); err != nil {
+		reaper.log.Warning("Unable to write report: ", err)
 	}
 }
 
@@ -104,38 +177,54 @@ func filterTitusContainers(containers []types.Container) []types.Container {
 	return ret
 }
 
-func (reaper *Reaper) processContainer(ctx context.Context, container types.Container, dockerClient *docker.Client) {
+func (reaper *Reaper) processContainer(ctx context.Context, container types.Container, dockerClient containerAPI, unresponsiveCycles int) *Verdict {
 	containerJSON, err := dockerClient.ContainerInspect(ctx, container.ID)
-	taskID := containerJSON.Config.Labels[models.TaskIDLabel]
-	l := reaper.log.WithField("taskID", taskID)
 	if docker.IsErrContainerNotFound(err) {
-		return
+		return nil
 	} else if err != nil {
 		reaper.log.Fatal("Unable to fetch container JSON: ", err)
 	}
+	taskID := containerJSON.Config.Labels[models.TaskIDLabel]
+	l := reaper.log.WithField("taskID", taskID)
 
-	if shouldTerminate(ctx, &reaper.log, containerJSON) {
-		l.Info("Terminating container")
-		timeout := 30 * time.Second
-		if err := dockerClient.ContainerStop(ctx, containerJSON.ID, &timeout); err != nil {
-			l.Warning("Unable to stop container: ", err)
+	verdict := evaluate(ctx, reaper.httpClient, containerJSON)
+	if verdict.ExecutorUnresponsive {
+		unresponsiveCycles++
+		reaper.unresponsiveCycles[container.ID] = unresponsiveCycles
+		if unresponsiveCycles >= maxUnresponsiveCycles {
+			verdict.terminate("executor API has been unresponsive for %d reap cycles", unresponsiveCycles)
 		}
-		if err := dockerClient.ContainerRemove(ctx, containerJSON.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
-			l.Warning("Unable to remove container: ", err)
+	}
+	l = l.WithField("reasons", verdict.Reasons).WithField("warnings", verdict.Warnings)
+	if !verdict.Terminate {
+		if len(verdict.Warnings) > 0 {
+			l.Warning("Not terminating container")
 		}
-		reaper.reporter.Counter("titusAgent.containersTerminatedSuccess", 1, nil)
+		return &verdict
 	}
+
+	if reaper.dryRun {
+		l.Info("Would terminate container, but running in dry-run mode")
+		reaper.reporter.Counter("titusAgent.containersTerminatedDryRun", 1, nil)
+		return &verdict
+	}
+
+	l.Info("Terminating container")
+	timeout := 30 * time.Second
+	if err := dockerClient.ContainerStop(ctx, containerJSON.ID, &timeout); err != nil {
+		l.Warning("Unable to stop container: ", err)
+	}
+	if err := dockerClient.ContainerRemove(ctx, containerJSON.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+		l.Warning("Unable to remove container: ", err)
+	}
+	reaper.reporter.Counter("titusAgent.containersTerminatedSuccess", 1, nil)
+	return &verdict
 }
 
-func shouldTerminate(ctx context.Context, logger *log.Entry, container types.ContainerJSON) bool {
-	executorPid := container.Config.Labels[models.ExecutorPidLabel]
-	/*
-		Steps:
-		1. Check if the executor PID exists
-		2. Check if we can hit the Executor bind URI, and find out about the container
-		3. Check if the container status is "right"
-	*/
-	return !isPidAlive(executorPid)
+func shouldClose(c io.Closer) {
+	if err := c.Close(); err != nil {
+		log.Error("Could not close: ", err)
+	}
 }
 
 func isPidAlive(pidStr string) bool {
@@ -2,14 +2,23 @@ package reaper
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Netflix/titus-executor/models"
+	"github.com/Netflix/titus-executor/procinfo"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netcontext "golang.org/x/net/context"
 )
 
 func newContainer(executorPid, taskIDLabel string) types.ContainerJSON {
@@ -23,27 +32,96 @@ func newContainer(executorPid, taskIDLabel string) types.ContainerJSON {
 	}
 }
 
+// fakeContainerAPI is a Docker daemon with a fixed set of containers
+type fakeContainerAPI struct {
+	containers map[string]types.ContainerJSON
+	stopped    []string
+	removed    []string
+}
+
+func (f *fakeContainerAPI) ContainerInspect(ctx netcontext.Context, containerID string) (types.ContainerJSON, error) {
+	return f.containers[containerID], nil
+}
+
+func (f *fakeContainerAPI) ContainerStop(ctx netcontext.Context, containerID string, timeout *time.Duration) error {
+	f.stopped = append(f.stopped, containerID)
+	return nil
+}
+
+func (f *fakeContainerAPI) ContainerRemove(ctx netcontext.Context, containerID string, options types.ContainerRemoveOptions) error {
+	f.removed = append(f.removed, containerID)
+	return nil
+}
+
 func TestReaperOneContainerMissingExecutor(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
-
 	defer cancel()
 
 	container := newContainer("0", "test-task-id")
-	if !shouldTerminate(ctx, log.NewEntry(log.New()), container) {
-		t.Fatal("Did not terminate container with missing executor")
-	}
+	container.ContainerJSONBase = &types.ContainerJSONBase{ID: "container-id"}
+	api := &fakeContainerAPI{containers: map[string]types.ContainerJSON{"container-id": container}}
+
+	reaper, root := newTestReaper(t, true)
+	defer os.RemoveAll(root)
+	verdict := reaper.processContainer(ctx, types.Container{ID: "container-id"}, api, 0)
+	require.NotNil(t, verdict)
+	assert.True(t, verdict.Terminate)
+	assert.Empty(t, api.stopped)
+	assert.Empty(t, api.removed)
+
+	reaper.dryRun = false
+	verdict = reaper.processContainer(ctx, types.Container{ID: "container-id"}, api, 0)
+	require.NotNil(t, verdict)
+	assert.True(t, verdict.Terminate)
+	assert.Equal(t, []string{"container-id"}, api.stopped)
+	assert.Equal(t, []string{"container-id"}, api.removed)
 }
 
 func TestReaperOneContainersNoCleanup(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	const testTaskID = "test-task-id"
+	container := newContainer(strconv.Itoa(os.Getpid()), "test-task-id")
+	container.ContainerJSONBase = &types.ContainerJSONBase{ID: "container-id"}
+	api := &fakeContainerAPI{containers: map[string]types.ContainerJSON{"container-id": container}}
+
+	reaper, root := newTestReaper(t, false)
+	defer os.RemoveAll(root)
+	verdict := reaper.processContainer(ctx, types.Container{ID: "container-id"}, api, 0)
+	require.NotNil(t, verdict)
+	assert.False(t, verdict.Terminate)
+	assert.Empty(t, api.stopped)
+	assert.Empty(t, api.removed)
+}
+
+func TestReaperUnresponsiveExecutor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	address := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	container := newContainer(strconv.Itoa(os.Getpid()), "test-task-id")
+	container.ContainerJSONBase = &types.ContainerJSONBase{ID: "container-id"}
+	container.Config.Labels[models.ExecutorHTTPListenerAddressLabel] = address
+	api := &fakeContainerAPI{containers: map[string]types.ContainerJSON{"container-id": container}}
 
-	container := newContainer(strconv.Itoa(os.Getpid()), testTaskID)
-	if shouldTerminate(ctx, log.NewEntry(log.New()), container) {
-		t.Fatal("Terminated task")
+	reaper, root := newTestReaper(t, false)
+	defer os.RemoveAll(root)
+	for cycle := 1; cycle < maxUnresponsiveCycles; cycle++ {
+		unresponsiveCycles := reaper.unresponsiveCycles
+		reaper.unresponsiveCycles = make(map[string]int)
+		verdict := reaper.processContainer(ctx, types.Container{ID: "container-id"}, api, unresponsiveCycles["container-id"])
+		require.NotNil(t, verdict)
+		assert.False(t, verdict.Terminate)
+		assert.Equal(t, cycle, reaper.unresponsiveCycles["container-id"])
 	}
+
+	verdict := reaper.processContainer(ctx, types.Container{ID: "container-id"}, api, reaper.unresponsiveCycles["container-id"])
+	require.NotNil(t, verdict)
+	assert.True(t, verdict.Terminate)
+	assert.Equal(t, []string{"container-id"}, api.removed)
 }
 
 func TestIsPidAlive(t *testing.T) {
@@ -55,3 +133,66 @@ func TestIsPidAlive(t *testing.T) {
 	}
 
 }
+
+func TestReaperPidReused(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	startTime, err := procinfo.StartTime(os.Getpid())
+	require.NoError(t, err)
+
+	container := newContainer(strconv.Itoa(os.Getpid()), "test-task-id")
+	container.Config.Labels[models.ExecutorPidStartTimeLabel] = strconv.FormatUint(startTime+1, 10)
+	verdict := evaluate(ctx, http.DefaultClient, container)
+	assert.True(t, verdict.Terminate)
+
+	container.Config.Labels[models.ExecutorPidStartTimeLabel] = strconv.FormatUint(startTime, 10)
+	container.Config.Labels[models.ExecutorProcessNameLabel] = filepath.Base(os.Args[0])
+	verdict = evaluate(ctx, http.DefaultClient, container)
+	assert.False(t, verdict.Terminate)
+
+	container.Config.Labels[models.ExecutorProcessNameLabel] = "titus-executor"
+	verdict = evaluate(ctx, http.DefaultClient, container)
+	assert.True(t, verdict.Terminate)
+}
+
+func TestReaperExecutorAPI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	taskState := models.TaskState{
+		TaskID: "test-task-id",
+		State:  "TASK_RUNNING",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tasks/test-task-id" {
+			http.NotFound(w, r)
+			return
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(taskState))
+	}))
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	container := newContainer(strconv.Itoa(os.Getpid()), "test-task-id")
+	container.ContainerJSONBase = &types.ContainerJSONBase{State: &types.ContainerState{Running: true}}
+	container.Config.Labels[models.ExecutorHTTPListenerAddressLabel] = address
+	verdict := evaluate(ctx, http.DefaultClient, container)
+	assert.False(t, verdict.Terminate)
+	assert.Empty(t, verdict.Warnings)
+
+	// The executor finished the task a long time ago, but never removed the container
+	taskState.State = "TASK_FINISHED"
+	taskState.Updates = []models.TaskStatus{{State: "TASK_FINISHED", Timestamp: time.Now().Add(-time.Hour)}}
+	verdict = evaluate(ctx, http.DefaultClient, container)
+	assert.True(t, verdict.Terminate)
+
+	container.Config.Labels[models.TaskIDLabel] = "other-task-id"
+	verdict = evaluate(ctx, http.DefaultClient, container)
+	assert.True(t, verdict.Terminate)
+
+	srv.Close()
+	verdict = evaluate(ctx, http.DefaultClient, container)
+	assert.False(t, verdict.Terminate)
+	assert.True(t, verdict.ExecutorUnresponsive)
+}
@@ -0,0 +1,173 @@
+package uploader
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ContentEncodingGzip is the content encoding of gzip compressed uploads
+	ContentEncodingGzip = "gzip"
+	// ContentTypeGzip is the content type of gzip compressed uploads to stores which don't record a content encoding
+	ContentTypeGzip = "application/gzip"
+	// GzipExtension is appended to the names of gzip compressed uploads
+	GzipExtension = ".gz"
+
+	// ContentEncodingZstd is the content encoding of zstd compressed uploads
+	ContentEncodingZstd = "zstd"
+	// ContentTypeZstd is the content type of zstd compressed uploads to stores which don't record a content encoding
+	ContentTypeZstd = "application/zstd"
+	// ZstdExtension is appended to the names of zstd compressed uploads
+	ZstdExtension = ".zst"
+)
+
+// ContentEncodings are the content encodings which uploads can be compressed with
+var ContentEncodings = []string{ContentEncodingGzip, ContentEncodingZstd}
+
+// Extension returns what's appended to the names of uploads compressed with contentEncoding
+func Extension(contentEncoding string) string {
+	switch contentEncoding {
+	case ContentEncodingGzip:
+		return GzipExtension
+	case ContentEncodingZstd:
+		return ZstdExtension
+	}
+	return ""
+}
+
+// CompressedContentType returns the content type of uploads compressed with contentEncoding, to stores which don't
+// record a content encoding, or "" if the encoding isn't known
+func CompressedContentType(contentEncoding string) string {
+	switch contentEncoding {
+	case ContentEncodingGzip:
+		return ContentTypeGzip
+	case ContentEncodingZstd:
+		return ContentTypeZstd
+	}
+	return ""
+}
+
+// NewCompressWriter returns a writer which compresses what's written to it with contentEncoding, and writes it to w.
+// It must be closed to flush the end of the stream.
+func NewCompressWriter(w io.Writer, contentEncoding string) (io.WriteCloser, error) {
+	switch contentEncoding {
+	case ContentEncodingGzip:
+		return gzip.NewWriter(w), nil
+	case ContentEncodingZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("Unknown content encoding %q", contentEncoding)
+}
+
+// NewDecompressReader returns a reader of what r decompresses to, when it's compressed with contentEncoding
+func NewDecompressReader(r io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	switch contentEncoding {
+	case ContentEncodingGzip:
+		return gzip.NewReader(r)
+	case ContentEncodingZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("Unknown content encoding %q", contentEncoding)
+}
+
+// CompressReader returns a reader of r, which is compressed with contentEncoding as it's read. Closing it before it's
+// been read to the end stops the compression. Once Close returns, r isn't read from anymore, so it can be reused.
+func CompressReader(r io.Reader, contentEncoding string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	cr := &compressReader{PipeReader: pr, done: make(chan struct{})}
+	go func() {
+		defer close(cr.done)
+		cw, err := NewCompressWriter(pw, contentEncoding)
+		if err == nil {
+			if _, err = io.Copy(cw, r); err == nil {
+				err = cw.Close()
+			}
+		}
+		// A nil error closes the pipe with io.EOF
+		_ = pw.CloseWithError(err)
+	}()
+	return cr
+}
+
+type compressReader struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+func (r *compressReader) Close() error {
+	err := r.PipeReader.Close()
+	<-r.done
+	return err
+}
+
+// UploadCompressed is like Upload, but the file is compressed with contentEncoding on the way up, and the encoding's
+// extension is appended to remote. Directories can't be uploaded this way.
+func (e *Uploaders) UploadCompressed(local, remote, contentEncoding string, ctypeFunc ContentTypeInferenceFunction) []error {
+	fi, err := os.Stat(local)
+	if err != nil {
+		return []error{err}
+	}
+	if fi.IsDir() {
+		return []error{errors.New("Directories can't be uploaded compressed")}
+	}
+
+	contentType := ctypeFunc(local)
+	remote += Extension(contentEncoding)
+	var errs []error
+	for _, uploader := range e.uploaders {
+		log.Printf("uploading %s to %s compressed with %s", local, remote, contentEncoding)
+		if err = uploadFileCompressed(uploader, local, remote, contentType, contentEncoding); err != nil {
+			errs = append(errs, fmt.Errorf("Error uploading to %s : %s", remote, err))
+		}
+	}
+	return errs
+}
+
+func uploadFileCompressed(uploader Uploader, local, remote, contentType, contentEncoding string) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err = f.Close(); err != nil {
+			log.Warningf("Failed to close %s: %s", f.Name(), err)
+		}
+	}()
+
+	cr := CompressReader(f, contentEncoding)
+	defer cr.Close() // nolint: errcheck
+	return uploader.UploadStream(cr, remote, contentType, contentEncoding)
+}
+
+// UploadPartOfFileCompressed is like UploadPartOfFile, but the part is compressed with contentEncoding on the way up,
+// and the encoding's extension is appended to remote
+func (e *Uploaders) UploadPartOfFileCompressed(local io.ReadSeeker, start, length int64, remote, contentType, contentEncoding string) []error {
+	remote += Extension(contentEncoding)
+	var errs []error
+	for _, uploader := range e.uploaders {
+		log.Debugf("uploading %s to %s compressed with %s", local, remote, contentEncoding)
+		if err := uploadPartOfFileCompressed(uploader, local, start, length, remote, contentType, contentEncoding); err != nil {
+			errs = append(errs, fmt.Errorf("Error uploading to %s : %s", remote, err))
+		}
+	}
+	return errs
+}
+
+func uploadPartOfFileCompressed(uploader Uploader, local io.ReadSeeker, start, length int64, remote, contentType, contentEncoding string) error {
+	if _, err := local.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	cr := CompressReader(io.LimitReader(local, length), contentEncoding)
+	defer cr.Close() // nolint: errcheck
+	return uploader.UploadStream(cr, remote, contentType, contentEncoding)
+}
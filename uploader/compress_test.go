@@ -0,0 +1,35 @@
+package uploader
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressReaderRoundTrip(t *testing.T) {
+	contents := strings.Repeat("hello prana\n", 1000)
+	for _, contentEncoding := range ContentEncodings {
+		cr := CompressReader(strings.NewReader(contents), contentEncoding)
+		compressed, err := ioutil.ReadAll(cr)
+		require.NoError(t, err, contentEncoding)
+		require.NoError(t, cr.Close(), contentEncoding)
+		assert.True(t, len(compressed) < len(contents), contentEncoding)
+
+		dr, err := NewDecompressReader(strings.NewReader(string(compressed)), contentEncoding)
+		require.NoError(t, err, contentEncoding)
+		decompressed, err := ioutil.ReadAll(dr)
+		require.NoError(t, err, contentEncoding)
+		require.NoError(t, dr.Close(), contentEncoding)
+		assert.Equal(t, contents, string(decompressed), contentEncoding)
+	}
+}
+
+func TestCompressReaderUnknownEncoding(t *testing.T) {
+	cr := CompressReader(strings.NewReader("hello"), "brotli")
+	defer cr.Close() // nolint: errcheck
+	_, err := ioutil.ReadAll(cr)
+	assert.Error(t, err)
+}
@@ -36,11 +36,16 @@ func (u *CopyUploader) Upload(local, remote string, ctypeFunc ContentTypeInferen
 	contentType := ctypeFunc(local)
 
 	// copy uploader doesn't understand content types, ignore it
-	return u.uploadFile(l, remote, contentType)
+	return u.uploadFile(l, remote, contentType, "")
+}
+
+// UploadStream copies everything read from local to a single file. The content type, and encoding are kept in xattrs.
+func (u *CopyUploader) UploadStream(local io.Reader, remote, contentType, contentEncoding string) error {
+	return u.uploadFile(local, remote, contentType, contentEncoding)
 }
 
 // UploadFile copies a single file only!
-func (u *CopyUploader) uploadFile(local io.Reader, remote, contentType string) error {
+func (u *CopyUploader) uploadFile(local io.Reader, remote, contentType, contentEncoding string) error {
 	fullremote := path.Join(u.Dir, remote)
 	log.Println("copy : local io.Reader -> " + fullremote)
 
@@ -65,6 +70,12 @@ func (u *CopyUploader) uploadFile(local io.Reader, remote, contentType string) e
 			log.Warning("Unable to set content type: ", err)
 		}
 	}
+	if contentEncoding != "" {
+		err = xattr.FSetXattr(r, xattr.ContentEncodingAttr, []byte(contentEncoding))
+		if err != nil {
+			log.Warning("Unable to set content encoding: ", err)
+		}
+	}
 	_, err = io.Copy(r, local)
 	return err
 }
@@ -77,5 +88,5 @@ func (u *CopyUploader) UploadPartOfFile(local io.ReadSeeker, start, length int64
 		return errors.New("Could not seek")
 	}
 	limitLocal := io.LimitReader(local, length)
-	return u.uploadFile(limitLocal, remote, contentType)
+	return u.uploadFile(limitLocal, remote, contentType, "")
 }
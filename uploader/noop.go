@@ -22,3 +22,8 @@ func (u *NoopUploader) Upload(local, remote string, ctypeFunc ContentTypeInferen
 func (u *NoopUploader) UploadPartOfFile(local io.ReadSeeker, start, length int64, remote, contentType string) error {
 	return nil
 }
+
+// UploadStream does nothing (i.e., noop)
+func (u *NoopUploader) UploadStream(local io.Reader, remote, contentType, contentEncoding string) error {
+	return nil
+}
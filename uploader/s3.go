@@ -25,19 +25,35 @@ const (
 type S3Uploader struct {
 	log        logrus.FieldLogger
 	bucketName string
+	prefix     string
 	s3Uploader *s3manager.Uploader
 }
 
 // NewS3Uploader creates a new instance of an S3 uploader
 func NewS3Uploader(log logrus.FieldLogger, bucket string) Uploader {
-	region, err := getEC2Region()
+	u, err := NewS3UploaderWithPrefix(log, bucket, "")
 	if err != nil {
 		panic(err)
 	}
 
+	return u
+}
+
+// NewS3UploaderWithPrefix creates a new instance of an S3 uploader, which places all of the objects it uploads under the given key prefix
+func NewS3UploaderWithPrefix(log logrus.FieldLogger, bucket, prefix string) (Uploader, error) {
+	if bucket == "" {
+		return nil, errors.New("S3 bucket name is empty")
+	}
+
+	region, err := getEC2Region()
+	if err != nil {
+		return nil, err
+	}
+
 	u := &S3Uploader{
 		log:        log,
 		bucketName: bucket,
+		prefix:     prefix,
 	}
 
 	session, err := session.NewSession(&aws.Config{
@@ -45,13 +61,13 @@ func NewS3Uploader(log logrus.FieldLogger, bucket string) Uploader {
 		Region: &region,
 	})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	u.s3Uploader = s3manager.NewUploader(session, func(u *s3manager.Uploader) {
 		u.PartSize = defaultS3PartSize
 	})
 
-	return u
+	return u, nil
 }
 
 func getEC2Region() (string, error) {
@@ -85,30 +101,49 @@ func (u *S3Uploader) Upload(local string, remote string, ctypeFunc ContentTypeIn
 		}
 	}()
 
-	return u.uploadFile(f, remote, contentType)
+	return u.uploadFile(f, remote, contentType, "")
+}
+
+// UploadStream writes everything read from local to a single S3 object. Compressed streams are stored as they are, with
+// a Content-Type of i.e. application/gzip, so the .gz objects they're uploaded to are fetched still compressed.
+func (u *S3Uploader) UploadStream(local io.Reader, remote, contentType, contentEncoding string) error {
+	return u.uploadFile(local, remote, contentType, contentEncoding)
 }
 
 // UploadFile writes a single file only to S3!
-func (u *S3Uploader) uploadFile(local io.Reader, remote string, contentType string) error {
+func (u *S3Uploader) uploadFile(local io.Reader, remote, contentType, contentEncoding string) error {
 	u.log.Printf("Attempting to upload file from: %s to: %s", local, path.Join(u.bucketName, remote))
+	result, err := u.s3Uploader.Upload(u.uploadInput(local, remote, contentType, contentEncoding))
+	if err != nil {
+		return err
+	}
+
+	u.log.Printf("Successfully uploaded file from: %s to: %s", local, result.Location)
+
+	return nil
+}
+
+func (u *S3Uploader) uploadInput(local io.Reader, remote, contentType, contentEncoding string) *s3manager.UploadInput {
 	if contentType == "" {
 		contentType = defaultS3ContentType
 	}
-
-	result, err := u.s3Uploader.Upload(&s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		ACL:         aws.String(defaultS3ACL),
 		ContentType: aws.String(contentType),
 		Bucket:      aws.String(u.bucketName),
-		Key:         aws.String(remote),
+		Key:         aws.String(path.Join(u.prefix, remote)),
 		Body:        local,
-	})
-	if err != nil {
-		return err
 	}
-
-	u.log.Printf("Successfully uploaded file from: %s to: %s", local, result.Location)
-
-	return nil
+	if contentEncoding != "" {
+		// Setting Content-Encoding would have clients decompress .gz and .zst objects as they fetch them, so they're
+		// stored as compressed files instead
+		if compressedContentType := CompressedContentType(contentEncoding); compressedContentType != "" {
+			input.ContentType = aws.String(compressedContentType)
+		} else {
+			input.ContentEncoding = aws.String(contentEncoding)
+		}
+	}
+	return input
 }
 
 // UploadPartOfFile copies a single file only. It doesn't preserve the cursor location in the file.
@@ -120,7 +155,7 @@ func (u *S3Uploader) UploadPartOfFile(local io.ReadSeeker, start, length int64,
 		contentType = defaultS3ContentType
 	}
 	limitLocal := io.LimitReader(local, length)
-	return u.uploadFile(limitLocal, remote, contentType)
+	return u.uploadFile(limitLocal, remote, contentType, "")
 }
 
 type logAdapter struct {
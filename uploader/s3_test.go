@@ -0,0 +1,26 @@
+package uploader
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3UploadInputCompressed(t *testing.T) {
+	u := &S3Uploader{bucketName: "bucket", prefix: "prefix"}
+
+	input := u.uploadInput(strings.NewReader(""), "app.log.gz", "text/plain", ContentEncodingGzip)
+	assert.Equal(t, ContentTypeGzip, aws.StringValue(input.ContentType))
+	assert.Nil(t, input.ContentEncoding)
+	assert.Equal(t, "prefix/app.log.gz", aws.StringValue(input.Key))
+
+	input = u.uploadInput(strings.NewReader(""), "app.log.zst", "text/plain", ContentEncodingZstd)
+	assert.Equal(t, ContentTypeZstd, aws.StringValue(input.ContentType))
+	assert.Nil(t, input.ContentEncoding)
+
+	input = u.uploadInput(strings.NewReader(""), "app.log", "", "")
+	assert.Equal(t, defaultS3ContentType, aws.StringValue(input.ContentType))
+	assert.Nil(t, input.ContentEncoding)
+}
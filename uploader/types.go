@@ -9,6 +9,8 @@ import (
 type Uploader interface {
 	Upload(local, remote string, ctypeFunc ContentTypeInferenceFunction) error
 	UploadPartOfFile(local io.ReadSeeker, start, length int64, remote, contentType string) error
+	// UploadStream uploads everything read from local. contentEncoding is set if what's read is compressed, i.e. "gzip".
+	UploadStream(local io.Reader, remote, contentType, contentEncoding string) error
 }
 
 // ContentTypeInferenceFunction is the callback that can be used to set the mime type of a file at upload time
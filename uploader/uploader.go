@@ -65,6 +65,17 @@ func NewUploadersFromUploaderArray(uploaders []Uploader) *Uploaders {
 	return e
 }
 
+// NewUploadersWith creates a new instance of an Uploaders object, which uses the uploaders of base, followed by the given uploaders.
+// base may be nil.
+func NewUploadersWith(base *Uploaders, uploaders ...Uploader) *Uploaders {
+	e := &Uploaders{}
+	if base != nil {
+		e.uploaders = append(e.uploaders, base.uploaders...)
+	}
+	e.uploaders = append(e.uploaders, uploaders...)
+	return e
+}
+
 // Performs a parallel upload of all of files in a directory but
 // not its subdirectories. A slice containing the error results for
 // each upload with an error is returned.
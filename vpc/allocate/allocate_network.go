@@ -7,13 +7,14 @@ import (
 	"os"
 	"os/signal"
 	"reflect"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"path/filepath"
 
 	"github.com/Netflix/titus-executor/fslocker"
-	"github.com/Netflix/titus-executor/vpc"
 	"github.com/Netflix/titus-executor/vpc/context"
 	"github.com/Netflix/titus-executor/vpc/ec2wrapper"
 	"github.com/Netflix/titus-executor/vpc/types"
@@ -26,8 +27,25 @@ import (
 var (
 	errInterfaceNotFoundAtIndex   = errors.New("Network interface not found at index")
 	errSecurityGroupsNotConverged = errors.New("Security groups for interface not converged")
+	errNoFreeIPAddress            = errors.New("No free IP address on interface, even after assigning more")
+	errInterfaceDraining          = errors.New("Interface draining for a security group change")
+	errSecurityGroupChangeQueued  = errors.New("Interface draining for a change to the requested security groups")
+	errInterfaceDrainTimeout      = errors.New("Timed out waiting for interface to drain for a security group change")
 )
 
+// queuedSecurityGroupChangeWaiters counts the allocations waiting on a security group change which another one queued
+var queuedSecurityGroupChangeWaiters int32
+
+// interfaceInUseError is returned if an interface has other security groups, and other allocations are using it, so
+// they can't be changed
+type interfaceInUseError struct {
+	securityGroups map[string]struct{}
+}
+
+func (e *interfaceInUseError) Error() string {
+	return fmt.Sprintf("Interface currently in use by other security groups: %v", e.securityGroups)
+}
+
 var AllocateNetwork = cli.Command{ // nolint: golint
 	Name:   "allocate-network",
 	Usage:  "Allocate networking for a particular VPC",
@@ -51,60 +69,70 @@ var AllocateNetwork = cli.Command{ // nolint: golint
 			Usage: "How long to wait for security groups to converge, in seconds",
 			Value: 10 * time.Second,
 		},
+		cli.BoolFlag{
+			Name:  "allocate-ipv6-address",
+			Usage: "Allocate an IPv6 address, in addition to the IPv4 address",
+		},
+		cli.BoolFlag{
+			Name:  "any-interface",
+			Usage: "Allocate from another interface if the one at device-idx is in use by other security groups, or out of addresses. device-idx is optional with this",
+		},
+		cli.DurationFlag{
+			Name:  "security-group-queue-timeout",
+			Usage: "How long to wait for an interface in use by other security groups to drain, so it can be reconfigured. 0 doesn't wait",
+		},
 	},
 }
 
-func getCommandLine(parentCtx *context.VPCContext) (securityGroups map[string]struct{}, batchSize, deviceIdx int, securityConvergenceTimeout time.Duration, retErr error) {
-	var err error
-
-	deviceIdx = parentCtx.CLIContext.Int("device-idx")
-	if deviceIdx <= 0 {
+func getCommandLine(parentCtx *context.VPCContext) (req types.AllocationRequest, retErr error) {
+	req.AnyInterface = parentCtx.CLIContext.Bool("any-interface")
+	req.DeviceIndex = parentCtx.CLIContext.Int("device-idx")
+	if req.DeviceIndex < 0 || (req.DeviceIndex == 0 && !req.AnyInterface) {
 		retErr = cli.NewExitError("device-idx required", 1)
 		return
 	}
 
-	if sgStringList := parentCtx.CLIContext.String("security-groups"); sgStringList == "" {
-		securityGroups, err = getDefaultSecurityGroups(parentCtx)
-		if err != nil {
-			retErr = cli.NewMultiError(cli.NewExitError("Unable to fetch default security groups required", 1), err)
-			return
-		}
-	} else {
-		securityGroups = make(map[string]struct{})
-		for _, sgID := range strings.Split(sgStringList, ",") {
-			securityGroups[sgID] = struct{}{}
-		}
+	if sgStringList := parentCtx.CLIContext.String("security-groups"); sgStringList != "" {
+		req.SecurityGroups = strings.Split(sgStringList, ",")
 	}
 
-	batchSize = parentCtx.CLIContext.Int("batch-size")
-	if batchSize <= 0 {
+	req.BatchSize = parentCtx.CLIContext.Int("batch-size")
+	if req.BatchSize <= 0 {
 		retErr = cli.NewExitError("Invalid batchsize", 1)
 	}
 
-	securityConvergenceTimeout = parentCtx.CLIContext.Duration("security-convergence-timeout")
-	if securityConvergenceTimeout <= 0 {
+	req.SecurityConvergenceTimeout = parentCtx.CLIContext.Duration("security-convergence-timeout")
+	if req.SecurityConvergenceTimeout <= 0 {
 		retErr = cli.NewExitError("Invalid security convergence timeout", 1)
 	}
 
+	req.SecurityGroupQueueTimeout = parentCtx.CLIContext.Duration("security-group-queue-timeout")
+	if req.SecurityGroupQueueTimeout < 0 {
+		retErr = cli.NewExitError("Invalid security group queue timeout", 1)
+	}
+
+	req.IPv6 = parentCtx.CLIContext.Bool("allocate-ipv6-address")
+
 	return
 }
 
 func allocateNetwork(parentCtx *context.VPCContext) error {
-	var err error
-
-	securityGroups, batchSize, deviceIdx, securityConvergenceTimeout, err := getCommandLine(parentCtx)
+	req, err := getCommandLine(parentCtx)
 	if err != nil {
 		return err
 	}
 
 	parentCtx.Logger.WithFields(map[string]interface{}{
-		"deviceIdx":                  deviceIdx,
-		"security-groups":            securityGroups,
-		"batch-size":                 batchSize,
-		"securityConvergenceTimeout": securityConvergenceTimeout,
+		"deviceIdx":                  req.DeviceIndex,
+		"security-groups":            req.SecurityGroups,
+		"batch-size":                 req.BatchSize,
+		"securityConvergenceTimeout": req.SecurityConvergenceTimeout,
+		"securityGroupQueueTimeout":  req.SecurityGroupQueueTimeout,
+		"anyInterface":               req.AnyInterface,
+		"ipv6":                       req.IPv6,
 	}).Debug()
 
-	allocation, err := doAllocateNetwork(parentCtx, deviceIdx, batchSize, securityGroups, securityConvergenceTimeout)
+	lease, err := NewAllocator(parentCtx).Allocate(parentCtx, req)
 	if err != nil {
 		errors := []error{cli.NewExitError("Unable to setup networking", 1), err}
 		err = json.NewEncoder(os.Stdout).Encode(types.Allocation{Success: false, Error: err.Error()})
@@ -113,35 +141,22 @@ func allocateNetwork(parentCtx *context.VPCContext) error {
 		}
 		return cli.NewMultiError(errors...)
 	}
-	ctx := parentCtx.WithField("ip", allocation.ipAddress)
-	ctx.Logger.Info("Network setup")
-	// TODO: Output JSON as to new network settings
-	err = json.NewEncoder(os.Stdout).Encode(types.Allocation{IPV4Address: allocation.ipAddress, DeviceIndex: deviceIdx, Success: true, ENI: allocation.eni})
+	ctx := parentCtx.WithField("ip", lease.Allocation().IPV4Address)
+	ctx.Logger.WithField("eni", lease.Allocation().ENI).WithField("security-groups", lease.Allocation().SecurityGroups).Info("Network setup")
+	err = json.NewEncoder(os.Stdout).Encode(lease.Allocation())
 	if err != nil {
+		lease.Release()
 		return cli.NewMultiError(cli.NewExitError("Unable to write allocation record", 1), err)
 	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, unix.SIGTERM, unix.SIGINT)
-	ticker := time.NewTicker(vpc.RefreshInterval)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-c:
-			goto exit
-		case <-ticker.C:
-			err = allocation.refresh()
-			if err != nil {
-				ctx.Logger.Error("Unable to refresh IP allocation record: ", err)
-			}
-		}
-	}
-exit:
-	parentCtx.Logger.Info("Beginning shutdown, and deallocation: ", allocation)
+	<-c
 
-	allocation.deallocate(ctx)
+	ctx.Logger.Info("Beginning shutdown, and deallocation")
+	lease.Release()
 	// TODO: Teardown turned up network namespace
-	parentCtx.Logger.Info("Finished shutting down and deallocating")
+	ctx.Logger.Info("Finished shutting down and deallocating")
 	return nil
 }
 
@@ -149,15 +164,27 @@ type allocation struct {
 	sharedSGLock    *fslocker.SharedLock
 	exclusiveIPLock *fslocker.ExclusiveLock
 	ipAddress       string
-	eni             string
+	// exclusiveIP6Lock is nil, and ip6Address is empty, if an IPv6 address wasn't requested
+	exclusiveIP6Lock *fslocker.ExclusiveLock
+	ip6Address       string
+	eni              string
+	deviceIndex      int
+	// securityGroups are sorted
+	securityGroups []string
 }
 
 func (a *allocation) refresh() error {
 	a.exclusiveIPLock.Bump()
+	if a.exclusiveIP6Lock != nil {
+		a.exclusiveIP6Lock.Bump()
+	}
 	return nil
 }
 
 func (a *allocation) deallocate(ctx *context.VPCContext) {
+	if a.exclusiveIP6Lock != nil {
+		a.exclusiveIP6Lock.Unlock()
+	}
 	a.exclusiveIPLock.Unlock()
 	a.sharedSGLock.Unlock()
 }
@@ -174,24 +201,111 @@ func getDefaultSecurityGroups(parentCtx *context.VPCContext) (map[string]struct{
 	return primaryInterface.SecurityGroupIds, nil
 }
 
-func doAllocateNetwork(parentCtx *context.VPCContext, deviceIdx, batchSize int, securityGroups map[string]struct{}, securityConvergenceTimeout time.Duration) (*allocation, error) {
-	// 1. Ensure security groups are setup
-	ctx, cancel := parentCtx.WithTimeout(5 * time.Minute)
+// doAllocateNetwork allocates from the interface at the requested device index. If any interface will do, and that one
+// is in use by other security groups, draining, or out of addresses, it moves on to the others. If they're all
+// unavailable, and the request allows queueing, it waits for a change to the requested security groups which is already
+// queued on an interface, or else for an interface which is in use by other security groups to drain, and reconfigures
+// it.
+func doAllocateNetwork(parentCtx *context.VPCContext, req types.AllocationRequest, securityGroups map[string]struct{}) (*allocation, error) {
+	ctx, cancel := parentCtx.WithTimeout(5*time.Minute + req.SecurityGroupQueueTimeout)
 	defer cancel()
 
-	networkInterface, err := getInterfaceByIdx(ctx, deviceIdx)
+	candidates, err := candidateInterfaces(ctx, req, securityGroups)
 	if err != nil {
-		ctx.Logger.Warning("Unable to get interface by idx: ", err)
+		ctx.Logger.Warning("Unable to get candidate interfaces: ", err)
 		return nil, err
 	}
-	sharedSGLock, err := setupSecurityGroups(ctx, networkInterface, securityGroups, securityConvergenceTimeout)
+
+	var lastErr error
+	// Interfaces already being changed to the requested security groups are waited on before ones in use by others
+	queued, busy := []*ec2wrapper.EC2NetworkInterface{}, []*ec2wrapper.EC2NetworkInterface{}
+	for _, networkInterface := range candidates {
+		alloc, allocErr := allocateFromInterface(ctx.WithField("eni", networkInterface.InterfaceID), networkInterface, req, securityGroups, 0)
+		if allocErr == nil {
+			return alloc, nil
+		}
+		lastErr = allocErr
+		if allocErr == errSecurityGroupChangeQueued {
+			queued = append(queued, networkInterface)
+			continue
+		}
+		if _, ok := allocErr.(*interfaceInUseError); ok {
+			busy = append(busy, networkInterface)
+			continue
+		}
+		if req.AnyInterface && (allocErr == errInterfaceDraining || allocErr == errMaxIPAddressesAllocated || allocErr == errNoFreeIPAddress) {
+			ctx.Logger.WithField("eni", networkInterface.InterfaceID).Info("Trying another interface, because: ", allocErr)
+			continue
+		}
+		return nil, allocErr
+	}
+
+	if busy = append(queued, busy...); len(busy) > 0 && req.SecurityGroupQueueTimeout > 0 {
+		return allocateFromInterface(ctx.WithField("eni", busy[0].InterfaceID), busy[0], req, securityGroups, req.SecurityGroupQueueTimeout)
+	}
+	return nil, lastErr
+}
+
+// candidateInterfaces returns the interfaces to try to allocate from, in order. That's just the one at the requested
+// device index, unless any interface will do, in which case it's followed by the interfaces which already have the
+// requested security groups, and then the rest, by device index. The primary interface is never a candidate.
+func candidateInterfaces(ctx *context.VPCContext, req types.AllocationRequest, securityGroups map[string]struct{}) ([]*ec2wrapper.EC2NetworkInterface, error) {
+	if !req.AnyInterface {
+		networkInterface, err := getInterfaceByIdx(ctx, req.DeviceIndex)
+		if err != nil {
+			return nil, err
+		}
+		return []*ec2wrapper.EC2NetworkInterface{networkInterface}, nil
+	}
+
+	allInterfaces, err := ctx.EC2metadataClientWrapper.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	candidates := []*ec2wrapper.EC2NetworkInterface{}
+	for mac := range allInterfaces {
+		networkInterface := allInterfaces[mac]
+		if networkInterface.DeviceNumber != 0 {
+			candidates = append(candidates, &networkInterface)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errInterfaceNotFoundAtIndex
+	}
+
+	rank := func(networkInterface *ec2wrapper.EC2NetworkInterface) int {
+		if networkInterface.DeviceNumber == req.DeviceIndex {
+			return 0
+		}
+		if reflect.DeepEqual(securityGroups, networkInterface.SecurityGroupIds) {
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(candidates, func(i, k int) bool {
+		if rank(candidates[i]) != rank(candidates[k]) {
+			return rank(candidates[i]) < rank(candidates[k])
+		}
+		return candidates[i].DeviceNumber < candidates[k].DeviceNumber
+	})
+	return candidates, nil
+}
+
+func allocateFromInterface(ctx *context.VPCContext, networkInterface *ec2wrapper.EC2NetworkInterface, req types.AllocationRequest, securityGroups map[string]struct{}, securityGroupQueueTimeout time.Duration) (*allocation, error) {
+	// 1. Ensure security groups are setup
+	sharedSGLock, err := setupSecurityGroups(ctx, networkInterface, securityGroups, req.SecurityConvergenceTimeout, securityGroupQueueTimeout)
 	if err != nil {
 		ctx.Logger.Warning("Unable to setup security groups: ", err)
 		return nil, err
 	}
 	// 2. Get a (free) IP
-	ip, ipLock, err := NewIPPoolManager(networkInterface).allocate(ctx, batchSize)
+	ipPoolManager := NewIPPoolManager(networkInterface)
+	ip, ipLock, err := ipPoolManager.allocate(ctx, ipv4, req.BatchSize)
+	if err == nil && ipLock == nil {
+		err = errNoFreeIPAddress
+	}
 	if err != nil {
+		sharedSGLock.Unlock()
 		return nil, err
 	}
 	allocation := &allocation{
@@ -199,11 +313,35 @@ func doAllocateNetwork(parentCtx *context.VPCContext, deviceIdx, batchSize int,
 		exclusiveIPLock: ipLock,
 		ipAddress:       ip,
 		eni:             networkInterface.InterfaceID,
+		deviceIndex:     networkInterface.DeviceNumber,
+		securityGroups:  sortedSecurityGroups(securityGroups),
+	}
+
+	// 3. Maybe get a (free) IPv6 address
+	if req.IPv6 {
+		allocation.ip6Address, allocation.exclusiveIP6Lock, err = ipPoolManager.allocate(ctx, ipv6, req.BatchSize)
+		if err == nil && allocation.exclusiveIP6Lock == nil {
+			err = errNoFreeIPAddress
+		}
+		if err != nil {
+			ctx.Logger.Warning("Unable to allocate IPv6 address: ", err)
+			allocation.deallocate(ctx)
+			return nil, err
+		}
 	}
 
 	return allocation, nil
 }
 
+func sortedSecurityGroups(securityGroups map[string]struct{}) []string {
+	ret := make([]string, 0, len(securityGroups))
+	for sgID := range securityGroups {
+		ret = append(ret, sgID)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
 func reconfigureSecurityGroups(ctx *context.VPCContext, networkInterface *ec2wrapper.EC2NetworkInterface, securityGroups map[string]struct{}, sgConfigurationLock *fslocker.SharedLock, securityConvergenceTimeout time.Duration) (*fslocker.SharedLock, error) {
 	// If we're supposed to reconfigure security groups, it means no one else should have a lock on the interface
 	lockFree := 0 * time.Second
@@ -211,11 +349,17 @@ func reconfigureSecurityGroups(ctx *context.VPCContext, networkInterface *ec2wra
 	if err != nil {
 		sgConfigurationLock.Unlock()
 		if err == unix.EWOULDBLOCK {
-			return nil, fmt.Errorf("Interface currently in use by other security groups: %v", networkInterface.SecurityGroupIds)
+			return nil, &interfaceInUseError{securityGroups: networkInterface.SecurityGroupIds}
 		}
 		return nil, err
 	}
 
+	return changeSecurityGroups(ctx, networkInterface, securityGroups, sgReconfigurationLock, securityConvergenceTimeout)
+}
+
+// changeSecurityGroups must be called with the exclusive lock on the interface's current security group configuration,
+// which it downgrades once they've converged, or lets go of if they don't
+func changeSecurityGroups(ctx *context.VPCContext, networkInterface *ec2wrapper.EC2NetworkInterface, securityGroups map[string]struct{}, sgReconfigurationLock *fslocker.ExclusiveLock, securityConvergenceTimeout time.Duration) (*fslocker.SharedLock, error) {
 	groups := []*string{}
 	for sgID := range securityGroups {
 		groups = append(groups, aws.String(sgID))
@@ -225,8 +369,7 @@ func reconfigureSecurityGroups(ctx *context.VPCContext, networkInterface *ec2wra
 		Groups:             groups,
 	}
 
-	svc := ec2.New(ctx.AWSSession)
-	_, err = svc.ModifyNetworkInterfaceAttributeWithContext(ctx, modifyNetworkInterfaceAttributeInput)
+	_, err := ctx.EC2.ModifyNetworkInterfaceAttributeWithContext(ctx, modifyNetworkInterfaceAttributeInput)
 	if err != nil {
 		ctx.Logger.Warning("Unable to reconfigure security groups: ", err)
 		sgReconfigurationLock.Unlock()
@@ -242,7 +385,13 @@ func reconfigureSecurityGroups(ctx *context.VPCContext, networkInterface *ec2wra
 	return sgReconfigurationLock.ToSharedLock(), nil
 }
 
-func setupSecurityGroups(ctx *context.VPCContext, networkInterface *ec2wrapper.EC2NetworkInterface, securityGroups map[string]struct{}, securityConvergenceTimeout time.Duration) (*fslocker.SharedLock, error) {
+// setupSecurityGroups uses three locks per interface. security-group-reconfig is held exclusively while deciding whether
+// to use, reconfigure, or queue a reconfiguration of the interface. security-group-current-config is shared by the
+// allocations using the interface, and held exclusively while its security groups are changed. security-group-pending is
+// held exclusively by an allocation waiting for the interface to drain, so it can change them, and new allocations stay
+// off the interface while it's held, so that it does drain. The exception is allocations which want the security groups
+// the interface is being changed to, which wait for the change instead.
+func setupSecurityGroups(ctx *context.VPCContext, networkInterface *ec2wrapper.EC2NetworkInterface, securityGroups map[string]struct{}, securityConvergenceTimeout, securityGroupQueueTimeout time.Duration) (*fslocker.SharedLock, error) {
 	lockTimeout := time.Minute
 	maybeReconfigurationLockPath := filepath.Join(networkInterface.LockPath(), "security-group-reconfig")
 	maybeReconfigurationLock, err := ctx.FSLocker.ExclusiveLock(maybeReconfigurationLockPath, &lockTimeout)
@@ -252,6 +401,23 @@ func setupSecurityGroups(ctx *context.VPCContext, networkInterface *ec2wrapper.E
 	}
 	defer maybeReconfigurationLock.Unlock()
 
+	draining, pendingSecurityGroups, err := isDraining(ctx, networkInterface)
+	if err != nil {
+		return nil, err
+	}
+	if draining {
+		if !reflect.DeepEqual(securityGroups, pendingSecurityGroups) {
+			return nil, errInterfaceDraining
+		}
+		// Allocations which want the security groups the interface is being changed to can wait for the change, rather
+		// than queue one of their own
+		if securityGroupQueueTimeout <= 0 {
+			return nil, errSecurityGroupChangeQueued
+		}
+		maybeReconfigurationLock.Unlock()
+		return waitForQueuedSecurityGroupChange(ctx, networkInterface, securityGroups, securityConvergenceTimeout, securityGroupQueueTimeout)
+	}
+
 	// Although nobody should be holding an exclusive lock on security-group-current-config in the critical section, we
 	// should still get the shared lock for safety.
 	sgConfigureLockPath := filepath.Join(networkInterface.LockPath(), "security-group-current-config")
@@ -274,6 +440,9 @@ func setupSecurityGroups(ctx *context.VPCContext, networkInterface *ec2wrapper.E
 
 	ctx.Logger.Info("Reconfiguring security groups")
 	sharedLock, err := reconfigureSecurityGroups(ctx, networkInterface, securityGroups, sgConfigurationLock, securityConvergenceTimeout)
+	if _, ok := err.(*interfaceInUseError); ok && securityGroupQueueTimeout > 0 {
+		return queueSecurityGroupChange(ctx, networkInterface, securityGroups, maybeReconfigurationLock, securityConvergenceTimeout, securityGroupQueueTimeout)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -281,6 +450,129 @@ func setupSecurityGroups(ctx *context.VPCContext, networkInterface *ec2wrapper.E
 	return sharedLock, nil
 }
 
+// isDraining checks whether a security group change is queued on the interface, and if so, what security groups it's
+// changing them to. They're nil if the change was queued by an allocation which didn't record them.
+func isDraining(ctx *context.VPCContext, networkInterface *ec2wrapper.EC2NetworkInterface) (bool, map[string]struct{}, error) {
+	lockFree := 0 * time.Second
+	sgPendingLock, err := ctx.FSLocker.SharedLock(filepath.Join(networkInterface.LockPath(), "security-group-pending"), &lockFree)
+	if err == unix.EWOULDBLOCK {
+		pendingSecurityGroups, err := queuedSecurityGroups(ctx, networkInterface)
+		return true, pendingSecurityGroups, err
+	} else if err != nil {
+		return false, nil, err
+	}
+	sgPendingLock.Unlock()
+	return false, nil, nil
+}
+
+// queuedSecurityGroups returns the security groups of the queued change on the interface. The allocation which queued it
+// holds a lock named after them in security-group-pending-target, any others are left over from allocations which died.
+func queuedSecurityGroups(ctx *context.VPCContext, networkInterface *ec2wrapper.EC2NetworkInterface) (map[string]struct{}, error) {
+	targetPath := filepath.Join(networkInterface.LockPath(), "security-group-pending-target")
+	records, err := ctx.FSLocker.ListFiles(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	lockFree := 0 * time.Second
+	for _, record := range records {
+		targetLock, err := ctx.FSLocker.SharedLock(filepath.Join(targetPath, record.Name), &lockFree)
+		if err == nil {
+			targetLock.Unlock()
+			continue
+		} else if err != unix.EWOULDBLOCK {
+			return nil, err
+		}
+		securityGroups := make(map[string]struct{})
+		for _, sgID := range strings.Split(record.Name, ",") {
+			securityGroups[sgID] = struct{}{}
+		}
+		return securityGroups, nil
+	}
+	return nil, nil
+}
+
+// waitForQueuedSecurityGroupChange waits for the change queued on the interface to finish, and then sets up its
+// security groups as usual. By then, they should already be the ones that were asked for.
+func waitForQueuedSecurityGroupChange(ctx *context.VPCContext, networkInterface *ec2wrapper.EC2NetworkInterface, securityGroups map[string]struct{}, securityConvergenceTimeout, securityGroupQueueTimeout time.Duration) (*fslocker.SharedLock, error) {
+	ctx.Logger.Info("Waiting for queued security group change")
+	atomic.AddInt32(&queuedSecurityGroupChangeWaiters, 1)
+	defer atomic.AddInt32(&queuedSecurityGroupChangeWaiters, -1)
+	// The lock is polled for, rather than waited on with a timeout, as FSLocker holds up RemovePath while a lock is
+	// being waited on, and the allocation which queued the change removes its security-group-pending-target lock
+	lockFree := 0 * time.Second
+	start := time.Now()
+	for {
+		sgPendingLock, err := ctx.FSLocker.SharedLock(filepath.Join(networkInterface.LockPath(), "security-group-pending"), &lockFree)
+		if err == nil {
+			sgPendingLock.Unlock()
+			return setupSecurityGroups(ctx, networkInterface, securityGroups, securityConvergenceTimeout, securityGroupQueueTimeout-time.Since(start))
+		} else if err != unix.EWOULDBLOCK {
+			return nil, err
+		}
+		if time.Since(start) > securityGroupQueueTimeout {
+			return nil, errInterfaceDrainTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(refreshInterval):
+		}
+	}
+}
+
+// queueSecurityGroupChange must be called with the security-group-reconfig lock, which it lets go of once the change
+// is queued, so that allocations on other interfaces, and other processes, aren't held up while this one waits
+func queueSecurityGroupChange(ctx *context.VPCContext, networkInterface *ec2wrapper.EC2NetworkInterface, securityGroups map[string]struct{}, maybeReconfigurationLock *fslocker.ExclusiveLock, securityConvergenceTimeout, securityGroupQueueTimeout time.Duration) (*fslocker.SharedLock, error) {
+	lockFree := 0 * time.Second
+	sgPendingLock, err := ctx.FSLocker.ExclusiveLock(filepath.Join(networkInterface.LockPath(), "security-group-pending"), &lockFree)
+	if err == unix.EWOULDBLOCK {
+		return nil, errInterfaceDraining
+	} else if err != nil {
+		return nil, err
+	}
+	defer sgPendingLock.Unlock()
+	targetLockPath := filepath.Join(networkInterface.LockPath(), "security-group-pending-target", strings.Join(sortedSecurityGroups(securityGroups), ","))
+	targetLock, err := ctx.FSLocker.ExclusiveLock(targetLockPath, &lockFree)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := ctx.FSLocker.RemovePath(targetLockPath); err != nil {
+			ctx.Logger.Warning("Unable to remove security-group-pending-target lock: ", err)
+		}
+		targetLock.Unlock()
+	}()
+	maybeReconfigurationLock.Unlock()
+
+	ctx.Logger.WithField("currentSecurityGroups", networkInterface.SecurityGroupIds).Info("Waiting for interface to drain before reconfiguring security groups")
+	sgConfigureLockPath := filepath.Join(networkInterface.LockPath(), "security-group-current-config")
+	start := time.Now()
+	for {
+		sgReconfigurationLock, err := ctx.FSLocker.ExclusiveLock(sgConfigureLockPath, &lockFree)
+		if err == nil {
+			if err = networkInterface.Refresh(); err != nil {
+				sgReconfigurationLock.Unlock()
+				return nil, err
+			}
+			if reflect.DeepEqual(securityGroups, networkInterface.SecurityGroupIds) {
+				return sgReconfigurationLock.ToSharedLock(), nil
+			}
+			ctx.Logger.Info("Interface drained, reconfiguring security groups")
+			return changeSecurityGroups(ctx, networkInterface, securityGroups, sgReconfigurationLock, securityConvergenceTimeout)
+		} else if err != unix.EWOULDBLOCK {
+			return nil, err
+		}
+		if time.Since(start) > securityGroupQueueTimeout {
+			return nil, errInterfaceDrainTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(refreshInterval):
+		}
+	}
+}
+
 func waitForSecurityGroupToConverge(ctx *context.VPCContext, networkInterface *ec2wrapper.EC2NetworkInterface, securityGroups map[string]struct{}, securityConvergenceTimeout time.Duration) error {
 	now := time.Now()
 	for time.Since(now) < securityConvergenceTimeout {
@@ -292,7 +584,7 @@ func waitForSecurityGroupToConverge(ctx *context.VPCContext, networkInterface *e
 		if reflect.DeepEqual(securityGroups, networkInterface.SecurityGroupIds) {
 			return nil
 		}
-		time.Sleep(time.Second)
+		time.Sleep(refreshInterval)
 	}
 	return errSecurityGroupsNotConverged
 }
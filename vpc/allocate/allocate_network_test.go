@@ -0,0 +1,253 @@
+package allocate
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Netflix/titus-executor/vpc/ec2wrapper"
+	"github.com/Netflix/titus-executor/vpc/fakeec2"
+	"github.com/Netflix/titus-executor/vpc/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	// The fake's metadata catches up within milliseconds, so there's no need to poll it as slowly as the real one
+	refreshInterval, gcRefreshInterval = 10*time.Millisecond, 10*time.Millisecond
+	os.Exit(m.Run())
+}
+
+func securityGroupsOf(fake *fakeec2.EC2, eni string) []string {
+	var ret []string
+	for _, group := range fake.Interface(eni).Groups {
+		ret = append(ret, aws.StringValue(group.GroupId))
+	}
+	return ret
+}
+
+func TestConcurrentAllocations(t *testing.T) {
+	fake := fakeec2.New("c5.large")
+	eni, err := fake.AttachNewInterface(1)
+	require.NoError(t, err)
+	fake.MetadataDelay = 50 * time.Millisecond
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	// c5.large interfaces have 10 IPv4 addresses, including the primary one, which is allocated first
+	const allocations = 10
+	var wg sync.WaitGroup
+	results := make(chan *allocation, allocations)
+	for i := 0; i < allocations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			alloc, allocErr := doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{fakeec2.DefaultSecurityGroup: {}})
+			assert.NoError(t, allocErr)
+			results <- alloc
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	assigned := make(map[string]struct{})
+	for _, addr := range fake.Interface(eni).PrivateIpAddresses {
+		assigned[aws.StringValue(addr.PrivateIpAddress)] = struct{}{}
+	}
+	allocated := make(map[string]struct{})
+	for alloc := range results {
+		require.NotNil(t, alloc)
+		defer alloc.deallocate(vpcCtx)
+		assert.Equal(t, eni, alloc.eni)
+		_, ok := assigned[alloc.ipAddress]
+		assert.True(t, ok, "%s isn't assigned to the interface", alloc.ipAddress)
+		allocated[alloc.ipAddress] = struct{}{}
+	}
+	assert.Len(t, allocated, allocations, "Every allocation should get its own IP address")
+
+	_, err = doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{fakeec2.DefaultSecurityGroup: {}})
+	assert.Equal(t, errMaxIPAddressesAllocated, err)
+}
+
+func TestAllocateIPv6(t *testing.T) {
+	fake := fakeec2.New("c5.large")
+	eni, err := fake.AttachNewInterface(1)
+	require.NoError(t, err)
+	fake.MetadataDelay = 50 * time.Millisecond
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	alloc, err := doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second, IPv6: true}, map[string]struct{}{fakeec2.DefaultSecurityGroup: {}})
+	require.NoError(t, err)
+	defer alloc.deallocate(vpcCtx)
+	require.NotEmpty(t, alloc.ip6Address)
+	assert.Equal(t, alloc.ip6Address, aws.StringValue(fake.Interface(eni).Ipv6Addresses[0].Ipv6Address))
+}
+
+func TestSecurityGroupReconfigurationConflict(t *testing.T) {
+	fake := fakeec2.New("c5.large", "sg-a", "sg-b")
+	eni, err := fake.AttachNewInterface(1)
+	require.NoError(t, err)
+	fake.MetadataDelay = 50 * time.Millisecond
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	allocA, err := doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{"sg-a": {}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sg-a"}, securityGroupsOf(fake, eni))
+
+	// The interface can't be reconfigured while another allocation is using it
+	_, err = doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{"sg-b": {}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Interface currently in use by other security groups")
+	assert.Equal(t, []string{"sg-a"}, securityGroupsOf(fake, eni))
+
+	// Allocations with the same security groups share it
+	allocA2, err := doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{"sg-a": {}})
+	require.NoError(t, err)
+	assert.NotEqual(t, allocA.ipAddress, allocA2.ipAddress)
+
+	allocA.deallocate(vpcCtx)
+	allocA2.deallocate(vpcCtx)
+	allocB, err := doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{"sg-b": {}})
+	require.NoError(t, err)
+	defer allocB.deallocate(vpcCtx)
+	assert.Equal(t, []string{"sg-b"}, securityGroupsOf(fake, eni))
+}
+
+func TestSecurityGroupErrors(t *testing.T) {
+	fake := fakeec2.New("c5.large", "sg-a")
+	_, err := fake.AttachNewInterface(1)
+	require.NoError(t, err)
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	_, err = doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{"sg-missing": {}})
+	require.Error(t, err)
+	awsErr, ok := err.(awserr.Error)
+	require.True(t, ok, "Error should come from EC2: %v", err)
+	assert.Equal(t, "InvalidGroup.NotFound", awsErr.Code())
+
+	// The change doesn't show up in the metadata service in time
+	fake.MetadataDelay = time.Second
+	_, err = doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: 100 * time.Millisecond}, map[string]struct{}{"sg-a": {}})
+	assert.Equal(t, errSecurityGroupsNotConverged, err)
+
+	_, err = doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 2, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{"sg-a": {}})
+	assert.Equal(t, errInterfaceNotFoundAtIndex, err)
+}
+
+func TestAllocateFromAnyInterface(t *testing.T) {
+	fake := fakeec2.New("c5.large", "sg-a", "sg-b")
+	eni1, err := fake.AttachNewInterface(1)
+	require.NoError(t, err)
+	eni2, err := fake.AttachNewInterface(2)
+	require.NoError(t, err)
+	fake.MetadataDelay = 50 * time.Millisecond
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	allocA, err := doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{"sg-a": {}})
+	require.NoError(t, err)
+	defer allocA.deallocate(vpcCtx)
+
+	// Interface 1 is in use by sg-a, so sg-b goes to the idle interface 2
+	allocB, err := doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, AnyInterface: true, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{"sg-b": {}})
+	require.NoError(t, err)
+	defer allocB.deallocate(vpcCtx)
+	assert.Equal(t, eni2, allocB.eni)
+	assert.Equal(t, 2, allocB.deviceIndex)
+	assert.Equal(t, []string{"sg-b"}, allocB.securityGroups)
+	assert.Equal(t, []string{"sg-a"}, securityGroupsOf(fake, eni1))
+	assert.Equal(t, []string{"sg-b"}, securityGroupsOf(fake, eni2))
+
+	// Without a preference, interfaces which already have the security groups come first
+	allocA2, err := doAllocateNetwork(vpcCtx, types.AllocationRequest{AnyInterface: true, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{"sg-a": {}})
+	require.NoError(t, err)
+	defer allocA2.deallocate(vpcCtx)
+	assert.Equal(t, eni1, allocA2.eni)
+
+	// Every interface is in use by other security groups
+	_, err = doAllocateNetwork(vpcCtx, types.AllocationRequest{AnyInterface: true, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{fakeec2.DefaultSecurityGroup: {}})
+	require.Error(t, err)
+	assert.IsType(t, &interfaceInUseError{}, err)
+}
+
+func TestQueuedSecurityGroupChange(t *testing.T) {
+	fake := fakeec2.New("c5.large", "sg-a", "sg-b")
+	eni, err := fake.AttachNewInterface(1)
+	require.NoError(t, err)
+	fake.MetadataDelay = 50 * time.Millisecond
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	allocA, err := doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{"sg-a": {}})
+	require.NoError(t, err)
+
+	// The change times out if the interface doesn't drain
+	_, err = doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second, SecurityGroupQueueTimeout: 100 * time.Millisecond}, map[string]struct{}{"sg-b": {}})
+	assert.Equal(t, errInterfaceDrainTimeout, err)
+
+	type result struct {
+		alloc *allocation
+		err   error
+	}
+	queued := make(chan result)
+	allocateB := func() {
+		alloc, allocErr := doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second, SecurityGroupQueueTimeout: 10 * time.Second}, map[string]struct{}{"sg-b": {}})
+		queued <- result{alloc: alloc, err: allocErr}
+	}
+	go allocateB()
+
+	// Once the change is queued, allocations with the interface's current security groups are kept off of it
+	networkInterface := &ec2wrapper.EC2NetworkInterface{MAC: aws.StringValue(fake.Interface(eni).MacAddress)}
+	require.NoError(t, waitFor(func() bool {
+		draining, _, drainingErr := isDraining(vpcCtx, networkInterface)
+		return drainingErr == nil && draining
+	}))
+	_, pendingSecurityGroups, err := isDraining(vpcCtx, networkInterface)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"sg-b": {}}, pendingSecurityGroups)
+	_, err = doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 2, SecurityConvergenceTimeout: time.Second}, map[string]struct{}{"sg-a": {}})
+	assert.Equal(t, errInterfaceDraining, err)
+	assert.Equal(t, []string{"sg-a"}, securityGroupsOf(fake, eni))
+
+	// Allocations with the security groups it's changing to wait for the change, instead of being turned away
+	go allocateB()
+	require.NoError(t, waitFor(func() bool {
+		return atomic.LoadInt32(&queuedSecurityGroupChangeWaiters) == 1
+	}))
+
+	allocA.deallocate(vpcCtx)
+	for i := 0; i < 2; i++ {
+		r := <-queued
+		require.NoError(t, r.err)
+		defer r.alloc.deallocate(vpcCtx)
+		assert.Equal(t, eni, r.alloc.eni)
+	}
+	assert.Equal(t, []string{"sg-b"}, securityGroupsOf(fake, eni))
+	_, pendingSecurityGroups, err = isDraining(vpcCtx, networkInterface)
+	require.NoError(t, err)
+	assert.Nil(t, pendingSecurityGroups)
+}
+
+func waitFor(condition func() bool) error {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return nil
+		}
+	}
+	return errors.New("Timed out waiting for condition")
+}
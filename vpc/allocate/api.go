@@ -0,0 +1,176 @@
+package allocate
+
+import (
+	stdcontext "context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Netflix/titus-executor/vpc"
+	"github.com/Netflix/titus-executor/vpc/context"
+	"github.com/Netflix/titus-executor/vpc/stats"
+	"github.com/Netflix/titus-executor/vpc/types"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultBatchSize                  = 4
+	defaultSecurityConvergenceTimeout = 10 * time.Second
+)
+
+var (
+	_ types.Allocator = (*allocator)(nil)
+	_ types.Lease     = (*lease)(nil)
+	_ types.Wirer     = (*wirer)(nil)
+	_ types.Wiring    = (*wiring)(nil)
+)
+
+type allocator struct {
+	vpcCtx *context.VPCContext
+}
+
+// NewAllocator returns an Allocator which allocates in the calling process. Leases are held by the calling process,
+// and are let go of if it dies.
+func NewAllocator(vpcCtx *context.VPCContext) types.Allocator {
+	return &allocator{vpcCtx: vpcCtx}
+}
+
+func (a *allocator) Allocate(ctx stdcontext.Context, req types.AllocationRequest) (types.Lease, error) {
+	vpcCtx := a.vpcCtx.WithContext(ctx).WithField("deviceIdx", req.DeviceIndex)
+	if req.DeviceIndex < 0 || (req.DeviceIndex == 0 && !req.AnyInterface) {
+		return nil, errInterfaceNotFoundAtIndex
+	}
+	if req.BatchSize <= 0 {
+		req.BatchSize = defaultBatchSize
+	}
+	if req.SecurityConvergenceTimeout <= 0 {
+		req.SecurityConvergenceTimeout = defaultSecurityConvergenceTimeout
+	}
+
+	var securityGroups map[string]struct{}
+	if len(req.SecurityGroups) == 0 {
+		var err error
+		securityGroups, err = getDefaultSecurityGroups(vpcCtx)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		securityGroups = make(map[string]struct{}, len(req.SecurityGroups))
+		for _, sgID := range req.SecurityGroups {
+			securityGroups[sgID] = struct{}{}
+		}
+	}
+
+	alloc, err := doAllocateNetwork(vpcCtx, req, securityGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &lease{
+		// The lease outlives the request, so it doesn't use its context
+		vpcCtx:     a.vpcCtx.WithField("ip", alloc.ipAddress),
+		allocation: alloc,
+		record: types.Allocation{
+			IPV4Address:    alloc.ipAddress,
+			IPV6Address:    alloc.ip6Address,
+			DeviceIndex:    alloc.deviceIndex,
+			Success:        true,
+			ENI:            alloc.eni,
+			SecurityGroups: alloc.securityGroups,
+		},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go l.refresh()
+	return l, nil
+}
+
+type lease struct {
+	vpcCtx     *context.VPCContext
+	allocation *allocation
+	record     types.Allocation
+	once       sync.Once
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func (l *lease) Allocation() types.Allocation {
+	return l.record
+}
+
+// refresh bumps the IP address' lock, so that GC knows that it's still in use
+func (l *lease) refresh() {
+	defer close(l.done)
+	ticker := time.NewTicker(vpc.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.allocation.refresh(); err != nil {
+				l.vpcCtx.Logger.Error("Unable to refresh IP allocation record: ", err)
+			}
+		}
+	}
+}
+
+func (l *lease) Release() {
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+		l.vpcCtx.Logger.Info("Deallocating")
+		l.allocation.deallocate(l.vpcCtx)
+	})
+}
+
+type wirer struct {
+	vpcCtx *context.VPCContext
+}
+
+// NewWirer returns a Wirer which wires containers up from the calling process
+func NewWirer(vpcCtx *context.VPCContext) types.Wirer {
+	return &wirer{vpcCtx: vpcCtx}
+}
+
+func (w *wirer) Wire(ctx stdcontext.Context, req types.WiringRequest) (types.Wiring, error) {
+	netnsfd, err := unix.Dup(req.NetnsFD)
+	if err != nil {
+		return nil, err
+	}
+	unix.CloseOnExec(netnsfd)
+
+	vpcCtx := w.vpcCtx.WithContext(ctx).WithField("ip", req.Allocation.IPV4Address)
+	link, err := doSetupContainer(vpcCtx, netnsfd, req.Bandwidth, req.Burst, req.Allocation, req.PortMappings)
+	if err != nil {
+		_ = unix.Close(netnsfd)
+		return nil, err
+	}
+	return &wiring{
+		vpcCtx:     w.vpcCtx.WithField("ip", req.Allocation.IPV4Address),
+		allocation: req.Allocation,
+		link:       link,
+		netnsfd:    netnsfd,
+	}, nil
+}
+
+type wiring struct {
+	vpcCtx     *context.VPCContext
+	allocation types.Allocation
+	link       netlink.Link
+	netnsfd    int
+	once       sync.Once
+}
+
+func (w *wiring) Stats() (*types.TrafficStats, error) {
+	return stats.ForIP(net.ParseIP(w.allocation.IPV4Address))
+}
+
+func (w *wiring) Teardown() {
+	w.once.Do(func() {
+		w.vpcCtx.Logger.Info("Tearing down container network")
+		teardownNetwork(w.vpcCtx, w.allocation, w.link, w.netnsfd)
+		_ = unix.Close(w.netnsfd)
+	})
+}
@@ -0,0 +1,163 @@
+package allocate
+
+import (
+	stdcontext "context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Netflix/titus-executor/fslocker"
+	"github.com/Netflix/titus-executor/vpc/context"
+	"github.com/Netflix/titus-executor/vpc/fakeec2"
+	"github.com/Netflix/titus-executor/vpc/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseRelease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vpc-lease")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	locker, err := fslocker.NewFSLocker(dir)
+	require.NoError(t, err)
+	vpcCtx := &context.VPCContext{Context: stdcontext.Background(), FSLocker: locker, Logger: logrus.NewEntry(logrus.New())}
+
+	var noTimeout time.Duration
+	sgLock, err := locker.SharedLock("interfaces/mac/security-group-current-config", &noTimeout)
+	require.NoError(t, err)
+	ipLock, err := locker.ExclusiveLock("interfaces/mac/ip-addresses/1.2.3.4", &noTimeout)
+	require.NoError(t, err)
+
+	l := &lease{
+		vpcCtx:     vpcCtx,
+		allocation: &allocation{sharedSGLock: sgLock, exclusiveIPLock: ipLock, ipAddress: "1.2.3.4", eni: "eni-1"},
+		record:     types.Allocation{IPV4Address: "1.2.3.4", ENI: "eni-1", Success: true},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go l.refresh()
+
+	_, err = locker.ExclusiveLock("interfaces/mac/ip-addresses/1.2.3.4", &noTimeout)
+	assert.Error(t, err, "The IP address should be held by the lease")
+
+	l.Release()
+	l.Release()
+	lock, err := locker.ExclusiveLock("interfaces/mac/ip-addresses/1.2.3.4", &noTimeout)
+	require.NoError(t, err, "The IP address should be free once the lease is released")
+	lock.Unlock()
+}
+
+func TestLeaseReleaseIPv6(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vpc-lease")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	locker, err := fslocker.NewFSLocker(dir)
+	require.NoError(t, err)
+	vpcCtx := &context.VPCContext{Context: stdcontext.Background(), FSLocker: locker, Logger: logrus.NewEntry(logrus.New())}
+
+	var noTimeout time.Duration
+	sgLock, err := locker.SharedLock("interfaces/mac/security-group-current-config", &noTimeout)
+	require.NoError(t, err)
+	ipLock, err := locker.ExclusiveLock("interfaces/mac/ip-addresses/1.2.3.4", &noTimeout)
+	require.NoError(t, err)
+	ip6Lock, err := locker.ExclusiveLock("interfaces/mac/ip6-addresses/2600:1f18::1234", &noTimeout)
+	require.NoError(t, err)
+
+	alloc := &allocation{
+		sharedSGLock:     sgLock,
+		exclusiveIPLock:  ipLock,
+		ipAddress:        "1.2.3.4",
+		exclusiveIP6Lock: ip6Lock,
+		ip6Address:       "2600:1f18::1234",
+		eni:              "eni-1",
+	}
+	require.NoError(t, alloc.refresh())
+	alloc.deallocate(vpcCtx)
+
+	lock, err := locker.ExclusiveLock("interfaces/mac/ip6-addresses/2600:1f18::1234", &noTimeout)
+	require.NoError(t, err, "The IPv6 address should be free once the allocation is deallocated")
+	lock.Unlock()
+}
+
+func TestAllocatorAllocate(t *testing.T) {
+	fake := fakeec2.New("c5.large", "sg-a")
+	eni, err := fake.AttachNewInterface(1)
+	require.NoError(t, err)
+	fake.MetadataDelay = 50 * time.Millisecond
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	l, err := NewAllocator(vpcCtx).Allocate(stdcontext.Background(), types.AllocationRequest{
+		DeviceIndex:                1,
+		SecurityGroups:             []string{"sg-a"},
+		SecurityConvergenceTimeout: time.Second,
+	})
+	require.NoError(t, err)
+	allocation := l.Allocation()
+	assert.True(t, allocation.Success)
+	assert.Equal(t, eni, allocation.ENI)
+	assert.Equal(t, 1, allocation.DeviceIndex)
+	assert.Equal(t, []string{"sg-a"}, allocation.SecurityGroups)
+	assert.Equal(t, []string{"sg-a"}, securityGroupsOf(fake, eni))
+
+	var noTimeout time.Duration
+	ipLockPath := filepath.Join("interfaces", aws.StringValue(fake.Interface(eni).MacAddress), "ip-addresses", allocation.IPV4Address)
+	_, err = vpcCtx.FSLocker.ExclusiveLock(ipLockPath, &noTimeout)
+	assert.Error(t, err, "The IP address should be held by the lease")
+
+	l.Release()
+	lock, err := vpcCtx.FSLocker.ExclusiveLock(ipLockPath, &noTimeout)
+	require.NoError(t, err, "The IP address should be free once the lease is released")
+	lock.Unlock()
+}
+
+func TestAllocatorDefaultSecurityGroups(t *testing.T) {
+	fake := fakeec2.New("c5.large")
+	eni, err := fake.AttachNewInterface(1)
+	require.NoError(t, err)
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	// Without security groups, the allocation gets the ones of the instance's primary interface
+	l, err := NewAllocator(vpcCtx).Allocate(stdcontext.Background(), types.AllocationRequest{DeviceIndex: 1, SecurityConvergenceTimeout: time.Second})
+	require.NoError(t, err)
+	defer l.Release()
+	assert.Equal(t, eni, l.Allocation().ENI)
+	assert.Equal(t, []string{fakeec2.DefaultSecurityGroup}, l.Allocation().SecurityGroups)
+}
+
+func TestAllocatorInvalidDeviceIndex(t *testing.T) {
+	fake := fakeec2.New("c5.large")
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	allocator := NewAllocator(vpcCtx)
+	// The primary interface is the instance's own
+	_, err = allocator.Allocate(stdcontext.Background(), types.AllocationRequest{DeviceIndex: 0})
+	assert.Equal(t, errInterfaceNotFoundAtIndex, err)
+	_, err = allocator.Allocate(stdcontext.Background(), types.AllocationRequest{DeviceIndex: 1, SecurityConvergenceTimeout: time.Second})
+	assert.Equal(t, errInterfaceNotFoundAtIndex, err)
+	assert.Equal(t, 0, fake.Calls("AssignPrivateIpAddresses"))
+}
+
+func TestParsePortMappings(t *testing.T) {
+	portMappings, err := parsePortMappings([]string{"31000:8080", "31001:31001/tcp", "31002:53/udp"})
+	require.NoError(t, err)
+	assert.Equal(t, []types.PortMapping{
+		{HostPort: 31000, ContainerPort: 8080, Protocol: types.ProtocolTCP},
+		{HostPort: 31001, ContainerPort: 31001, Protocol: types.ProtocolTCP},
+		{HostPort: 31002, ContainerPort: 53, Protocol: types.ProtocolUDP},
+	}, portMappings)
+
+	for _, invalid := range []string{"31000", "31000:0", "0:8080", "31000:70000", "31000:8080/sctp"} {
+		_, err = parsePortMappings([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
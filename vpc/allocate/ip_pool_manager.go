@@ -14,11 +14,35 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+// How long to wait between refreshes of an interface, when waiting for a change to it to show up in the metadata
+// service. They're variables, so that tests against a fake EC2 backend don't have to wait as long.
+var (
+	refreshInterval   = time.Second
+	gcRefreshInterval = 5 * time.Second
+)
+
 var (
 	errIPRefreshFailed         = errors.New("IP refresh failed")
 	errMaxIPAddressesAllocated = errors.New("Maximum number of ip addresses allocated")
 )
 
+// addressFamily is the kind of address that the pool manager allocates, or garbage collects
+type addressFamily int
+
+const (
+	ipv4 addressFamily = iota
+	ipv6
+)
+
+var addressFamilies = []addressFamily{ipv4, ipv6}
+
+func (family addressFamily) String() string {
+	if family == ipv6 {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
 // IPPoolManager encapsulates all management, and locking for a given interface. It must be constructed with NewIPPoolManager
 type IPPoolManager struct {
 	networkInterface *ec2wrapper.EC2NetworkInterface
@@ -36,46 +60,111 @@ func (mgr *IPPoolManager) lockConfiguration(parentCtx *context.VPCContext) (*fsl
 	return parentCtx.FSLocker.ExclusiveLock(path, &timeout)
 }
 
-func (mgr *IPPoolManager) assignMoreIPs(ctx *context.VPCContext, batchSize int) error {
-	if len(mgr.networkInterface.IPv4Addresses) >= vpc.GetMaxIPv4Addresses(ctx.InstanceType) {
-		return errMaxIPAddressesAllocated
+// addresses returns the addresses of the family which are assigned to the interface, as of the last refresh
+func (mgr *IPPoolManager) addresses(family addressFamily) []string {
+	if family == ipv6 {
+		return mgr.networkInterface.IPv6Addresses
 	}
+	return mgr.networkInterface.IPv4Addresses
+}
 
-	if len(mgr.networkInterface.IPv4Addresses)+batchSize > vpc.GetMaxIPv4Addresses(ctx.InstanceType) {
-		batchSize = vpc.GetMaxIPv4Addresses(ctx.InstanceType) - len(mgr.networkInterface.IPv4Addresses)
+// reclaimableAddresses returns the addresses which GC can give back to AWS. The primary IPv4 address is always the
+// first one, and it can't be unassigned from the interface.
+func (mgr *IPPoolManager) reclaimableAddresses(family addressFamily) []string {
+	addresses := mgr.addresses(family)
+	if family == ipv4 && len(addresses) > 0 {
+		return addresses[1:]
 	}
+	return addresses
+}
 
-	ctx.Logger.Info("Unable to allocate, no IP addresses available, allocating new IPs")
+func maxAddresses(ctx *context.VPCContext, family addressFamily) (int, error) {
+	limits, err := vpc.GetLimits(ctx.InstanceType)
+	if err != nil {
+		return 0, err
+	}
+	if family == ipv6 {
+		return limits.IPv6AddressesPerInterface, nil
+	}
+	return limits.IPv4AddressesPerInterface, nil
+}
+
+func (mgr *IPPoolManager) assignAddresses(ctx *context.VPCContext, family addressFamily, count int) error {
+	if family == ipv6 {
+		assignIpv6AddressesInput := &ec2.AssignIpv6AddressesInput{
+			NetworkInterfaceId: aws.String(mgr.networkInterface.InterfaceID),
+			Ipv6AddressCount:   aws.Int64(int64(count)),
+		}
+		_, err := ctx.EC2.AssignIpv6AddressesWithContext(ctx, assignIpv6AddressesInput)
+		return err
+	}
 
-	// We failed to lock an IP address, let's retry.
 	assignPrivateIPAddressesInput := &ec2.AssignPrivateIpAddressesInput{
 		NetworkInterfaceId:             aws.String(mgr.networkInterface.InterfaceID),
-		SecondaryPrivateIpAddressCount: aws.Int64(int64(batchSize)),
+		SecondaryPrivateIpAddressCount: aws.Int64(int64(count)),
+	}
+	_, err := ctx.EC2.AssignPrivateIpAddressesWithContext(ctx, assignPrivateIPAddressesInput)
+	return err
+}
+
+func (mgr *IPPoolManager) unassignAddresses(ctx *context.VPCContext, family addressFamily, addresses []string) error {
+	if family == ipv6 {
+		unassignIpv6AddressesInput := &ec2.UnassignIpv6AddressesInput{
+			Ipv6Addresses:      aws.StringSlice(addresses),
+			NetworkInterfaceId: aws.String(mgr.networkInterface.InterfaceID),
+		}
+		_, err := ctx.EC2.UnassignIpv6AddressesWithContext(ctx, unassignIpv6AddressesInput)
+		return err
 	}
-	_, err := ec2.New(ctx.AWSSession).AssignPrivateIpAddresses(assignPrivateIPAddressesInput)
+
+	unassignPrivateIPAddressesInput := &ec2.UnassignPrivateIpAddressesInput{
+		PrivateIpAddresses: aws.StringSlice(addresses),
+		NetworkInterfaceId: aws.String(mgr.networkInterface.InterfaceID),
+	}
+	_, err := ctx.EC2.UnassignPrivateIpAddressesWithContext(ctx, unassignPrivateIPAddressesInput)
+	return err
+}
+
+func (mgr *IPPoolManager) assignMoreIPs(ctx *context.VPCContext, family addressFamily, batchSize int) error {
+	limit, err := maxAddresses(ctx, family)
+	if err != nil {
+		return err
+	}
+	if len(mgr.addresses(family)) >= limit {
+		return errMaxIPAddressesAllocated
+	}
+
+	if len(mgr.addresses(family))+batchSize > limit {
+		batchSize = limit - len(mgr.addresses(family))
+	}
+
+	ctx.Logger.WithField("family", family).Info("Unable to allocate, no IP addresses available, allocating new IPs")
+
+	// We failed to lock an IP address, let's retry.
+	err = mgr.assignAddresses(ctx, family, batchSize)
 	if err != nil {
 		ctx.Logger.Warning("Unable to assign IPs from AWS: ", err)
 		return err
 	}
 
-	originalIPCount := len(mgr.networkInterface.IPv4Addresses)
+	originalIPCount := len(mgr.addresses(family))
 	for i := 0; i < 10; i++ {
 		err = mgr.networkInterface.Refresh()
 		if err != nil {
 			return err
 		}
-		if len(mgr.networkInterface.IPv4Addresses) > originalIPCount {
+		if len(mgr.addresses(family)) > originalIPCount {
 			// Retry the allocation
 			return nil
 		}
-		time.Sleep(time.Second)
+		time.Sleep(refreshInterval)
 	}
 
 	ctx.Logger.Warning("Refreshed allocations seconds failed")
 	return errIPRefreshFailed
 }
 
-func (mgr *IPPoolManager) allocate(ctx *context.VPCContext, batchSize int) (string, *fslocker.ExclusiveLock, error) {
+func (mgr *IPPoolManager) allocate(ctx *context.VPCContext, family addressFamily, batchSize int) (string, *fslocker.ExclusiveLock, error) {
 	configLock, err := mgr.lockConfiguration(ctx)
 	if err != nil {
 		ctx.Logger.Warning("Unable to get lock during allocation: ", err)
@@ -89,7 +178,7 @@ func (mgr *IPPoolManager) allocate(ctx *context.VPCContext, batchSize int) (stri
 		return "", nil, err
 	}
 
-	ip, lock, err := mgr.doAllocate(ctx)
+	ip, lock, err := mgr.doAllocate(ctx, family)
 	// Did we successfully get an IP, or was there an error?
 	if err != nil || lock != nil {
 		if err != nil {
@@ -98,21 +187,21 @@ func (mgr *IPPoolManager) allocate(ctx *context.VPCContext, batchSize int) (stri
 		return ip, lock, err
 	}
 
-	err = mgr.assignMoreIPs(ctx, batchSize)
+	err = mgr.assignMoreIPs(ctx, family, batchSize)
 	if err != nil {
 		ctx.Logger.Warning("Unable assign more IPs: ", err)
 		return "", nil, err
 	}
 
-	return mgr.doAllocate(ctx)
+	return mgr.doAllocate(ctx, family)
 
 }
 
-func (mgr *IPPoolManager) doAllocate(ctx *context.VPCContext) (string, *fslocker.ExclusiveLock, error) {
+func (mgr *IPPoolManager) doAllocate(ctx *context.VPCContext, family addressFamily) (string, *fslocker.ExclusiveLock, error) {
 	// Let's see if we can lease a free IP address?
 	// Try locking the primary IP address first (always)
-	for _, ipAddress := range mgr.networkInterface.IPv4Addresses {
-		lock, err := mgr.tryAllocate(ctx, ipAddress)
+	for _, ipAddress := range mgr.addresses(family) {
+		lock, err := mgr.tryAllocate(ctx, family, ipAddress)
 		if err != nil {
 			ctx.Logger.Warning("Unable to do allocation: ", err)
 			return "", nil, err
@@ -125,17 +214,22 @@ func (mgr *IPPoolManager) doAllocate(ctx *context.VPCContext) (string, *fslocker
 	return "", nil, nil
 }
 
-func (mgr *IPPoolManager) ipAddressesPath() string {
+// ipAddressesPath returns the directory of the locks of the family's addresses. IPv6 addresses have their own
+// directory, so that the record names of IPv4 addresses stay the same.
+func (mgr *IPPoolManager) ipAddressesPath(family addressFamily) string {
+	if family == ipv6 {
+		return filepath.Join(mgr.networkInterface.LockPath(), "ip6-addresses")
+	}
 	return filepath.Join(mgr.networkInterface.LockPath(), "ip-addresses")
 }
 
-func (mgr *IPPoolManager) ipAddressPath(ip string) string {
-	return filepath.Join(mgr.ipAddressesPath(), ip)
+func (mgr *IPPoolManager) ipAddressPath(family addressFamily, ip string) string {
+	return filepath.Join(mgr.ipAddressesPath(family), ip)
 }
 
-func (mgr *IPPoolManager) tryAllocate(ctx *context.VPCContext, ipAddress string) (*fslocker.ExclusiveLock, error) {
+func (mgr *IPPoolManager) tryAllocate(ctx *context.VPCContext, family addressFamily, ipAddress string) (*fslocker.ExclusiveLock, error) {
 	var noTimeout time.Duration
-	ipAddressPath := mgr.ipAddressPath(ipAddress)
+	ipAddressPath := mgr.ipAddressPath(family, ipAddress)
 
 	// Non-blocking lock
 	lock, err := ctx.FSLocker.ExclusiveLock(ipAddressPath, &noTimeout)
@@ -146,12 +240,12 @@ func (mgr *IPPoolManager) tryAllocate(ctx *context.VPCContext, ipAddress string)
 	return lock, nil
 }
 
-func (mgr *IPPoolManager) firstPass(parentCtx *context.VPCContext, gracePeriod time.Duration) (deallocationList []string, locks []*fslocker.ExclusiveLock, retErr error) {
+func (mgr *IPPoolManager) firstPass(parentCtx *context.VPCContext, family addressFamily, gracePeriod time.Duration) (deallocationList []string, locks []*fslocker.ExclusiveLock, retErr error) {
 	timeout := 0 * time.Second
 	recordsDict := make(map[string]fslocker.Record)
 	locks = []*fslocker.ExclusiveLock{}
 
-	records, err := parentCtx.FSLocker.ListFiles(mgr.ipAddressesPath())
+	records, err := parentCtx.FSLocker.ListFiles(mgr.ipAddressesPath(family))
 	if err != nil {
 		retErr = err
 		return
@@ -160,13 +254,12 @@ func (mgr *IPPoolManager) firstPass(parentCtx *context.VPCContext, gracePeriod t
 		recordsDict[record.Name] = record
 	}
 
-	// the first IP is always the primary IP on the interface, therefore it shouldn't be tested for removal
-	for _, ip := range mgr.networkInterface.IPv4Addresses[1:] {
+	for _, ip := range mgr.reclaimableAddresses(family) {
 		logEntry := parentCtx.Logger.WithField("ip", ip)
 		logEntry.Debug("Checking IP address")
 
 		// Checks:
-		ipAddrLock, err := parentCtx.FSLocker.ExclusiveLock(filepath.Join(mgr.ipAddressesPath(), ip), &timeout)
+		ipAddrLock, err := parentCtx.FSLocker.ExclusiveLock(mgr.ipAddressPath(family, ip), &timeout)
 		// Seems like this address is in use
 		if err == unix.EWOULDBLOCK {
 			logEntry.Debug("File currently locked")
@@ -195,14 +288,23 @@ func (mgr *IPPoolManager) firstPass(parentCtx *context.VPCContext, gracePeriod t
 	return
 }
 
-// DoGc triggers GC for this IP Pool Manager.
+// DoGc triggers GC for this IP Pool Manager. IPv4, and IPv6 addresses are collected in turn.
 func (mgr *IPPoolManager) DoGc(parentCtx *context.VPCContext, gracePeriod time.Duration) error {
+	for _, family := range addressFamilies {
+		if err := mgr.doGc(parentCtx.WithField("family", family), family, gracePeriod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mgr *IPPoolManager) doGc(parentCtx *context.VPCContext, family addressFamily, gracePeriod time.Duration) error {
 	lock, err := mgr.lockConfiguration(parentCtx)
 	if err != nil {
 		return err
 	}
 	defer lock.Unlock()
-	deallocationList, locks, err := mgr.firstPass(parentCtx, gracePeriod)
+	deallocationList, locks, err := mgr.firstPass(parentCtx, family, gracePeriod)
 	if err != nil {
 		return err
 	}
@@ -217,27 +319,27 @@ func (mgr *IPPoolManager) DoGc(parentCtx *context.VPCContext, gracePeriod time.D
 	// We unlock here, because in freeIPs, it can take quite a while (minutes).
 	lock.Unlock()
 
-	err = mgr.freeIPs(parentCtx, deallocationList)
+	err = mgr.freeIPs(parentCtx, family, deallocationList)
 	if err != nil {
 		return err
 	}
 
 	// Do file deletions
-	return mgr.doFileCleanup(parentCtx, deallocationList)
+	return mgr.doFileCleanup(parentCtx, family, deallocationList)
 }
 
-func (mgr *IPPoolManager) doFileCleanup(parentCtx *context.VPCContext, deallocationList []string) error {
+func (mgr *IPPoolManager) doFileCleanup(parentCtx *context.VPCContext, family addressFamily, deallocationList []string) error {
 	timeout := 0 * time.Second
 	recordsNotToDelete := make(map[string]struct{})
 	for _, ip := range deallocationList {
 		recordsNotToDelete[ip] = struct{}{}
 	}
 
-	for _, ip := range mgr.networkInterface.IPv4Addresses {
+	for _, ip := range mgr.addresses(family) {
 		recordsNotToDelete[ip] = struct{}{}
 	}
 
-	records, err := parentCtx.FSLocker.ListFiles(mgr.ipAddressesPath())
+	records, err := parentCtx.FSLocker.ListFiles(mgr.ipAddressesPath(family))
 	if err != nil {
 		return err
 	}
@@ -252,7 +354,7 @@ func (mgr *IPPoolManager) doFileCleanup(parentCtx *context.VPCContext, deallocat
 			continue
 		}
 		logEntry.Info("Removing")
-		path := filepath.Join(mgr.ipAddressesPath(), record.Name)
+		path := mgr.ipAddressPath(family, record.Name)
 		lock, err := parentCtx.FSLocker.ExclusiveLock(path, &timeout)
 		// Seems like this address is in use
 		if err == unix.EWOULDBLOCK {
@@ -273,7 +375,7 @@ func (mgr *IPPoolManager) doFileCleanup(parentCtx *context.VPCContext, deallocat
 	return nil
 }
 
-func (mgr *IPPoolManager) ipsFreed(parentCtx *context.VPCContext, oldIPList, deallocationList []string) bool {
+func (mgr *IPPoolManager) ipsFreed(parentCtx *context.VPCContext, family addressFamily, oldIPList, deallocationList []string) bool {
 	successCount := 0
 	for i := 0; i < 180; i++ {
 		err := mgr.networkInterface.Refresh()
@@ -281,7 +383,7 @@ func (mgr *IPPoolManager) ipsFreed(parentCtx *context.VPCContext, oldIPList, dea
 			parentCtx.Logger.Error("Could not refresh IPs: ", err)
 		} else {
 			allocMap := make(map[string]struct{})
-			for _, ip := range mgr.networkInterface.IPv4Addresses {
+			for _, ip := range mgr.addresses(family) {
 				allocMap[ip] = struct{}{}
 			}
 
@@ -303,29 +405,24 @@ func (mgr *IPPoolManager) ipsFreed(parentCtx *context.VPCContext, oldIPList, dea
 				successCount = 0
 			}
 		}
-		time.Sleep(5 * time.Second)
+		time.Sleep(gcRefreshInterval)
 	}
 	return false
 }
 
-func (mgr *IPPoolManager) freeIPs(parentCtx *context.VPCContext, deallocationList []string) error {
+func (mgr *IPPoolManager) freeIPs(parentCtx *context.VPCContext, family addressFamily, deallocationList []string) error {
 	// Prioritize giving IPs back to Amazon
-	oldIPList := mgr.networkInterface.IPv4Addresses
+	oldIPList := mgr.addresses(family)
 	if len(deallocationList) == 0 {
 		return nil
 	}
 
 	parentCtx.Logger.Info("Deallocating Ip addresses: ", deallocationList)
-	unassignPrivateIPAddressesInput := &ec2.UnassignPrivateIpAddressesInput{
-		PrivateIpAddresses: aws.StringSlice(deallocationList),
-		NetworkInterfaceId: aws.String(mgr.networkInterface.InterfaceID),
-	}
-
-	if _, err := ec2.New(parentCtx.AWSSession).UnassignPrivateIpAddressesWithContext(parentCtx, unassignPrivateIPAddressesInput); err != nil {
+	if err := mgr.unassignAddresses(parentCtx, family, deallocationList); err != nil {
 		return err
 	}
 
-	if !mgr.ipsFreed(parentCtx, oldIPList, deallocationList) {
+	if !mgr.ipsFreed(parentCtx, family, oldIPList, deallocationList) {
 		parentCtx.Logger.Warning("IP Refresh failed on GC")
 	}
 
@@ -0,0 +1,66 @@
+package allocate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Netflix/titus-executor/vpc/fakeec2"
+	"github.com/Netflix/titus-executor/vpc/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGC(t *testing.T) {
+	fake := fakeec2.New("c5.large")
+	eni, err := fake.AttachNewInterface(1)
+	require.NoError(t, err)
+	fake.MetadataDelay = 50 * time.Millisecond
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	sgs := map[string]struct{}{fakeec2.DefaultSecurityGroup: {}}
+	var allocs []*allocation
+	for i := 0; i < 3; i++ {
+		alloc, allocErr := doAllocateNetwork(vpcCtx, types.AllocationRequest{DeviceIndex: 1, BatchSize: 4, SecurityConvergenceTimeout: time.Second, IPv6: true}, sgs)
+		require.NoError(t, allocErr)
+		allocs = append(allocs, alloc)
+	}
+	defer allocs[0].deallocate(vpcCtx)
+	allocs[1].deallocate(vpcCtx)
+	allocs[2].deallocate(vpcCtx)
+	before := fake.Interface(eni)
+	// The primary address, and a batch of 4
+	require.Len(t, before.PrivateIpAddresses, 5)
+	require.Len(t, before.Ipv6Addresses, 4)
+
+	networkInterface, err := getInterfaceByIdx(vpcCtx, 1)
+	require.NoError(t, err)
+	require.NoError(t, NewIPPoolManager(networkInterface).DoGc(vpcCtx, 0))
+
+	// Only the addresses which were allocated, and let go of, are given back. The ones which were never used don't have
+	// lock records, so GC leaves them alone.
+	after := fake.Interface(eni)
+	var ipv4Addresses, ipv6Addresses []string
+	for _, addr := range after.PrivateIpAddresses {
+		ipv4Addresses = append(ipv4Addresses, aws.StringValue(addr.PrivateIpAddress))
+	}
+	for _, addr := range after.Ipv6Addresses {
+		ipv6Addresses = append(ipv6Addresses, aws.StringValue(addr.Ipv6Address))
+	}
+	assert.Len(t, ipv4Addresses, 3)
+	assert.Contains(t, ipv4Addresses, allocs[0].ipAddress)
+	assert.NotContains(t, ipv4Addresses, allocs[1].ipAddress)
+	assert.NotContains(t, ipv4Addresses, allocs[2].ipAddress)
+	assert.Len(t, ipv6Addresses, 2)
+	assert.Contains(t, ipv6Addresses, allocs[0].ip6Address)
+	assert.Equal(t, 1, fake.Calls("UnassignPrivateIpAddresses"))
+	assert.Equal(t, 1, fake.Calls("UnassignIpv6Addresses"))
+
+	// Addresses which are still in use, or were just let go of, aren't collected
+	require.NoError(t, allocs[0].refresh())
+	require.NoError(t, NewIPPoolManager(networkInterface).DoGc(vpcCtx, time.Hour))
+	assert.Len(t, fake.Interface(eni).PrivateIpAddresses, 3)
+}
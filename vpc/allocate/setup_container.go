@@ -2,8 +2,11 @@ package allocate
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 
 	"github.com/Netflix/titus-executor/vpc/context"
 	"github.com/Netflix/titus-executor/vpc/types"
@@ -29,24 +32,67 @@ var SetupContainer = cli.Command{ // nolint: golint
 			Name:  "burst",
 			Usage: "Allow this container to burst its network allocation",
 		},
+		cli.StringSliceFlag{
+			Name:  "port-mapping",
+			Usage: "A hostPort:containerPort[/tcp|udp] pair to DNAT inside of the container's network namespace, can be specified multiple times",
+		},
 	},
 }
 
+// parsePortMappings parses hostPort:containerPort[/protocol] pairs. The protocol defaults to TCP.
+func parsePortMappings(portMappingStrs []string) ([]types.PortMapping, error) {
+	portMappings := make([]types.PortMapping, 0, len(portMappingStrs))
+	for _, portMappingStr := range portMappingStrs {
+		protocol := types.ProtocolTCP
+		ports := portMappingStr
+		if slash := strings.Index(ports, "/"); slash != -1 {
+			protocol = ports[slash+1:]
+			ports = ports[:slash]
+		}
+		if protocol != types.ProtocolTCP && protocol != types.ProtocolUDP {
+			return nil, fmt.Errorf("Invalid port mapping protocol: %s", portMappingStr)
+		}
+		parts := strings.Split(ports, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid port mapping: %s", portMappingStr)
+		}
+		hostPort, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		containerPort, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		if hostPort == 0 || containerPort == 0 {
+			return nil, fmt.Errorf("Invalid port mapping, port 0 is not valid: %s", portMappingStr)
+		}
+		portMappings = append(portMappings, types.PortMapping{HostPort: uint16(hostPort), ContainerPort: uint16(containerPort), Protocol: protocol})
+	}
+	return portMappings, nil
+}
+
 func setupContainer(parentCtx *context.VPCContext) error {
-	burst := parentCtx.CLIContext.Bool("burst")
-	bandwidth := parentCtx.CLIContext.Uint64("bandwidth")
-	netns := parentCtx.CLIContext.Int("netns")
-	if netns <= 0 {
+	req := types.WiringRequest{
+		Burst:     parentCtx.CLIContext.Bool("burst"),
+		Bandwidth: parentCtx.CLIContext.Uint64("bandwidth"),
+		NetnsFD:   parentCtx.CLIContext.Int("netns"),
+	}
+	if req.NetnsFD <= 0 {
 		return cli.NewExitError("netns required", 1)
 	}
+	var err error
+	req.PortMappings, err = parsePortMappings(parentCtx.CLIContext.StringSlice("port-mapping"))
+	if err != nil {
+		return cli.NewMultiError(cli.NewExitError("Unable to parse port mappings", 1), err)
+	}
 
-	var allocation types.Allocation
-	err := json.NewDecoder(os.Stdin).Decode(&allocation)
+	err = json.NewDecoder(os.Stdin).Decode(&req.Allocation)
 	if err != nil {
 		return cli.NewMultiError(cli.NewExitError("Unable to read allocation", 1), err)
 	}
 
-	link, err := doSetupContainer(parentCtx, netns, bandwidth, burst, allocation)
+	wiring, err := NewWirer(parentCtx).Wire(parentCtx, req)
 	if err != nil {
 		_ = json.NewEncoder(os.Stdout).Encode(types.WiringStatus{Success: false, Error: err.Error()})
 		return cli.NewMultiError(cli.NewExitError("Unable to setup container", 1), err)
@@ -61,8 +107,8 @@ func setupContainer(parentCtx *context.VPCContext) error {
 	signal.Notify(c, os.Interrupt, unix.SIGTERM, unix.SIGINT)
 	<-c
 
-	parentCtx.Logger.Info("Beginning shutdown, and container teardown: ", allocation)
-	teardownNetwork(parentCtx, allocation, link, netns)
+	parentCtx.Logger.Info("Beginning shutdown, and container teardown: ", req.Allocation)
+	wiring.Teardown()
 	// TODO: Teardown turned up network namespace
 	parentCtx.Logger.Info("Finished shutting down and deallocating")
 	return nil
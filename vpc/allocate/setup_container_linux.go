@@ -10,6 +10,9 @@ import (
 
 	"fmt"
 	"math/rand"
+	"os/exec"
+	"runtime"
+	"strconv"
 
 	"github.com/Netflix/titus-executor/vpc"
 	"github.com/Netflix/titus-executor/vpc/context"
@@ -24,13 +27,19 @@ import (
 const (
 	mtu = 9000
 	hz  = 100.0
+	// ipv6FilterPriority is the priority of the u32 filters which classify IPv6 traffic on the IFBs. IPv4 traffic is
+	// classified by the BPF filter, at priority 32000.
+	ipv6FilterPriority = 31000
+	// These are the offsets of the source, and destination addresses in the IPv6 header
+	ipv6SrcOffset = 8
+	ipv6DstOffset = 24
 )
 
 var (
 	errLinkNotFound = errors.New("Link not found")
 )
 
-func doSetupContainer(parentCtx *context.VPCContext, netnsfd int, bandwidth uint64, burst bool, allocation types.Allocation) (netlink.Link, error) {
+func doSetupContainer(parentCtx *context.VPCContext, netnsfd int, bandwidth uint64, burst bool, allocation types.Allocation, portMappings []types.PortMapping) (netlink.Link, error) {
 	networkInterface, err := getInterfaceByIdx(parentCtx, allocation.DeviceIndex)
 	if err != nil {
 		parentCtx.Logger.Error("Cannot get interface by index: ", err)
@@ -76,7 +85,82 @@ func doSetupContainer(parentCtx *context.VPCContext, netnsfd int, bandwidth uint
 		return nil, err
 	}
 
-	return newLink, configureLink(parentCtx, nsHandle, newLink, bandwidth, burst, networkInterface, ip)
+	err = configureLink(parentCtx, nsHandle, newLink, bandwidth, burst, networkInterface, ip)
+	if err != nil {
+		return newLink, err
+	}
+
+	if allocation.IPV6Address != "" {
+		ip6 := net.ParseIP(allocation.IPV6Address)
+		err = configureIPv6(parentCtx, nsHandle, newLink, networkInterface, ip6)
+		if err != nil {
+			return newLink, err
+		}
+		err = setupIFBIPv6Filters(parentCtx, ip, ip6)
+		if err != nil {
+			return newLink, err
+		}
+	}
+
+	return newLink, setupPortMappings(parentCtx, netnsfd, ip, portMappings)
+}
+
+// setupPortMappings installs DNAT rules inside of the container's network namespace. The rules are torn down
+// along with the network namespace. If any of them can't be installed, the ones which were are removed.
+func setupPortMappings(parentCtx *context.VPCContext, netnsfd int, ip net.IP, portMappings []types.PortMapping) error {
+	if len(portMappings) == 0 {
+		return nil
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		// This goroutine never unlocks the OS thread, so the thread, which is left in the container's
+		// network namespace, is thrown away when the goroutine exits
+		runtime.LockOSThread()
+		if err := netns.Set(netns.NsHandle(netnsfd)); err != nil {
+			errCh <- err
+			return
+		}
+		added := [][]string{}
+		for _, pm := range portMappings {
+			if pm.HostPort == pm.ContainerPort {
+				continue
+			}
+			protocol := pm.Protocol
+			if protocol == "" {
+				protocol = types.ProtocolTCP
+			}
+			rule := []string{
+				"PREROUTING",
+				"-p", protocol, "-d", ip.String(), "--dport", strconv.Itoa(int(pm.HostPort)),
+				"-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%d", ip.String(), pm.ContainerPort),
+			}
+			parentCtx.Logger.Debug("Adding port mapping: ", rule)
+			if output, err := iptablesNAT("-A", rule); err != nil {
+				removePortMappings(parentCtx, added)
+				errCh <- fmt.Errorf("Unable to setup port mapping %d:%d/%s: %s: %v", pm.HostPort, pm.ContainerPort, protocol, string(output), err)
+				return
+			}
+			added = append(added, rule)
+		}
+		errCh <- nil
+	}()
+
+	return <-errCh
+}
+
+func iptablesNAT(op string, rule []string) ([]byte, error) {
+	args := append([]string{"-t", "nat", op}, rule...)
+	return exec.Command("iptables", args...).CombinedOutput() // nolint: gas
+}
+
+// removePortMappings must be called from the container's network namespace
+func removePortMappings(parentCtx *context.VPCContext, rules [][]string) {
+	for idx := len(rules) - 1; idx >= 0; idx-- {
+		if output, err := iptablesNAT("-D", rules[idx]); err != nil {
+			parentCtx.Logger.Warningf("Unable to remove port mapping %v: %s: %v", rules[idx], string(output), err)
+		}
+	}
 }
 
 func configureLink(parentCtx *context.VPCContext, nsHandle *netlink.Handle, link netlink.Link, bandwidth uint64, burst bool, networkInterface *ec2wrapper.EC2NetworkInterface, ip net.IP) error {
@@ -128,6 +212,58 @@ func configureLink(parentCtx *context.VPCContext, nsHandle *netlink.Handle, link
 	return setupIFBClasses(parentCtx, bandwidth, burst, ip)
 }
 
+// configureIPv6 adds the IPv6 address to the link, which has to be up, and named already. The traffic shaping classes
+// are keyed on the IPv4 address, so IPv6 traffic is put in them by setupIFBIPv6Filters.
+func configureIPv6(parentCtx *context.VPCContext, nsHandle *netlink.Handle, link netlink.Link, networkInterface *ec2wrapper.EC2NetworkInterface, ip net.IP) error {
+	subnet, err := parentCtx.SubnetCache.DescribeSubnet(parentCtx, networkInterface.SubnetID)
+	if err != nil {
+		return err
+	}
+
+	var ipnet *net.IPNet
+	for _, association := range subnet.Ipv6CidrBlockAssociationSet {
+		if association.Ipv6CidrBlock == nil {
+			continue
+		}
+		_, ipnet, err = net.ParseCIDR(*association.Ipv6CidrBlock)
+		if err != nil {
+			return err
+		}
+		if ipnet.Contains(ip) {
+			break
+		}
+		ipnet = nil
+	}
+	if ipnet == nil {
+		return fmt.Errorf("IPv6 address %s is not in any of the IPv6 CIDR blocks of subnet %s", ip, networkInterface.SubnetID)
+	}
+
+	// The address was assigned to us by EC2, so it's not worth waiting on duplicate address detection
+	newAddr := netlink.Addr{
+		IPNet: &net.IPNet{IP: ip, Mask: ipnet.Mask},
+		Flags: unix.IFA_F_NODAD,
+	}
+	err = nsHandle.AddrAdd(link, &newAddr)
+	if err != nil {
+		parentCtx.Logger.Error("Unable to add IPv6 addr to link: ", err)
+		return err
+	}
+
+	// Like with IPv4, the VPC router is the first address after the network address
+	newRoute := netlink.Route{
+		Gw:        cidr.Inc(ipnet.IP),
+		Src:       ip,
+		LinkIndex: link.Attrs().Index,
+	}
+	err = nsHandle.RouteAdd(&newRoute)
+	if err != nil {
+		parentCtx.Logger.Error("Unable to add IPv6 route to link: ", err)
+		return err
+	}
+
+	return nil
+}
+
 func setupIFBClasses(parentCtx *context.VPCContext, bandwidth uint64, burst bool, ip net.IP) error {
 	// The class is based on the last two parts of the IPv4 address
 	// The reasoning is that 0 and 1 of the subnet are reserved by Amazon, so we will never get those IPs
@@ -161,8 +297,61 @@ func setupIFBClasses(parentCtx *context.VPCContext, bandwidth uint64, burst bool
 	return setupIFBSubqdisc(parentCtx, ip, ifbIngress)
 }
 
+// setupIFBIPv6Filters classifies the container's IPv6 traffic into the HTB classes of its IPv4 address, as the BPF
+// filters only classify IPv4 traffic, and unclassified traffic isn't shaped
+func setupIFBIPv6Filters(parentCtx *context.VPCContext, ip, ip6 net.IP) error {
+	ifbEgress, err := netlink.LinkByName(vpc.EgressIFB)
+	if err != nil {
+		return err
+	}
+	ifbIngress, err := netlink.LinkByName(vpc.IngressIFB)
+	if err != nil {
+		return err
+	}
+
+	handle := vpc.IPAddressToHandle(ip)
+	// Egress traffic comes from the container's address, and ingress traffic goes to it
+	for _, f := range []*netlink.U32{newIPv6Filter(ifbEgress, handle, ip6, ipv6SrcOffset), newIPv6Filter(ifbIngress, handle, ip6, ipv6DstOffset)} {
+		parentCtx.Logger.Debug("Setting up IPv6 filter: ", f)
+		if err = netlink.FilterAdd(f); err != nil {
+			parentCtx.Logger.Error("Unable to add IPv6 filter: ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func newIPv6Filter(link netlink.Link, handle uint16, ip6 net.IP, offset int32) *netlink.U32 {
+	return &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    netlink.MakeHandle(1, 0),
+			Priority:  ipv6FilterPriority,
+			Protocol:  unix.ETH_P_IPV6,
+		},
+		ClassId: netlink.MakeHandle(1, handle),
+		Sel:     ipv6AddressSelector(ip6, offset),
+	}
+}
+
+// ipv6AddressSelector matches the 16 bytes of the address at the offset in the IPv6 header. The netlink library takes
+// the keys in host byte order.
+func ipv6AddressSelector(ip6 net.IP, offset int32) *netlink.TcU32Sel {
+	addr := ip6.To16()
+	sel := &netlink.TcU32Sel{Flags: netlink.TC_U32_TERMINAL}
+	for i := 0; i < net.IPv6len; i += 4 {
+		sel.Keys = append(sel.Keys, netlink.TcU32Key{
+			Mask: 0xffffffff,
+			Val:  binary.BigEndian.Uint32(addr[i : i+4]),
+			Off:  offset + int32(i),
+		})
+	}
+	sel.Nkeys = uint8(len(sel.Keys))
+	return sel
+}
+
 func setupIFBSubqdisc(parentCtx *context.VPCContext, ip net.IP, link netlink.Link) error {
-	handle := ipaddressToHandle(ip)
+	handle := vpc.IPAddressToHandle(ip)
 
 	// The qdisc wasn't found, add it
 	attrs := netlink.QdiscAttrs{
@@ -182,7 +371,7 @@ func setupIFBSubqdisc(parentCtx *context.VPCContext, ip net.IP, link netlink.Lin
 }
 
 func setupIFBClass(parentCtx *context.VPCContext, bandwidth uint64, burst bool, ip net.IP, link netlink.Link) error {
-	handle := ipaddressToHandle(ip)
+	handle := vpc.IPAddressToHandle(ip)
 
 	classattrs := netlink.ClassAttrs{
 		LinkIndex: link.Attrs().Index,
@@ -193,7 +382,11 @@ func setupIFBClass(parentCtx *context.VPCContext, bandwidth uint64, burst bool,
 
 	ceil := bandwidth
 	if burst {
-		ceil = vpc.GetMaxNetworkbps(parentCtx.InstanceType)
+		limits, err := vpc.GetLimits(parentCtx.InstanceType)
+		if err != nil {
+			return err
+		}
+		ceil = limits.NetworkBps()
 	}
 	htbclassattrs := netlink.HtbClassAttrs{
 		Rate:    bandwidth,
@@ -230,17 +423,17 @@ func getLink(networkInterface *ec2wrapper.EC2NetworkInterface) (netlink.Link, er
 	return nil, errLinkNotFound
 }
 
-func ipaddressToHandle(ip net.IP) uint16 {
-	return binary.BigEndian.Uint16([]byte(ip.To4()[2:4]))
-}
-
 func teardownNetwork(ctx *context.VPCContext, allocation types.Allocation, link netlink.Link, netnsfd int) {
 	deleteLink(ctx, link, netnsfd)
 	ip := net.ParseIP(allocation.IPV4Address)
 
 	// Removing the classes automatically removes the qdiscs
+	// The IPv6 filters point at the classes, so they go first
 	ifbEgress, err := netlink.LinkByName(vpc.EgressIFB)
 	if err == nil {
+		if allocation.IPV6Address != "" {
+			removeIPv6Filter(ctx, ip, ifbEgress)
+		}
 		removeClass(ctx, ip, ifbEgress)
 	} else {
 		ctx.Logger.Warning("Unable to find ifb egress, during deallocation: ", err)
@@ -248,6 +441,9 @@ func teardownNetwork(ctx *context.VPCContext, allocation types.Allocation, link
 
 	ifbIngress, err := netlink.LinkByName(vpc.IngressIFB)
 	if err == nil {
+		if allocation.IPV6Address != "" {
+			removeIPv6Filter(ctx, ip, ifbIngress)
+		}
 		removeClass(ctx, ip, ifbIngress)
 	} else {
 		ctx.Logger.Warning("Unable to find ifb ingress, during deallocation: ", err)
@@ -270,8 +466,29 @@ func deleteLink(ctx *context.VPCContext, link netlink.Link, netnsfd int) {
 	}
 }
 
+func removeIPv6Filter(ctx *context.VPCContext, ip net.IP, link netlink.Link) {
+	classID := netlink.MakeHandle(1, vpc.IPAddressToHandle(ip))
+	filters, err := netlink.FilterList(link, netlink.MakeHandle(1, 0))
+	if err != nil {
+		ctx.Logger.Errorf("Unable to list filters on link %v because %v", link, err)
+		return
+	}
+	for _, f := range filters {
+		u32, ok := f.(*netlink.U32)
+		if !ok || u32.Protocol != unix.ETH_P_IPV6 || u32.ClassId != classID {
+			continue
+		}
+		if err = netlink.FilterDel(u32); err != nil {
+			ctx.Logger.Warning("Unable to remove IPv6 filter: ", err)
+		}
+		return
+	}
+
+	ctx.Logger.Warning("Unable to find IPv6 filter for container")
+}
+
 func removeClass(ctx *context.VPCContext, ip net.IP, link netlink.Link) {
-	handle := ipaddressToHandle(ip)
+	handle := vpc.IPAddressToHandle(ip)
 	classes, err := netlink.ClassList(link, netlink.MakeHandle(1, 1))
 	if err != nil {
 		ctx.Logger.Errorf("Unable to list classes on link %v because %v", link, err)
@@ -0,0 +1,27 @@
+// +build linux
+
+package allocate
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestIPv6AddressSelector(t *testing.T) {
+	sel := ipv6AddressSelector(net.ParseIP("2001:db8::1234:5678"), ipv6SrcOffset)
+	assert.Equal(t, uint8(netlink.TC_U32_TERMINAL), sel.Flags)
+	assert.Equal(t, uint8(4), sel.Nkeys)
+	assert.Equal(t, []netlink.TcU32Key{
+		{Mask: 0xffffffff, Val: 0x20010db8, Off: 8},
+		{Mask: 0xffffffff, Val: 0, Off: 12},
+		{Mask: 0xffffffff, Val: 0, Off: 16},
+		{Mask: 0xffffffff, Val: 0x12345678, Off: 20},
+	}, sel.Keys)
+
+	sel = ipv6AddressSelector(net.ParseIP("2001:db8::1"), ipv6DstOffset)
+	assert.Equal(t, int32(24), sel.Keys[0].Off)
+	assert.Equal(t, uint32(1), sel.Keys[3].Val)
+}
@@ -9,4 +9,6 @@ const (
 	IngressIFB = "ifb-ingress"
 	// EgressIFB is the intermediate functional block device used to do egress processing
 	EgressIFB = "ifb-egress"
+	// DefaultStateDir is where the fslocker state, and subnet cache live, unless VPC_STATE_DIR says otherwise
+	DefaultStateDir = "/run/titus-vpc-tool"
 )
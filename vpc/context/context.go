@@ -18,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/sirupsen/logrus"
 	"github.com/wercker/journalhook"
 	"gopkg.in/urfave/cli.v1"
@@ -32,6 +33,7 @@ type VPCContext struct {
 	CLIContext               *cli.Context
 	FSLocker                 *fslocker.FSLocker
 	AWSSession               *session.Session
+	EC2                      ec2wrapper.EC2Client
 	EC2metadataClientWrapper *ec2wrapper.EC2MetadataClientWrapper
 	Logger                   *logrus.Entry
 	InstanceType             string
@@ -53,45 +55,74 @@ func newVPCContext(cliContext *cli.Context) (*VPCContext, error) {
 	} else {
 		logger.Info("Disabling journald hook")
 	}
+
+	// Setup state manager:
+	stateDir := cliContext.GlobalString(StateDir)
+	if stateDir == "" {
+		return nil, cli.NewExitError("state directory not specified", 1)
+	}
+
+	ret, err := NewVPCContext(context.Background(), logrus.NewEntry(logger), stateDir)
+	if err != nil {
+		return nil, err
+	}
+	ret.CLIContext = cliContext
+	return ret, nil
+}
+
+// NewVPCContext sets up a context for using the VPC packages from outside of titus-vpc-tool, where there's no CLI
+// context. stateDir must be the same one that titus-vpc-tool uses, so that they share locks.
+func NewVPCContext(ctx context.Context, logger *logrus.Entry, stateDir string) (*VPCContext, error) {
 	ret := &VPCContext{
-		Context:    context.Background(),
-		CLIContext: cliContext,
-		Logger:     logrus.NewEntry(logger),
+		Context: ctx,
+		Logger:  logger,
 	}
 
 	// Setup EC2 client
-	err = ret.setupEC2()
+	err := ret.setupEC2()
 	if err != nil {
 		logger.Warning("Unable to setup EC2 client: ", err)
 		return nil, err
 	}
 
-	// Setup state manager:
-	stateDir := cliContext.GlobalString(StateDir)
-	if stateDir == "" {
-		return nil, cli.NewExitError("state directory not specified", 1)
+	return ret, ret.setupState(stateDir)
+}
+
+// NewVPCContextWithClients is like NewVPCContext, but it uses the given EC2, and EC2 metadata clients, instead of ones
+// for the instance that it's running on. It's how the VPC packages are exercised against fakes.
+func NewVPCContextWithClients(ctx context.Context, logger *logrus.Entry, stateDir, instanceType, instanceID string, ec2Client ec2wrapper.EC2Client, metadataClient ec2wrapper.EC2MetadataClient) (*VPCContext, error) {
+	ret := &VPCContext{
+		Context:                  ctx,
+		Logger:                   logger,
+		EC2:                      ec2Client,
+		EC2metadataClientWrapper: ec2wrapper.WrapEC2MetadataClient(metadataClient, logger),
+		InstanceType:             instanceType,
+		InstanceID:               instanceID,
 	}
+	return ret, ret.setupState(stateDir)
+}
 
+func (ctx *VPCContext) setupState(stateDir string) error {
 	fslockerDir := filepath.Join(stateDir, "fslocker")
-	err = os.MkdirAll(fslockerDir, 0700)
+	err := os.MkdirAll(fslockerDir, 0700)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	locker, err := fslocker.NewFSLocker(fslockerDir)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	ret.FSLocker = locker
+	ctx.FSLocker = locker
 
 	subnetCachingDirectory := filepath.Join(stateDir, "subnets")
 	err = os.MkdirAll(subnetCachingDirectory, 0700)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	ret.SubnetCache = newSubnetCache(locker, subnetCachingDirectory)
+	ctx.SubnetCache = newSubnetCache(locker, subnetCachingDirectory)
 
-	return ret, nil
+	return nil
 }
 
 func getInstanceIdentityDocument(ec2MetadataClient *ec2metadata.EC2Metadata) (ec2metadata.EC2InstanceIdentityDocument, error) {
@@ -182,6 +213,7 @@ func (ctx *VPCContext) setupEC2() error {
 
 		if awsSession, err2 := session.NewSession(awsConfig); err2 == nil {
 			ctx.AWSSession = awsSession
+			ctx.EC2 = ec2.New(awsSession)
 			ctx.EC2metadataClientWrapper = ec2wrapper.NewEC2MetadataClientWrapper(awsSession, ctx.Logger)
 		} else {
 			return cli.NewMultiError(cli.NewExitError("Unable to create AWS Session", 1), err2)
@@ -211,6 +243,14 @@ func (ctx *VPCContext) WithTimeout(timeout time.Duration) (*VPCContext, context.
 	return ret, cancel
 }
 
+// WithContext returns a copy of the context, which uses ctx for deadlines, and cancellation
+func (ctx *VPCContext) WithContext(newCtx context.Context) *VPCContext {
+	ret := &VPCContext{}
+	*ret = *ctx
+	ret.Context = newCtx
+	return ret
+}
+
 // WithField returns a copy of the context, but with this key-value added to the logger
 func (ctx *VPCContext) WithField(key string, value interface{}) *VPCContext {
 	ret := &VPCContext{}
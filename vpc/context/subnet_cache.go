@@ -89,7 +89,7 @@ func (sc *SubnetCache) fetchFromEC2(ctx *VPCContext, subnetid string) (*ec2.Subn
 	describeSubnetsInput := &ec2.DescribeSubnetsInput{
 		SubnetIds: []*string{aws.String(subnetid)},
 	}
-	subnetOutput, err := ec2.New(ctx.AWSSession).DescribeSubnetsWithContext(ctx, describeSubnetsInput)
+	subnetOutput, err := ctx.EC2.DescribeSubnetsWithContext(ctx, describeSubnetsInput)
 	if err != nil {
 		return nil, err
 	}
@@ -16,7 +16,7 @@ import (
 
 // EC2MetadataClientWrapper wraps the EC2 library and provides some helper functions
 type EC2MetadataClientWrapper struct {
-	ec2metadata *ec2metadata.EC2Metadata
+	ec2metadata EC2MetadataClient
 	logger      *logrus.Entry
 }
 
@@ -37,7 +37,8 @@ type EC2NetworkInterface struct {
 	// even though they're equal
 	// IPv4Addresses is the set of currently assigned IPs -- The primary IPv4 address is the first in this list
 	IPv4Addresses []string
-	// TODO: Add IPv6 addresses
+	// IPv6Addresses is the set of currently assigned IPv6 addresses. Interfaces don't have a primary IPv6 address.
+	IPv6Addresses []string
 }
 
 // NewEC2MetadataClientWrapper creates a new ec2metadata wrapper instance
@@ -48,6 +49,14 @@ func NewEC2MetadataClientWrapper(session client.ConfigProvider, logger *logrus.E
 	}
 }
 
+// WrapEC2MetadataClient is like NewEC2MetadataClientWrapper, but it wraps an existing client, i.e. a fake one
+func WrapEC2MetadataClient(client EC2MetadataClient, logger *logrus.Entry) *EC2MetadataClientWrapper {
+	return &EC2MetadataClientWrapper{
+		ec2metadata: client,
+		logger:      logger,
+	}
+}
+
 // PrimaryInterfaceMac returns the mac of the primary interface
 func (mdc *EC2MetadataClientWrapper) PrimaryInterfaceMac() (string, error) {
 	val, err := mdc.getMetadata("mac")
@@ -148,7 +157,7 @@ func (mdc *EC2MetadataClientWrapper) getMetadata(path string) (string, error) {
 	return val, err
 }
 
-// Refresh updates the security groups. and local IPv4 addresses for an interface
+// Refresh updates the security groups, and local IPv4, and IPv6 addresses for an interface
 func (ni *EC2NetworkInterface) Refresh() error {
 	ni.SecurityGroupIds = make(map[string]struct{})
 
@@ -169,6 +178,36 @@ func (ni *EC2NetworkInterface) Refresh() error {
 		ni.IPv4Addresses[idx] = strings.Trim(strings.TrimSpace(addr), "\x00")
 	}
 
+	return ni.refreshIPv6Addresses()
+}
+
+func (ni *EC2NetworkInterface) refreshIPv6Addresses() error {
+	ni.IPv6Addresses = nil
+
+	// The ipv6s key only shows up once the interface has IPv6 addresses, and fetching it before then is an error
+	keys, err := ni.mdc.getDataForInterface(ni.MAC, "")
+	if err != nil {
+		return err
+	}
+	hasIPv6s := false
+	for _, key := range strings.Split(keys, "\n") {
+		if strings.TrimSpace(key) == "ipv6s" {
+			hasIPv6s = true
+		}
+	}
+	if !hasIPv6s {
+		return nil
+	}
+
+	localIPv6s, err := ni.mdc.getDataForInterface(ni.MAC, "ipv6s")
+	if err != nil {
+		return err
+	}
+	for _, addr := range strings.Split(localIPv6s, "\n") {
+		if addr = strings.Trim(strings.TrimSpace(addr), "\x00"); addr != "" {
+			ni.IPv6Addresses = append(ni.IPv6Addresses, addr)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,721 @@
+package fakeec2
+
+import (
+	stdcontext "context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Netflix/titus-executor/vpc"
+	"github.com/Netflix/titus-executor/vpc/context"
+	"github.com/Netflix/titus-executor/vpc/ec2wrapper"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// InstanceID is the ID of the fake's instance
+	InstanceID = "i-00000000000000001"
+	// SubnetID is the ID of the fake's subnet, which all of its interfaces are in
+	SubnetID = "subnet-00000001"
+	// DefaultSecurityGroup is the security group of the instance's primary interface
+	DefaultSecurityGroup = "sg-00000001"
+
+	vpcID            = "vpc-00000001"
+	availabilityZone = "us-east-1a"
+	subnetCIDR       = "10.0.0.0/16"
+	subnetIPv6CIDR   = "2001:db8::/64"
+)
+
+var (
+	_ ec2wrapper.EC2Client         = (*EC2)(nil)
+	_ ec2wrapper.EC2MetadataClient = (*EC2)(nil)
+)
+
+// EC2 is an in-memory fake of the parts of the EC2 API, and metadata service, which the VPC packages use. It has one
+// subnet, and one instance, with its primary interface attached at device index 0. Changes to the instance's interfaces
+// only show up in the metadata service after MetadataDelay, like they do in EC2. The EC2 API itself is consistent.
+type EC2 struct {
+	// MetadataDelay is how long changes to the instance's interfaces take to show up in the metadata service
+	MetadataDelay time.Duration
+	InstanceType  string
+
+	mu             sync.Mutex
+	securityGroups map[string]struct{}
+	interfaces     map[string]*networkInterface
+	// metadata is the instance's interfaces by MAC, as the metadata service sees them
+	metadata        map[string]*networkInterface
+	pendingMetadata []metadataUpdate
+	calls           map[string]int
+	lastID          int
+	lastIPv4        net.IP
+	lastIPv6        net.IP
+}
+
+type networkInterface struct {
+	id             string
+	mac            string
+	description    string
+	securityGroups []string
+	// The primary IPv4 address is the first one
+	ipv4Addresses       []string
+	ipv6Addresses       []string
+	tags                map[string]string
+	attachmentID        string
+	deviceIndex         int
+	deleteOnTermination bool
+}
+
+func (ni *networkInterface) attached() bool {
+	return ni.attachmentID != ""
+}
+
+func (ni *networkInterface) copy() *networkInterface {
+	ret := *ni
+	ret.securityGroups = append([]string{}, ni.securityGroups...)
+	ret.ipv4Addresses = append([]string{}, ni.ipv4Addresses...)
+	ret.ipv6Addresses = append([]string{}, ni.ipv6Addresses...)
+	ret.tags = make(map[string]string, len(ni.tags))
+	for k, v := range ni.tags {
+		ret.tags[k] = v
+	}
+	return &ret
+}
+
+type metadataUpdate struct {
+	visibleAt time.Time
+	mac       string
+	// networkInterface is nil if the interface was detached
+	networkInterface *networkInterface
+}
+
+// New returns a fake EC2, whose instance is of instanceType. Its security groups are DefaultSecurityGroup, and
+// securityGroups.
+func New(instanceType string, securityGroups ...string) *EC2 {
+	f := &EC2{
+		InstanceType:   instanceType,
+		securityGroups: map[string]struct{}{DefaultSecurityGroup: {}},
+		interfaces:     make(map[string]*networkInterface),
+		metadata:       make(map[string]*networkInterface),
+		calls:          make(map[string]int),
+	}
+	for _, sg := range securityGroups {
+		f.securityGroups[sg] = struct{}{}
+	}
+	_, ipv4Net, _ := net.ParseCIDR(subnetCIDR)
+	f.lastIPv4 = ipv4Net.IP
+	_, ipv6Net, _ := net.ParseCIDR(subnetIPv6CIDR)
+	f.lastIPv6 = ipv6Net.IP
+
+	primary := f.createInterface("primary", []string{DefaultSecurityGroup})
+	if err := f.attach(primary, 0); err != nil {
+		panic(err)
+	}
+	// The primary interface was there before the instance started
+	f.applyMetadataUpdates(time.Now().Add(f.MetadataDelay))
+	return f
+}
+
+// NewVPCContext returns a VPC context whose EC2, and EC2 metadata clients are the fake. Its state is kept in a temporary
+// directory, which the returned func removes.
+func (f *EC2) NewVPCContext(logger *logrus.Entry) (*context.VPCContext, func(), error) {
+	stateDir, err := ioutil.TempDir("", "vpc-state")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		_ = os.RemoveAll(stateDir)
+	}
+	vpcCtx, err := context.NewVPCContextWithClients(stdcontext.Background(), logger, stateDir, f.InstanceType, InstanceID, f, f)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return vpcCtx, cleanup, nil
+}
+
+// Calls returns how many times an API, i.e. "AssignPrivateIpAddresses", has been called
+func (f *EC2) Calls(api string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[api]
+}
+
+// AttachNewInterface creates an interface, with the default security group, and attaches it to the instance at
+// deviceIndex. It returns the interface's ID.
+func (f *EC2) AttachNewInterface(deviceIndex int) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ni := f.createInterface("titus-managed", []string{DefaultSecurityGroup})
+	return ni.id, f.attach(ni, deviceIndex)
+}
+
+// CreateDetachedInterface creates an interface which isn't attached to anything, with the tags given. It returns the
+// interface's ID.
+func (f *EC2) CreateDetachedInterface(description string, tags map[string]string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ni := f.createInterface(description, []string{DefaultSecurityGroup})
+	for k, v := range tags {
+		ni.tags[k] = v
+	}
+	return ni.id
+}
+
+// DetachInterface detaches an interface from the instance
+func (f *EC2) DetachInterface(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ni, err := f.getInterface(aws.String(id))
+	if err != nil {
+		return err
+	}
+	if !ni.attached() {
+		return awserr.New("IncorrectState", fmt.Sprintf("Interface %s is not attached", id), nil)
+	}
+	ni.attachmentID = ""
+	f.publish(ni.mac, nil)
+	return nil
+}
+
+// Interface returns the interface, as DescribeNetworkInterfaces would, or nil if it doesn't exist
+func (f *EC2) Interface(id string) *ec2.NetworkInterface {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ni, ok := f.interfaces[id]
+	if !ok {
+		return nil
+	}
+	return ni.describe()
+}
+
+// AssignPrivateIpAddressesWithContext assigns secondary IPv4 addresses, up to the instance type's limit
+func (f *EC2) AssignPrivateIpAddressesWithContext(ctx aws.Context, input *ec2.AssignPrivateIpAddressesInput, opts ...request.Option) (*ec2.AssignPrivateIpAddressesOutput, error) { // nolint: golint
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "AssignPrivateIpAddresses"); err != nil {
+		return nil, err
+	}
+	ni, err := f.getInterface(input.NetworkInterfaceId)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := vpc.GetLimits(f.InstanceType)
+	if err != nil {
+		return nil, err
+	}
+	count := int(aws.Int64Value(input.SecondaryPrivateIpAddressCount)) + len(input.PrivateIpAddresses)
+	if len(ni.ipv4Addresses)+count > limits.IPv4AddressesPerInterface {
+		return nil, awserr.New("PrivateIpAddressLimitExceeded", fmt.Sprintf("Number of private addresses will exceed limit for %s", f.InstanceType), nil)
+	}
+	ni.ipv4Addresses = append(ni.ipv4Addresses, aws.StringValueSlice(input.PrivateIpAddresses)...)
+	for i := 0; i < int(aws.Int64Value(input.SecondaryPrivateIpAddressCount)); i++ {
+		ni.ipv4Addresses = append(ni.ipv4Addresses, f.nextIPv4())
+	}
+	f.publishInterface(ni)
+	return &ec2.AssignPrivateIpAddressesOutput{}, nil
+}
+
+// UnassignPrivateIpAddressesWithContext unassigns secondary IPv4 addresses
+func (f *EC2) UnassignPrivateIpAddressesWithContext(ctx aws.Context, input *ec2.UnassignPrivateIpAddressesInput, opts ...request.Option) (*ec2.UnassignPrivateIpAddressesOutput, error) { // nolint: golint
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "UnassignPrivateIpAddresses"); err != nil {
+		return nil, err
+	}
+	ni, err := f.getInterface(input.NetworkInterfaceId)
+	if err != nil {
+		return nil, err
+	}
+	remaining, err := removeAddresses(ni.ipv4Addresses, aws.StringValueSlice(input.PrivateIpAddresses))
+	if err != nil {
+		return nil, err
+	}
+	if len(remaining) == 0 || remaining[0] != ni.ipv4Addresses[0] {
+		return nil, awserr.New("InvalidParameterValue", "The primary private IP address can't be unassigned", nil)
+	}
+	ni.ipv4Addresses = remaining
+	f.publishInterface(ni)
+	return &ec2.UnassignPrivateIpAddressesOutput{}, nil
+}
+
+// AssignIpv6AddressesWithContext assigns IPv6 addresses, up to the instance type's limit
+func (f *EC2) AssignIpv6AddressesWithContext(ctx aws.Context, input *ec2.AssignIpv6AddressesInput, opts ...request.Option) (*ec2.AssignIpv6AddressesOutput, error) { // nolint: golint
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "AssignIpv6Addresses"); err != nil {
+		return nil, err
+	}
+	ni, err := f.getInterface(input.NetworkInterfaceId)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := vpc.GetLimits(f.InstanceType)
+	if err != nil {
+		return nil, err
+	}
+	count := int(aws.Int64Value(input.Ipv6AddressCount))
+	if len(ni.ipv6Addresses)+count > limits.IPv6AddressesPerInterface {
+		return nil, awserr.New("InvalidParameterValue", fmt.Sprintf("Number of IPv6 addresses will exceed limit for %s", f.InstanceType), nil)
+	}
+	output := &ec2.AssignIpv6AddressesOutput{NetworkInterfaceId: input.NetworkInterfaceId}
+	for i := 0; i < count; i++ {
+		addr := f.nextIPv6()
+		ni.ipv6Addresses = append(ni.ipv6Addresses, addr)
+		output.AssignedIpv6Addresses = append(output.AssignedIpv6Addresses, aws.String(addr))
+	}
+	f.publishInterface(ni)
+	return output, nil
+}
+
+// UnassignIpv6AddressesWithContext unassigns IPv6 addresses
+func (f *EC2) UnassignIpv6AddressesWithContext(ctx aws.Context, input *ec2.UnassignIpv6AddressesInput, opts ...request.Option) (*ec2.UnassignIpv6AddressesOutput, error) { // nolint: golint
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "UnassignIpv6Addresses"); err != nil {
+		return nil, err
+	}
+	ni, err := f.getInterface(input.NetworkInterfaceId)
+	if err != nil {
+		return nil, err
+	}
+	if ni.ipv6Addresses, err = removeAddresses(ni.ipv6Addresses, aws.StringValueSlice(input.Ipv6Addresses)); err != nil {
+		return nil, err
+	}
+	f.publishInterface(ni)
+	return &ec2.UnassignIpv6AddressesOutput{NetworkInterfaceId: input.NetworkInterfaceId, UnassignedIpv6Addresses: input.Ipv6Addresses}, nil
+}
+
+// CreateNetworkInterfaceWithContext creates an available interface in the fake's subnet
+func (f *EC2) CreateNetworkInterfaceWithContext(ctx aws.Context, input *ec2.CreateNetworkInterfaceInput, opts ...request.Option) (*ec2.CreateNetworkInterfaceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "CreateNetworkInterface"); err != nil {
+		return nil, err
+	}
+	if aws.StringValue(input.SubnetId) != SubnetID {
+		return nil, awserr.New("InvalidSubnetID.NotFound", fmt.Sprintf("The subnet ID '%s' does not exist", aws.StringValue(input.SubnetId)), nil)
+	}
+	securityGroups := aws.StringValueSlice(input.Groups)
+	if len(securityGroups) == 0 {
+		securityGroups = []string{DefaultSecurityGroup}
+	}
+	if err := f.checkSecurityGroups(securityGroups); err != nil {
+		return nil, err
+	}
+	ni := f.createInterface(aws.StringValue(input.Description), securityGroups)
+	return &ec2.CreateNetworkInterfaceOutput{NetworkInterface: ni.describe()}, nil
+}
+
+// AttachNetworkInterfaceWithContext attaches an available interface to the fake's instance
+func (f *EC2) AttachNetworkInterfaceWithContext(ctx aws.Context, input *ec2.AttachNetworkInterfaceInput, opts ...request.Option) (*ec2.AttachNetworkInterfaceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "AttachNetworkInterface"); err != nil {
+		return nil, err
+	}
+	if aws.StringValue(input.InstanceId) != InstanceID {
+		return nil, awserr.New("InvalidInstanceID.NotFound", fmt.Sprintf("The instance ID '%s' does not exist", aws.StringValue(input.InstanceId)), nil)
+	}
+	ni, err := f.getInterface(input.NetworkInterfaceId)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.attach(ni, int(aws.Int64Value(input.DeviceIndex))); err != nil {
+		return nil, err
+	}
+	return &ec2.AttachNetworkInterfaceOutput{AttachmentId: aws.String(ni.attachmentID)}, nil
+}
+
+// ModifyNetworkInterfaceAttributeWithContext changes an interface's security groups, or whether it's deleted on
+// termination
+func (f *EC2) ModifyNetworkInterfaceAttributeWithContext(ctx aws.Context, input *ec2.ModifyNetworkInterfaceAttributeInput, opts ...request.Option) (*ec2.ModifyNetworkInterfaceAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "ModifyNetworkInterfaceAttribute"); err != nil {
+		return nil, err
+	}
+	ni, err := f.getInterface(input.NetworkInterfaceId)
+	if err != nil {
+		return nil, err
+	}
+	if input.Groups != nil {
+		securityGroups := aws.StringValueSlice(input.Groups)
+		if err = f.checkSecurityGroups(securityGroups); err != nil {
+			return nil, err
+		}
+		ni.securityGroups = securityGroups
+	}
+	if input.Attachment != nil {
+		if aws.StringValue(input.Attachment.AttachmentId) != ni.attachmentID {
+			return nil, awserr.New("InvalidAttachmentID.NotFound", fmt.Sprintf("The attachment ID '%s' does not exist", aws.StringValue(input.Attachment.AttachmentId)), nil)
+		}
+		ni.deleteOnTermination = aws.BoolValue(input.Attachment.DeleteOnTermination)
+	}
+	f.publishInterface(ni)
+	return &ec2.ModifyNetworkInterfaceAttributeOutput{}, nil
+}
+
+// DescribeNetworkInterfacesWithContext describes interfaces by ID, and the description, status, tag-key, and
+// attachment.instance-id filters
+func (f *EC2) DescribeNetworkInterfacesWithContext(ctx aws.Context, input *ec2.DescribeNetworkInterfacesInput, opts ...request.Option) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "DescribeNetworkInterfaces"); err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	if len(input.NetworkInterfaceIds) > 0 {
+		for _, id := range input.NetworkInterfaceIds {
+			if _, err := f.getInterface(id); err != nil {
+				return nil, err
+			}
+		}
+		ids = aws.StringValueSlice(input.NetworkInterfaceIds)
+	} else {
+		for id := range f.interfaces {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	output := &ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: []*ec2.NetworkInterface{}}
+	for _, id := range ids {
+		described := f.interfaces[id].describe()
+		matches, err := matchesFilters(described, input.Filters)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			output.NetworkInterfaces = append(output.NetworkInterfaces, described)
+		}
+	}
+	return output, nil
+}
+
+// DeleteNetworkInterfaceWithContext deletes an interface, which mustn't be attached
+func (f *EC2) DeleteNetworkInterfaceWithContext(ctx aws.Context, input *ec2.DeleteNetworkInterfaceInput, opts ...request.Option) (*ec2.DeleteNetworkInterfaceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "DeleteNetworkInterface"); err != nil {
+		return nil, err
+	}
+	ni, err := f.getInterface(input.NetworkInterfaceId)
+	if err != nil {
+		return nil, err
+	}
+	if ni.attached() {
+		return nil, awserr.New("InvalidNetworkInterface.InUse", fmt.Sprintf("Interface: [%s] in use", ni.id), nil)
+	}
+	delete(f.interfaces, ni.id)
+	return &ec2.DeleteNetworkInterfaceOutput{}, nil
+}
+
+// CreateTagsWithContext tags interfaces
+func (f *EC2) CreateTagsWithContext(ctx aws.Context, input *ec2.CreateTagsInput, opts ...request.Option) (*ec2.CreateTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "CreateTags"); err != nil {
+		return nil, err
+	}
+	interfaces, err := f.getInterfaces(input.Resources)
+	if err != nil {
+		return nil, err
+	}
+	for _, ni := range interfaces {
+		for _, tag := range input.Tags {
+			ni.tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// DeleteTagsWithContext removes tags from interfaces. Tags without values are removed whatever their value.
+func (f *EC2) DeleteTagsWithContext(ctx aws.Context, input *ec2.DeleteTagsInput, opts ...request.Option) (*ec2.DeleteTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "DeleteTags"); err != nil {
+		return nil, err
+	}
+	interfaces, err := f.getInterfaces(input.Resources)
+	if err != nil {
+		return nil, err
+	}
+	for _, ni := range interfaces {
+		for _, tag := range input.Tags {
+			if value, ok := ni.tags[aws.StringValue(tag.Key)]; ok && (tag.Value == nil || *tag.Value == value) {
+				delete(ni.tags, aws.StringValue(tag.Key))
+			}
+		}
+	}
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+// DescribeSubnetsWithContext describes the fake's subnet
+func (f *EC2) DescribeSubnetsWithContext(ctx aws.Context, input *ec2.DescribeSubnetsInput, opts ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "DescribeSubnets"); err != nil {
+		return nil, err
+	}
+	for _, id := range input.SubnetIds {
+		if aws.StringValue(id) != SubnetID {
+			return nil, awserr.New("InvalidSubnetID.NotFound", fmt.Sprintf("The subnet ID '%s' does not exist", aws.StringValue(id)), nil)
+		}
+	}
+	return &ec2.DescribeSubnetsOutput{
+		Subnets: []*ec2.Subnet{
+			{
+				AvailabilityZone: aws.String(availabilityZone),
+				CidrBlock:        aws.String(subnetCIDR),
+				Ipv6CidrBlockAssociationSet: []*ec2.SubnetIpv6CidrBlockAssociation{
+					{
+						Ipv6CidrBlock:      aws.String(subnetIPv6CIDR),
+						Ipv6CidrBlockState: &ec2.SubnetCidrBlockState{State: aws.String("associated")},
+					},
+				},
+				State:    aws.String("available"),
+				SubnetId: aws.String(SubnetID),
+				VpcId:    aws.String(vpcID),
+			},
+		},
+	}, nil
+}
+
+// call must be called with the fake locked
+func (f *EC2) call(ctx aws.Context, api string) error {
+	f.calls[api]++
+	return ctx.Err()
+}
+
+func (f *EC2) nextID() int {
+	f.lastID++
+	return f.lastID
+}
+
+func (f *EC2) nextIPv4() string {
+	f.lastIPv4 = nextIP(f.lastIPv4.To4())
+	return f.lastIPv4.String()
+}
+
+func (f *EC2) nextIPv6() string {
+	f.lastIPv6 = nextIP(f.lastIPv6.To16())
+	return f.lastIPv6.String()
+}
+
+func nextIP(ip net.IP) net.IP {
+	ret := append(net.IP{}, ip...)
+	for i := len(ret) - 1; i >= 0; i-- {
+		ret[i]++
+		if ret[i] != 0 {
+			break
+		}
+	}
+	return ret
+}
+
+func (f *EC2) createInterface(description string, securityGroups []string) *networkInterface {
+	id := f.nextID()
+	ni := &networkInterface{
+		id:             fmt.Sprintf("eni-%017x", id),
+		mac:            fmt.Sprintf("0a:00:00:00:%02x:%02x", (id>>8)&0xff, id&0xff),
+		description:    description,
+		securityGroups: securityGroups,
+		ipv4Addresses:  []string{f.nextIPv4()},
+		tags:           make(map[string]string),
+	}
+	f.interfaces[ni.id] = ni
+	return ni
+}
+
+func (f *EC2) attach(ni *networkInterface, deviceIndex int) error {
+	if ni.attached() {
+		return awserr.New("InvalidNetworkInterface.InUse", fmt.Sprintf("Interface: [%s] in use", ni.id), nil)
+	}
+	limits, err := vpc.GetLimits(f.InstanceType)
+	if err != nil {
+		return err
+	}
+	if deviceIndex >= limits.Interfaces {
+		return awserr.New("AttachmentLimitExceeded", fmt.Sprintf("Interface count %d exceeds the limit for %s", deviceIndex+1, f.InstanceType), nil)
+	}
+	for _, other := range f.interfaces {
+		if other.attached() && other.deviceIndex == deviceIndex {
+			return awserr.New("InvalidParameterValue", fmt.Sprintf("Instance %s already has an interface attached at device index %d", InstanceID, deviceIndex), nil)
+		}
+	}
+	ni.attachmentID = fmt.Sprintf("eni-attach-%017x", f.nextID())
+	ni.deviceIndex = deviceIndex
+	f.publishInterface(ni)
+	return nil
+}
+
+func (f *EC2) checkSecurityGroups(securityGroups []string) error {
+	for _, sg := range securityGroups {
+		if _, ok := f.securityGroups[sg]; !ok {
+			return awserr.New("InvalidGroup.NotFound", fmt.Sprintf("The security group '%s' does not exist", sg), nil)
+		}
+	}
+	return nil
+}
+
+func (f *EC2) getInterface(id *string) (*networkInterface, error) {
+	if ni, ok := f.interfaces[aws.StringValue(id)]; ok {
+		return ni, nil
+	}
+	return nil, awserr.New("InvalidNetworkInterfaceID.NotFound", fmt.Sprintf("The networkInterface ID '%s' does not exist", aws.StringValue(id)), nil)
+}
+
+func (f *EC2) getInterfaces(ids []*string) ([]*networkInterface, error) {
+	ret := make([]*networkInterface, len(ids))
+	for idx, id := range ids {
+		ni, err := f.getInterface(id)
+		if err != nil {
+			return nil, err
+		}
+		ret[idx] = ni
+	}
+	return ret, nil
+}
+
+// publishInterface makes the interface's current state visible to the metadata service after MetadataDelay, if it's
+// attached to the instance
+func (f *EC2) publishInterface(ni *networkInterface) {
+	if ni.attached() {
+		f.publish(ni.mac, ni.copy())
+	}
+}
+
+func (f *EC2) publish(mac string, ni *networkInterface) {
+	f.pendingMetadata = append(f.pendingMetadata, metadataUpdate{
+		visibleAt:        time.Now().Add(f.MetadataDelay),
+		mac:              mac,
+		networkInterface: ni,
+	})
+}
+
+// applyMetadataUpdates must be called with the fake locked
+func (f *EC2) applyMetadataUpdates(now time.Time) {
+	idx := 0
+	for ; idx < len(f.pendingMetadata) && !f.pendingMetadata[idx].visibleAt.After(now); idx++ {
+		update := f.pendingMetadata[idx]
+		if update.networkInterface == nil {
+			delete(f.metadata, update.mac)
+		} else {
+			f.metadata[update.mac] = update.networkInterface
+		}
+	}
+	f.pendingMetadata = f.pendingMetadata[idx:]
+}
+
+func removeAddresses(addresses, toRemove []string) ([]string, error) {
+	removeSet := make(map[string]struct{}, len(toRemove))
+	for _, addr := range toRemove {
+		removeSet[addr] = struct{}{}
+	}
+	ret := []string{}
+	for _, addr := range addresses {
+		if _, ok := removeSet[addr]; ok {
+			delete(removeSet, addr)
+			continue
+		}
+		ret = append(ret, addr)
+	}
+	for addr := range removeSet {
+		return nil, awserr.New("InvalidParameterValue", fmt.Sprintf("Address %s is not assigned to the interface", addr), nil)
+	}
+	return ret, nil
+}
+
+func (ni *networkInterface) describe() *ec2.NetworkInterface {
+	ret := &ec2.NetworkInterface{
+		AvailabilityZone:   aws.String(availabilityZone),
+		Description:        aws.String(ni.description),
+		MacAddress:         aws.String(ni.mac),
+		NetworkInterfaceId: aws.String(ni.id),
+		PrivateIpAddress:   aws.String(ni.ipv4Addresses[0]),
+		Status:             aws.String("available"),
+		SubnetId:           aws.String(SubnetID),
+		VpcId:              aws.String(vpcID),
+	}
+	for _, sg := range ni.securityGroups {
+		ret.Groups = append(ret.Groups, &ec2.GroupIdentifier{GroupId: aws.String(sg)})
+	}
+	for idx, addr := range ni.ipv4Addresses {
+		ret.PrivateIpAddresses = append(ret.PrivateIpAddresses, &ec2.NetworkInterfacePrivateIpAddress{
+			Primary:          aws.Bool(idx == 0),
+			PrivateIpAddress: aws.String(addr),
+		})
+	}
+	for _, addr := range ni.ipv6Addresses {
+		ret.Ipv6Addresses = append(ret.Ipv6Addresses, &ec2.NetworkInterfaceIpv6Address{Ipv6Address: aws.String(addr)})
+	}
+	keys := make([]string, 0, len(ni.tags))
+	for k := range ni.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ret.TagSet = append(ret.TagSet, &ec2.Tag{Key: aws.String(k), Value: aws.String(ni.tags[k])})
+	}
+	if ni.attached() {
+		ret.Status = aws.String("in-use")
+		ret.Attachment = &ec2.NetworkInterfaceAttachment{
+			AttachmentId:        aws.String(ni.attachmentID),
+			DeleteOnTermination: aws.Bool(ni.deleteOnTermination),
+			DeviceIndex:         aws.Int64(int64(ni.deviceIndex)),
+			InstanceId:          aws.String(InstanceID),
+			Status:              aws.String("attached"),
+		}
+	}
+	return ret
+}
+
+func matchesFilters(ni *ec2.NetworkInterface, filters []*ec2.Filter) (bool, error) {
+	for _, filter := range filters {
+		var values []string
+		switch aws.StringValue(filter.Name) {
+		case "description":
+			values = []string{aws.StringValue(ni.Description)}
+		case "status":
+			values = []string{aws.StringValue(ni.Status)}
+		case "tag-key":
+			for _, tag := range ni.TagSet {
+				values = append(values, aws.StringValue(tag.Key))
+			}
+		case "subnet-id":
+			values = []string{aws.StringValue(ni.SubnetId)}
+		case "availability-zone":
+			values = []string{aws.StringValue(ni.AvailabilityZone)}
+		case "attachment.instance-id":
+			if ni.Attachment != nil {
+				values = []string{aws.StringValue(ni.Attachment.InstanceId)}
+			}
+		default:
+			return false, awserr.New("InvalidParameterValue", fmt.Sprintf("The filter '%s' is invalid", aws.StringValue(filter.Name)), nil)
+		}
+		if !anyMatch(values, aws.StringValueSlice(filter.Values)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func anyMatch(values, wanted []string) bool {
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+	return false
+}
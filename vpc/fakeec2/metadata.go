@@ -0,0 +1,79 @@
+package fakeec2
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// GetMetadata serves the parts of the metadata service about the instance, and its interfaces, as of MetadataDelay ago
+func (f *EC2) GetMetadata(path string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["GetMetadata"]++
+	f.applyMetadataUpdates(time.Now())
+
+	switch path {
+	case "instance-id":
+		return InstanceID, nil
+	case "mac":
+		for mac, ni := range f.metadata {
+			if ni.deviceIndex == 0 {
+				return mac, nil
+			}
+		}
+		return "", notFound(path)
+	case "network/interfaces/macs/":
+		macs := make([]string, 0, len(f.metadata))
+		for mac := range f.metadata {
+			macs = append(macs, mac+"/")
+		}
+		sort.Strings(macs)
+		return strings.Join(macs, "\n"), nil
+	}
+
+	if !strings.HasPrefix(path, "network/interfaces/macs/") {
+		return "", notFound(path)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "network/interfaces/macs/"), "/", 2)
+	ni, ok := f.metadata[parts[0]]
+	if !ok || len(parts) != 2 {
+		return "", notFound(path)
+	}
+
+	switch parts[1] {
+	case "":
+		keys := []string{"device-number", "interface-id", "local-ipv4s", "mac", "security-group-ids", "subnet-id"}
+		// Like the real thing, the ipv6s key only shows up once the interface has IPv6 addresses
+		if len(ni.ipv6Addresses) > 0 {
+			keys = append(keys, "ipv6s")
+		}
+		sort.Strings(keys)
+		return strings.Join(keys, "\n"), nil
+	case "device-number":
+		return fmt.Sprint(ni.deviceIndex), nil
+	case "interface-id":
+		return ni.id, nil
+	case "mac":
+		return ni.mac, nil
+	case "subnet-id":
+		return SubnetID, nil
+	case "security-group-ids":
+		return strings.Join(ni.securityGroups, "\n"), nil
+	case "local-ipv4s":
+		return strings.Join(ni.ipv4Addresses, "\n"), nil
+	case "ipv6s":
+		if len(ni.ipv6Addresses) > 0 {
+			return strings.Join(ni.ipv6Addresses, "\n"), nil
+		}
+	}
+	return "", notFound(path)
+}
+
+func notFound(path string) error {
+	return awserr.NewRequestFailure(awserr.New("EC2MetadataError", fmt.Sprintf("failed to make EC2Metadata request for %s: 404 - Not Found", path), nil), http.StatusNotFound, "")
+}
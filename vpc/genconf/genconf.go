@@ -40,9 +40,16 @@ func genConf(parentCtx *context.VPCContext) error {
 }
 
 func doGenConf(parentCtx *context.VPCContext) error {
-	maxInterfaces := vpc.GetMaxInterfaces(parentCtx.InstanceType)
-	maxIPs := vpc.GetMaxIPv4Addresses(parentCtx.InstanceType)
-	maxNetworkMbps := vpc.GetMaxNetworkMbps(parentCtx.InstanceType)
+	limits, err := vpc.GetLimits(parentCtx.InstanceType)
+	if err != nil {
+		return err
+	}
+	if limits.Derived {
+		parentCtx.Logger.WithField("instanceType", parentCtx.InstanceType).Warningf("Instance type not in limits table, using limits derived from its size: %+v", *limits)
+	}
+	maxInterfaces := limits.Interfaces
+	maxIPs := limits.IPv4AddressesPerInterface
+	maxNetworkMbps := limits.NetworkThroughput
 	// The number of interfaces exposed to the Titus scheduler is the maximum number of interfaces this instance can handle minus 1.
 	resourceSet := fmt.Sprintf("ResourceSet-ENIs-%d-%d", maxInterfaces-1, maxIPs)
 	if resourceSetOnly {
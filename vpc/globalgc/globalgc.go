@@ -2,6 +2,8 @@ package globalgc
 
 import (
 	"fmt"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/Netflix/titus-executor/vpc/context"
@@ -14,6 +16,8 @@ import (
 
 const (
 	markTag = "titus-gc-mark-time"
+	// batchSize is how many interfaces are tagged, or untagged, per call
+	batchSize = 10
 )
 
 var GlobalGC = cli.Command{ // nolint: golint
@@ -31,99 +35,193 @@ var GlobalGC = cli.Command{ // nolint: golint
 			Usage: "How long an ENI has to be detached before we will clean it up",
 			Value: time.Minute * 30,
 		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Report what would be marked, unmarked, and deleted, without changing anything",
+		},
+		cli.IntFlag{
+			Name:  "max-deletions",
+			Usage: "Maximum number of ENIs to delete per run, the oldest marked are deleted first, and the rest are left for the next run. 0 means unlimited",
+			Value: 100,
+		},
+		cli.StringSliceFlag{
+			Name:  "subnet",
+			Usage: "Only GC ENIs in this subnet, can be specified multiple times",
+		},
+		cli.StringSliceFlag{
+			Name:  "availability-zone",
+			Usage: "Only GC ENIs in this availability zone, can be specified multiple times",
+		},
+		cli.StringFlag{
+			Name:  "output",
+			Usage: "How to print the report, either text or json",
+			Value: outputText,
+		},
 	},
 }
 
+// InconsistentResultError is returned if AWS describes an interface which doesn't match the filters it was asked for.
+// It's usually transient, so GC should be run again.
+type InconsistentResultError struct {
+	Reason error
+}
+
+func (e *InconsistentResultError) Error() string {
+	return fmt.Sprintf("Inconsistent result from AWS : %s", e.Reason.Error())
+}
+
+type gcOptions struct {
+	minDetachTime     time.Duration
+	maxDeletions      int
+	dryRun            bool
+	subnets           []string
+	availabilityZones []string
+}
+
 func globalGc(parentCtx *context.VPCContext) error {
 	timeout := parentCtx.CLIContext.Duration("timeout")
 	ctx, cancel := parentCtx.WithTimeout(timeout)
 	defer cancel()
 
-	minDetachTime := parentCtx.CLIContext.Duration("detach-time")
+	opts := gcOptions{
+		minDetachTime:     parentCtx.CLIContext.Duration("detach-time"),
+		maxDeletions:      parentCtx.CLIContext.Int("max-deletions"),
+		dryRun:            parentCtx.CLIContext.Bool("dry-run"),
+		subnets:           parentCtx.CLIContext.StringSlice("subnet"),
+		availabilityZones: parentCtx.CLIContext.StringSlice("availability-zone"),
+	}
+	if opts.maxDeletions < 0 {
+		return cli.NewExitError("max-deletions must not be negative", 1)
+	}
+	output := parentCtx.CLIContext.String("output")
+	if output != outputText && output != outputJSON {
+		return cli.NewExitError(fmt.Sprintf("Unknown output format: %s", output), 1)
+	}
 
-	if err := doGlobalGc(ctx, minDetachTime); err != nil {
+	report, err := doGlobalGc(ctx, opts)
+	// Whatever was done before an error is still worth reporting
+	if report != nil {
+		if printErr := report.print(os.Stdout, output); printErr != nil {
+			parentCtx.Logger.Error("Unable to print report: ", printErr)
+		}
+	}
+	if err != nil {
 		return cli.NewMultiError(cli.NewExitError("Unable to run GC", 1), err)
 	}
 
 	return nil
 }
 
-func doGlobalGc(parentCtx *context.VPCContext, minDetachTime time.Duration) error {
-	ec2Client := ec2.New(parentCtx.AWSSession)
-
-	describeAvailableRequest := &ec2.DescribeNetworkInterfacesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("description"),
-				Values: aws.StringSlice([]string{setup.NetworkInterfaceDescription}),
-			},
-			{
-				Name:   aws.String("status"),
-				Values: aws.StringSlice([]string{"available"}),
-			},
-		},
-	}
+func doGlobalGc(parentCtx *context.VPCContext, opts gcOptions) (*Report, error) {
+	report := &Report{DryRun: opts.dryRun, MaxDeletions: opts.maxDeletions, Interfaces: []*InterfaceReport{}}
 
-	networkInterfaces, err := ec2Client.DescribeNetworkInterfacesWithContext(parentCtx, describeAvailableRequest)
+	networkInterfaces, err := describeNetworkInterfaces(parentCtx, opts, &ec2.Filter{
+		Name:   aws.String("status"),
+		Values: aws.StringSlice([]string{"available"}),
+	})
 	if err != nil {
-		return err
+		return report, err
 	}
 
 	now := time.Now()
 	// Get the candidates, and selected
-	candidates, selected := markAndCollect(parentCtx, networkInterfaces, minDetachTime, now)
-	parentCtx.Logger.Info("Going to GC: ", selected)
-	// Delete the selected
-	err = deleteSelected(parentCtx, selected)
+	candidates, selected, err := markAndCollect(parentCtx, networkInterfaces, opts.minDetachTime, now)
+	if err != nil {
+		return report, err
+	}
+	selected = limitDeletions(parentCtx, selected, opts.maxDeletions)
+	report.add(selected...)
+	report.add(candidates...)
+
+	// Find incorrectly marked interfaces
+	markedNetworkInterfaces, err := describeNetworkInterfaces(parentCtx, opts,
+		&ec2.Filter{
+			Name:   aws.String("status"),
+			Values: aws.StringSlice([]string{"in-use"}),
+		},
+		&ec2.Filter{
+			Name:   aws.String("tag-key"),
+			Values: aws.StringSlice([]string{markTag}),
+		},
+	)
 	if err != nil {
-		return err
+		return report, err
+	}
+	unmarked := make([]*InterfaceReport, len(markedNetworkInterfaces))
+	for idx, iface := range markedNetworkInterfaces {
+		unmarked[idx] = newInterfaceReport(iface, ActionUnmark)
+	}
+	report.add(unmarked...)
+
+	if opts.dryRun {
+		parentCtx.Logger.Info("Dry run, not changing any interfaces")
+		return report, nil
+	}
+
+	parentCtx.Logger.Info("Going to GC: ", interfaceIDs(selected, ActionDelete))
+	// Delete the selected
+	if err = deleteSelected(parentCtx, selected); err != nil {
+		return report, err
 	}
 
 	// Mark the candidates
-	if len(candidates) > 0 {
-		err = doMark(parentCtx, candidates, now, ec2Client)
-		if err != nil {
-			return err
-		}
+	if err = doMark(parentCtx, interfaceIDs(candidates, ActionMark), now); err != nil {
+		return report, err
 	}
 
-	// Remove incorrectly marked interfaces
-	describeMarkedRequest := &ec2.DescribeNetworkInterfacesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("description"),
-				Values: aws.StringSlice([]string{setup.NetworkInterfaceDescription}),
-			},
-			{
-				Name:   aws.String("status"),
-				Values: aws.StringSlice([]string{"in-use"}),
-			},
-			{
-				Name:   aws.String("tag-key"),
-				Values: aws.StringSlice([]string{markTag}),
-			},
+	// Remove the marks from attached interfaces
+	if err = doUnmark(parentCtx, interfaceIDs(unmarked, ActionUnmark)); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// describeNetworkInterfaces describes our interfaces, in the subnets, and availability zones, GC is scoped to. The EC2
+// API version we use returns every matching interface in one response, so there are no pages to follow here, but
+// everything done to the results is batched.
+func describeNetworkInterfaces(parentCtx *context.VPCContext, opts gcOptions, filters ...*ec2.Filter) ([]*ec2.NetworkInterface, error) {
+	filters = append([]*ec2.Filter{
+		{
+			Name:   aws.String("description"),
+			Values: aws.StringSlice([]string{setup.NetworkInterfaceDescription}),
 		},
+	}, filters...)
+	if len(opts.subnets) > 0 {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("subnet-id"),
+			Values: aws.StringSlice(opts.subnets),
+		})
+	}
+	if len(opts.availabilityZones) > 0 {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("availability-zone"),
+			Values: aws.StringSlice(opts.availabilityZones),
+		})
 	}
 
-	markedNetworkInterfaces, err := ec2Client.DescribeNetworkInterfacesWithContext(parentCtx, describeMarkedRequest)
+	output, err := parentCtx.EC2.DescribeNetworkInterfacesWithContext(parentCtx, &ec2.DescribeNetworkInterfacesInput{Filters: filters})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return output.NetworkInterfaces, nil
+}
 
-	attachedInterfaces := extractNetworkInterfaces(markedNetworkInterfaces)
-	if len(attachedInterfaces) > 0 {
-		parentCtx.Logger.Info("Removing gc mark from interfaces: ", attachedInterfaces)
-		deleteTagsInput := &ec2.DeleteTagsInput{
-			Resources: attachedInterfaces,
+func doMark(parentCtx *context.VPCContext, candidates []string, now time.Time) error {
+	for pageBegin := 0; pageBegin < len(candidates); pageBegin += batchSize {
+		pageEnd := min(len(candidates), pageBegin+batchSize)
+		parentCtx.Logger.Info("Marking candidates: ", candidates[pageBegin:pageEnd])
+		createTagsInput := &ec2.CreateTagsInput{
+			Resources: aws.StringSlice(candidates[pageBegin:pageEnd]),
 			Tags: []*ec2.Tag{
 				{
-					Key: aws.String(markTag),
+					Key:   aws.String(markTag),
+					Value: aws.String(now.Format(time.RFC3339)),
 				},
 			},
 		}
-		_, err = ec2Client.DeleteTagsWithContext(parentCtx, deleteTagsInput)
-
-		if err != nil {
+		_, err := parentCtx.EC2.CreateTagsWithContext(parentCtx, createTagsInput)
+		if err = ignoreNotFound(parentCtx, err); err != nil {
 			return err
 		}
 	}
@@ -131,73 +229,85 @@ func doGlobalGc(parentCtx *context.VPCContext, minDetachTime time.Duration) erro
 	return nil
 }
 
-func doMark(parentCtx *context.VPCContext, candidates []string, now time.Time, ec2Client *ec2.EC2) error {
-	// Split it up into chunks of 10
-	for i := 0; i*10 < len(candidates); i++ {
-		pageBegin := i * 10
-		pageEnd := min(len(candidates), (i+1)*10)
-		parentCtx.Logger.Info("Marking candidates: ", candidates[pageBegin:pageEnd])
-		createTagsInput := &ec2.CreateTagsInput{
-			Resources: aws.StringSlice(candidates[pageBegin:pageEnd]),
+func doUnmark(parentCtx *context.VPCContext, attachedInterfaces []string) error {
+	for pageBegin := 0; pageBegin < len(attachedInterfaces); pageBegin += batchSize {
+		pageEnd := min(len(attachedInterfaces), pageBegin+batchSize)
+		parentCtx.Logger.Info("Removing gc mark from interfaces: ", attachedInterfaces[pageBegin:pageEnd])
+		deleteTagsInput := &ec2.DeleteTagsInput{
+			Resources: aws.StringSlice(attachedInterfaces[pageBegin:pageEnd]),
 			Tags: []*ec2.Tag{
 				{
-					Key:   aws.String(markTag),
-					Value: aws.String(now.Format(time.RFC3339)),
+					Key: aws.String(markTag),
 				},
 			},
 		}
-		_, err := ec2Client.CreateTagsWithContext(parentCtx, createTagsInput)
-		// Is this an actual fatal error?
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == "InvalidNetworkInterfaceID.NotFound" {
-				parentCtx.Logger.Warning("Unable to process batch because: ", err)
-			} else {
-				return err
-			}
+		_, err := parentCtx.EC2.DeleteTagsWithContext(parentCtx, deleteTagsInput)
+		if err = ignoreNotFound(parentCtx, err); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func extractNetworkInterfaces(networkInterfaces *ec2.DescribeNetworkInterfacesOutput) []*string {
-	ret := make([]*string, len(networkInterfaces.NetworkInterfaces))
-	for idx, iface := range networkInterfaces.NetworkInterfaces {
-		ret[idx] = iface.NetworkInterfaceId
+// ignoreNotFound swallows errors about interfaces which were deleted since they were described
+func ignoreNotFound(parentCtx *context.VPCContext, err error) error {
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "InvalidNetworkInterfaceID.NotFound" {
+		parentCtx.Logger.Warning("Unable to process batch because: ", err)
+		return nil
 	}
-	return ret
+	return err
 }
 
-// Returns ENI IDs that match the criteria to be GCd, and marks ENIs which could be elected for the next generation of GC
-func markAndCollect(parentCtx *context.VPCContext, networkInterfaces *ec2.DescribeNetworkInterfacesOutput, minDetachTime time.Duration, now time.Time) ([]string, []string) {
-	candidates := []string{}
-	selected := []string{}
+// Returns reports for the ENIs which could be elected for the next generation of GC, and the ENIs which match the
+// criteria to be GCd, oldest marked first
+func markAndCollect(parentCtx *context.VPCContext, networkInterfaces []*ec2.NetworkInterface, minDetachTime time.Duration, now time.Time) ([]*InterfaceReport, []*InterfaceReport, error) {
+	candidates := []*InterfaceReport{}
+	selected := []*InterfaceReport{}
 
-	for _, iface := range networkInterfaces.NetworkInterfaces {
-		// AWS's APIs can return inconsistent results, so these might panic. If so, we should run again.
-		if *iface.Description != setup.NetworkInterfaceDescription {
-			panic(fmt.Sprintf("Interface description is %s instead of %s", *iface.Description, setup.NetworkInterfaceDescription))
+	for _, iface := range networkInterfaces {
+		// AWS's APIs can return inconsistent results. If so, we should run again.
+		if aws.StringValue(iface.Description) != setup.NetworkInterfaceDescription {
+			return nil, nil, &InconsistentResultError{Reason: fmt.Errorf("Interface %s description is %s instead of %s", aws.StringValue(iface.NetworkInterfaceId), aws.StringValue(iface.Description), setup.NetworkInterfaceDescription)}
 		}
-		if *iface.Status != "available" {
-			panic(fmt.Sprintf("Interface status is %s instead of available", *iface.Status))
+		if aws.StringValue(iface.Status) != "available" {
+			return nil, nil, &InconsistentResultError{Reason: fmt.Errorf("Interface %s status is %s instead of available", aws.StringValue(iface.NetworkInterfaceId), aws.StringValue(iface.Status))}
 		}
-		ifaceTags := tagSetToMap(iface.TagSet)
-		if markTagValue, ok := ifaceTags[markTag]; ok && markTagValue != nil {
-			markTimestamp, err := time.Parse(time.RFC3339, *markTagValue)
-			if err != nil {
-				// This shouldn't happen.
-				parentCtx.Logger.Error("Unable to parse marktimestamp: ", markTagValue)
-			} else if now.Sub(markTimestamp) > minDetachTime {
-				parentCtx.Logger.Debug("Marking interface as selected: ", *iface)
-				selected = append(selected, *iface.NetworkInterfaceId)
-			}
-		} else {
+		if iface.Attachment != nil && aws.StringValue(iface.Attachment.Status) == "attached" {
+			return nil, nil, &InconsistentResultError{Reason: fmt.Errorf("Interface %s is available, but attached to %s", aws.StringValue(iface.NetworkInterfaceId), aws.StringValue(iface.Attachment.InstanceId))}
+		}
+
+		ifaceReport := newInterfaceReport(iface, ActionMark)
+		if ifaceReport.MarkedAt == nil {
 			parentCtx.Logger.Debug("Marking interface as candidate: ", *iface)
-			candidates = append(candidates, *iface.NetworkInterfaceId)
+			candidates = append(candidates, ifaceReport)
+		} else if now.Sub(*ifaceReport.MarkedAt) > minDetachTime {
+			parentCtx.Logger.Debug("Marking interface as selected: ", *iface)
+			ifaceReport.Action = ActionDelete
+			selected = append(selected, ifaceReport)
+		} else {
+			ifaceReport.Action = ActionWait
+			candidates = append(candidates, ifaceReport)
 		}
 	}
 
-	return candidates, selected
+	sort.SliceStable(selected, func(i, k int) bool {
+		return selected[i].MarkedAt.Before(*selected[k].MarkedAt)
+	})
+	return candidates, selected, nil
+}
+
+// limitDeletions defers the deletion of the selected ENIs beyond maxDeletions to the next run, so a bad mark, or an
+// inconsistent view of the account, can only do bounded damage
+func limitDeletions(parentCtx *context.VPCContext, selected []*InterfaceReport, maxDeletions int) []*InterfaceReport {
+	if maxDeletions == 0 || len(selected) <= maxDeletions {
+		return selected
+	}
+	parentCtx.Logger.Warningf("%d interfaces selected for deletion, only deleting %d of them this run", len(selected), maxDeletions)
+	for _, ifaceReport := range selected[maxDeletions:] {
+		ifaceReport.Action = ActionDeferred
+	}
+	return selected
 }
 
 func tagSetToMap(tagSet []*ec2.Tag) map[string]*string {
@@ -212,16 +322,28 @@ func tagSetToMap(tagSet []*ec2.Tag) map[string]*string {
 	return ret
 }
 
-func deleteSelected(parentCtx *context.VPCContext, selected []string) error {
-	ec2Client := ec2.New(parentCtx.AWSSession)
-	for _, iface := range selected {
-		ctx := parentCtx.WithField("iface", iface)
+func deleteSelected(parentCtx *context.VPCContext, selected []*InterfaceReport) error {
+	for _, ifaceReport := range selected {
+		if ifaceReport.Action != ActionDelete {
+			continue
+		}
+		ctx := parentCtx.WithField("iface", ifaceReport.NetworkInterfaceID)
 		deleteNetworkInterfaceInput := &ec2.DeleteNetworkInterfaceInput{
-			NetworkInterfaceId: aws.String(iface),
+			NetworkInterfaceId: aws.String(ifaceReport.NetworkInterfaceID),
 		}
 		ctx.Logger.Debug("Deleting interface")
-		_, err := ec2Client.DeleteNetworkInterfaceWithContext(ctx, deleteNetworkInterfaceInput)
+		_, err := ctx.EC2.DeleteNetworkInterfaceWithContext(ctx, deleteNetworkInterfaceInput)
+		if awsErr, ok := err.(awserr.Error); ok {
+			// Someone else deleted, or attached, the interface since we described it
+			switch awsErr.Code() {
+			case "InvalidNetworkInterfaceID.NotFound", "InvalidNetworkInterface.InUse":
+				ctx.Logger.Warning("Unable to delete interface: ", err)
+				ifaceReport.Error = err.Error()
+				continue
+			}
+		}
 		if err != nil {
+			ifaceReport.Error = err.Error()
 			return err
 		}
 		ctx.Logger.Debug("Deleted interface")
@@ -0,0 +1,158 @@
+package globalgc
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Netflix/titus-executor/vpc/fakeec2"
+	"github.com/Netflix/titus-executor/vpc/setup"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tagValue(fake *fakeec2.EC2, eni, key string) *string {
+	for _, tag := range fake.Interface(eni).TagSet {
+		if aws.StringValue(tag.Key) == key {
+			return tag.Value
+		}
+	}
+	return nil
+}
+
+func actions(report *Report) map[string]Action {
+	ret := make(map[string]Action)
+	for _, ifaceReport := range report.Interfaces {
+		ret[ifaceReport.NetworkInterfaceID] = ifaceReport.Action
+	}
+	return ret
+}
+
+func TestGlobalGC(t *testing.T) {
+	fake := fakeec2.New("c5.large")
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	now := time.Now()
+	unmarked := fake.CreateDetachedInterface(setup.NetworkInterfaceDescription, nil)
+	recentlyMarked := fake.CreateDetachedInterface(setup.NetworkInterfaceDescription, map[string]string{markTag: now.Add(-time.Minute).Format(time.RFC3339)})
+	longMarked := fake.CreateDetachedInterface(setup.NetworkInterfaceDescription, map[string]string{markTag: now.Add(-time.Hour).Format(time.RFC3339)})
+	notOurs := fake.CreateDetachedInterface("someone else's", map[string]string{markTag: now.Add(-time.Hour).Format(time.RFC3339)})
+	// This one was marked, and then attached again
+	reattached, err := fake.AttachNewInterface(1)
+	require.NoError(t, err)
+	_, err = vpcCtx.EC2.CreateTagsWithContext(vpcCtx, &ec2.CreateTagsInput{
+		Resources: aws.StringSlice([]string{reattached}),
+		Tags:      []*ec2.Tag{{Key: aws.String(markTag), Value: aws.String(now.Add(-time.Hour).Format(time.RFC3339))}},
+	})
+	require.NoError(t, err)
+
+	report, err := doGlobalGc(vpcCtx, gcOptions{minDetachTime: 30 * time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Action{
+		unmarked:       ActionMark,
+		recentlyMarked: ActionWait,
+		longMarked:     ActionDelete,
+		reattached:     ActionUnmark,
+	}, actions(report))
+
+	assert.Nil(t, fake.Interface(longMarked), "Interfaces which have been marked for longer than the detach time should be deleted")
+	assert.NotNil(t, fake.Interface(recentlyMarked))
+	assert.NotNil(t, fake.Interface(notOurs), "Interfaces which aren't titus-managed should be left alone")
+	assert.NotNil(t, fake.Interface(unmarked))
+	assert.NotNil(t, tagValue(fake, unmarked, markTag), "Unmarked, detached interfaces should be marked")
+	assert.Nil(t, tagValue(fake, reattached, markTag), "Attached interfaces should have their marks removed")
+	assert.Equal(t, 1, fake.Calls("DeleteNetworkInterface"))
+}
+
+func TestGlobalGCDryRun(t *testing.T) {
+	fake := fakeec2.New("c5.large")
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	unmarked := fake.CreateDetachedInterface(setup.NetworkInterfaceDescription, nil)
+	longMarked := fake.CreateDetachedInterface(setup.NetworkInterfaceDescription, map[string]string{markTag: time.Now().Add(-time.Hour).Format(time.RFC3339)})
+
+	report, err := doGlobalGc(vpcCtx, gcOptions{minDetachTime: 30 * time.Minute, dryRun: true})
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, map[string]Action{unmarked: ActionMark, longMarked: ActionDelete}, actions(report))
+	assert.NotNil(t, fake.Interface(longMarked))
+	assert.Nil(t, tagValue(fake, unmarked, markTag))
+	assert.Equal(t, 0, fake.Calls("DeleteNetworkInterface"))
+	assert.Equal(t, 0, fake.Calls("CreateTags"))
+	assert.Equal(t, 0, fake.Calls("DeleteTags"))
+
+	var buf bytes.Buffer
+	require.NoError(t, report.print(&buf, outputText))
+	assert.Contains(t, buf.String(), longMarked)
+	buf.Reset()
+	require.NoError(t, report.print(&buf, outputJSON))
+	var decoded Report
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Len(t, decoded.Interfaces, 2)
+}
+
+func TestGlobalGCMaxDeletions(t *testing.T) {
+	fake := fakeec2.New("c5.large")
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	now := time.Now()
+	oldest := fake.CreateDetachedInterface(setup.NetworkInterfaceDescription, map[string]string{markTag: now.Add(-3 * time.Hour).Format(time.RFC3339)})
+	newest := fake.CreateDetachedInterface(setup.NetworkInterfaceDescription, map[string]string{markTag: now.Add(-time.Hour).Format(time.RFC3339)})
+	middle := fake.CreateDetachedInterface(setup.NetworkInterfaceDescription, map[string]string{markTag: now.Add(-2 * time.Hour).Format(time.RFC3339)})
+
+	report, err := doGlobalGc(vpcCtx, gcOptions{minDetachTime: 30 * time.Minute, maxDeletions: 2})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Action{oldest: ActionDelete, middle: ActionDelete, newest: ActionDeferred}, actions(report))
+	assert.Nil(t, fake.Interface(oldest))
+	assert.Nil(t, fake.Interface(middle))
+	assert.NotNil(t, fake.Interface(newest), "Interfaces over the deletion limit should be left for the next run")
+}
+
+func TestGlobalGCScope(t *testing.T) {
+	fake := fakeec2.New("c5.large")
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	longMarked := fake.CreateDetachedInterface(setup.NetworkInterfaceDescription, map[string]string{markTag: time.Now().Add(-time.Hour).Format(time.RFC3339)})
+
+	report, err := doGlobalGc(vpcCtx, gcOptions{minDetachTime: 30 * time.Minute, subnets: []string{"subnet-elsewhere"}})
+	require.NoError(t, err)
+	assert.Len(t, report.Interfaces, 0)
+	report, err = doGlobalGc(vpcCtx, gcOptions{minDetachTime: 30 * time.Minute, availabilityZones: []string{"us-west-2z"}})
+	require.NoError(t, err)
+	assert.Len(t, report.Interfaces, 0)
+	assert.NotNil(t, fake.Interface(longMarked))
+
+	_, err = doGlobalGc(vpcCtx, gcOptions{minDetachTime: 30 * time.Minute, subnets: []string{fakeec2.SubnetID}})
+	require.NoError(t, err)
+	assert.Nil(t, fake.Interface(longMarked))
+}
+
+func TestInconsistentResults(t *testing.T) {
+	fake := fakeec2.New("c5.large")
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	networkInterfaces := []*ec2.NetworkInterface{
+		{
+			NetworkInterfaceId: aws.String("eni-inconsistent"),
+			Description:        aws.String(setup.NetworkInterfaceDescription),
+			Status:             aws.String("in-use"),
+		},
+	}
+	_, _, err = markAndCollect(vpcCtx, networkInterfaces, 30*time.Minute, time.Now())
+	require.Error(t, err)
+	assert.IsType(t, &InconsistentResultError{}, err)
+}
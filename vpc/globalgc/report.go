@@ -0,0 +1,109 @@
+package globalgc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// Action is what global GC did, or would have done in a dry run, to an interface
+type Action string
+
+const (
+	// ActionMark is for detached interfaces which were unmarked, and are now candidates for the next run
+	ActionMark Action = "mark"
+	// ActionWait is for marked interfaces which haven't been detached for long enough to be deleted
+	ActionWait Action = "wait"
+	// ActionDelete is for marked interfaces which have been detached for long enough to be deleted
+	ActionDelete Action = "delete"
+	// ActionDeferred is for interfaces which would have been deleted, but for the maximum deletions per run
+	ActionDeferred Action = "deferred"
+	// ActionUnmark is for marked interfaces which have since been attached
+	ActionUnmark Action = "unmark"
+)
+
+// InterfaceReport is what global GC found out about an interface, and what it did with it
+type InterfaceReport struct {
+	NetworkInterfaceID string     `json:"networkInterfaceId"`
+	SubnetID           string     `json:"subnetId"`
+	AvailabilityZone   string     `json:"availabilityZone"`
+	Status             string     `json:"status"`
+	MarkedAt           *time.Time `json:"markedAt,omitempty"`
+	Action             Action     `json:"action"`
+	Error              string     `json:"error,omitempty"`
+}
+
+// Report is the outcome of a global GC run
+type Report struct {
+	DryRun       bool               `json:"dryRun"`
+	MaxDeletions int                `json:"maxDeletions"`
+	Interfaces   []*InterfaceReport `json:"interfaces"`
+}
+
+func newInterfaceReport(iface *ec2.NetworkInterface, action Action) *InterfaceReport {
+	ifaceReport := &InterfaceReport{
+		NetworkInterfaceID: aws.StringValue(iface.NetworkInterfaceId),
+		SubnetID:           aws.StringValue(iface.SubnetId),
+		AvailabilityZone:   aws.StringValue(iface.AvailabilityZone),
+		Status:             aws.StringValue(iface.Status),
+		Action:             action,
+	}
+	if markTagValue, ok := tagSetToMap(iface.TagSet)[markTag]; ok && markTagValue != nil {
+		markTimestamp, err := time.Parse(time.RFC3339, *markTagValue)
+		if err != nil {
+			// This shouldn't happen. The interface is treated as unmarked, so it gets a fresh mark.
+			log.Error("Unable to parse marktimestamp: ", *markTagValue)
+		} else {
+			ifaceReport.MarkedAt = &markTimestamp
+		}
+	}
+	return ifaceReport
+}
+
+func (r *Report) add(ifaceReports ...*InterfaceReport) {
+	r.Interfaces = append(r.Interfaces, ifaceReports...)
+}
+
+func interfaceIDs(ifaceReports []*InterfaceReport, action Action) []string {
+	ret := []string{}
+	for _, ifaceReport := range ifaceReports {
+		if ifaceReport.Action == action {
+			ret = append(ret, ifaceReport.NetworkInterfaceID)
+		}
+	}
+	return ret
+}
+
+func (r *Report) print(w io.Writer, output string) error {
+	if output == outputJSON {
+		return json.NewEncoder(w).Encode(r)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "INTERFACE\tSUBNET\tAZ\tSTATUS\tMARKED\tACTION\tERROR"); err != nil {
+		return err
+	}
+	for _, ifaceReport := range r.Interfaces {
+		marked := "-"
+		if ifaceReport.MarkedAt != nil {
+			marked = ifaceReport.MarkedAt.Format(time.RFC3339)
+		}
+		_, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", ifaceReport.NetworkInterfaceID, ifaceReport.SubnetID, ifaceReport.AvailabilityZone,
+			ifaceReport.Status, marked, ifaceReport.Action, ifaceReport.Error)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,13 @@
+package vpc
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// IPAddressToHandle returns the minor number of the HTB class on the IFBs which shapes the traffic of the container
+// with the IPv4 address, which is also the major number of the qdisc under the class. It's based on the last two
+// parts of the address. The container's IPv6 traffic is classified into the same class, by its IPv6 address.
+func IPAddressToHandle(ip net.IP) uint16 {
+	return binary.BigEndian.Uint16([]byte(ip.To4()[2:4]))
+}
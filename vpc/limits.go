@@ -1,192 +1,150 @@
 package vpc
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 )
 
-type limits struct {
-	interfaces               int
-	ipAddressesPerInterface  int
-	ip6AddressesPerInterface int
-	// in Mbps
-	networkThroughput int
+// Limits are the networking limits of an instance type
+type Limits struct {
+	// Interfaces includes the primary ENI
+	Interfaces                int `json:"interfaces"`
+	IPv4AddressesPerInterface int `json:"ipv4AddressesPerInterface"`
+	IPv6AddressesPerInterface int `json:"ipv6AddressesPerInterface"`
+	// NetworkThroughput is in Mbps
+	NetworkThroughput int `json:"networkThroughput"`
+	// Derived is true if the instance type isn't in the limits table, and its limits were derived from its size
+	Derived bool `json:"derived,omitempty"`
 }
 
-var interfaceLimits = map[string]map[string]limits{
-	"m4": {
-		"large": limits{
-			interfaces:               2,
-			ipAddressesPerInterface:  10,
-			ip6AddressesPerInterface: 10,
-			networkThroughput:        100,
-		},
-		"xlarge": limits{
-			interfaces:               4,
-			ipAddressesPerInterface:  15,
-			ip6AddressesPerInterface: 15,
-			networkThroughput:        1000,
-		},
-		"2xlarge": limits{
-			interfaces:               4,
-			ipAddressesPerInterface:  15,
-			ip6AddressesPerInterface: 15,
-			networkThroughput:        1000,
-		},
-		"4xlarge": limits{
-			interfaces:               8,
-			ipAddressesPerInterface:  30,
-			ip6AddressesPerInterface: 30,
-			networkThroughput:        2000,
-		},
-		"10xlarge": limits{
-			interfaces:               8,
-			ipAddressesPerInterface:  30,
-			ip6AddressesPerInterface: 30,
-			// Is this number correct?
-			networkThroughput: 10000,
-		},
-		"16xlarge": limits{
-			interfaces:               8,
-			ipAddressesPerInterface:  30,
-			ip6AddressesPerInterface: 30,
-			networkThroughput:        23000,
-		},
-	},
-	"m5": {
-		"large": limits{
-			interfaces:               3,
-			ipAddressesPerInterface:  10,
-			ip6AddressesPerInterface: 10,
-			networkThroughput:        100,
-		},
-		"xlarge": limits{
-			interfaces:               4,
-			ipAddressesPerInterface:  15,
-			ip6AddressesPerInterface: 15,
-			networkThroughput:        1000,
-		},
-		"2xlarge": limits{
-			interfaces:               4,
-			ipAddressesPerInterface:  15,
-			ip6AddressesPerInterface: 15,
-			networkThroughput:        1000,
-		},
-		"4xlarge": limits{
-			interfaces:               8,
-			ipAddressesPerInterface:  30,
-			ip6AddressesPerInterface: 30,
-			networkThroughput:        2000,
-		},
-		"12xlarge": limits{
-			interfaces:               8,
-			ipAddressesPerInterface:  30,
-			ip6AddressesPerInterface: 30,
-			// Is this number correct?
-			networkThroughput: 10000,
-		},
-		"24xlarge": limits{
-			interfaces:               15,
-			ipAddressesPerInterface:  30,
-			ip6AddressesPerInterface: 50,
-			networkThroughput:        23000,
-		},
-	},
-	"r4": {
-		"large": limits{
-			interfaces:               3,
-			ipAddressesPerInterface:  10,
-			ip6AddressesPerInterface: 10,
-			networkThroughput:        1000,
-		},
-		"xlarge": limits{
-			interfaces:               4,
-			ipAddressesPerInterface:  15,
-			ip6AddressesPerInterface: 15,
-			networkThroughput:        1000,
-		},
-		"2xlarge": limits{
-			interfaces:               4,
-			ipAddressesPerInterface:  15,
-			ip6AddressesPerInterface: 15,
-			networkThroughput:        2000,
-		},
-		"4xlarge": limits{
-			interfaces:               8,
-			ipAddressesPerInterface:  30,
-			ip6AddressesPerInterface: 30,
-			networkThroughput:        4000,
-		},
-		"8xlarge": limits{
-			interfaces:               8,
-			ipAddressesPerInterface:  30,
-			ip6AddressesPerInterface: 30,
-			networkThroughput:        9000,
-		},
-		"16xlarge": limits{
-			interfaces:               15,
-			ipAddressesPerInterface:  50,
-			ip6AddressesPerInterface: 50,
-			networkThroughput:        23000,
-		},
-	},
-	"p2": {
-		"xlarge": limits{
-			interfaces:               4,
-			ipAddressesPerInterface:  15,
-			ip6AddressesPerInterface: 15,
-			// Maybe?
-			networkThroughput: 2000,
-		},
-		"8xlarge": limits{
-			interfaces:               8,
-			ipAddressesPerInterface:  30,
-			ip6AddressesPerInterface: 30,
-			networkThroughput:        6000,
-		},
-		"16xlarge": limits{
-			interfaces:               8,
-			ipAddressesPerInterface:  30,
-			ip6AddressesPerInterface: 30,
-			networkThroughput:        20000,
-		},
-	},
+// NetworkBps returns the network throughput in bits per second
+func (l *Limits) NetworkBps() uint64 {
+	return uint64(l.NetworkThroughput) * 1000 * 1000
 }
 
-// This function will panic if the instance type is unknown
-func getLimits(instanceType string) limits {
-	familyAndSubtype := strings.Split(instanceType, ".")
-	family := familyAndSubtype[0]
-	subtype := familyAndSubtype[1]
-	subtypes, ok := interfaceLimits[family]
-	if !ok {
-		panic(fmt.Sprint("Unknown family: ", family))
+func (l *Limits) validate() error {
+	if l.Interfaces < 2 {
+		return fmt.Errorf("%d interfaces leaves none for containers", l.Interfaces)
 	}
+	if l.IPv4AddressesPerInterface <= 0 {
+		return fmt.Errorf("%d IPv4 addresses per interface is invalid", l.IPv4AddressesPerInterface)
+	}
+	if l.IPv6AddressesPerInterface < 0 {
+		return fmt.Errorf("%d IPv6 addresses per interface is invalid", l.IPv6AddressesPerInterface)
+	}
+	if l.NetworkThroughput <= 0 {
+		return fmt.Errorf("%d Mbps of network throughput is invalid", l.NetworkThroughput)
+	}
+	return nil
+}
+
+// LimitsTable maps instance families (i.e. m5), to their sizes (i.e. 2xlarge), to their limits. Limits for sizes of
+// the derivedFamily are used for instance types which aren't in the table.
+type LimitsTable map[string]map[string]Limits
+
+const derivedFamily = "*"
+
+// UnknownInstanceTypeError is returned if the limits of an instance type can't be found, or derived
+type UnknownInstanceTypeError struct {
+	InstanceType string
+}
+
+func (e *UnknownInstanceTypeError) Error() string {
+	return fmt.Sprintf("No limits known for instance type %q", e.InstanceType)
+}
+
+var limitsTable = mustParseLimitsTable(strings.NewReader(defaultLimitsTable))
+
+func parseLimitsTable(r io.Reader) (LimitsTable, error) {
+	table := LimitsTable{}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&table); err != nil {
+		return nil, err
+	}
+	for family, sizes := range table {
+		for size, limits := range sizes {
+			if limits.Derived {
+				return nil, fmt.Errorf("%s.%s: derived can't be set in the limits table", family, size)
+			}
+			if err := limits.validate(); err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", family, size, err)
+			}
+		}
+	}
+	return table, nil
+}
 
-	limits, ok := subtypes[subtype]
-	if !ok {
-		panic(fmt.Sprint("Unknown subtype for family: ", subtype))
+func mustParseLimitsTable(r io.Reader) LimitsTable {
+	table, err := parseLimitsTable(r)
+	if err != nil {
+		panic(fmt.Sprint("Default limits table is invalid: ", err))
 	}
-	return limits
+	return table
 }
 
-// GetMaxInterfaces returns the maximum number of interfaces that this instance type can handle
-// includes the primary ENI
-func GetMaxInterfaces(instanceType string) int {
-	return getLimits(instanceType).interfaces
+// LoadLimitsOverrides reads a limits table from a JSON file, in the same format as the default one, and adds its
+// limits to the table, replacing the limits of any instance types in both. It isn't safe to call concurrently with
+// GetLimits, so it should be called once, at startup.
+func LoadLimitsOverrides(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	overrides, err := parseLimitsTable(f)
+	if err != nil {
+		return fmt.Errorf("Unable to parse limits overrides %s: %v", path, err)
+	}
+	limitsTable.merge(overrides)
+	return nil
 }
 
-// GetMaxIPv4Addresses returns  the maximum number of IPv4 addresses that this instance type can handle
-func GetMaxIPv4Addresses(instanceType string) int {
-	return getLimits(instanceType).ipAddressesPerInterface
+func (table LimitsTable) merge(other LimitsTable) {
+	for family, sizes := range other {
+		if _, ok := table[family]; !ok {
+			table[family] = make(map[string]Limits, len(sizes))
+		}
+		for size, limits := range sizes {
+			table[family][size] = limits
+		}
+	}
+}
+
+func (table LimitsTable) lookup(instanceType string) (*Limits, error) {
+	familyAndSize := strings.SplitN(instanceType, ".", 2)
+	if len(familyAndSize) != 2 {
+		return nil, &UnknownInstanceTypeError{InstanceType: instanceType}
+	}
+	family, size := familyAndSize[0], familyAndSize[1]
+
+	if limits, ok := table[family][size]; ok {
+		return &limits, nil
+	}
+
+	// Families generally have the same limits for a given size, so that's our best guess
+	if limits, ok := table[derivedFamily][size]; ok {
+		limits.Derived = true
+		return &limits, nil
+	}
+	return nil, &UnknownInstanceTypeError{InstanceType: instanceType}
 }
 
-// GetMaxNetworkMbps returns the maximum network throughput in Megabits per second that this instance type can handle
-func GetMaxNetworkMbps(instanceType string) int {
-	return getLimits(instanceType).networkThroughput
+// GetLimits returns the networking limits of the instance type. It returns an *UnknownInstanceTypeError if they aren't
+// known.
+func GetLimits(instanceType string) (*Limits, error) {
+	return limitsTable.lookup(instanceType)
 }
 
-// GetMaxNetworkbps returns the maximum network throughput in bits per second that this instance type can handle
-func GetMaxNetworkbps(instanceType string) uint64 {
-	return uint64(GetMaxNetworkMbps(instanceType)) * 1000 * 1000
+// GetLimitsTable returns a copy of the limits table, including overrides
+func GetLimitsTable() LimitsTable {
+	table := LimitsTable{}
+	table.merge(limitsTable)
+	return table
 }
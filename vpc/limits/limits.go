@@ -0,0 +1,57 @@
+package limits
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/Netflix/titus-executor/vpc"
+	"github.com/Netflix/titus-executor/vpc/context"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var Limits = cli.Command{ // nolint: golint
+	Name:   "limits",
+	Usage:  "Print the networking limits of this instance, or of another instance type",
+	Action: limits,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "instance-type",
+			Usage: "The instance type to print the limits of, instead of this instance's. It doesn't need to be run on EC2 if this is set",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "Print the whole limits table, including overrides",
+		},
+	},
+}
+
+type instanceTypeLimits struct {
+	InstanceType string `json:"instanceType"`
+	*vpc.Limits
+}
+
+func limits(cliCtx *cli.Context) error {
+	if cliCtx.Bool("all") {
+		return printJSON(vpc.GetLimitsTable())
+	}
+	if instanceType := cliCtx.String("instance-type"); instanceType != "" {
+		return printLimits(instanceType)
+	}
+	return context.WrapFunc(func(parentCtx *context.VPCContext) error {
+		return printLimits(parentCtx.InstanceType)
+	})(cliCtx)
+}
+
+func printLimits(instanceType string) error {
+	limits, err := vpc.GetLimits(instanceType)
+	if err != nil {
+		return cli.NewMultiError(cli.NewExitError("Unable to get limits", 1), err)
+	}
+	return printJSON(instanceTypeLimits{InstanceType: instanceType, Limits: limits})
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
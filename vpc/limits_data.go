@@ -0,0 +1,209 @@
+package vpc
+
+// defaultLimitsTable is the limits of the instance types we know about. Network throughput is the baseline, rather than
+// the burst throughput, for instance types which can burst. The limits of the "*" family are used for instance types
+// which aren't in the table, based on their size.
+//
+// Limits can be overridden, or added to with a file in the same format, see LoadLimitsOverrides.
+const defaultLimitsTable = `
+{
+  "*": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1250},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 5000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "9xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "12xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 12000},
+    "16xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 20000},
+    "18xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000},
+    "24xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000}
+  },
+  "c4": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 500},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 750},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1000},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 2000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000}
+  },
+  "c5": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1250},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 5000},
+    "9xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "12xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 12000},
+    "18xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000},
+    "24xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000}
+  },
+  "c5d": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1250},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 5000},
+    "9xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "12xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 12000},
+    "18xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000},
+    "24xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000}
+  },
+  "c5n": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 3000},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 5000},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 10000},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 15000},
+    "9xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 50000},
+    "18xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 100000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 100000}
+  },
+  "g3": {
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 5000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "16xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 20000}
+  },
+  "g4dn": {
+    "xlarge": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 5000},
+    "2xlarge": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 10000},
+    "4xlarge": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 20000},
+    "8xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 50000},
+    "12xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 50000},
+    "16xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 50000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 100000}
+  },
+  "i3": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1250},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 5000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "16xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 20000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000}
+  },
+  "i3en": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1250},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "3xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 5000},
+    "6xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "12xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 50000},
+    "24xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 100000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 100000}
+  },
+  "m4": {
+    "large": {"interfaces": 2, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 100},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1000},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1000},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 2000},
+    "10xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "16xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 23000}
+  },
+  "m5": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 100},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1000},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1000},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 2000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "12xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "16xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 20000},
+    "24xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 50, "networkThroughput": 23000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000}
+  },
+  "m5a": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1250},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 5000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "12xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 12000},
+    "16xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 20000},
+    "24xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000}
+  },
+  "m5d": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1250},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 5000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "12xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 12000},
+    "16xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 20000},
+    "24xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000}
+  },
+  "p2": {
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 6000},
+    "16xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 20000}
+  },
+  "p3": {
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "16xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 25000}
+  },
+  "p3dn": {
+    "24xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 100000}
+  },
+  "r4": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 1000},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1000},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2000},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 4000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 9000},
+    "16xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 23000}
+  },
+  "r5": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1250},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 5000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "12xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 12000},
+    "16xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 20000},
+    "24xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000}
+  },
+  "r5a": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1250},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 5000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "12xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 12000},
+    "16xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 20000},
+    "24xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000}
+  },
+  "r5d": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1250},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "4xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 5000},
+    "8xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "12xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 12000},
+    "16xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 20000},
+    "24xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000}
+  },
+  "x1": {
+    "16xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "32xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 25000}
+  },
+  "x1e": {
+    "xlarge": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1000},
+    "4xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1500},
+    "8xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 5000},
+    "16xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "32xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 25000}
+  },
+  "z1d": {
+    "large": {"interfaces": 3, "ipv4AddressesPerInterface": 10, "ipv6AddressesPerInterface": 10, "networkThroughput": 750},
+    "xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 1250},
+    "2xlarge": {"interfaces": 4, "ipv4AddressesPerInterface": 15, "ipv6AddressesPerInterface": 15, "networkThroughput": 2500},
+    "3xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 5000},
+    "6xlarge": {"interfaces": 8, "ipv4AddressesPerInterface": 30, "ipv6AddressesPerInterface": 30, "networkThroughput": 10000},
+    "12xlarge": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000},
+    "metal": {"interfaces": 15, "ipv4AddressesPerInterface": 50, "ipv6AddressesPerInterface": 50, "networkThroughput": 25000}
+  }
+}
+`
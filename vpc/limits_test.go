@@ -0,0 +1,61 @@
+package vpc
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLimits(t *testing.T) {
+	limits, err := GetLimits("m4.16xlarge")
+	require.NoError(t, err)
+	assert.Equal(t, Limits{Interfaces: 8, IPv4AddressesPerInterface: 30, IPv6AddressesPerInterface: 30, NetworkThroughput: 23000}, *limits)
+	assert.Equal(t, uint64(23000000000), limits.NetworkBps())
+}
+
+func TestGetLimitsDerived(t *testing.T) {
+	limits, err := GetLimits("q9.4xlarge")
+	require.NoError(t, err)
+	assert.True(t, limits.Derived)
+	assert.Equal(t, 8, limits.Interfaces)
+
+	_, err = GetLimits("q9.nano")
+	assert.IsType(t, &UnknownInstanceTypeError{}, err)
+	_, err = GetLimits("garbage")
+	assert.IsType(t, &UnknownInstanceTypeError{}, err)
+}
+
+func TestLoadLimitsOverrides(t *testing.T) {
+	f, err := ioutil.TempFile("", "limits")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"q9": {"large": {"interfaces": 2, "ipv4AddressesPerInterface": 5, "ipv6AddressesPerInterface": 0, "networkThroughput": 10}}}`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	originalTable := limitsTable
+	limitsTable = GetLimitsTable()
+	defer func() { limitsTable = originalTable }()
+
+	require.NoError(t, LoadLimitsOverrides(f.Name()))
+	limits, err := GetLimits("q9.large")
+	require.NoError(t, err)
+	assert.Equal(t, Limits{Interfaces: 2, IPv4AddressesPerInterface: 5, NetworkThroughput: 10}, *limits)
+	// The rest of the table is left alone
+	_, err = GetLimits("m4.large")
+	assert.NoError(t, err)
+}
+
+func TestLoadLimitsOverridesInvalid(t *testing.T) {
+	f, err := ioutil.TempFile("", "limits")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"q9": {"large": {"interfaces": 2, "ipv4AddressesPerInterface": 5, "networkThroughput": 0}}}`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assert.Error(t, LoadLimitsOverrides(f.Name()))
+}
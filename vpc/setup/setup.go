@@ -58,16 +58,20 @@ func setup(parentCtx *context.VPCContext) error {
 
 // TODO: Wrap in CLI errrors
 func setupInterfaces(ctx *context.VPCContext) error {
+	limits, err := vpc.GetLimits(ctx.InstanceType)
+	if err != nil {
+		return err
+	}
 	allInterfaces, err := ctx.EC2metadataClientWrapper.Interfaces()
 	if err != nil {
 		return err
 	}
 
 	// We're "setup" here, go on
-	if vpc.GetMaxInterfaces(ctx.InstanceType) == len(allInterfaces) {
+	if limits.Interfaces == len(allInterfaces) {
 		return nil
 	}
-	ctx.Logger.Infof("%d interfaces missing, adding them", vpc.GetMaxInterfaces(ctx.InstanceType)-len(allInterfaces))
+	ctx.Logger.Infof("%d interfaces missing, adding them", limits.Interfaces-len(allInterfaces))
 
 	interfaceByIdx := make(map[int]ec2wrapper.EC2NetworkInterface)
 
@@ -87,7 +91,7 @@ func setupInterfaces(ctx *context.VPCContext) error {
 	subnetID := defaultInterface.SubnetID
 
 	// Ignore interface device index 0 -- that's always the default network adapter
-	for i := 1; i < vpc.GetMaxInterfaces(ctx.InstanceType); i++ {
+	for i := 1; i < limits.Interfaces; i++ {
 		if _, ok := interfaceByIdx[i]; !ok {
 			err = attachInterfaceAtIdx(ctx, ctx.InstanceID, subnetID, i)
 			if err != nil {
@@ -97,19 +101,17 @@ func setupInterfaces(ctx *context.VPCContext) error {
 		}
 	}
 
-	return waitForInterfaces(ctx)
+	return waitForInterfaces(ctx, limits.Interfaces)
 }
 
 func attachInterfaceAtIdx(ctx *context.VPCContext, instanceID, subnetID string, idx int) error {
 	// TODO: Check DescribeInstances to make sure an existing interface is not in attaching
-	svc := ec2.New(ctx.AWSSession)
-
 	createNetworkInterfaceInput := &ec2.CreateNetworkInterfaceInput{
 		Description: aws.String(NetworkInterfaceDescription),
 		SubnetId:    aws.String(subnetID),
-		//	Ipv6AddressCount: aws.Int64(int64(getLimits(instanceType).ip6AddressesPerInterface)),
+		//	Ipv6AddressCount: aws.Int64(int64(limits.IPv6AddressesPerInterface)),
 	}
-	createNetworkInterfaceResult, err := svc.CreateNetworkInterfaceWithContext(ctx, createNetworkInterfaceInput)
+	createNetworkInterfaceResult, err := ctx.EC2.CreateNetworkInterfaceWithContext(ctx, createNetworkInterfaceInput)
 	if err != nil {
 		return err
 	}
@@ -120,7 +122,7 @@ func attachInterfaceAtIdx(ctx *context.VPCContext, instanceID, subnetID string,
 		NetworkInterfaceId: createNetworkInterfaceResult.NetworkInterface.NetworkInterfaceId,
 	}
 	// TODO: Delete interface if attaching fails.
-	attachNetworkInterfaceResult, err := svc.AttachNetworkInterfaceWithContext(ctx, attachNetworkInterfaceInput)
+	attachNetworkInterfaceResult, err := ctx.EC2.AttachNetworkInterfaceWithContext(ctx, attachNetworkInterfaceInput)
 	if err != nil {
 		return err
 	}
@@ -132,18 +134,18 @@ func attachInterfaceAtIdx(ctx *context.VPCContext, instanceID, subnetID string,
 		},
 		NetworkInterfaceId: createNetworkInterfaceResult.NetworkInterface.NetworkInterfaceId,
 	}
-	_, err = svc.ModifyNetworkInterfaceAttributeWithContext(ctx, modifyNetworkInterfaceAttributeInput)
+	_, err = ctx.EC2.ModifyNetworkInterfaceAttributeWithContext(ctx, modifyNetworkInterfaceAttributeInput)
 	return err
 }
 
-func waitForInterfaces(ctx *context.VPCContext) error {
+func waitForInterfaces(ctx *context.VPCContext, maxInterfaces int) error {
 	waitUntil := time.Now().Add(time.Minute)
 	for time.Until(waitUntil) > 0 {
 		allInterfaces, err := ctx.EC2metadataClientWrapper.Interfaces()
 		if err != nil {
 			return err
 		}
-		if vpc.GetMaxInterfaces(ctx.InstanceType) == len(allInterfaces) {
+		if maxInterfaces == len(allInterfaces) {
 			return waitForInterfacesUp(ctx, allInterfaces)
 		}
 		time.Sleep(5 * time.Second)
@@ -240,7 +240,11 @@ func setupIFBHTBRootClass(ctx *context.VPCContext, link netlink.Link) error {
 		Handle:    rootHtbClass,
 	}
 
-	rate := vpc.GetMaxNetworkbps(ctx.InstanceType)
+	limits, err := vpc.GetLimits(ctx.InstanceType)
+	if err != nil {
+		return err
+	}
+	rate := limits.NetworkBps()
 	htbclassattrs := netlink.HtbClassAttrs{
 		Rate:    rate,
 		Buffer:  uint32(float64(rate/8)/netlink.Hz() + float64(mtu)),
@@ -0,0 +1,34 @@
+package setup
+
+import (
+	"testing"
+
+	"github.com/Netflix/titus-executor/vpc/fakeec2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachInterfaceAtIdx(t *testing.T) {
+	fake := fakeec2.New("c5.large")
+	vpcCtx, cleanup, err := fake.NewVPCContext(logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	defer cleanup()
+
+	require.NoError(t, attachInterfaceAtIdx(vpcCtx, vpcCtx.InstanceID, fakeec2.SubnetID, 1))
+	interfaces, err := vpcCtx.EC2metadataClientWrapper.Interfaces()
+	require.NoError(t, err)
+	require.Len(t, interfaces, 2)
+	for _, networkInterface := range interfaces {
+		if networkInterface.DeviceNumber != 1 {
+			continue
+		}
+		ni := fake.Interface(networkInterface.InterfaceID)
+		assert.Equal(t, NetworkInterfaceDescription, aws.StringValue(ni.Description))
+		assert.True(t, aws.BoolValue(ni.Attachment.DeleteOnTermination))
+	}
+
+	// c5.large instances only have 3 interfaces
+	assert.Error(t, attachInterfaceAtIdx(vpcCtx, vpcCtx.InstanceID, fakeec2.SubnetID, 3))
+}
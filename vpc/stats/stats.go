@@ -0,0 +1,94 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sort"
+
+	"github.com/Netflix/titus-executor/vpc"
+	"github.com/Netflix/titus-executor/vpc/context"
+	"github.com/Netflix/titus-executor/vpc/types"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// ErrClassNotFound indicates that there's no class on the IFBs for the IP address
+var ErrClassNotFound = errors.New("Traffic shaping class not found")
+
+var Stats = cli.Command{ // nolint: golint
+	Name:   "stats",
+	Usage:  "Print the traffic of the containers on this instance, as counted by the classes that shape it",
+	Action: context.WrapFunc(printStats),
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "ip",
+			Usage: "Only print the traffic of the container with this IPv4 address",
+		},
+	},
+}
+
+type containerStats struct {
+	// IPAddress, and ENI are empty if the handle doesn't belong to any of the addresses assigned to the instance
+	IPAddress string `json:"ipAddress,omitempty"`
+	ENI       string `json:"eni,omitempty"`
+	Handle    uint16 `json:"handle"`
+	*types.TrafficStats
+}
+
+func printStats(parentCtx *context.VPCContext) error {
+	if err := doPrintStats(parentCtx); err != nil {
+		return cli.NewMultiError(cli.NewExitError("Unable to get stats", 1), err)
+	}
+	return nil
+}
+
+func doPrintStats(parentCtx *context.VPCContext) error {
+	all, err := All()
+	if err != nil {
+		return err
+	}
+
+	interfaces, err := parentCtx.EC2metadataClientWrapper.Interfaces()
+	if err != nil {
+		return err
+	}
+	containers := make(map[uint16]*containerStats, len(all))
+	for handle, trafficStats := range all {
+		containers[handle] = &containerStats{Handle: handle, TrafficStats: trafficStats}
+	}
+	for _, networkInterface := range interfaces {
+		// The primary interface's addresses are the host's
+		if networkInterface.DeviceNumber == 0 {
+			continue
+		}
+		for _, ip := range networkInterface.IPv4Addresses {
+			parsedIP := net.ParseIP(ip)
+			if parsedIP == nil || parsedIP.To4() == nil {
+				continue
+			}
+			if container, ok := containers[vpc.IPAddressToHandle(parsedIP)]; ok {
+				container.IPAddress = ip
+				container.ENI = networkInterface.InterfaceID
+			}
+		}
+	}
+
+	ret := make([]*containerStats, 0, len(containers))
+	onlyIP := parentCtx.CLIContext.String("ip")
+	for _, container := range containers {
+		if onlyIP == "" || container.IPAddress == onlyIP {
+			ret = append(ret, container)
+		}
+	}
+	if onlyIP != "" && len(ret) == 0 {
+		return ErrClassNotFound
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Handle < ret[j].Handle
+	})
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(ret)
+}
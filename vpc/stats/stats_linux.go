@@ -0,0 +1,132 @@
+// +build linux
+
+package stats
+
+import (
+	"net"
+
+	"github.com/Netflix/titus-executor/vpc"
+	"github.com/Netflix/titus-executor/vpc/types"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// These are the attributes nested in TCA_STATS2, from linux/gen_stats.h. The vendored netlink package doesn't parse
+// class statistics.
+const (
+	tcaStatsBasic = 1
+	tcaStatsQueue = 3
+)
+
+var rootClass = netlink.MakeHandle(1, 1)
+
+// ForIP returns the traffic of the container with the IPv4 address
+func ForIP(ip net.IP) (*types.TrafficStats, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	stats, ok := all[vpc.IPAddressToHandle(ip)]
+	if !ok {
+		return nil, ErrClassNotFound
+	}
+	return stats, nil
+}
+
+// All returns the traffic of every container with a class on the IFBs, by class handle
+func All() (map[uint16]*types.TrafficStats, error) {
+	egress, err := ifbClassStats(vpc.EgressIFB)
+	if err != nil {
+		return nil, err
+	}
+	ingress, err := ifbClassStats(vpc.IngressIFB)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[uint16]*types.TrafficStats, len(egress))
+	for handle, classStats := range egress {
+		all[handle] = &types.TrafficStats{Egress: classStats}
+	}
+	for handle, classStats := range ingress {
+		if _, ok := all[handle]; !ok {
+			all[handle] = &types.TrafficStats{}
+		}
+		all[handle].Ingress = classStats
+	}
+	return all, nil
+}
+
+func ifbClassStats(name string) (map[uint16]types.ClassStats, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return classStats(link)
+}
+
+// classStats returns the counters of the per-container classes on the link, by their minor number
+func classStats(link netlink.Link) (map[uint16]types.ClassStats, error) {
+	req := nl.NewNetlinkRequest(unix.RTM_GETTCLASS, unix.NLM_F_DUMP)
+	req.AddData(&nl.TcMsg{
+		Family:  nl.FAMILY_ALL,
+		Ifindex: int32(link.Attrs().Index),
+		Parent:  rootClass,
+	})
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWTCLASS)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[uint16]types.ClassStats, len(msgs))
+	for _, m := range msgs {
+		msg := nl.DeserializeTcMsg(m)
+		major, minor := netlink.MajorMinor(msg.Handle)
+		// The root class isn't a container's
+		if major != 1 || msg.Handle == rootClass {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+		if err != nil {
+			return nil, err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type != nl.TCA_STATS2 {
+				continue
+			}
+			stats, err := parseStats2(attr.Value)
+			if err != nil {
+				return nil, err
+			}
+			ret[minor] = stats
+		}
+	}
+	return ret, nil
+}
+
+func parseStats2(data []byte) (types.ClassStats, error) {
+	var stats types.ClassStats
+	attrs, err := nl.ParseRouteAttr(data)
+	if err != nil {
+		return stats, err
+	}
+	native := nl.NativeEndian()
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case tcaStatsBasic:
+			// struct gnet_stats_basic: bytes, and packets
+			if len(attr.Value) >= 12 {
+				stats.Bytes = native.Uint64(attr.Value[0:8])
+				stats.Packets = uint64(native.Uint32(attr.Value[8:12]))
+			}
+		case tcaStatsQueue:
+			// struct gnet_stats_queue: qlen, backlog, drops, requeues, and overlimits
+			if len(attr.Value) >= 20 {
+				stats.Drops = uint64(native.Uint32(attr.Value[8:12]))
+				stats.Overlimits = uint64(native.Uint32(attr.Value[16:20]))
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,27 @@
+// +build linux
+
+package stats
+
+import (
+	"testing"
+
+	"github.com/Netflix/titus-executor/vpc/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink/nl"
+)
+
+func TestParseStats2(t *testing.T) {
+	native := nl.NativeEndian()
+	basic := make([]byte, 16)
+	native.PutUint64(basic[0:8], 123456789012)
+	native.PutUint32(basic[8:12], 4242)
+	queue := make([]byte, 20)
+	native.PutUint32(queue[8:12], 7)
+	native.PutUint32(queue[16:20], 99)
+
+	data := append(nl.NewRtAttr(tcaStatsBasic, basic).Serialize(), nl.NewRtAttr(tcaStatsQueue, queue).Serialize()...)
+	stats, err := parseStats2(data)
+	require.NoError(t, err)
+	assert.Equal(t, types.ClassStats{Bytes: 123456789012, Packets: 4242, Drops: 7, Overlimits: 99}, stats)
+}
@@ -0,0 +1,19 @@
+// +build !linux
+
+package stats
+
+import (
+	"net"
+
+	"github.com/Netflix/titus-executor/vpc/types"
+)
+
+// ForIP returns the traffic of the container with the IPv4 address
+func ForIP(ip net.IP) (*types.TrafficStats, error) {
+	return nil, types.ErrUnsupported
+}
+
+// All returns the traffic of every container with a class on the IFBs, by class handle
+func All() (map[uint16]*types.TrafficStats, error) {
+	return nil, types.ErrUnsupported
+}
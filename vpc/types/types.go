@@ -1,14 +1,22 @@
 package types
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 // Allocation is the public interface exposed when we allocate a namespace
 type Allocation struct {
 	IPV4Address string `json:"ipv4Address"`
+	// IPV6Address is only set if one was requested
+	IPV6Address string `json:"ipv6Address,omitempty"`
 	DeviceIndex int    `json:"deviceIndex"`
 	Success     bool   `json:"success"`
 	Error       string `json:"error"`
 	ENI         string `json:"eni"`
+	// SecurityGroups are the security groups of the ENI, sorted
+	SecurityGroups []string `json:"securityGroups,omitempty"`
 }
 
 // WiringStatus indicates whether or not wiring was successful
@@ -19,3 +27,91 @@ type WiringStatus struct {
 
 // ErrUnsupported indicates that the operation is unsupported on this platform
 var ErrUnsupported = errors.New("Unsupported")
+
+// AllocationRequest describes the IP address, and security groups that a container needs
+type AllocationRequest struct {
+	// DeviceIndex is the AWS device index of the interface to allocate on. It's 1-indexed, since device 0 is the
+	// primary interface of the instance, and isn't used for containers. With AnyInterface, it's only a preference, and
+	// can be 0 for none.
+	DeviceIndex int
+	// AnyInterface allows allocating from interfaces other than the one at DeviceIndex, if it's in use by other
+	// security groups, or out of addresses. The Allocation says which interface was used.
+	AnyInterface bool
+	// SecurityGroups default to the security groups of the primary interface
+	SecurityGroups []string
+	// BatchSize is how many IP addresses are assigned to the interface at once, when it runs out of them
+	BatchSize int
+	// SecurityConvergenceTimeout is how long to wait for security group changes to show up in the instance metadata
+	SecurityConvergenceTimeout time.Duration
+	// SecurityGroupQueueTimeout is how long to wait for an interface which is in use by other security groups to drain,
+	// so its security groups can be changed. While an allocation waits, no new ones are let onto the interface. 0
+	// doesn't wait.
+	SecurityGroupQueueTimeout time.Duration
+	// IPv6 allocates an IPv6 address from the interface, in addition to the IPv4 address
+	IPv6 bool
+}
+
+// Allocator allocates IP addresses, and configures the security groups of the interfaces they're on
+type Allocator interface {
+	Allocate(ctx context.Context, req AllocationRequest) (Lease, error)
+}
+
+// Lease holds an allocation, and keeps it from being garbage collected, until it's released
+type Lease interface {
+	Allocation() Allocation
+	// Release is idempotent
+	Release()
+}
+
+// Protocols that ports can be mapped for
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// PortMapping redirects traffic destined for the container's IP on HostPort to ContainerPort
+type PortMapping struct {
+	HostPort      uint16
+	ContainerPort uint16
+	// Protocol is either ProtocolTCP, or ProtocolUDP
+	Protocol string
+}
+
+// WiringRequest describes how to connect a container's network namespace to its allocation
+type WiringRequest struct {
+	Allocation Allocation
+	// NetnsFD is a file descriptor of the container's network namespace. The Wirer keeps its own copy of it, so the
+	// caller can close it once Wire returns.
+	NetnsFD int
+	// Bandwidth is in bps
+	Bandwidth    uint64
+	Burst        bool
+	PortMappings []PortMapping
+}
+
+// Wirer connects containers' network namespaces to the VPC
+type Wirer interface {
+	Wire(ctx context.Context, req WiringRequest) (Wiring, error)
+}
+
+// Wiring is a container's connection to the VPC
+type Wiring interface {
+	// Stats returns the container's traffic, as counted by the classes that shape it
+	Stats() (*TrafficStats, error)
+	// Teardown is idempotent
+	Teardown()
+}
+
+// ClassStats are the counters of an HTB class. Packets, and Drops are 32-bit counters in the kernel, and wrap.
+type ClassStats struct {
+	Bytes      uint64 `json:"bytes"`
+	Packets    uint64 `json:"packets"`
+	Drops      uint64 `json:"drops"`
+	Overlimits uint64 `json:"overlimits"`
+}
+
+// TrafficStats is a container's traffic, in each direction
+type TrafficStats struct {
+	Egress  ClassStats `json:"egress"`
+	Ingress ClassStats `json:"ingress"`
+}